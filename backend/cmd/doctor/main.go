@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Baaaki/digital-square/internal/config"
+	"github.com/Baaaki/digital-square/internal/diagnostics"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// doctor validates the runtime environment and prints a pass/fail report.
+// Exit code is non-zero if any check fails, so it can gate container startup.
+func main() {
+	cfg := config.Load()
+
+	results := []diagnostics.Result{diagnostics.CheckConfig(cfg)}
+
+	// PostgreSQL
+	db, err := gorm.Open(postgres.Open(cfg.DatabaseURL), &gorm.Config{
+		Logger: gormlogger.Default.LogMode(gormlogger.Silent),
+	})
+	if err != nil {
+		results = append(results, diagnostics.Result{Name: "database", Detail: err.Error()})
+	} else {
+		results = append(results, diagnostics.CheckDatabase(db))
+		if sqlDB, err := db.DB(); err == nil {
+			defer sqlDB.Close()
+		}
+	}
+
+	// Redis
+	opt, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		results = append(results, diagnostics.Result{Name: "redis", Detail: err.Error()})
+	} else {
+		client := redis.NewClient(opt)
+		defer client.Close()
+		results = append(results, diagnostics.CheckRedis(client))
+	}
+
+	results = append(results,
+		diagnostics.CheckWAL(cfg.WALPath),
+		diagnostics.CheckHashing(),
+		diagnostics.CheckJWT(cfg.JWTSecret),
+	)
+
+	fmt.Println("Digital Square doctor")
+	fmt.Println("---------------------")
+	for _, r := range results {
+		status := "PASS"
+		if !r.OK {
+			status = "FAIL"
+		}
+		fmt.Printf("[%s] %-10s %-8s %s\n", status, r.Name, r.Duration.Round(1e6), r.Detail)
+	}
+
+	if !diagnostics.AllPassed(results) {
+		fmt.Println("\nOne or more checks failed")
+		os.Exit(1)
+	}
+	fmt.Println("\nAll checks passed")
+}
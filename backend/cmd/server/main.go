@@ -2,20 +2,31 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/Baaaki/digital-square/internal/broker"
 	"github.com/Baaaki/digital-square/internal/config"
 	"github.com/Baaaki/digital-square/internal/database"
+	"github.com/Baaaki/digital-square/internal/geoip"
 	"github.com/Baaaki/digital-square/internal/handler"
 	"github.com/Baaaki/digital-square/internal/middleware"
+	"github.com/Baaaki/digital-square/internal/models"
+	"github.com/Baaaki/digital-square/internal/moderation"
 	"github.com/Baaaki/digital-square/internal/repository"
 	"github.com/Baaaki/digital-square/internal/service"
+	"github.com/Baaaki/digital-square/internal/utils"
 	"github.com/Baaaki/digital-square/internal/wal"
 	"github.com/Baaaki/digital-square/pkg/logger"
+	"github.com/Baaaki/digital-square/pkg/metrics"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
@@ -29,60 +40,314 @@ func main() {
 	logger.Log.Info("Starting Digital Square Backend")
 
 	cfg := config.Load()
+	if err := cfg.Validate(); err != nil {
+		logger.Log.Fatal("Invalid configuration", zap.Error(err))
+	}
+	if len(cfg.JWTSecret) < config.MinJWTSecretLength {
+		logger.Log.Warn("JWT_SECRET is weak; production refuses to start with it",
+			zap.Int("min_length", config.MinJWTSecretLength))
+	}
 	logger.Log.Info("Config loaded successfully")
 
+	// Cost of new password hashes (fail fast on unsafe values)
+	if err := utils.SetArgon2Params(utils.Argon2Params{
+		Memory:      cfg.Argon2Memory,
+		Iterations:  cfg.Argon2Iterations,
+		Parallelism: cfg.Argon2Parallelism,
+		KeyLength:   cfg.Argon2KeyLength,
+	}); err != nil {
+		logger.Log.Fatal("Invalid Argon2 configuration", zap.Error(err))
+	}
+	// Each hash holds Argon2Memory KiB, so cap how many run at once
+	utils.SetHashConcurrency(cfg.HashConcurrency)
+
+	// Issuer and audience stamped on and required of every JWT
+	utils.SetTokenIdentity(utils.TokenIdentity{
+		Issuer:   cfg.JWTIssuer,
+		Audience: cfg.JWTAudience,
+	})
+
 	database.Connect(cfg)
 	database.Migrate()
 
+	// Prometheus metrics (served on GET /metrics)
+	appMetrics := metrics.NewWithRuntime(prometheus.NewRegistry())
+
 	// Initialize WAL
 	logger.Log.Info("Initializing WAL (Write-Ahead Log)")
-	walInstance, err := wal.NewWAL("./data/wal.log")
+	walInstance, err := wal.NewWALWithConfig(cfg.WALPath, wal.WALConfig{
+		WriteTimeout:    cfg.WALWriteTimeout,
+		MaxSegmentBytes: cfg.WALMaxSegmentBytes,
+		MaxSegments:     cfg.WALMaxSegments,
+		MaxEntryBytes:   cfg.WALMaxEntryBytes,
+	})
 	if err != nil {
 		logger.Log.Fatal("Failed to initialize WAL", zap.Error(err))
 	}
-	defer walInstance.Close()
-	logger.Log.Info("WAL initialized successfully")
+	logger.Log.Info("WAL initialized successfully", zap.String("path", cfg.WALPath))
 
 	// Initialize Redis Broker (cache only for Phase 1-2)
 	logger.Log.Info("Connecting to Redis")
-	redisBroker, err := broker.NewRedisMessageBroker(cfg.RedisURL)
+	brokerConfig := broker.BrokerConfig{
+		MaxRetries:      cfg.RedisMaxRetries,
+		MinRetryBackoff: cfg.RedisMinRetryBackoff,
+		MaxRetryBackoff: cfg.RedisMaxRetryBackoff,
+		DialTimeout:     cfg.RedisDialTimeout,
+		ReadTimeout:     cfg.RedisReadTimeout,
+		WriteTimeout:    cfg.RedisWriteTimeout,
+
+		OperationTimeout: cfg.RedisOpTimeout,
+
+		RecentTTL: cfg.RecentCacheTTL,
+
+		NodeID: cfg.NodeID,
+	}
+	redisBroker, err := broker.NewRedisMessageBroker(cfg.RedisURL, brokerConfig)
 	if err != nil {
 		logger.Log.Fatal("Failed to initialize Redis broker", zap.Error(err))
 	}
-	defer redisBroker.Close()
 	logger.Log.Info("Redis connected successfully")
 
+	// Optional IP geolocation (country/ASN tags for logs and the admin top-IPs view)
+	var geoProvider geoip.Provider = geoip.Nop{}
+	if cfg.GeoIPDatabase != "" {
+		table, err := geoip.LoadCSV(cfg.GeoIPDatabase)
+		if err != nil {
+			logger.Log.Fatal("Failed to load GeoIP database", zap.Error(err))
+		}
+		geoProvider = table
+		logger.Log.Info("GeoIP tagging enabled", zap.Int("networks", table.Len()))
+	}
+
 	// Rate limiter setup
 	rateLimiterConfig := middleware.RateLimiterConfig{
 		MaxRequests: cfg.RateLimitMaxRequests,
 		Window:      cfg.RateLimitWindow,
 		BlockTime:   cfg.RateLimitBlockTime,
+
+		UserMaxRequests: cfg.RateLimitUserMaxRequests,
+		UserWindow:      cfg.RateLimitUserWindow,
+
+		MaxTrackedIPs:       cfg.RateLimitMaxTrackedIPs,
+		MaintenanceInterval: cfg.RateLimitMaintenanceInterval,
+
+		LimitsRefresh: cfg.RateLimitConfigRefresh,
+
+		LimitedResponse: middleware.RejectResponse{
+			Status:      cfg.RateLimitResponseStatus,
+			ContentType: cfg.RateLimitResponseContentType,
+			Body:        cfg.RateLimitResponseBody,
+		},
+		BannedResponse: middleware.RejectResponse{
+			Status:      cfg.IPBanResponseStatus,
+			ContentType: cfg.IPBanResponseContentType,
+			Body:        cfg.IPBanResponseBody,
+		},
+
+		Metrics: appMetrics,
+		Geo:     geoProvider,
 	}
 	rateLimiter := middleware.NewRateLimiter(redisBroker.GetClient(), rateLimiterConfig)
 	logger.Log.Info("Rate limiter initialized",
 		zap.Int("max_requests", cfg.RateLimitMaxRequests),
-		zap.Duration("window", cfg.RateLimitWindow))
+		zap.Duration("window", cfg.RateLimitWindow),
+		zap.Int("user_max_requests", cfg.RateLimitUserMaxRequests),
+		zap.Duration("user_window", cfg.RateLimitUserWindow))
+
+	// Per-user bandwidth budget for WebSocket sends
+	byteBudget := middleware.NewByteBudget(redisBroker.GetClient(), middleware.ByteBudgetConfig{
+		MaxBytes: cfg.ByteBudgetMaxBytes,
+		Window:   cfg.ByteBudgetWindow,
+	})
+
+	// Per-user message rate for WebSocket sends
+	messageRate := middleware.NewMessageRate(redisBroker.GetClient(), middleware.MessageRateConfig{
+		MaxMessages: cfg.MessageRateMax,
+		Window:      cfg.MessageRateWindow,
+	})
+
+	// Process-wide registration cap (many IPs together can still exhaust Argon2 CPU)
+	registrationThrottle := middleware.NewGlobalThrottle(middleware.GlobalThrottleConfig{
+		MaxRequests: cfg.RegistrationRateMax,
+		Window:      cfg.RegistrationRateWindow,
+	})
+
+	// Per-user send rates (top talkers)
+	sendMetrics := middleware.NewSendMetrics(redisBroker.GetClient(), middleware.SendMetricsConfig{
+		Window:         cfg.SendMetricsWindow,
+		MaxTracked:     cfg.SendMetricsMaxTracked,
+		ReportInterval: cfg.SendMetricsReportInterval,
+	})
 
 	// Initialize repositories
 	userRepo := repository.NewUserRepository(database.DB)
-	messageRepo := repository.NewMessageRepository(database.DB)
+	messageRepo := repository.NewMessageRepositoryWithConfig(database.DB, repository.MessageRepositoryConfig{
+		SkipUserPreload: cfg.MessageSkipUserPreload,
+	})
+
+	// Reserved author for announcements
+	if _, err := userRepo.EnsureSystemUser(); err != nil {
+		logger.Log.Fatal("Failed to seed system user", zap.Error(err))
+	}
+	auditRepo := repository.NewAuditLogRepository(database.DB)
+	refreshRepo := repository.NewRefreshTokenRepository(database.DB)
+	verifyRepo := repository.NewVerificationTokenRepository(database.DB)
+
+	// Revoked access tokens (logout, password change), checked by AuthMiddleware
+	tokenDenylist := middleware.NewTokenDenylist(redisBroker.GetClient())
 
 	// Initialize services
-	authService := service.NewAuthService(userRepo, cfg.JWTSecret, 24*time.Hour, cfg.Environment)
-	messageService := service.NewMessageService(messageRepo, redisBroker, walInstance)
+	authService := service.NewAuthService(userRepo, messageRepo, auditRepo, refreshRepo, verifyRepo, redisBroker, cfg.JWTSecret, 24*time.Hour, cfg.Environment, service.AuthServiceConfig{
+		UsernameMinLength: cfg.UsernameMinLength,
+		UsernameMaxLength: cfg.UsernameMaxLength,
+		DefaultRole:       models.Role(cfg.DefaultUserRole),
+		FirstUserAdmin:    cfg.FirstUserAdmin,
+		AccessTokenTTL:    cfg.AccessTokenTTL,
+		RefreshTokenTTL:   cfg.RefreshTokenTTL,
+		TokenRevoker:      tokenDenylist,
+		FailedLoginDelay:  cfg.FailedLoginDelay,
+		LockoutThreshold:  cfg.LockoutThreshold,
+		LockoutDuration:   cfg.LockoutDuration,
+
+		RequireEmailVerification:   cfg.EmailVerificationRequired,
+		VerificationTokenTTL:       cfg.EmailVerificationTTL,
+		VerificationURL:            cfg.EmailVerificationURL,
+		VerificationResendCooldown: cfg.EmailVerificationResendCooldown,
+	})
+	messageConfig := service.MessageServiceConfig{
+		MaxMessageLength:       cfg.MaxMessageLength,
+		MinAccountAge:          cfg.MinAccountAge,
+		TrimWhitespace:         cfg.MessageTrimWhitespace,
+		MaxConsecutiveNewlines: cfg.MessageMaxNewlines,
+		DetectLanguage:         cfg.MessageDetectLanguage,
+
+		ModerationFailOpen: cfg.ModerationFailOpen,
+
+		SearchMaxLimit:    cfg.SearchMaxLimit,
+		SearchMaxIDsLimit: cfg.SearchMaxIDsLimit,
+		SearchMaxOffset:   cfg.SearchMaxOffset,
 
-	// Start batch writer (WAL → PostgreSQL every 1 minute)
-	ctx := context.Background()
+		RoomCountCacheKey: cfg.RoomCountCacheKey,
+		RoomCountCacheTTL: cfg.RoomCountCacheTTL,
+
+		SendIdempotencyTTL: cfg.SendIdempotencyTTL,
+
+		BatchWriterInterval:   cfg.BatchWriterInterval,
+		BatchWriterMaxPending: cfg.BatchWriterMaxPending,
+
+		Metrics: appMetrics,
+	}
+	if cfg.ModerationWebhookURL != "" {
+		messageConfig.Moderator = moderation.NewWebhookModerator(moderation.WebhookConfig{
+			URL:     cfg.ModerationWebhookURL,
+			Timeout: cfg.ModerationTimeout,
+		})
+		logger.Log.Info("Content moderation webhook enabled",
+			zap.Duration("timeout", cfg.ModerationTimeout),
+			zap.Bool("fail_open", cfg.ModerationFailOpen),
+		)
+	}
+	blocklist := cfg.ContentBlocklist
+	if cfg.ContentBlocklistFile != "" {
+		words, err := service.LoadBlocklist(cfg.ContentBlocklistFile)
+		if err != nil {
+			logger.Log.Fatal("Failed to load content blocklist", zap.Error(err))
+		}
+		blocklist = append(blocklist, words...)
+	}
+	if len(blocklist) > 0 {
+		contentFilter := service.NewBlocklistFilter(blocklist, cfg.ContentFilterMode)
+		messageConfig.ContentFilter = contentFilter
+		logger.Log.Info("Content blocklist enabled",
+			zap.Int("words", contentFilter.Len()),
+			zap.String("mode", contentFilter.Mode()),
+		)
+	}
+	messageService := service.NewMessageService(messageRepo, userRepo, redisBroker, walInstance, messageConfig)
+	appMetrics.ObserveCache(
+		func() uint64 { return messageService.CacheStats().Hits },
+		func() uint64 { return messageService.CacheStats().Misses },
+	)
+
+	// Start batch writer (WAL → PostgreSQL every BatchWriterInterval, earlier under load)
+	ctx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
 	messageService.StartBatchWriter(ctx)
 
+	authMiddleware := middleware.AuthMiddleware(cfg.JWTSecret, tokenDenylist)
+
+	// Periodically trim rate limiter bookkeeping in Redis
+	rateLimiter.StartMaintenance(ctx)
+
+	// Periodically log top talkers
+	sendMetrics.StartReporter(ctx)
+
 	// Initialize handlers
-	authHandler := handler.NewAuthHandler(authService)
-	adminHandler := handler.NewAdminHandler(authService)
-	messageHandler := handler.NewMessageHandler(messageService)
-	wsHandler := handler.NewWebSocketHandler(messageService, cfg.JWTSecret)
+	authHandler := handler.NewAuthHandler(authService, tokenDenylist)
+	adminHandler := handler.NewAdminHandler(authService, messageService, byteBudget, sendMetrics, rateLimiter)
+	healthHandler := handler.NewHealthHandler(database.DB, redisBroker.GetClient(), cfg.WALPath)
+	wsHandler := handler.NewWebSocketHandler(messageService, byteBudget, sendMetrics, messageRate, redisBroker, cfg.JWTSecret, cfg.AllowedOrigins, handler.WSConfig{
+		SessionMode:     cfg.WSSessionMode,
+		SessionLifetime: cfg.WSSessionLifetime,
+		SessionMax:      cfg.WSSessionMax,
+		PongWait:        cfg.WSPongWait,
+		PingPeriod:      cfg.WSPingPeriod,
+		WriteWait:       cfg.WSWriteWait,
+
+		MaxMessageSize:  cfg.WSMaxMessageSize,
+		ReadBufferSize:  cfg.WSReadBufferSize,
+		WriteBufferSize: cfg.WSWriteBufferSize,
+
+		SendBufferSize: cfg.WSSendBufferSize,
+
+		MaxConnections:        cfg.WSMaxConnections,
+		MaxConnectionsPerUser: cfg.WSMaxConnectionsPerUser,
+
+		ReconnectBase:   cfg.WSReconnectBase,
+		ReconnectJitter: cfg.WSReconnectJitter,
+
+		EnableCompression:   cfg.WSEnableCompression,
+		MaxDecompressedSize: cfg.WSMaxDecompressedSize,
+
+		PresenceWindow:    cfg.WSPresenceWindow,
+		EnableMessagePack: cfg.WSEnableMessagePack,
+
+		StrictOrigin: cfg.Environment == "production",
+
+		Metrics: appMetrics,
+	})
+	// REST sends share the WebSocket send limits and live delivery
+	messageHandler := handler.NewMessageHandler(messageService, wsHandler)
+
+	// Kick banned users' live connections (ban events come from any node)
+	bannedUsers, err := redisBroker.SubscribeUserBanned(ctx)
+	if err != nil {
+		logger.Log.Fatal("Failed to subscribe to ban events", zap.Error(err))
+	}
+	go wsHandler.WatchBans(bannedUsers)
+
+	// Deliver messages sent, edited or deleted and reactions on other nodes to our clients
+	relayed, err := redisBroker.Subscribe(ctx)
+	if err != nil {
+		logger.Log.Fatal("Failed to subscribe to chat broadcasts", zap.Error(err))
+	}
+	go wsHandler.WatchBroadcasts(relayed)
+
+	// Setup Gin router (RequestLogger replaces gin's default access log)
+	router := gin.New()
+	router.Use(gin.Recovery())
+
+	// Client IP resolution (rate limit keys, logs, geo tags) behind reverse proxies
+	if err := middleware.ConfigureClientIP(router, middleware.ClientIPConfig{
+		Header:         cfg.ClientIPHeader,
+		TrustedProxies: cfg.TrustedProxies,
+	}); err != nil {
+		logger.Log.Fatal("Invalid client IP configuration", zap.Error(err))
+	}
 
-	// Setup Gin router
-	router := gin.Default()
+	// Request IDs, geo tags and structured access logs (ahead of CORS and rate limiting, so rejected requests are logged too)
+	router.Use(middleware.RequestLogger())
+	router.Use(middleware.GeoTagger(geoProvider))
 
 	// Security Headers Middleware (MUST be first for all responses)
 	router.Use(middleware.SecurityHeadersMiddleware())
@@ -92,46 +357,137 @@ func main() {
 
 	// CORS configuration (allow cookies from frontend)
 	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:3000", "http://localhost:3001", "http://localhost:10000"}, // Frontend URL (3000, 3001, or 10000 for Docker)
+		AllowOrigins:     cfg.AllowedOrigins, // Frontend URLs (ALLOWED_ORIGINS; defaults to 3000, 3001, or 10000 for Docker)
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Cookie"},
-		ExposeHeaders:    []string{"Set-Cookie"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Cookie", middleware.RequestIDHeader},
+		ExposeHeaders:    []string{"Set-Cookie", middleware.RequestIDHeader},
 		AllowCredentials: true, // ✅ Cookie'lerin gönderilmesine izin ver
 		MaxAge:           12 * time.Hour,
 	}))
 
-	// Rate limiting middleware (after CORS, before routes)
-	router.Use(rateLimiter.Middleware())
+	// Rate limiting runs per route: by IP on public routes, and after
+	// authMiddleware on protected ones so logged-in users are limited by user ID
+	rateLimit := rateLimiter.Middleware()
+
+	// Health probes (no auth, no rate limiting: probes must never be throttled)
+	router.GET("/healthz", healthHandler.Healthz)
+	router.GET("/readyz", healthHandler.Readyz)
+	router.GET("/metrics", gin.WrapH(appMetrics.Handler()))
 
 	// Public routes
-	router.POST("/api/auth/register", authHandler.Register)
-	router.POST("/api/auth/login", authHandler.Login)
+	router.POST("/api/auth/register", rateLimit, registrationThrottle.Middleware(), authHandler.Register)
+	router.POST("/api/auth/login", rateLimit, authHandler.Login)
+	// Link from the verification email: the token is the credential
+	router.GET("/api/auth/verify", rateLimit, authHandler.VerifyEmail)
+	// Lost verification emails (cooldown per email; same answer for unknown emails)
+	router.POST("/api/auth/resend-verification", rateLimit, authHandler.ResendVerification)
+	// Refresh needs a still-valid access token anyway; the middleware also rejects revoked ones
+	router.POST("/api/auth/refresh", authMiddleware, rateLimit, authHandler.Refresh)
+	// Client limits (max message length), needed before login to render the composer
+	router.GET("/api/config", rateLimit, messageHandler.GetConfig)
 
 	// Protected routes (require JWT)
 	protected := router.Group("/api")
-	protected.Use(middleware.AuthMiddleware(cfg.JWTSecret))
+	protected.Use(authMiddleware, rateLimit)
 	{
+		// Logout (revokes this token only; other devices stay logged in)
+		protected.POST("/auth/logout", authHandler.Logout)
+		// Password change (logs out other devices once their access tokens expire)
+		protected.POST("/auth/change-password", authHandler.ChangePassword)
+
 		// WebSocket connection
 		protected.GET("/ws", wsHandler.HandleWebSocket)
 
+		// Who is online (this node)
+		protected.GET("/presence", wsHandler.GetPresence)
+
 		// Message endpoints
+		protected.POST("/messages", messageHandler.Send)
 		protected.GET("/messages/before/:id", messageHandler.GetBefore)
+		protected.GET("/messages/after/:id", messageHandler.GetAfter)
+		protected.GET("/messages/search", messageHandler.Search)
+
+		// Own deleted messages (self-restore)
+		protected.GET("/users/me/messages/deleted", messageHandler.GetMyDeleted)
+		protected.POST("/users/me/messages/:id/restore", messageHandler.RestoreMine)
+
+		// Mentions feed (unread @mentions, for users who were offline)
+		protected.GET("/notifications", messageHandler.GetNotifications)
+		protected.POST("/notifications/read", messageHandler.MarkNotificationsRead)
 	}
 
 	// Admin routes (require JWT + Admin role)
 	admin := router.Group("/api/admin")
-	admin.Use(middleware.AuthMiddleware(cfg.JWTSecret))
+	admin.Use(authMiddleware, rateLimit)
 	admin.Use(middleware.AdminMiddleware())
 	{
 		admin.GET("/users", adminHandler.GetAllUsers)
 		admin.POST("/ban", adminHandler.BanUser)
 		admin.POST("/ban-bulk", adminHandler.BanBulk)
+		admin.POST("/unban", adminHandler.UnbanUser)
+		admin.POST("/unban-bulk", adminHandler.UnbanBulk)
+		admin.POST("/mute", adminHandler.MuteUser)
+		admin.POST("/unmute", adminHandler.UnmuteUser)
+		admin.GET("/bandwidth", adminHandler.GetBandwidthUsage)
+		admin.GET("/top-talkers", adminHandler.GetTopTalkers)
+		admin.GET("/top-ips", adminHandler.GetTopIPs)
+		admin.GET("/ratelimit/config", adminHandler.GetRateLimitConfig)
+		admin.PUT("/ratelimit/config", adminHandler.UpdateRateLimitConfig)
+		admin.POST("/batch/process", adminHandler.ProcessBatch)
+		admin.POST("/cache/purge", adminHandler.PurgeCache)
+		admin.GET("/cache/stats", adminHandler.GetCacheStats)
+		admin.GET("/messages/search", adminHandler.SearchMessages)
 	}
 
 	// Start server
-	logger.Log.Info("Server starting", zap.String("port", cfg.ServerPort))
-	logger.Log.Info("Direct broadcast mode (single node)")
-	if err := router.Run(cfg.ServerPort); err != nil {
-		logger.Log.Fatal("Failed to start server", zap.Error(err))
+	srv := &http.Server{
+		Addr:    cfg.ServerPort,
+		Handler: router,
+	}
+	go func() {
+		logger.Log.Info("Server starting", zap.String("port", cfg.ServerPort))
+		logger.Log.Info("Multi-node broadcast via Redis Pub/Sub", zap.String("node_id", redisBroker.NodeID()))
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Log.Fatal("Failed to start server", zap.Error(err))
+		}
+	}()
+
+	// On SIGINT/SIGTERM, stop accepting requests and drain everything in flight
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-sigCh
+	logger.Log.Info("Shutdown signal received", zap.String("signal", sig.String()))
+
+	// In-flight HTTP requests (hijacked WebSocket connections are not tracked by the server)
+	graceCtx, cancelGrace := context.WithTimeout(context.Background(), cfg.ShutdownGracePeriod)
+	if err := srv.Shutdown(graceCtx); err != nil {
+		logger.Log.Warn("HTTP server did not drain in time", zap.Error(err))
 	}
+
+	// Close frames tell clients to reconnect to another node; wait for their read
+	// loops so messages already received land in the WAL
+	if err := wsHandler.Shutdown(graceCtx); err != nil {
+		logger.Log.Warn("WebSocket shutdown incomplete", zap.Error(err))
+	}
+	cancelGrace()
+
+	// Stop the batch writer and background jobs, then drain the WAL to PostgreSQL
+	stopBackground()
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), cfg.ShutdownFlushTimeout)
+	if err := messageService.Flush(flushCtx); err != nil {
+		logger.Log.Error("WAL flush on shutdown failed; remaining entries persist on next boot", zap.Error(err))
+	}
+	cancelFlush()
+
+	if err := redisBroker.Close(); err != nil {
+		logger.Log.Warn("Failed to close Redis", zap.Error(err))
+	}
+	if err := walInstance.Close(); err != nil {
+		logger.Log.Warn("Failed to close WAL", zap.Error(err))
+	}
+	if err := database.Close(); err != nil {
+		logger.Log.Warn("Failed to close database", zap.Error(err))
+	}
+
+	logger.Log.Info("Server stopped")
 }
\ No newline at end of file
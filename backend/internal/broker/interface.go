@@ -1,19 +1,77 @@
 package broker
 
-import "github.com/Baaaki/digital-square/internal/models"
+import (
+	"context"
+	"time"
 
-// MessageBroker provides caching for recent messages
-// Phase 1-2: Cache only (single node architecture)
-// Phase 3: Pub/Sub will be added for multi-node communication
+	"github.com/Baaaki/digital-square/internal/models"
+)
+
+// MessageBroker provides caching for recent messages and relays chat events
+// between nodes (Phase 3: multi-node)
 type MessageBroker interface {
-	// Cache operations (Phase 1-2)
-	CacheMessage(msg models.Message) error
-	GetRecentMessages(limit int) ([]models.Message, error)
-	MarkMessageAsDeleted(messageID string, isDeletedByAdmin bool) error
+	// Cache operations (Phase 1-2), one recent-messages list per room
+	CacheMessage(msg models.Message) error                        // Cached in msg.RoomID
+	CacheMessages(msgs []models.Message) error                    // Bulk CacheMessage in one round-trip (msgs newest first)
+	ReplaceRecent(roomID string, messages []models.Message) error // Atomically swap a room's list (messages newest first)
+	GetRecentMessages(roomID string, limit int) ([]models.Message, error)
+	MarkMessageAsDeleted(roomID, messageID string, isDeletedByAdmin bool) error
+	MarkMessageAsRestored(roomID, messageID string) error
+	MarkMessageAsEdited(roomID, messageID, content, lang string, editedAt time.Time) error
+	MarkUserMessagesAsDeleted(userIDs []string) (int, error) // Admin delete in every room (e.g. on ban); returns the count marked
+	PurgeCache() error // Drop cached recent messages of every room; the next read repopulates from PostgreSQL
+
+	// Read receipts (approximate distinct viewers per message, expires)
+	MarkSeen(userID string, messageIDs []string) error
+	GetSeenCounts(messageIDs []string) (map[string]int64, error)
+
+	// Mutes (temporary send bans, expire on their own)
+	MuteUser(userID string, until time.Time) error
+	UnmuteUser(userID string) (bool, error)         // false = user was not muted
+	GetMutedUntil(userID string) (time.Time, error) // Zero time = not muted
+
+	// Login lockout (per email, expire on their own)
+	RecordLoginFailure(email string, threshold int, lockFor time.Duration) (time.Time, error) // Non-zero = threshold reached, locked until then
+	ResetLoginFailures(email string) error
+	GetLoginLockedUntil(email string) (time.Time, error) // Zero time = not locked
+
+	// Verification email resends (per email, expire on their own)
+	ClaimVerificationResend(email string, cooldown time.Duration) (time.Duration, error) // 0 = claimed; otherwise the remaining cooldown
+
+	// Idempotent sends (per user and client message key, expire on their own)
+	ClaimSend(userID, key string, msg models.Message, ttl time.Duration) (*models.Message, error) // nil = claimed; otherwise the message sent first
+	ReleaseSend(userID, key string) error                                                         // Drop a claim whose send failed, so a retry can go through
+	GetSend(userID, key string) (*models.Message, error)                                          // nil = no send recorded for the key
+
+	// Cached counts (short-lived aggregates such as room totals)
+	GetCachedCount(key string) (int64, bool, error) // false = miss
+	SetCachedCount(key string, count int64, ttl time.Duration) error
+
+	// Account events (pub/sub, delivered to every node)
+	PublishUserBanned(userID string) error
+	SubscribeUserBanned(ctx context.Context) (<-chan string, error)
+
+	// Chat events (pub/sub, delivered to every other node). The message's
+	// state says what happened: DeletedAt set = deleted, EditedAt set = edited,
+	// otherwise new.
+	Publish(msg models.Message) error
+	PublishReaction(event ReactionEvent) error
+	Subscribe(ctx context.Context) (<-chan BroadcastEvent, error)
 
 	Close() error
+}
+
+// BroadcastEvent is a chat event relayed from another node: a message event,
+// or a reaction count change when Reaction is set
+type BroadcastEvent struct {
+	Message  models.Message
+	Reaction *ReactionEvent
+}
 
-	// Phase 3: Uncomment for multi-node deployment
-	// Publish(msg Message) error
-	// Subscribe() (<-chan Message, error)
+// ReactionEvent is an emoji's new reaction count on a message
+type ReactionEvent struct {
+	MessageID string `json:"message_id"`
+	RoomID    string `json:"room_id"`
+	Emoji     string `json:"emoji"`
+	Count     int64  `json:"count"`
 }
\ No newline at end of file
@@ -3,25 +3,131 @@ package broker
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
 
 	"github.com/Baaaki/digital-square/internal/models"
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 )
 
-// RedisMessageBroker implements MessageBroker interface for caching
-// Phase 1-2: Cache only (single node)
-// Phase 3: Pub/Sub will be added for multi-node deployment
+// userBannedChannel carries IDs of banned users to every node
+const userBannedChannel = "events:user_banned"
+
+// broadcastChannel relays new, edited and deleted messages and reaction counts between nodes
+const broadcastChannel = "chat:broadcast"
+
+// broadcastEnvelope is the payload on broadcastChannel. The publishing node
+// has already delivered the event to its own clients, so it skips it.
+type broadcastEnvelope struct {
+	NodeID   string         `json:"node_id"`
+	Message  models.Message `json:"message"`
+	Reaction *ReactionEvent `json:"reaction,omitempty"` // Set for reaction count changes (Message is empty)
+}
+
+const (
+	// CacheSchemaVersion must be bumped whenever the cached models.Message JSON
+	// shape changes. Entries written in an older format live under an older key
+	// and are ignored (cold cache, re-warmed from PostgreSQL) instead of being
+	// deserialized into incomplete structs.
+	// v3: per-room lists, messages carry RoomID
+	CacheSchemaVersion = 3
+
+	legacyRecentKey = "global:recent" // Single global list, used before rooms (v1-v2)
+
+	recentCacheSize = 100 // Messages kept per room list
+)
+
+const (
+	seenKeyPrefix = "seen:"        // HyperLogLog of viewer user IDs per message
+	seenTTL       = 24 * time.Hour // Seen counts are ephemeral; refreshed on each read receipt
+)
+
+const mutedKeyPrefix = "muted:" // Mute expiry per user ID, TTL'd to the expiry itself
+
+const (
+	loginFailsKeyPrefix  = "login_fails:"  // Consecutive failed logins per email
+	loginLockedKeyPrefix = "login_locked:" // Lockout expiry per email, TTL'd to the expiry itself
+)
+
+const verifyResendKeyPrefix = "verify_resend:" // Resend cooldown per email, TTL'd to the cooldown
+
+const sentKeyPrefix = "sent:" // Message sent per user and client message key (sent:<user>:<key>)
+
+// RedisMessageBroker implements MessageBroker interface for caching and
+// multi-node pub/sub
 type RedisMessageBroker struct {
-	client *redis.Client
-	ctx    context.Context
+	client       *redis.Client
+	ctx          context.Context
+	timeout      time.Duration // Per-operation deadline (0 = none)
+	cacheVersion int           // Cache schema version used in recent-messages keys
+	recentTTL    time.Duration // Expiry of recent-messages lists, refreshed on write (0 = none)
+	nodeID       string        // Identifies this node's events on broadcastChannel
 }
 
-func NewRedisMessageBroker(redisURL string) (*RedisMessageBroker, error) {
+// BrokerConfig holds Redis client retry and timeout settings.
+// Zero values keep the go-redis defaults (or values set in the URL).
+type BrokerConfig struct {
+	MaxRetries      int           // Retries per command (-1 disables retries)
+	MinRetryBackoff time.Duration // Initial backoff between retries
+	MaxRetryBackoff time.Duration // Backoff cap; go-redis applies jitter up to this
+	DialTimeout     time.Duration
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+
+	// OperationTimeout bounds each broker call (including retries) so a
+	// hung Redis can't stall callers (0 = no per-call deadline)
+	OperationTimeout time.Duration
+
+	// RecentTTL expires a room's recent-messages list this long after its
+	// last write, so a list nobody updates (crash, idle room) is reloaded
+	// from PostgreSQL instead of served forever (0 = no expiry)
+	RecentTTL time.Duration
+
+	// NodeID identifies this node on the broadcast channel (empty = random per process)
+	NodeID string
+}
+
+// NewRedisOptions parses the Redis URL and applies the broker config on top
+func NewRedisOptions(redisURL string, config BrokerConfig) (*redis.Options, error) {
 	opt, err := redis.ParseURL(redisURL)
 	if err != nil {
 		return nil, err
 	}
 
+	if config.MaxRetries != 0 {
+		opt.MaxRetries = config.MaxRetries
+	}
+	if config.MinRetryBackoff > 0 {
+		opt.MinRetryBackoff = config.MinRetryBackoff
+	}
+	if config.MaxRetryBackoff > 0 {
+		opt.MaxRetryBackoff = config.MaxRetryBackoff
+	}
+	if config.DialTimeout > 0 {
+		opt.DialTimeout = config.DialTimeout
+	}
+	if config.ReadTimeout > 0 {
+		opt.ReadTimeout = config.ReadTimeout
+	}
+	if config.WriteTimeout > 0 {
+		opt.WriteTimeout = config.WriteTimeout
+	}
+	if config.OperationTimeout > 0 {
+		// Let context deadlines cut blocking socket reads/writes short
+		opt.ContextTimeoutEnabled = true
+	}
+
+	return opt, nil
+}
+
+func NewRedisMessageBroker(redisURL string, config BrokerConfig) (*RedisMessageBroker, error) {
+	opt, err := NewRedisOptions(redisURL, config)
+	if err != nil {
+		return nil, err
+	}
+
 	client := redis.NewClient(opt)
 
 	ctx := context.Background()
@@ -29,33 +135,163 @@ func NewRedisMessageBroker(redisURL string) (*RedisMessageBroker, error) {
 		return nil, err
 	}
 
+	nodeID := config.NodeID
+	if nodeID == "" {
+		nodeID = uuid.NewString()
+	}
+
 	return &RedisMessageBroker{
-		client: client,
-		ctx:    ctx,
+		client:       client,
+		ctx:          ctx,
+		timeout:      config.OperationTimeout,
+		cacheVersion: CacheSchemaVersion,
+		recentTTL:    config.RecentTTL,
+		nodeID:       nodeID,
 	}, nil
 }
 
+// RecentCacheKey names a room's recent-messages list for the current cache schema version
+func RecentCacheKey(roomID string) string {
+	return recentCacheKey(roomID, CacheSchemaVersion)
+}
+
+// recentCacheKey names a room's recent-messages list (room:<id>:recent:v<version>).
+// Messages without a room belong to models.DefaultRoomID.
+func recentCacheKey(roomID string, version int) string {
+	if roomID == "" {
+		roomID = models.DefaultRoomID
+	}
+	return fmt.Sprintf("room:%s:recent:v%d", roomID, version)
+}
+
+// recentKey names a room's recent-messages list for this broker's cache version
+func (r *RedisMessageBroker) recentKey(roomID string) string {
+	return recentCacheKey(roomID, r.cacheVersion)
+}
+
+// opContext returns a context bounded by the per-operation timeout
+func (r *RedisMessageBroker) opContext() (context.Context, context.CancelFunc) {
+	if r.timeout <= 0 {
+		return context.WithCancel(r.ctx)
+	}
+	return context.WithTimeout(r.ctx, r.timeout)
+}
+
 func (r *RedisMessageBroker) Close() error {
 	return r.client.Close()
 }
 
-// CacheMessage stores message in Redis list (last 100 messages)
+// CacheMessage stores message in its room's Redis list (last 100 messages)
 func (r *RedisMessageBroker) CacheMessage(msg models.Message) error {
-	data, err := json.Marshal(msg)
-	if err != nil {
-		return err
+	return r.CacheMessages([]models.Message{msg})
+}
+
+// CacheMessages stores messages (newest first) in their rooms' Redis lists
+// with one LPUSH and one LTRIM per room, all in a single round-trip. The
+// lists end up as if each message had been cached in turn, oldest first.
+// It adds to what is cached, so it suits new messages only: warming a room
+// from PostgreSQL goes through ReplaceRecent, which can't duplicate entries
+// already in the list.
+func (r *RedisMessageBroker) CacheMessages(msgs []models.Message) error {
+	if len(msgs) == 0 {
+		return nil
 	}
 
-	if err := r.client.LPush(r.ctx, "global:recent", data).Err(); err != nil {
-		return err
+	ctx, cancel := r.opContext()
+	defer cancel()
+
+	// LPUSH puts its last argument at the head, so push oldest first
+	var keys []string
+	values := make(map[string][]interface{})
+	for i := len(msgs) - 1; i >= 0; i-- {
+		data, err := json.Marshal(msgs[i])
+		if err != nil {
+			return err
+		}
+		key := r.recentKey(msgs[i].RoomID)
+		if _, seen := values[key]; !seen {
+			keys = append(keys, key)
+		}
+		values[key] = append(values[key], data)
+	}
+
+	pipe := r.client.Pipeline()
+	for _, key := range keys {
+		pipe.LPush(ctx, key, values[key]...)
+		pipe.LTrim(ctx, key, 0, recentCacheSize-1)
+		if r.recentTTL > 0 {
+			pipe.Expire(ctx, key, r.recentTTL)
+		}
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// ReplaceRecent swaps a room's cached list for the given messages (newest first).
+// The new list is built in a temp key and RENAMEd over the live one in a single
+// transaction, so readers see either the old or the new list, never an empty one.
+func (r *RedisMessageBroker) ReplaceRecent(roomID string, messages []models.Message) error {
+	ctx, cancel := r.opContext()
+	defer cancel()
+
+	key := r.recentKey(roomID)
+	if len(messages) > recentCacheSize {
+		messages = messages[:recentCacheSize]
+	}
+	if len(messages) == 0 {
+		return r.client.Del(ctx, key).Err()
+	}
+
+	values := make([]interface{}, 0, len(messages))
+	for _, msg := range messages {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		values = append(values, data)
 	}
 
-	return r.client.LTrim(r.ctx, "global:recent", 0, 99).Err()
+	tmpKey := fmt.Sprintf("%s:tmp:%d", key, time.Now().UnixNano())
+	pipe := r.client.TxPipeline()
+	pipe.RPush(ctx, tmpKey, values...) // Head stays newest, like CacheMessage's LPUSH
+	if r.recentTTL > 0 {
+		pipe.Expire(ctx, tmpKey, r.recentTTL) // RENAME carries the expiry over
+	}
+	pipe.Rename(ctx, tmpKey, key)
+	_, err := pipe.Exec(ctx)
+	return err
 }
 
-// GetRecentMessages retrieves last N messages from Redis cache
-func (r *RedisMessageBroker) GetRecentMessages(limit int) ([]models.Message, error) {
-	results, err := r.client.LRange(r.ctx, "global:recent", 0, int64(limit-1)).Result()
+// PurgeCache deletes the recent-messages cache of every room, including lists
+// left over from older cache schema versions.
+// The next GetRecentMessages misses and the service re-warms it from PostgreSQL.
+func (r *RedisMessageBroker) PurgeCache() error {
+	ctx, cancel := r.opContext()
+	defer cancel()
+
+	var keys []string
+	for _, pattern := range []string{"room:*:recent*", legacyRecentKey + "*"} {
+		iter := r.client.Scan(ctx, 0, pattern, 100).Iterator()
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+	return r.client.Del(ctx, keys...).Err()
+}
+
+// GetRecentMessages retrieves a room's last N messages from Redis cache
+func (r *RedisMessageBroker) GetRecentMessages(roomID string, limit int) ([]models.Message, error) {
+	ctx, cancel := r.opContext()
+	defer cancel()
+
+	results, err := r.client.LRange(ctx, r.recentKey(roomID), 0, int64(limit-1)).Result()
 	if err != nil {
 		return nil, err
 	}
@@ -74,40 +310,497 @@ func (r *RedisMessageBroker) GetRecentMessages(limit int) ([]models.Message, err
 
 // MarkMessageAsDeleted marks a message as deleted in Redis cache (soft delete)
 // This allows admins to see deleted messages from cache
-func (r *RedisMessageBroker) MarkMessageAsDeleted(messageID string, isDeletedByAdmin bool) error {
-	// 1. Get all cached messages
-	results, err := r.client.LRange(r.ctx, "global:recent", 0, -1).Result()
-	if err != nil {
-		return err
-	}
+func (r *RedisMessageBroker) MarkMessageAsDeleted(roomID, messageID string, isDeletedByAdmin bool) error {
+	ctx, cancel := r.opContext()
+	defer cancel()
 
-	// 2. Find and update the matching message
-	for i, data := range results {
-		var msg models.Message
-		if err := json.Unmarshal([]byte(data), &msg); err != nil {
-			continue
+	// A message not in the cache (expired or not cached yet) is left alone
+	_, err := r.updateCachedMessages(ctx, r.recentKey(roomID), func(msg *models.Message) bool {
+		if msg.MessageID != messageID {
+			return false
 		}
+		msg.DeletedAt.Valid = true
+		msg.IsDeletedByAdmin = isDeletedByAdmin
+		return true
+	})
+	return err
+}
+
+// MarkUserMessagesAsDeleted marks every cached message of the given users as
+// deleted by an admin, across all rooms of the current cache version
+func (r *RedisMessageBroker) MarkUserMessagesAsDeleted(userIDs []string) (int, error) {
+	ctx, cancel := r.opContext()
+	defer cancel()
+
+	targets := make(map[string]struct{}, len(userIDs))
+	for _, id := range userIDs {
+		targets[id] = struct{}{}
+	}
+
+	var keys []string
+	iter := r.client.Scan(ctx, 0, recentCacheKey("*", r.cacheVersion), 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return 0, err
+	}
 
-		// Found the message to delete
-		if msg.MessageID == messageID {
-			// Update message fields (soft delete)
+	marked := 0
+	for _, key := range keys {
+		updated, err := r.updateCachedMessages(ctx, key, func(msg *models.Message) bool {
+			if _, ok := targets[msg.UserID.String()]; !ok || msg.DeletedAt.Valid {
+				return false
+			}
 			msg.DeletedAt.Valid = true
-			msg.IsDeletedByAdmin = isDeletedByAdmin
+			msg.IsDeletedByAdmin = true
+			return true
+		})
+		marked += updated
+		if err != nil {
+			return marked, err
+		}
+	}
+
+	return marked, nil
+}
+
+// MarkMessageAsRestored clears the deleted flags of a cached message
+func (r *RedisMessageBroker) MarkMessageAsRestored(roomID, messageID string) error {
+	ctx, cancel := r.opContext()
+	defer cancel()
+
+	_, err := r.updateCachedMessages(ctx, r.recentKey(roomID), func(msg *models.Message) bool {
+		if msg.MessageID != messageID {
+			return false
+		}
+		msg.DeletedAt.Valid = false
+		msg.DeletedBy = nil
+		msg.IsDeletedByAdmin = false
+		return true
+	})
+	return err
+}
+
+// MarkMessageAsEdited replaces the content (and language tag) of a cached message and stamps its edit time
+func (r *RedisMessageBroker) MarkMessageAsEdited(roomID, messageID, content, lang string, editedAt time.Time) error {
+	ctx, cancel := r.opContext()
+	defer cancel()
+
+	_, err := r.updateCachedMessages(ctx, r.recentKey(roomID), func(msg *models.Message) bool {
+		if msg.MessageID != messageID {
+			return false
+		}
+		msg.Content = content
+		msg.Lang = lang
+		msg.EditedAt = &editedAt
+		return true
+	})
+	return err
+}
 
-			// Re-serialize the updated message
-			updatedData, err := json.Marshal(msg)
+// maxCacheUpdateAttempts bounds updateCachedMessages' retries while a list
+// keeps changing under it
+const maxCacheUpdateAttempts = 5
+
+// updateCachedMessages rewrites the messages of a cached list that update
+// changes (update returns false to leave one as is) and returns how many it
+// rewrote. Messages are written back by index, so the list is WATCHed: a
+// push, trim or replace in between aborts the write and the update is
+// retried on the new list instead of landing on the wrong message.
+func (r *RedisMessageBroker) updateCachedMessages(ctx context.Context, key string, update func(msg *models.Message) bool) (int, error) {
+	for attempt := 0; attempt < maxCacheUpdateAttempts; attempt++ {
+		updated := 0
+		err := r.client.Watch(ctx, func(tx *redis.Tx) error {
+			results, err := tx.LRange(ctx, key, 0, -1).Result()
 			if err != nil {
 				return err
 			}
 
-			// Update in Redis: Remove old, insert updated at same position
-			// Note: Redis LSET requires index, so we use position i
-			return r.client.LSet(r.ctx, "global:recent", int64(i), updatedData).Err()
+			changed := make(map[int64][]byte)
+			for i, data := range results {
+				var msg models.Message
+				if err := json.Unmarshal([]byte(data), &msg); err != nil {
+					continue
+				}
+				if !update(&msg) {
+					continue
+				}
+				updatedData, err := json.Marshal(msg)
+				if err != nil {
+					return err
+				}
+				changed[int64(i)] = updatedData
+			}
+			if len(changed) == 0 {
+				return nil
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				for i, data := range changed {
+					pipe.LSet(ctx, key, i, data)
+				}
+				return nil
+			})
+			if err == nil {
+				updated = len(changed)
+			}
+			return err
+		}, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return updated, err
+		}
+	}
+	return 0, redis.TxFailedErr
+}
+
+// MarkSeen adds the user to each message's viewer set.
+// Uses HyperLogLog, so memory per message is bounded (~12 KB) and counts are approximate.
+func (r *RedisMessageBroker) MarkSeen(userID string, messageIDs []string) error {
+	ctx, cancel := r.opContext()
+	defer cancel()
+
+	pipe := r.client.Pipeline()
+	for _, messageID := range messageIDs {
+		key := seenKeyPrefix + messageID
+		pipe.PFAdd(ctx, key, userID)
+		pipe.Expire(ctx, key, seenTTL)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// GetSeenCounts returns the approximate distinct viewer count per message
+// (messages nobody has seen are omitted)
+func (r *RedisMessageBroker) GetSeenCounts(messageIDs []string) (map[string]int64, error) {
+	ctx, cancel := r.opContext()
+	defer cancel()
+
+	pipe := r.client.Pipeline()
+	cmds := make([]*redis.IntCmd, len(messageIDs))
+	for i, messageID := range messageIDs {
+		cmds[i] = pipe.PFCount(ctx, seenKeyPrefix+messageID)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(messageIDs))
+	for i, cmd := range cmds {
+		if n := cmd.Val(); n > 0 {
+			counts[messageIDs[i]] = n
+		}
+	}
+	return counts, nil
+}
+
+// MuteUser stores a mute that Redis expires at until (no cleanup job needed)
+func (r *RedisMessageBroker) MuteUser(userID string, until time.Time) error {
+	ttl := time.Until(until)
+	if ttl <= 0 {
+		return nil
+	}
+
+	ctx, cancel := r.opContext()
+	defer cancel()
+
+	return r.client.Set(ctx, mutedKeyPrefix+userID, until.UTC().Format(time.RFC3339Nano), ttl).Err()
+}
+
+// UnmuteUser lifts a mute early, reporting whether one was active
+func (r *RedisMessageBroker) UnmuteUser(userID string) (bool, error) {
+	ctx, cancel := r.opContext()
+	defer cancel()
+
+	n, err := r.client.Del(ctx, mutedKeyPrefix+userID).Result()
+	return n > 0, err
+}
+
+// GetMutedUntil returns when the user's mute expires (zero time if not muted)
+func (r *RedisMessageBroker) GetMutedUntil(userID string) (time.Time, error) {
+	ctx, cancel := r.opContext()
+	defer cancel()
+
+	value, err := r.client.Get(ctx, mutedKeyPrefix+userID).Result()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// RecordLoginFailure counts a failed login for email. Once threshold
+// consecutive failures are reached the email is locked for lockFor and the
+// count starts over. The count itself expires lockFor after the last failure,
+// so occasional typos never add up to a lockout.
+func (r *RedisMessageBroker) RecordLoginFailure(email string, threshold int, lockFor time.Duration) (time.Time, error) {
+	ctx, cancel := r.opContext()
+	defer cancel()
+
+	key := loginFailsKeyPrefix + email
+	pipe := r.client.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, lockFor)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return time.Time{}, err
+	}
+	if incr.Val() < int64(threshold) {
+		return time.Time{}, nil
+	}
+
+	until := time.Now().Add(lockFor)
+	pipe = r.client.TxPipeline()
+	pipe.Set(ctx, loginLockedKeyPrefix+email, until.UTC().Format(time.RFC3339Nano), lockFor)
+	pipe.Del(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return time.Time{}, err
+	}
+	return until, nil
+}
+
+// ResetLoginFailures clears email's failure count after a successful login
+func (r *RedisMessageBroker) ResetLoginFailures(email string) error {
+	ctx, cancel := r.opContext()
+	defer cancel()
+
+	return r.client.Del(ctx, loginFailsKeyPrefix+email).Err()
+}
+
+// GetLoginLockedUntil returns when email's lockout ends (zero time if not locked)
+func (r *RedisMessageBroker) GetLoginLockedUntil(email string) (time.Time, error) {
+	ctx, cancel := r.opContext()
+	defer cancel()
+
+	value, err := r.client.Get(ctx, loginLockedKeyPrefix+email).Result()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// ClaimVerificationResend starts email's resend cooldown, unless one is
+// already running; then the time left on it is returned
+func (r *RedisMessageBroker) ClaimVerificationResend(email string, cooldown time.Duration) (time.Duration, error) {
+	ctx, cancel := r.opContext()
+	defer cancel()
+
+	key := verifyResendKeyPrefix + email
+	claimed, err := r.client.SetNX(ctx, key, 1, cooldown).Result()
+	if err != nil || claimed {
+		return 0, err
+	}
+
+	remaining, err := r.client.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if remaining <= 0 {
+		// Expired in between: the cooldown is over
+		return 0, nil
+	}
+	return remaining, nil
+}
+
+// ClaimSend records msg as the send for the user's client message key, unless
+// one is already recorded; then that message is returned and msg is not stored
+func (r *RedisMessageBroker) ClaimSend(userID, key string, msg models.Message, ttl time.Duration) (*models.Message, error) {
+	ctx, cancel := r.opContext()
+	defer cancel()
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	redisKey := sentKeyPrefix + userID + ":" + key
+	claimed, err := r.client.SetNX(ctx, redisKey, data, ttl).Result()
+	if err != nil || claimed {
+		return nil, err
+	}
+
+	existing, err := r.client.Get(ctx, redisKey).Result()
+	if err == redis.Nil {
+		// Expired in between: too old to count as a retry
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var original models.Message
+	if err := json.Unmarshal([]byte(existing), &original); err != nil {
+		return nil, err
+	}
+	return &original, nil
+}
+
+// ReleaseSend drops the user's claim on a client message key
+func (r *RedisMessageBroker) ReleaseSend(userID, key string) error {
+	ctx, cancel := r.opContext()
+	defer cancel()
+
+	return r.client.Del(ctx, sentKeyPrefix+userID+":"+key).Err()
+}
+
+// GetSend returns the message recorded for the user's client message key,
+// without claiming it
+func (r *RedisMessageBroker) GetSend(userID, key string) (*models.Message, error) {
+	ctx, cancel := r.opContext()
+	defer cancel()
+
+	data, err := r.client.Get(ctx, sentKeyPrefix+userID+":"+key).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var msg models.Message
+	if err := json.Unmarshal([]byte(data), &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// GetCachedCount returns a count stored by SetCachedCount (false on a miss)
+func (r *RedisMessageBroker) GetCachedCount(key string) (int64, bool, error) {
+	ctx, cancel := r.opContext()
+	defer cancel()
+
+	count, err := r.client.Get(ctx, key).Int64()
+	if err == redis.Nil {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return count, true, nil
+}
+
+// SetCachedCount stores a count that Redis drops after ttl
+func (r *RedisMessageBroker) SetCachedCount(key string, count int64, ttl time.Duration) error {
+	ctx, cancel := r.opContext()
+	defer cancel()
+
+	return r.client.Set(ctx, key, count, ttl).Err()
+}
+
+// PublishUserBanned announces a ban so every node can drop the user's connections
+func (r *RedisMessageBroker) PublishUserBanned(userID string) error {
+	ctx, cancel := r.opContext()
+	defer cancel()
+
+	return r.client.Publish(ctx, userBannedChannel, userID).Err()
+}
+
+// SubscribeUserBanned streams banned user IDs until ctx is cancelled
+func (r *RedisMessageBroker) SubscribeUserBanned(ctx context.Context) (<-chan string, error) {
+	pubsub := r.client.Subscribe(ctx, userBannedChannel)
+
+	// Wait for the subscription to be confirmed so no event is missed
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	userIDs := make(chan string)
+	go func() {
+		defer close(userIDs)
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				select {
+				case userIDs <- msg.Payload:
+				case <-ctx.Done():
+					return
+				}
+			}
 		}
+	}()
+
+	return userIDs, nil
+}
+
+// NodeID returns the ID this node publishes broadcasts under
+func (r *RedisMessageBroker) NodeID() string {
+	return r.nodeID
+}
+
+// Publish relays a message event to the other nodes
+func (r *RedisMessageBroker) Publish(msg models.Message) error {
+	msg.User = models.User{} // Only the denormalized author fields travel
+
+	return r.publishEnvelope(broadcastEnvelope{NodeID: r.nodeID, Message: msg})
+}
+
+// PublishReaction relays a reaction count change to the other nodes
+func (r *RedisMessageBroker) PublishReaction(event ReactionEvent) error {
+	return r.publishEnvelope(broadcastEnvelope{NodeID: r.nodeID, Reaction: &event})
+}
+
+func (r *RedisMessageBroker) publishEnvelope(envelope broadcastEnvelope) error {
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := r.opContext()
+	defer cancel()
+
+	return r.client.Publish(ctx, broadcastChannel, payload).Err()
+}
+
+// Subscribe streams chat events published by other nodes until ctx is
+// cancelled. This node's own events and undecodable payloads are skipped.
+func (r *RedisMessageBroker) Subscribe(ctx context.Context) (<-chan BroadcastEvent, error) {
+	pubsub := r.client.Subscribe(ctx, broadcastChannel)
+
+	// Wait for the subscription to be confirmed so no event is missed
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
 	}
 
-	// Message not found in cache (already expired or not cached yet)
-	return nil
+	events := make(chan BroadcastEvent)
+	go func() {
+		defer close(events)
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				var envelope broadcastEnvelope
+				if err := json.Unmarshal([]byte(msg.Payload), &envelope); err != nil || envelope.NodeID == r.nodeID {
+					continue
+				}
+				select {
+				case events <- BroadcastEvent{Message: envelope.Message, Reaction: envelope.Reaction}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return events, nil
 }
 
 // GetClient returns the underlying Redis client (for rate limiter and other utilities)
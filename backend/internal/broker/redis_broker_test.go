@@ -0,0 +1,571 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Baaaki/digital-square/internal/models"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestNewRedisOptionsAppliesConfig tests that retry and timeout settings
+// reach the go-redis options
+func TestNewRedisOptionsAppliesConfig(t *testing.T) {
+	opt, err := NewRedisOptions("redis://localhost:6379/0", BrokerConfig{
+		MaxRetries:      7,
+		MinRetryBackoff: 20 * time.Millisecond,
+		MaxRetryBackoff: 2 * time.Second,
+		DialTimeout:     4 * time.Second,
+		ReadTimeout:     1500 * time.Millisecond,
+		WriteTimeout:    2500 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "localhost:6379", opt.Addr)
+	assert.Equal(t, 7, opt.MaxRetries)
+	assert.Equal(t, 20*time.Millisecond, opt.MinRetryBackoff)
+	assert.Equal(t, 2*time.Second, opt.MaxRetryBackoff)
+	assert.Equal(t, 4*time.Second, opt.DialTimeout)
+	assert.Equal(t, 1500*time.Millisecond, opt.ReadTimeout)
+	assert.Equal(t, 2500*time.Millisecond, opt.WriteTimeout)
+}
+
+// TestNewRedisOptionsZeroConfigKeepsURLValues tests that an empty config
+// doesn't override settings from the URL
+func TestNewRedisOptionsZeroConfigKeepsURLValues(t *testing.T) {
+	opt, err := NewRedisOptions("redis://localhost:6379/0?max_retries=5&dial_timeout=9s", BrokerConfig{})
+	require.NoError(t, err)
+
+	assert.Equal(t, 5, opt.MaxRetries)
+	assert.Equal(t, 9*time.Second, opt.DialTimeout)
+}
+
+// TestNewRedisOptionsInvalidURL tests URL parse errors are returned
+func TestNewRedisOptionsInvalidURL(t *testing.T) {
+	_, err := NewRedisOptions("not-a-url", BrokerConfig{})
+	assert.Error(t, err)
+}
+
+// newUnresponsiveBroker returns a broker whose Redis accepts connections
+// but never replies
+func newUnresponsiveBroker(t *testing.T, timeout time.Duration) *RedisMessageBroker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			// Swallow commands without answering
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+
+	opt, err := NewRedisOptions("redis://"+ln.Addr().String(), BrokerConfig{
+		MaxRetries:       -1,
+		ReadTimeout:      10 * time.Second,
+		OperationTimeout: timeout,
+	})
+	require.NoError(t, err)
+
+	client := redis.NewClient(opt)
+	t.Cleanup(func() { client.Close() })
+
+	return &RedisMessageBroker{client: client, ctx: context.Background(), timeout: timeout, cacheVersion: CacheSchemaVersion}
+}
+
+// TestOperationTimeoutWithUnresponsiveRedis tests that broker calls give up
+// after the per-operation timeout instead of waiting on a hung Redis
+func TestOperationTimeoutWithUnresponsiveRedis(t *testing.T) {
+	b := newUnresponsiveBroker(t, 100*time.Millisecond)
+
+	start := time.Now()
+	err := b.CacheMessage(models.Message{MessageID: "m1", Content: "hello"})
+	elapsed := time.Since(start)
+
+	// go-redis reports the deadline as a net timeout
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	assert.True(t, netErr.Timeout())
+	assert.Less(t, elapsed, 2*time.Second, "call must not wait for the 10s read timeout")
+
+	start = time.Now()
+	_, err = b.GetRecentMessages(models.DefaultRoomID, 10)
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+// TestCacheSchemaVersionBumpStartsCold tests that entries written under an older
+// cache schema version are ignored rather than deserialized into the new shape
+func TestCacheSchemaVersionBumpStartsCold(t *testing.T) {
+	mr := miniredis.RunT(t)
+	b, err := NewRedisMessageBroker("redis://"+mr.Addr(), BrokerConfig{})
+	require.NoError(t, err)
+	defer b.Close()
+
+	// A node still running the previous format fills its cache
+	old := *b
+	old.cacheVersion = CacheSchemaVersion - 1
+	require.NoError(t, old.CacheMessage(models.Message{MessageID: "old-1", Content: "old format"}))
+
+	// Entries from before versioning, in a shape the current struct can't read
+	mr.Lpush(legacyRecentKey, `{"message_id":"legacy","content":{"text":"nested"}}`)
+
+	messages, err := b.GetRecentMessages(models.DefaultRoomID, 10)
+	require.NoError(t, err)
+	assert.Empty(t, messages, "Version bump must start with a cold cache")
+
+	// The current version caches and reads as usual
+	require.NoError(t, b.CacheMessage(models.Message{MessageID: "new-1", Content: "new format"}))
+	messages, err = b.GetRecentMessages(models.DefaultRoomID, 10)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "new-1", messages[0].MessageID)
+
+	// Purging also drops the stale lists
+	require.NoError(t, b.PurgeCache())
+	assert.False(t, mr.Exists(legacyRecentKey))
+	assert.False(t, mr.Exists(old.recentKey(models.DefaultRoomID)))
+	assert.False(t, mr.Exists(b.recentKey(models.DefaultRoomID)))
+}
+
+// TestRecentMessagesArePerRoom tests that each room has its own cached list
+func TestRecentMessagesArePerRoom(t *testing.T) {
+	mr := miniredis.RunT(t)
+	b, err := NewRedisMessageBroker("redis://"+mr.Addr(), BrokerConfig{})
+	require.NoError(t, err)
+	defer b.Close()
+
+	require.NoError(t, b.CacheMessage(models.Message{MessageID: "g-1", Content: "hi all"})) // No room = general
+	require.NoError(t, b.CacheMessage(models.Message{MessageID: "r-1", RoomID: "random", Content: "hi random"}))
+	assert.True(t, mr.Exists("room:random:recent:v3"))
+
+	general, err := b.GetRecentMessages(models.DefaultRoomID, 10)
+	require.NoError(t, err)
+	require.Len(t, general, 1)
+	assert.Equal(t, "g-1", general[0].MessageID)
+
+	random, err := b.GetRecentMessages("random", 10)
+	require.NoError(t, err)
+	require.Len(t, random, 1)
+	assert.Equal(t, "r-1", random[0].MessageID)
+
+	// Cache updates only look in the given room
+	require.NoError(t, b.MarkMessageAsDeleted(models.DefaultRoomID, "r-1", false))
+	random, err = b.GetRecentMessages("random", 10)
+	require.NoError(t, err)
+	assert.False(t, random[0].DeletedAt.Valid)
+
+	require.NoError(t, b.MarkMessageAsDeleted("random", "r-1", false))
+	random, err = b.GetRecentMessages("random", 10)
+	require.NoError(t, err)
+	assert.True(t, random[0].DeletedAt.Valid)
+
+	// Purge clears every room
+	require.NoError(t, b.PurgeCache())
+	assert.False(t, mr.Exists(RecentCacheKey(models.DefaultRoomID)))
+	assert.False(t, mr.Exists(RecentCacheKey("random")))
+}
+
+// TestReplaceRecentIsAtomic tests that concurrent readers never see an empty
+// list while the cache is being replaced (best effort: many swaps under load)
+func TestReplaceRecentIsAtomic(t *testing.T) {
+	mr := miniredis.RunT(t)
+	b, err := NewRedisMessageBroker("redis://"+mr.Addr(), BrokerConfig{})
+	require.NoError(t, err)
+	defer b.Close()
+
+	batch := func(gen int) []models.Message {
+		messages := make([]models.Message, 50)
+		for i := range messages {
+			messages[i] = models.Message{MessageID: fmt.Sprintf("gen%d-%d", gen, i), Content: "hi"}
+		}
+		return messages
+	}
+	require.NoError(t, b.ReplaceRecent(models.DefaultRoomID, batch(0)))
+
+	var emptyReads, reads atomic.Int64
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				messages, err := b.GetRecentMessages(models.DefaultRoomID, recentCacheSize)
+				if err != nil {
+					continue
+				}
+				reads.Add(1)
+				if len(messages) == 0 {
+					emptyReads.Add(1)
+				}
+			}
+		}()
+	}
+
+	for gen := 1; gen <= 200; gen++ {
+		require.NoError(t, b.ReplaceRecent(models.DefaultRoomID, batch(gen)))
+	}
+	close(stop)
+	wg.Wait()
+
+	assert.Positive(t, reads.Load())
+	assert.Zero(t, emptyReads.Load(), "Readers must never observe an empty cache")
+
+	// Final list is the last batch, newest first, with no temp keys left behind
+	messages, err := b.GetRecentMessages(models.DefaultRoomID, recentCacheSize)
+	require.NoError(t, err)
+	require.Len(t, messages, 50)
+	assert.Equal(t, "gen200-0", messages[0].MessageID)
+	assert.Equal(t, []string{RecentCacheKey(models.DefaultRoomID)}, mr.Keys())
+}
+
+// roundTrips counts commands and pipelines sent to Redis (one network round-trip each)
+type roundTrips struct {
+	n atomic.Int64
+}
+
+func (h *roundTrips) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h *roundTrips) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		h.n.Add(1)
+		return next(ctx, cmd)
+	}
+}
+
+func (h *roundTrips) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		h.n.Add(1)
+		return next(ctx, cmds)
+	}
+}
+
+// warmupMessages returns a full recent-messages list, newest first
+func warmupMessages() []models.Message {
+	messages := make([]models.Message, recentCacheSize)
+	for i := range messages {
+		messages[i] = models.Message{MessageID: fmt.Sprintf("msg-%03d", len(messages)-i), Content: "hi"}
+	}
+	return messages
+}
+
+// TestWarmupIsOneRoundTrip tests that warming the cache with ReplaceRecent
+// yields the same list as caching message by message, in a single round-trip
+// instead of one per message
+func TestWarmupIsOneRoundTrip(t *testing.T) {
+	mr := miniredis.RunT(t)
+	b, err := NewRedisMessageBroker("redis://"+mr.Addr(), BrokerConfig{RecentTTL: time.Hour})
+	require.NoError(t, err)
+	defer b.Close()
+	counter := &roundTrips{}
+	b.client.AddHook(counter)
+
+	messages := warmupMessages()
+
+	// Per message, oldest first so the newest ends up at the head
+	for i := len(messages) - 1; i >= 0; i-- {
+		require.NoError(t, b.CacheMessage(messages[i]))
+	}
+	perMessage, err := b.GetRecentMessages(models.DefaultRoomID, recentCacheSize)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(messages)+1), counter.n.Load(), "One pipeline per message, plus the read")
+
+	counter.n.Store(0)
+	require.NoError(t, b.ReplaceRecent(models.DefaultRoomID, messages))
+	assert.Equal(t, int64(1), counter.n.Load())
+
+	pipelined, err := b.GetRecentMessages(models.DefaultRoomID, recentCacheSize)
+	require.NoError(t, err)
+	assert.Equal(t, perMessage, pipelined)
+	assert.Equal(t, "msg-100", pipelined[0].MessageID)
+	assert.Positive(t, mr.TTL(RecentCacheKey(models.DefaultRoomID)))
+}
+
+// TestCacheMessagesBulk tests that a bulk cache keeps each room's list newest
+// first and trimmed, in a single round-trip
+func TestCacheMessagesBulk(t *testing.T) {
+	mr := miniredis.RunT(t)
+	b, err := NewRedisMessageBroker("redis://"+mr.Addr(), BrokerConfig{RecentTTL: time.Hour})
+	require.NoError(t, err)
+	defer b.Close()
+
+	require.NoError(t, b.CacheMessage(models.Message{MessageID: "old", Content: "hi"}))
+
+	// Newest first, two rooms interleaved, more than fit in a list
+	var msgs []models.Message
+	for i := recentCacheSize + 5; i >= 1; i-- {
+		msgs = append(msgs, models.Message{MessageID: fmt.Sprintf("g-%03d", i), Content: "hi"})
+		if i <= 3 {
+			msgs = append(msgs, models.Message{MessageID: fmt.Sprintf("r-%d", i), RoomID: "random", Content: "hi"})
+		}
+	}
+	counter := &roundTrips{}
+	b.client.AddHook(counter)
+	require.NoError(t, b.CacheMessages(msgs))
+	assert.Equal(t, int64(1), counter.n.Load())
+	require.NoError(t, b.CacheMessages(nil))
+
+	general, err := b.GetRecentMessages(models.DefaultRoomID, recentCacheSize+10)
+	require.NoError(t, err)
+	require.Len(t, general, recentCacheSize, "older entries are trimmed")
+	assert.Equal(t, "g-105", general[0].MessageID)
+	assert.Equal(t, "g-104", general[1].MessageID)
+	assert.Equal(t, "g-006", general[recentCacheSize-1].MessageID)
+
+	random, err := b.GetRecentMessages("random", 10)
+	require.NoError(t, err)
+	require.Len(t, random, 3)
+	assert.Equal(t, []string{"r-3", "r-2", "r-1"}, []string{random[0].MessageID, random[1].MessageID, random[2].MessageID})
+	assert.Positive(t, mr.TTL(RecentCacheKey("random")))
+}
+
+// BenchmarkWarmup compares warming a room's cache message by message, in bulk
+// with CacheMessages, and with the ReplaceRecent swap used on a cache miss
+func BenchmarkWarmup(b *testing.B) {
+	mr := miniredis.RunT(b)
+	broker, err := NewRedisMessageBroker("redis://"+mr.Addr(), BrokerConfig{RecentTTL: time.Hour})
+	require.NoError(b, err)
+	defer broker.Close()
+
+	messages := warmupMessages()
+
+	b.Run("PerMessage", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for i := len(messages) - 1; i >= 0; i-- {
+				if err := broker.CacheMessage(messages[i]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("Bulk", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			if err := broker.CacheMessages(messages); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Pipelined", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			if err := broker.ReplaceRecent(models.DefaultRoomID, messages); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// TestPublishSubscribeSkipsOwnNode tests that a node receives message events
+// published by other nodes but not its own
+func TestPublishSubscribeSkipsOwnNode(t *testing.T) {
+	mr := miniredis.RunT(t)
+	nodeA, err := NewRedisMessageBroker("redis://"+mr.Addr(), BrokerConfig{NodeID: "node-a"})
+	require.NoError(t, err)
+	defer nodeA.Close()
+	nodeB, err := NewRedisMessageBroker("redis://"+mr.Addr(), BrokerConfig{NodeID: "node-b"})
+	require.NoError(t, err)
+	defer nodeB.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	eventsA, err := nodeA.Subscribe(ctx)
+	require.NoError(t, err)
+	eventsB, err := nodeB.Subscribe(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, nodeA.Publish(models.Message{MessageID: "from-a", RoomID: "random", Content: "hi", User: models.User{PasswordHash: "secret"}}))
+	require.NoError(t, nodeB.Publish(models.Message{MessageID: "from-b", RoomID: "random", Content: "hello"}))
+
+	select {
+	case event := <-eventsB:
+		assert.Equal(t, "from-a", event.Message.MessageID)
+		assert.Equal(t, "random", event.Message.RoomID)
+		assert.Empty(t, event.Message.User.PasswordHash, "Author record is not relayed")
+		assert.Nil(t, event.Reaction)
+	case <-time.After(time.Second):
+		t.Fatal("node B did not receive node A's message")
+	}
+
+	// A's first event is B's message: its own was skipped
+	select {
+	case event := <-eventsA:
+		assert.Equal(t, "from-b", event.Message.MessageID)
+	case <-time.After(time.Second):
+		t.Fatal("node A did not receive node B's message")
+	}
+
+	select {
+	case event := <-eventsB:
+		t.Fatalf("node B received its own message %q", event.Message.MessageID)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Reaction counts travel the same way
+	require.NoError(t, nodeA.PublishReaction(ReactionEvent{MessageID: "from-b", RoomID: "random", Emoji: "👍", Count: 2}))
+	select {
+	case event := <-eventsB:
+		assert.Equal(t, &ReactionEvent{MessageID: "from-b", RoomID: "random", Emoji: "👍", Count: 2}, event.Reaction)
+	case <-time.After(time.Second):
+		t.Fatal("node B did not receive node A's reaction")
+	}
+}
+
+// TestMuteExpiresOnItsOwn tests that a mute reads back its expiry and
+// disappears once its TTL passes, with no cleanup
+func TestMuteExpiresOnItsOwn(t *testing.T) {
+	mr := miniredis.RunT(t)
+	b, err := NewRedisMessageBroker("redis://"+mr.Addr(), BrokerConfig{})
+	require.NoError(t, err)
+	defer b.Close()
+
+	until := time.Now().Add(10 * time.Minute)
+	require.NoError(t, b.MuteUser("user-1", until))
+
+	got, err := b.GetMutedUntil("user-1")
+	require.NoError(t, err)
+	assert.WithinDuration(t, until, got, time.Millisecond)
+
+	got, err = b.GetMutedUntil("user-2")
+	require.NoError(t, err)
+	assert.True(t, got.IsZero(), "Users without a mute aren't muted")
+
+	mr.FastForward(11 * time.Minute)
+	got, err = b.GetMutedUntil("user-1")
+	require.NoError(t, err)
+	assert.True(t, got.IsZero(), "Mute must expire with its TTL")
+
+	wasMuted, err := b.UnmuteUser("user-1")
+	require.NoError(t, err)
+	assert.False(t, wasMuted)
+}
+
+// TestMarkUserMessagesAsDeleted tests that a ban's cache update marks the
+// users' messages in every room and leaves other messages alone
+func TestMarkUserMessagesAsDeleted(t *testing.T) {
+	mr := miniredis.RunT(t)
+	b, err := NewRedisMessageBroker("redis://"+mr.Addr(), BrokerConfig{})
+	require.NoError(t, err)
+	defer b.Close()
+
+	banned, other := uuid.New(), uuid.New()
+	for _, msg := range []models.Message{
+		{MessageID: "g1", UserID: banned, RoomID: "general"},
+		{MessageID: "g2", UserID: other, RoomID: "general"},
+		{MessageID: "r1", UserID: banned, RoomID: "random"},
+	} {
+		require.NoError(t, b.CacheMessage(msg))
+	}
+
+	marked, err := b.MarkUserMessagesAsDeleted([]string{banned.String()})
+	require.NoError(t, err)
+	assert.Equal(t, 2, marked)
+
+	deleted := map[string]bool{}
+	for _, room := range []string{"general", "random"} {
+		messages, err := b.GetRecentMessages(room, 10)
+		require.NoError(t, err)
+		for _, msg := range messages {
+			deleted[msg.MessageID] = msg.DeletedAt.Valid && msg.IsDeletedByAdmin
+		}
+	}
+	assert.Equal(t, map[string]bool{"g1": true, "g2": false, "r1": true}, deleted)
+}
+
+// pushAfterRead is a redis.Hook that caches a new message through another
+// connection right after the first LRANGE, between a cache update's read and
+// its write
+type pushAfterRead struct {
+	other *RedisMessageBroker
+	msg   models.Message
+	done  atomic.Bool
+}
+
+func (h *pushAfterRead) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h *pushAfterRead) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func (h *pushAfterRead) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := next(ctx, cmd)
+		if cmd.Name() == "lrange" && h.done.CompareAndSwap(false, true) {
+			if pushErr := h.other.CacheMessage(h.msg); pushErr != nil {
+				return pushErr
+			}
+		}
+		return err
+	}
+}
+
+// TestCacheUpdatesRaceWithPushes tests that a cache update racing a new
+// message updates the intended message, not the one that moved into its
+// old position in the list
+func TestCacheUpdatesRaceWithPushes(t *testing.T) {
+	mr := miniredis.RunT(t)
+	b, err := NewRedisMessageBroker("redis://"+mr.Addr(), BrokerConfig{})
+	require.NoError(t, err)
+	defer b.Close()
+	other, err := NewRedisMessageBroker("redis://"+mr.Addr(), BrokerConfig{})
+	require.NoError(t, err)
+	defer other.Close()
+
+	author := uuid.New()
+	update := func(name string, target models.Message, mark func() error) []models.Message {
+		target.MessageID, target.UserID, target.RoomID = "target", author, "general"
+		require.NoError(t, b.ReplaceRecent("general", []models.Message{target}))
+		b.client.AddHook(&pushAfterRead{other: other, msg: models.Message{MessageID: "newer", RoomID: "general", Content: "newer"}})
+		require.NoError(t, mark(), name)
+
+		messages, err := b.GetRecentMessages("general", 10)
+		require.NoError(t, err)
+		require.Len(t, messages, 2, name)
+		assert.Equal(t, "newer", messages[0].MessageID, name)
+		assert.Equal(t, "newer", messages[0].Content, "%s overwrote the newer message", name)
+		assert.False(t, messages[0].DeletedAt.Valid, name)
+		return messages
+	}
+
+	messages := update("edit", models.Message{Content: "original"}, func() error {
+		return b.MarkMessageAsEdited("general", "target", "edited", "en", time.Now())
+	})
+	assert.Equal(t, "edited", messages[1].Content)
+
+	messages = update("delete", models.Message{}, func() error {
+		return b.MarkMessageAsDeleted("general", "target", true)
+	})
+	assert.True(t, messages[1].DeletedAt.Valid)
+
+	messages = update("ban", models.Message{}, func() error {
+		_, err := b.MarkUserMessagesAsDeleted([]string{author.String()})
+		return err
+	})
+	assert.True(t, messages[1].DeletedAt.Valid)
+
+	deleted := models.Message{}
+	deleted.DeletedAt.Valid = true
+	messages = update("restore", deleted, func() error {
+		return b.MarkMessageAsRestored("general", "target")
+	})
+	assert.False(t, messages[1].DeletedAt.Valid)
+}
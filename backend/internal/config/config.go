@@ -1,10 +1,13 @@
 package config
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/joho/godotenv"
 )
@@ -16,12 +19,169 @@ type Config struct {
 	ServerPort  string
 	Environment string
 	JWTExpiry   time.Duration
-	WALPath     string
+
+	// JWT iss/aud, so tokens of another deployment sharing the secret are
+	// rejected (empty = not set or checked; setting them logs everyone out once)
+	JWTIssuer   string
+	JWTAudience string
+
+	// Token refresh
+	AccessTokenTTL  time.Duration // Lifetime of access tokens issued by /api/auth/refresh
+	RefreshTokenTTL time.Duration // Lifetime of a single-use refresh token
+	WALPath         string
+
+	// WAL
+	WALWriteTimeout    time.Duration // Max time for a WAL write+sync before SendMessage fails
+	WALMaxSegmentBytes int64         // Rotate to a new WAL segment past this size
+	WALMaxSegments     int           // Force a batch flush once this many segments exist
+	WALMaxEntryBytes   int           // Longest WAL line written or read back on recovery
+
+	// Batch writer (WAL → PostgreSQL); messages sit only in the local WAL for at most the interval
+	BatchWriterInterval   time.Duration // Periodic flush
+	BatchWriterMaxPending int           // Flush early once this many sends are pending
+
+	// Shutdown
+	ShutdownFlushTimeout time.Duration // Max time to drain the WAL to PostgreSQL on SIGTERM
+	ShutdownGracePeriod  time.Duration // Max time for in-flight HTTP requests and WebSocket closes on SIGTERM
+
+	// Redis client (retry backoff is exponential with jitter between min and max)
+	RedisMaxRetries      int
+	RedisMinRetryBackoff time.Duration
+	RedisMaxRetryBackoff time.Duration
+	RedisDialTimeout     time.Duration
+	RedisReadTimeout     time.Duration
+	RedisWriteTimeout    time.Duration
+	RedisOpTimeout       time.Duration // Deadline for each broker call
+
+	// Recent-messages cache
+	RecentCacheTTL time.Duration // A room's list expires this long after its last write (0 = never)
+
+	// Multi-node broadcast (Redis Pub/Sub)
+	NodeID string // Identifies this node's broadcasts (empty = random per process)
 
 	// Rate limiting
 	RateLimitMaxRequests int
 	RateLimitWindow      time.Duration
 	RateLimitBlockTime   time.Duration
+
+	// Per-user rate limiting for authenticated requests (replaces the IP limit once logged in)
+	RateLimitUserMaxRequests int
+	RateLimitUserWindow      time.Duration
+
+	// Rate limiter maintenance (bounds Redis memory)
+	RateLimitMaxTrackedIPs       int
+	RateLimitMaintenanceInterval time.Duration
+
+	// How often limits changed at runtime (admin API) are re-read from Redis
+	RateLimitConfigRefresh time.Duration
+
+	// Rejection response overrides (empty body = default JSON)
+	RateLimitResponseStatus      int
+	RateLimitResponseBody        string
+	RateLimitResponseContentType string
+	IPBanResponseStatus          int
+	IPBanResponseBody            string
+	IPBanResponseContentType     string
+
+	// Accounts
+	UsernameMinLength int
+	UsernameMaxLength int
+	DefaultUserRole   string        // Role for new registrations ("user" or "admin")
+	FirstUserAdmin    bool          // Bootstrap: the very first registered account becomes admin
+	FailedLoginDelay  time.Duration // Fixed slowdown on every failed login (0 = disabled)
+	LockoutThreshold  int           // Consecutive failed logins per email before a lockout (0 = disabled)
+	LockoutDuration   time.Duration // How long a locked-out email is refused
+
+	// Email verification
+	EmailVerificationRequired       bool          // New accounts can read but not send until the email is verified
+	EmailVerificationTTL            time.Duration // Lifetime of a verification link
+	EmailVerificationURL            string        // Public URL of GET /api/auth/verify used in the emailed link (empty = relative path)
+	EmailVerificationResendCooldown time.Duration // Min time between resend requests per email
+
+	// Password hashing (Argon2id) for new hashes; existing ones are upgraded on login
+	Argon2Memory      int // KiB
+	Argon2Iterations  int
+	Argon2Parallelism int
+	Argon2KeyLength   int // Bytes
+	HashConcurrency   int // Hashes computed at once, the rest queue (0 = number of CPUs)
+
+	// Messaging
+	MaxMessageLength       int           // Max message content length in characters (Unicode-aware), also served to clients
+	MinAccountAge          time.Duration // Account age required before first message (0 = disabled)
+	MessageTrimWhitespace  bool          // Trim leading/trailing whitespace before validation
+	MessageMaxNewlines     int           // Collapse longer runs of blank lines to this many newlines (0 = disabled)
+	MessageDetectLanguage  bool          // Tag each message with a detected language
+	MessageSkipUserPreload bool          // History reads use only the denormalized username (no users join; names may be stale)
+
+	// External moderation webhook
+	ModerationWebhookURL string        // POST endpoint returning allow/block/flag (empty = disabled)
+	ModerationTimeout    time.Duration // Max time per webhook call
+	ModerationFailOpen   bool          // Accept messages when the webhook fails or times out
+
+	// Word blocklist (env list and/or file, merged; empty = disabled)
+	ContentBlocklist     []string // Blocked words, matched case-insensitively as whole words
+	ContentBlocklistFile string   // One word per line, # comments
+	ContentFilterMode    string   // "reject" (default) or "mask" (replace with asterisks)
+
+	// Optional IP geolocation for logs and the admin top-IPs view
+	GeoIPDatabase string // Local CSV of network,country,asn rows (empty = disabled)
+
+	// Admin message search caps
+	SearchMaxLimit    int // Max messages per page (larger limits are clamped)
+	SearchMaxIDsLimit int // Max IDs per page when only IDs are requested
+	SearchMaxOffset   int // Max pagination offset (deeper pages are rejected)
+
+	// Room totals returned by history pages with include_total
+	RoomCountCacheKey string        // Redis key prefix, room ID appended (empty = "room_count:")
+	RoomCountCacheTTL time.Duration // How long a cached total is reused
+
+	// Idempotent sends: how long a client message key dedupes retries in Redis
+	SendIdempotencyTTL time.Duration
+
+	// Browser origins allowed by CORS and the WebSocket upgrade
+	AllowedOrigins []string
+
+	// Client IP resolution behind reverse proxies (rate limiting and logs)
+	TrustedProxies []string // Proxy IPs/CIDRs allowed to set the client IP header (empty = Gin default)
+	ClientIPHeader string   // Header to trust, e.g. X-Real-IP (empty = Gin default: X-Forwarded-For, then X-Real-IP)
+
+	// WebSocket
+	WSSessionMode           string        // "fixed" (hard cap), "sliding" (reset by activity, capped by WSSessionMax) or "off"
+	WSSessionLifetime       time.Duration // Connections are closed after this long (idle time in sliding mode)
+	WSSessionMax            time.Duration // Absolute cap in sliding mode
+	WSPongWait              time.Duration // Read deadline, extended by every pong
+	WSPingPeriod            time.Duration // Ping interval (must be below WSPongWait)
+	WSWriteWait             time.Duration // Time allowed to write a frame
+	WSMaxMessageSize        int64         // Max inbound frame size on the wire
+	WSReadBufferSize        int           // Upgrader read buffer
+	WSWriteBufferSize       int           // Upgrader write buffer
+	WSSendBufferSize        int           // Outbound frames queued per client before it's dropped as a slow consumer
+	WSMaxConnections        int           // Open connections per node (0 = unlimited)
+	WSMaxConnectionsPerUser int           // Open connections per user (0 = unlimited)
+
+	WSReconnectBase       time.Duration // Suggested reconnect delay on server-initiated close
+	WSReconnectJitter     time.Duration // Random jitter added to the reconnect delay
+	WSEnableCompression   bool          // Negotiate permessage-deflate
+	WSMaxDecompressedSize int64         // Max size of an inbound message after decompression
+	WSPresenceWindow      time.Duration // Coalesce joins/leaves into one presence_delta per window
+	WSEnableMessagePack   bool          // Offer the msgpack subprotocol (JSON stays the default)
+
+	// Per-user bandwidth budget (WebSocket sends)
+	ByteBudgetMaxBytes int64
+	ByteBudgetWindow   time.Duration
+
+	// Per-user message rate (WebSocket sends)
+	MessageRateMax    int
+	MessageRateWindow time.Duration
+
+	// Process-wide registration throttle (protects hashing CPU from mass signups)
+	RegistrationRateMax    int // 0 = disabled
+	RegistrationRateWindow time.Duration
+
+	// Per-user send rates (top talkers for abuse dashboards)
+	SendMetricsWindow         time.Duration
+	SendMetricsMaxTracked     int
+	SendMetricsReportInterval time.Duration // Log top talkers this often (0 = disabled)
 }
 
 func Load() *Config {
@@ -38,15 +198,144 @@ func Load() *Config {
 		log.Fatal("Invalid JWT_EXPIRY format")
 	}
 
+	accessTokenTTL := getEnvAsDuration("ACCESS_TOKEN_TTL", "15m")
+	refreshTokenTTL := getEnvAsDuration("REFRESH_TOKEN_TTL", "168h")
+
+	// WAL file used by the server, /readyz, doctor and walreplay
 	walPath := os.Getenv("WAL_PATH")
 	if walPath == "" {
-		walPath = "data/wal_messages"
+		walPath = "./data/wal.log"
 	}
 
+	walWriteTimeout := getEnvAsDuration("WAL_WRITE_TIMEOUT", "5s")
+	walMaxSegment := getEnvAsInt("WAL_MAX_SEGMENT_BYTES", 64<<20)
+	walMaxSegments := getEnvAsInt("WAL_MAX_SEGMENTS", 8)
+	walMaxEntry := getEnvAsInt("WAL_MAX_ENTRY_BYTES", 1<<20)
+	batchWriterInterval := getEnvAsDuration("BATCH_WRITER_INTERVAL", "1m")
+	batchWriterMaxPending := getEnvAsInt("BATCH_WRITER_MAX_PENDING", 500)
+	shutdownFlushTimeout := getEnvAsDuration("SHUTDOWN_FLUSH_TIMEOUT", "30s")
+	shutdownGracePeriod := getEnvAsDuration("SHUTDOWN_GRACE_PERIOD", "15s")
+
+	// Redis client defaults (match go-redis defaults)
+	redisMaxRetries := getEnvAsInt("REDIS_MAX_RETRIES", 3)
+	redisMinBackoff := getEnvAsDuration("REDIS_MIN_RETRY_BACKOFF", "8ms")
+	redisMaxBackoff := getEnvAsDuration("REDIS_MAX_RETRY_BACKOFF", "512ms")
+	redisDialTimeout := getEnvAsDuration("REDIS_DIAL_TIMEOUT", "5s")
+	redisReadTimeout := getEnvAsDuration("REDIS_READ_TIMEOUT", "3s")
+	redisWriteTimeout := getEnvAsDuration("REDIS_WRITE_TIMEOUT", "3s")
+	redisOpTimeout := getEnvAsDuration("REDIS_OPERATION_TIMEOUT", "2s")
+
+	// Recent-messages cache TTL (well above quiet periods, so it only
+	// expires once writes have stopped)
+	recentCacheTTL := getEnvAsDuration("RECENT_CACHE_TTL", "24h")
+
 	// Rate limiting defaults
 	rateLimitMax := getEnvAsInt("RATE_LIMIT_MAX_REQUESTS", 100)
 	rateLimitWindow := getEnvAsDuration("RATE_LIMIT_WINDOW", "1m")
 	rateLimitBlock := getEnvAsDuration("RATE_LIMIT_BLOCK_TIME", "5m")
+	rateLimitTrackedIPs := getEnvAsInt("RATE_LIMIT_MAX_TRACKED_IPS", 1000)
+	rateLimitMaintenance := getEnvAsDuration("RATE_LIMIT_MAINTENANCE_INTERVAL", "1m")
+	rateLimitUserMax := getEnvAsInt("RATE_LIMIT_USER_MAX_REQUESTS", 100)
+	rateLimitUserWindow := getEnvAsDuration("RATE_LIMIT_USER_WINDOW", "1m")
+	rateLimitConfigRefresh := getEnvAsDuration("RATE_LIMIT_CONFIG_REFRESH", "5s")
+
+	// Account defaults (max is capped by the varchar(50) username column)
+	usernameMin := getEnvAsInt("USERNAME_MIN_LENGTH", 3)
+	usernameMax := getEnvAsInt("USERNAME_MAX_LENGTH", 50)
+	if usernameMax > 50 {
+		log.Printf("USERNAME_MAX_LENGTH %d exceeds column size, using 50", usernameMax)
+		usernameMax = 50
+	}
+	defaultUserRole := os.Getenv("DEFAULT_USER_ROLE")
+	if defaultUserRole == "" {
+		defaultUserRole = "user"
+	}
+	if defaultUserRole != "user" && defaultUserRole != "admin" {
+		log.Printf("Invalid DEFAULT_USER_ROLE %q, using user", defaultUserRole)
+		defaultUserRole = "user"
+	}
+	firstUserAdmin := getEnvAsBool("FIRST_USER_ADMIN", false)
+	failedLoginDelay := getEnvAsDuration("FAILED_LOGIN_DELAY", "0s")
+	lockoutThreshold := getEnvAsInt("LOGIN_LOCKOUT_THRESHOLD", 5)
+	lockoutDuration := getEnvAsDuration("LOGIN_LOCKOUT_DURATION", "15m")
+	emailVerificationRequired := getEnvAsBool("EMAIL_VERIFICATION_REQUIRED", true)
+	emailVerificationTTL := getEnvAsDuration("EMAIL_VERIFICATION_TTL", "24h")
+	emailVerificationResendCooldown := getEnvAsDuration("EMAIL_VERIFICATION_RESEND_COOLDOWN", "2m")
+
+	// Argon2 defaults match utils.DefaultArgon2Params; bounds are checked at startup
+	argon2Memory := getEnvAsInt("ARGON2_MEMORY", 64*1024)
+	argon2Iterations := getEnvAsInt("ARGON2_ITERATIONS", 1)
+	argon2Parallelism := getEnvAsInt("ARGON2_PARALLELISM", 4)
+	argon2KeyLength := getEnvAsInt("ARGON2_KEY_LENGTH", 32)
+	hashConcurrency := getEnvAsInt("HASH_CONCURRENCY", 0)
+
+	// Messaging defaults
+	maxMessageLength := getEnvAsInt("MAX_MESSAGE_LENGTH", 5000)
+	minAccountAge := getEnvAsDuration("MIN_ACCOUNT_AGE", "0s")
+	messageTrim := getEnvAsBool("MESSAGE_TRIM_WHITESPACE", true)
+	messageMaxNewlines := getEnvAsInt("MESSAGE_MAX_CONSECUTIVE_NEWLINES", 2)
+	messageDetectLang := getEnvAsBool("MESSAGE_DETECT_LANGUAGE", false)
+	messageSkipUserPreload := getEnvAsBool("MESSAGE_SKIP_USER_PRELOAD", false)
+
+	// Moderation defaults (fail open so an outage doesn't stop the chat)
+	moderationTimeout := getEnvAsDuration("MODERATION_TIMEOUT", "2s")
+	moderationFailOpen := getEnvAsBool("MODERATION_FAIL_OPEN", true)
+
+	// Word blocklist (comma-separated)
+	contentBlocklist := getEnvAsList("CONTENT_BLOCKLIST", nil)
+
+	// Search caps (deep OFFSET pages get expensive)
+	searchMaxLimit := getEnvAsInt("SEARCH_MAX_LIMIT", 100)
+	searchMaxIDsLimit := getEnvAsInt("SEARCH_MAX_IDS_LIMIT", 1000)
+	searchMaxOffset := getEnvAsInt("SEARCH_MAX_OFFSET", 10000)
+
+	// Room total cache TTL (a COUNT per history page adds up)
+	roomCountCacheTTL := getEnvAsDuration("ROOM_COUNT_CACHE_TTL", "30s")
+
+	// Client retries after a missed ACK arrive within seconds to minutes
+	sendIdempotencyTTL := getEnvAsDuration("SEND_IDEMPOTENCY_TTL", "10m")
+
+	// Allowed origins (comma-separated; defaults to the local frontends)
+	allowedOrigins := getEnvAsList("ALLOWED_ORIGINS", []string{"http://localhost:3000", "http://localhost:3001", "http://localhost:10000"})
+
+	// Trusted proxies (comma-separated IPs or CIDRs)
+	trustedProxies := getEnvAsList("TRUSTED_PROXIES", nil)
+
+	// WebSocket defaults
+	wsSessionLifetime := getEnvAsDuration("WS_SESSION_LIFETIME", "15m")
+	wsSessionMax := getEnvAsDuration("WS_SESSION_MAX", "24h")
+	wsPongWait := getEnvAsDuration("WS_PONG_WAIT", "60s")
+	wsPingPeriod := getEnvAsDuration("WS_PING_PERIOD", "54s")
+	wsWriteWait := getEnvAsDuration("WS_WRITE_WAIT", "10s")
+	wsMaxMessageSize := getEnvAsInt("WS_MAX_MESSAGE_SIZE", 512*1024)
+	wsReadBuffer := getEnvAsInt("WS_READ_BUFFER_SIZE", 4096)
+	wsWriteBuffer := getEnvAsInt("WS_WRITE_BUFFER_SIZE", 4096)
+	wsSendBuffer := getEnvAsInt("WS_SEND_BUFFER_SIZE", 256)
+	wsMaxConnections := getEnvAsInt("WS_MAX_CONNECTIONS", 0)
+	wsMaxConnectionsPerUser := getEnvAsInt("WS_MAX_CONNECTIONS_PER_USER", 0)
+	wsReconnectBase := getEnvAsDuration("WS_RECONNECT_BASE", "1s")
+	wsReconnectJitter := getEnvAsDuration("WS_RECONNECT_JITTER", "5s")
+	wsCompression := getEnvAsBool("WS_ENABLE_COMPRESSION", false)
+	wsMaxDecompressed := getEnvAsInt("WS_MAX_DECOMPRESSED_SIZE", 512*1024)
+	wsPresenceWindow := getEnvAsDuration("WS_PRESENCE_WINDOW", "500ms")
+	wsMessagePack := getEnvAsBool("WS_ENABLE_MSGPACK", true)
+
+	// Byte budget defaults (512 KB per minute per user)
+	byteBudgetMax := getEnvAsInt("BYTE_BUDGET_MAX_BYTES", 512*1024)
+	byteBudgetWindow := getEnvAsDuration("BYTE_BUDGET_WINDOW", "1m")
+
+	// Message rate defaults (10 messages per 10 seconds per user)
+	messageRateMax := getEnvAsInt("MESSAGE_RATE_MAX", 10)
+	messageRateWindow := getEnvAsDuration("MESSAGE_RATE_WINDOW", "10s")
+
+	// Registration throttle defaults (disabled; e.g. 60 per minute per node)
+	registrationRateMax := getEnvAsInt("REGISTRATION_RATE_MAX", 0)
+	registrationRateWindow := getEnvAsDuration("REGISTRATION_RATE_WINDOW", "1m")
+
+	// Send metrics defaults
+	sendMetricsWindow := getEnvAsDuration("SEND_METRICS_WINDOW", "5m")
+	sendMetricsMaxTracked := getEnvAsInt("SEND_METRICS_MAX_TRACKED", 1000)
+	sendMetricsReport := getEnvAsDuration("SEND_METRICS_REPORT_INTERVAL", "1m")
 
 	cfg := &Config{
 		DatabaseURL: os.Getenv("DATABASE_URL"),
@@ -57,14 +346,173 @@ func Load() *Config {
 		JWTExpiry:   expiry,
 		WALPath:     walPath,
 
+		AccessTokenTTL:  accessTokenTTL,
+		RefreshTokenTTL: refreshTokenTTL,
+
+		JWTIssuer:   os.Getenv("JWT_ISSUER"),
+		JWTAudience: os.Getenv("JWT_AUDIENCE"),
+
+		WALWriteTimeout:    walWriteTimeout,
+		WALMaxSegmentBytes: int64(walMaxSegment),
+		WALMaxSegments:     walMaxSegments,
+		WALMaxEntryBytes:   walMaxEntry,
+
+		BatchWriterInterval:   batchWriterInterval,
+		BatchWriterMaxPending: batchWriterMaxPending,
+
+		ShutdownFlushTimeout: shutdownFlushTimeout,
+		ShutdownGracePeriod:  shutdownGracePeriod,
+
+		RedisMaxRetries:      redisMaxRetries,
+		RedisMinRetryBackoff: redisMinBackoff,
+		RedisMaxRetryBackoff: redisMaxBackoff,
+		RedisDialTimeout:     redisDialTimeout,
+		RedisReadTimeout:     redisReadTimeout,
+		RedisWriteTimeout:    redisWriteTimeout,
+		RedisOpTimeout:       redisOpTimeout,
+
+		RecentCacheTTL: recentCacheTTL,
+
+		NodeID: os.Getenv("NODE_ID"),
+
 		RateLimitMaxRequests: rateLimitMax,
 		RateLimitWindow:      rateLimitWindow,
 		RateLimitBlockTime:   rateLimitBlock,
+
+		RateLimitUserMaxRequests: rateLimitUserMax,
+		RateLimitUserWindow:      rateLimitUserWindow,
+
+		RateLimitMaxTrackedIPs:       rateLimitTrackedIPs,
+		RateLimitMaintenanceInterval: rateLimitMaintenance,
+
+		RateLimitConfigRefresh: rateLimitConfigRefresh,
+
+		RateLimitResponseStatus:      getEnvAsInt("RATE_LIMIT_RESPONSE_STATUS", 0),
+		RateLimitResponseBody:        os.Getenv("RATE_LIMIT_RESPONSE_BODY"),
+		RateLimitResponseContentType: os.Getenv("RATE_LIMIT_RESPONSE_CONTENT_TYPE"),
+		IPBanResponseStatus:          getEnvAsInt("IP_BAN_RESPONSE_STATUS", 0),
+		IPBanResponseBody:            os.Getenv("IP_BAN_RESPONSE_BODY"),
+		IPBanResponseContentType:     os.Getenv("IP_BAN_RESPONSE_CONTENT_TYPE"),
+
+		UsernameMinLength: usernameMin,
+		UsernameMaxLength: usernameMax,
+		DefaultUserRole:   defaultUserRole,
+		FirstUserAdmin:    firstUserAdmin,
+		FailedLoginDelay:  failedLoginDelay,
+		LockoutThreshold:  lockoutThreshold,
+		LockoutDuration:   lockoutDuration,
+
+		EmailVerificationRequired:       emailVerificationRequired,
+		EmailVerificationTTL:            emailVerificationTTL,
+		EmailVerificationURL:            os.Getenv("EMAIL_VERIFICATION_URL"),
+		EmailVerificationResendCooldown: emailVerificationResendCooldown,
+
+		Argon2Memory:      argon2Memory,
+		Argon2Iterations:  argon2Iterations,
+		Argon2Parallelism: argon2Parallelism,
+		Argon2KeyLength:   argon2KeyLength,
+		HashConcurrency:   hashConcurrency,
+
+		MaxMessageLength:       maxMessageLength,
+		MinAccountAge:          minAccountAge,
+		MessageTrimWhitespace:  messageTrim,
+		MessageMaxNewlines:     messageMaxNewlines,
+		MessageDetectLanguage:  messageDetectLang,
+		MessageSkipUserPreload: messageSkipUserPreload,
+
+		ModerationWebhookURL: os.Getenv("MODERATION_WEBHOOK_URL"),
+		ModerationTimeout:    moderationTimeout,
+		ModerationFailOpen:   moderationFailOpen,
+
+		ContentBlocklist:     contentBlocklist,
+		ContentBlocklistFile: os.Getenv("CONTENT_BLOCKLIST_FILE"),
+		ContentFilterMode:    os.Getenv("CONTENT_FILTER_MODE"),
+
+		GeoIPDatabase: os.Getenv("GEOIP_DATABASE"),
+
+		SearchMaxLimit:    searchMaxLimit,
+		SearchMaxIDsLimit: searchMaxIDsLimit,
+		SearchMaxOffset:   searchMaxOffset,
+
+		RoomCountCacheKey: os.Getenv("ROOM_COUNT_CACHE_KEY"),
+		RoomCountCacheTTL: roomCountCacheTTL,
+
+		SendIdempotencyTTL: sendIdempotencyTTL,
+
+		AllowedOrigins: allowedOrigins,
+
+		TrustedProxies: trustedProxies,
+		ClientIPHeader: os.Getenv("CLIENT_IP_HEADER"),
+
+		WSSessionMode:           os.Getenv("WS_SESSION_MODE"),
+		WSSessionLifetime:       wsSessionLifetime,
+		WSSessionMax:            wsSessionMax,
+		WSPongWait:              wsPongWait,
+		WSPingPeriod:            wsPingPeriod,
+		WSWriteWait:             wsWriteWait,
+		WSMaxMessageSize:        int64(wsMaxMessageSize),
+		WSReadBufferSize:        wsReadBuffer,
+		WSWriteBufferSize:       wsWriteBuffer,
+		WSSendBufferSize:        wsSendBuffer,
+		WSMaxConnections:        wsMaxConnections,
+		WSMaxConnectionsPerUser: wsMaxConnectionsPerUser,
+
+		WSReconnectBase:       wsReconnectBase,
+		WSReconnectJitter:     wsReconnectJitter,
+		WSEnableCompression:   wsCompression,
+		WSMaxDecompressedSize: int64(wsMaxDecompressed),
+		WSPresenceWindow:      wsPresenceWindow,
+		WSEnableMessagePack:   wsMessagePack,
+
+		ByteBudgetMaxBytes: int64(byteBudgetMax),
+		ByteBudgetWindow:   byteBudgetWindow,
+
+		MessageRateMax:    messageRateMax,
+		MessageRateWindow: messageRateWindow,
+
+		RegistrationRateMax:    registrationRateMax,
+		RegistrationRateWindow: registrationRateWindow,
+
+		SendMetricsWindow:         sendMetricsWindow,
+		SendMetricsMaxTracked:     sendMetricsMaxTracked,
+		SendMetricsReportInterval: sendMetricsReport,
 	}
 
 	return cfg
 }
 
+// MinJWTSecretLength is the shortest JWT_SECRET accepted in production
+// (an HS256 key should carry at least 256 bits)
+const MinJWTSecretLength = 32
+
+// wsFrameOverhead is room in a send_message frame for everything but the
+// content (type, temp_id, client_msg_id, JSON syntax)
+const wsFrameOverhead = 1024
+
+// Validate rejects inconsistent settings, and settings that are unsafe in
+// production. Development stays lenient so a local setup runs without real
+// secrets.
+func (c *Config) Validate() error {
+	// A frame limit below the longest allowed message would close the
+	// connection on a valid send instead of answering "message too long"
+	// (0 keeps the handler default)
+	maxFrame := int64(c.MaxMessageLength)*utf8.UTFMax + wsFrameOverhead
+	if c.WSMaxMessageSize > 0 && c.WSMaxMessageSize < maxFrame {
+		return fmt.Errorf("WS_MAX_MESSAGE_SIZE must be at least %d bytes to fit MAX_MESSAGE_LENGTH=%d, got %d", maxFrame, c.MaxMessageLength, c.WSMaxMessageSize)
+	}
+	if c.WSMaxDecompressedSize > 0 && c.WSMaxDecompressedSize < maxFrame {
+		return fmt.Errorf("WS_MAX_DECOMPRESSED_SIZE must be at least %d bytes to fit MAX_MESSAGE_LENGTH=%d, got %d", maxFrame, c.MaxMessageLength, c.WSMaxDecompressedSize)
+	}
+
+	if c.Environment != "production" {
+		return nil
+	}
+	if len(c.JWTSecret) < MinJWTSecretLength {
+		return fmt.Errorf("JWT_SECRET must be at least %d characters in production, got %d", MinJWTSecretLength, len(c.JWTSecret))
+	}
+	return nil
+}
+
 // getEnvAsInt retrieves environment variable as int with default value
 func getEnvAsInt(key string, defaultVal int) int {
 	valStr := os.Getenv(key)
@@ -79,6 +527,20 @@ func getEnvAsInt(key string, defaultVal int) int {
 	return val
 }
 
+// getEnvAsBool retrieves environment variable as bool with default value
+func getEnvAsBool(key string, defaultVal bool) bool {
+	valStr := os.Getenv(key)
+	if valStr == "" {
+		return defaultVal
+	}
+	val, err := strconv.ParseBool(valStr)
+	if err != nil {
+		log.Printf("Invalid %s value, using default: %t", key, defaultVal)
+		return defaultVal
+	}
+	return val
+}
+
 // getEnvAsDuration retrieves environment variable as duration with default value
 func getEnvAsDuration(key string, defaultVal string) time.Duration {
 	valStr := os.Getenv(key)
@@ -92,3 +554,18 @@ func getEnvAsDuration(key string, defaultVal string) time.Duration {
 	}
 	return duration
 }
+
+// getEnvAsList retrieves a comma-separated environment variable as a list with default value
+func getEnvAsList(key string, defaultVal []string) []string {
+	valStr := os.Getenv(key)
+	if valStr == "" {
+		return defaultVal
+	}
+	var list []string
+	for _, item := range strings.Split(valStr, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
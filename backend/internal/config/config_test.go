@@ -0,0 +1,59 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestValidateJWTSecret tests that production refuses to start with an empty
+// or short JWT secret while development accepts it
+func TestValidateJWTSecret(t *testing.T) {
+	t.Setenv("JWT_EXPIRY", "24h")
+
+	tests := []struct {
+		name        string
+		environment string
+		secret      string
+		wantErr     bool
+	}{
+		{"production empty", "production", "", true},
+		{"production short", "production", "changeme", true},
+		{"production strong", "production", strings.Repeat("s", MinJWTSecretLength), false},
+		{"development empty", "development", "", false},
+		{"development short", "", "changeme", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ENVIRONMENT", tt.environment)
+			t.Setenv("JWT_SECRET", tt.secret)
+
+			err := Load().Validate()
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "JWT_SECRET")
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// TestValidateWSFrameFitsMessageLength tests that a WebSocket frame limit too
+// small for a MAX_MESSAGE_LENGTH message is rejected in every environment
+func TestValidateWSFrameFitsMessageLength(t *testing.T) {
+	t.Setenv("JWT_EXPIRY", "24h")
+	t.Setenv("ENVIRONMENT", "development")
+	t.Setenv("MAX_MESSAGE_LENGTH", "20000")
+
+	t.Setenv("WS_MAX_MESSAGE_SIZE", "65536")
+	assert.ErrorContains(t, Load().Validate(), "WS_MAX_MESSAGE_SIZE")
+
+	t.Setenv("WS_MAX_MESSAGE_SIZE", "131072")
+	t.Setenv("WS_MAX_DECOMPRESSED_SIZE", "65536")
+	assert.ErrorContains(t, Load().Validate(), "WS_MAX_DECOMPRESSED_SIZE")
+
+	t.Setenv("WS_MAX_DECOMPRESSED_SIZE", "131072")
+	assert.NoError(t, Load().Validate())
+}
@@ -14,7 +14,8 @@ var DB *gorm.DB
 func Connect(cfg *config.Config){
 	var err error
 
-	DB, err = gorm.Open(postgres.Open(cfg.DatabaseURL), &gorm.Config{})
+	// TranslateError maps driver errors (e.g. unique violations) to gorm.ErrDuplicatedKey
+	DB, err = gorm.Open(postgres.Open(cfg.DatabaseURL), &gorm.Config{TranslateError: true})
 
 	if err!= nil {
 		log.Fatal("Failed to connect database:", err)
@@ -23,12 +24,38 @@ func Connect(cfg *config.Config){
 	log.Println("Database connect successfully")
 }
 
+// Close closes the underlying connection pool
+func Close() error {
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
 func Migrate(){
-	err := DB.AutoMigrate(&models.User{}, &models.Message{})
+	// Accounts created before email verification existed are grandfathered in
+	grandfatherVerified := DB.Migrator().HasTable(&models.User{}) && !DB.Migrator().HasColumn(&models.User{}, "EmailVerified")
+
+	err := DB.AutoMigrate(&models.User{}, &models.Message{}, &models.AuditLog{}, &models.MessageEdit{}, &models.MessageReaction{}, &models.Mention{}, &models.RefreshToken{}, &models.VerificationToken{})
 
 	if err != nil {
 		log.Fatal("Migration failed:", err)
 	}
 
+	if grandfatherVerified {
+		if err := DB.Exec("UPDATE users SET email_verified = true").Error; err != nil {
+			log.Fatal("Migration failed:", err)
+		}
+	}
+
+	// Full-text search over message content (MessageSearch.FullText)
+	if DB.Dialector.Name() == "postgres" {
+		err = DB.Exec("CREATE INDEX IF NOT EXISTS idx_messages_content_fts ON messages USING GIN (to_tsvector('simple', content))").Error
+		if err != nil {
+			log.Fatal("Migration failed:", err)
+		}
+	}
+
 	log.Println("Database migration completed")
 }
\ No newline at end of file
@@ -0,0 +1,184 @@
+package diagnostics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Baaaki/digital-square/internal/config"
+	"github.com/Baaaki/digital-square/internal/models"
+	"github.com/Baaaki/digital-square/internal/utils"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// checkTimeout bounds network checks so a hung dependency fails instead of blocking
+const checkTimeout = 5 * time.Second
+
+// Result is the outcome of a single diagnostic check
+type Result struct {
+	Name     string
+	OK       bool
+	Detail   string
+	Duration time.Duration
+}
+
+// run times fn and converts its error into a Result
+func run(name string, fn func() (string, error)) Result {
+	start := time.Now()
+	detail, err := fn()
+	result := Result{
+		Name:     name,
+		OK:       err == nil,
+		Detail:   detail,
+		Duration: time.Since(start),
+	}
+	if err != nil {
+		result.Detail = err.Error()
+	}
+	return result
+}
+
+// CheckConfig verifies that required settings are present
+func CheckConfig(cfg *config.Config) Result {
+	return run("config", func() (string, error) {
+		required := map[string]string{
+			"DATABASE_URL": cfg.DatabaseURL,
+			"REDIS_URL":    cfg.RedisURL,
+			"JWT_SECRET":   cfg.JWTSecret,
+			"SERVER_PORT":  cfg.ServerPort,
+		}
+		var missing []string
+		for _, key := range []string{"DATABASE_URL", "REDIS_URL", "JWT_SECRET", "SERVER_PORT"} {
+			if required[key] == "" {
+				missing = append(missing, key)
+			}
+		}
+		if len(missing) > 0 {
+			return "", fmt.Errorf("missing required settings: %v", missing)
+		}
+		if cfg.JWTExpiry <= 0 {
+			return "", errors.New("JWT_EXPIRY must be positive")
+		}
+		return fmt.Sprintf("environment=%q", cfg.Environment), nil
+	})
+}
+
+// CheckDatabase pings the database connection
+func CheckDatabase(db *gorm.DB) Result {
+	return run("database", func() (string, error) {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return "", err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+		defer cancel()
+		if err := sqlDB.PingContext(ctx); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("dialect=%s", db.Dialector.Name()), nil
+	})
+}
+
+// CheckRedis pings the Redis server
+func CheckRedis(client *redis.Client) Result {
+	return run("redis", func() (string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+		defer cancel()
+		if err := client.Ping(ctx).Err(); err != nil {
+			return "", err
+		}
+		return client.Options().Addr, nil
+	})
+}
+
+// CheckWAL verifies the WAL directory is writable and fsync works.
+// It writes a scratch file next to the WAL, never the WAL itself; an
+// existing WAL file is only opened for append to check its permissions.
+func CheckWAL(walPath string) Result {
+	return run("wal", func() (string, error) {
+		dir := filepath.Dir(walPath)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", err
+		}
+
+		wal, err := os.OpenFile(walPath, os.O_WRONLY|os.O_APPEND, 0)
+		switch {
+		case err == nil:
+			wal.Close()
+		case !errors.Is(err, os.ErrNotExist):
+			return "", err
+		}
+
+		f, err := os.CreateTemp(dir, ".doctor-*")
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		if _, err := f.WriteString("doctor\n"); err != nil {
+			return "", err
+		}
+		if err := f.Sync(); err != nil {
+			return "", err
+		}
+		return dir, nil
+	})
+}
+
+// CheckHashing runs an Argon2 hash/verify round-trip
+func CheckHashing() Result {
+	return run("hashing", func() (string, error) {
+		hash, err := utils.HashPassword("doctor-self-test")
+		if err != nil {
+			return "", err
+		}
+		valid, err := utils.VerifyPassword("doctor-self-test", hash)
+		if err != nil {
+			return "", err
+		}
+		if !valid {
+			return "", errors.New("hash round-trip did not verify")
+		}
+		return "argon2id", nil
+	})
+}
+
+// CheckJWT runs a token generate/validate round-trip with the configured secret
+func CheckJWT(secret string) Result {
+	return run("jwt", func() (string, error) {
+		user := &models.User{
+			ID:       uuid.New(),
+			Username: "doctor",
+			Email:    "doctor@localhost",
+			Role:     models.RoleUser,
+		}
+		token, err := utils.GenerateToken(user, secret, time.Minute)
+		if err != nil {
+			return "", err
+		}
+		claims, err := utils.ValidateToken(token, secret)
+		if err != nil {
+			return "", err
+		}
+		if claims.UserID != user.ID {
+			return "", errors.New("token round-trip returned wrong user")
+		}
+		return "HS256", nil
+	})
+}
+
+// AllPassed reports whether every result succeeded
+func AllPassed(results []Result) bool {
+	for _, r := range results {
+		if !r.OK {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+package diagnostics
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Baaaki/digital-square/internal/config"
+	"github.com/Baaaki/digital-square/internal/testutil"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckConfig(t *testing.T) {
+	cfg := &config.Config{
+		DatabaseURL: "postgres://localhost/db",
+		RedisURL:    "redis://localhost:6379",
+		JWTSecret:   "secret",
+		ServerPort:  ":8080",
+		JWTExpiry:   time.Hour,
+	}
+	assert.True(t, CheckConfig(cfg).OK)
+
+	cfg.JWTSecret = ""
+	result := CheckConfig(cfg)
+	assert.False(t, result.OK)
+	assert.Contains(t, result.Detail, "JWT_SECRET")
+}
+
+func TestCheckDatabase(t *testing.T) {
+	testDB := testutil.SetupTestDatabase(t)
+
+	result := CheckDatabase(testDB.DB)
+	assert.True(t, result.OK, result.Detail)
+	assert.Equal(t, "dialect=sqlite", result.Detail)
+
+	// Closed connection must fail the check
+	testDB.Teardown(t)
+	assert.False(t, CheckDatabase(testDB.DB).OK)
+}
+
+func TestCheckRedis(t *testing.T) {
+	testRedis := testutil.SetupTestRedis(t)
+	client := redis.NewClient(&redis.Options{Addr: testRedis.Server.Addr()})
+	defer client.Close()
+
+	assert.True(t, CheckRedis(client).OK)
+
+	// Stopped server must fail the check
+	testRedis.Teardown(t)
+	assert.False(t, CheckRedis(client).OK)
+}
+
+func TestCheckWAL(t *testing.T) {
+	dir := t.TempDir()
+
+	result := CheckWAL(filepath.Join(dir, "wal.log"))
+	assert.True(t, result.OK, result.Detail)
+
+	// Scratch file must not be left behind
+	leftovers, _ := filepath.Glob(filepath.Join(dir, ".doctor-*"))
+	assert.Empty(t, leftovers)
+}
+
+func TestCheckHashingAndJWT(t *testing.T) {
+	assert.True(t, CheckHashing().OK)
+	assert.True(t, CheckJWT("doctor-secret").OK)
+}
+
+func TestAllPassed(t *testing.T) {
+	assert.True(t, AllPassed([]Result{{OK: true}, {OK: true}}))
+	assert.False(t, AllPassed([]Result{{OK: true}, {OK: false}}))
+}
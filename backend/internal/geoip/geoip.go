@@ -0,0 +1,138 @@
+package geoip
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Location is the coarse origin of an IP, for abuse investigation
+type Location struct {
+	Country string `json:"country,omitempty"` // ISO 3166-1 alpha-2 code
+	ASN     uint32 `json:"asn,omitempty"`     // Autonomous system number (0 = unknown)
+}
+
+// Provider resolves IPs to locations. Lookups run on the request path, so
+// implementations should answer from local data rather than call out.
+type Provider interface {
+	Lookup(ip netip.Addr) (Location, bool)
+}
+
+// Nop is the default provider: it never resolves anything
+type Nop struct{}
+
+// Lookup implements Provider
+func (Nop) Lookup(netip.Addr) (Location, bool) { return Location{}, false }
+
+// LookupString parses ip and resolves it with p. Unparseable IPs and a nil
+// provider resolve to nothing.
+func LookupString(p Provider, ip string) (Location, bool) {
+	if p == nil {
+		return Location{}, false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return Location{}, false
+	}
+	return p.Lookup(addr.Unmap())
+}
+
+// network is one row of a Table
+type network struct {
+	prefix   netip.Prefix
+	location Location
+}
+
+// Table is an in-memory Provider over non-overlapping networks (like the
+// GeoLite2 CSV exports), searched by binary search
+type Table struct {
+	networks []network // Sorted by first address
+}
+
+// NewTable builds a Table from networks mapped to their location
+func NewTable(networks map[netip.Prefix]Location) *Table {
+	t := &Table{networks: make([]network, 0, len(networks))}
+	for prefix, location := range networks {
+		t.networks = append(t.networks, network{prefix: prefix.Masked(), location: location})
+	}
+	sort.Slice(t.networks, func(i, j int) bool {
+		return t.networks[i].prefix.Addr().Less(t.networks[j].prefix.Addr())
+	})
+	return t
+}
+
+// Lookup implements Provider
+func (t *Table) Lookup(ip netip.Addr) (Location, bool) {
+	// Last network starting at or before ip
+	i := sort.Search(len(t.networks), func(i int) bool {
+		return ip.Less(t.networks[i].prefix.Addr())
+	}) - 1
+	if i < 0 || !t.networks[i].prefix.Contains(ip) {
+		return Location{}, false
+	}
+	return t.networks[i].location, true
+}
+
+// Len returns the number of networks in the table
+func (t *Table) Len() int {
+	return len(t.networks)
+}
+
+// LoadCSV reads a Table from a local CSV file of "network,country,asn" rows,
+// e.g. "203.0.113.0/24,NL,64500". The ASN may be empty; a header row and
+// lines starting with # are skipped.
+func LoadCSV(path string) (*Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadCSV(f)
+}
+
+// ReadCSV reads a Table in the LoadCSV format
+func ReadCSV(r io.Reader) (*Table, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 3
+
+	networks := make(map[netip.Prefix]Location)
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(record[0]))
+		if err != nil {
+			if first {
+				continue // Header
+			}
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		location := Location{Country: strings.ToUpper(strings.TrimSpace(record[1]))}
+		if asn := strings.TrimPrefix(strings.TrimSpace(record[2]), "AS"); asn != "" {
+			n, err := strconv.ParseUint(asn, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid ASN %q", line, record[2])
+			}
+			location.ASN = uint32(n)
+		}
+		networks[prefix] = location
+	}
+
+	return NewTable(networks), nil
+}
@@ -0,0 +1,56 @@
+package geoip
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadCSV_Lookup(t *testing.T) {
+	table, err := ReadCSV(strings.NewReader(`network,country,asn
+# Documentation ranges
+203.0.113.0/24,nl,AS64500
+198.51.100.0/25,DE,
+2001:db8::/32,US,64501
+`))
+	require.NoError(t, err)
+	assert.Equal(t, 3, table.Len())
+
+	tests := []struct {
+		ip       string
+		location Location
+		found    bool
+	}{
+		{"203.0.113.7", Location{Country: "NL", ASN: 64500}, true},
+		{"::ffff:203.0.113.7", Location{Country: "NL", ASN: 64500}, true}, // IPv4-mapped
+		{"198.51.100.127", Location{Country: "DE"}, true},
+		{"198.51.100.128", Location{}, false}, // Just past the /25
+		{"2001:db8::1", Location{Country: "US", ASN: 64501}, true},
+		{"192.0.2.1", Location{}, false},
+		{"not-an-ip", Location{}, false},
+	}
+	for _, tt := range tests {
+		location, found := LookupString(table, tt.ip)
+		assert.Equal(t, tt.found, found, tt.ip)
+		assert.Equal(t, tt.location, location, tt.ip)
+	}
+}
+
+func TestReadCSV_InvalidRows(t *testing.T) {
+	_, err := ReadCSV(strings.NewReader("203.0.113.0/24,NL,64500\nbogus,DE,1\n"))
+	assert.ErrorContains(t, err, "line 2")
+
+	_, err = ReadCSV(strings.NewReader("203.0.113.0/24,NL,ASX\n"))
+	assert.ErrorContains(t, err, "invalid ASN")
+}
+
+func TestNopAndNilProvider(t *testing.T) {
+	_, found := Nop{}.Lookup(netip.MustParseAddr("203.0.113.7"))
+	assert.False(t, found)
+
+	_, found = LookupString(nil, "203.0.113.7")
+	assert.False(t, found)
+}
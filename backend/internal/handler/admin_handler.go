@@ -1,21 +1,36 @@
 package handler
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/Baaaki/digital-square/internal/middleware"
+	"github.com/Baaaki/digital-square/internal/repository"
 	"github.com/Baaaki/digital-square/internal/service"
 	"github.com/Baaaki/digital-square/pkg/logger"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
 type AdminHandler struct {
-	authService *service.AuthService
+	authService    *service.AuthService
+	messageService *service.MessageService
+	byteBudget     *middleware.ByteBudget
+	sendMetrics    *middleware.SendMetrics
+	rateLimiter    *middleware.RateLimiter
 }
 
-func NewAdminHandler(authService *service.AuthService) *AdminHandler {
+func NewAdminHandler(authService *service.AuthService, messageService *service.MessageService, byteBudget *middleware.ByteBudget, sendMetrics *middleware.SendMetrics, rateLimiter *middleware.RateLimiter) *AdminHandler {
 	return &AdminHandler{
-		authService: authService,
+		authService:    authService,
+		messageService: messageService,
+		byteBudget:     byteBudget,
+		sendMetrics:    sendMetrics,
+		rateLimiter:    rateLimiter,
 	}
 }
 
@@ -30,6 +45,33 @@ type BanBulkRequest struct {
 	Reason  string   `json:"reason" binding:"required"`
 }
 
+type UnbanUserRequest struct {
+	UserID string `json:"user_id" binding:"required"`
+}
+
+type UnbanBulkRequest struct {
+	UserIDs []string `json:"user_ids" binding:"required"`
+}
+
+type MuteUserRequest struct {
+	UserID   string `json:"user_id" binding:"required"`
+	Duration string `json:"duration" binding:"required"` // Go duration, e.g. "10m"
+	Reason   string `json:"reason" binding:"required"`
+}
+
+type UnmuteUserRequest struct {
+	UserID string `json:"user_id" binding:"required"`
+}
+
+// RateLimitConfig is the runtime rate-limit configuration, with Go durations
+// (e.g. "1m"). A PUT replaces all fields.
+type RateLimitConfig struct {
+	MaxRequests     int    `json:"max_requests" binding:"required"`
+	Window          string `json:"window" binding:"required"`
+	UserMaxRequests int    `json:"user_max_requests"` // 0 = limit by IP only
+	UserWindow      string `json:"user_window"`
+}
+
 // GetAllUsers returns all users (including banned ones)
 // GET /admin/users
 func (h *AdminHandler) GetAllUsers(c *gin.Context) {
@@ -75,7 +117,8 @@ func (h *AdminHandler) BanUser(c *gin.Context) {
 		zap.String("reason", req.Reason),
 	)
 
-	if err := h.authService.BanUser(req.UserID, adminID, req.Reason); err != nil {
+	removed, err := h.authService.BanUser(req.UserID, adminID, req.Reason)
+	if err != nil {
 		logger.Log.Error("Failed to ban user",
 			zap.Error(err),
 			zap.String("user_id", req.UserID),
@@ -87,7 +130,8 @@ func (h *AdminHandler) BanUser(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "User banned successfully",
+		"message":          fmt.Sprintf("Banned user and removed %d messages", removed),
+		"removed_messages": removed,
 	})
 }
 
@@ -113,7 +157,8 @@ func (h *AdminHandler) BanBulk(c *gin.Context) {
 		zap.String("reason", req.Reason),
 	)
 
-	if err := h.authService.BanBulk(req.UserIDs, adminID, req.Reason); err != nil {
+	removed, err := h.authService.BanBulk(req.UserIDs, adminID, req.Reason)
+	if err != nil {
 		logger.Log.Error("Failed to bulk ban users",
 			zap.Error(err),
 		)
@@ -124,6 +169,436 @@ func (h *AdminHandler) BanBulk(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Users banned successfully",
+		"message":          fmt.Sprintf("Banned users and removed %d messages", removed),
+		"removed_messages": removed,
+	})
+}
+
+// UnbanUser restores a banned user (their removed messages stay deleted)
+// POST /admin/unban
+func (h *AdminHandler) UnbanUser(c *gin.Context) {
+	var req UnbanUserRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request body",
+		})
+		return
+	}
+
+	adminID := c.GetString("user_id")
+	logger.Log.Info("Admin unbanning user",
+		zap.String("admin_id", adminID),
+		zap.String("target_user_id", req.UserID),
+	)
+
+	if err := h.authService.UnbanUser(req.UserID, adminID); err != nil {
+		switch {
+		case errors.Is(err, service.ErrUserNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrNotBanned), errors.Is(err, service.ErrUnbanConflict):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unban user"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "User unbanned successfully",
+	})
+}
+
+// UnbanBulk restores several banned users at once (their removed messages stay deleted)
+// POST /admin/unban-bulk
+func (h *AdminHandler) UnbanBulk(c *gin.Context) {
+	var req UnbanBulkRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Log.Warn("Bulk unban request parsing failed",
+			zap.Error(err),
+		)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request body",
+		})
+		return
+	}
+
+	adminID := c.GetString("user_id")
+	logger.Log.Info("Admin bulk unbanning users",
+		zap.String("admin_id", adminID),
+		zap.Int("count", len(req.UserIDs)),
+	)
+
+	unbanned, err := h.authService.UnbanBulk(req.UserIDs, adminID)
+	if err != nil {
+		logger.Log.Error("Failed to bulk unban users",
+			zap.Error(err),
+		)
+		if errors.Is(err, service.ErrUnbanConflict) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to unban users",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  fmt.Sprintf("Unbanned %d users", unbanned),
+		"unbanned": unbanned,
+	})
+}
+
+// MuteUser stops a user from sending for a while (they can still read)
+// POST /admin/mute
+func (h *AdminHandler) MuteUser(c *gin.Context) {
+	var req MuteUserRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request body",
+		})
+		return
+	}
+
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid duration (e.g. 10m, 1h)",
+		})
+		return
+	}
+
+	adminID := c.GetString("user_id")
+	logger.Log.Info("Admin muting user",
+		zap.String("admin_id", adminID),
+		zap.String("target_user_id", req.UserID),
+		zap.Duration("duration", duration),
+		zap.String("reason", req.Reason),
+	)
+
+	if err := h.authService.MuteUser(req.UserID, adminID, duration, req.Reason); err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidMuteDuration):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrUserNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mute user"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "User muted successfully",
+	})
+}
+
+// UnmuteUser lifts a mute before it expires
+// POST /admin/unmute
+func (h *AdminHandler) UnmuteUser(c *gin.Context) {
+	var req UnmuteUserRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request body",
+		})
+		return
+	}
+
+	if err := h.authService.UnmuteUser(req.UserID, c.GetString("user_id")); err != nil {
+		switch {
+		case errors.Is(err, service.ErrNotMuted):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unmute user"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "User unmuted successfully",
+	})
+}
+
+// GetBandwidthUsage returns users who exceeded the per-user byte budget
+// GET /admin/bandwidth
+func (h *AdminHandler) GetBandwidthUsage(c *gin.Context) {
+	users, err := h.byteBudget.FlaggedUsers(100)
+	if err != nil {
+		logger.Log.Error("Failed to fetch bandwidth usage",
+			zap.Error(err),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch bandwidth usage",
+		})
+		return
+	}
+
+	budget := h.byteBudget.Config()
+	c.JSON(http.StatusOK, gin.H{
+		"flagged_users": users,
+		"max_bytes":     budget.MaxBytes,
+		"window":        budget.Window.String(),
 	})
 }
+
+// GetTopTalkers returns the users sending the most messages in the rolling window
+// GET /admin/top-talkers
+func (h *AdminHandler) GetTopTalkers(c *gin.Context) {
+	talkers, err := h.sendMetrics.TopTalkers(100)
+	if err != nil {
+		logger.Log.Error("Failed to fetch top talkers",
+			zap.Error(err),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch top talkers",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"top_talkers": talkers,
+		"window":      h.sendMetrics.Config().Window.String(),
+	})
+}
+
+// GetTopIPs returns the IPs sending the most requests, tagged with their
+// country/ASN when a geo provider is configured
+// GET /admin/top-ips?limit=50
+func (h *AdminHandler) GetTopIPs(c *gin.Context) {
+	limit, err := queryInt(c, "limit")
+	if err != nil || limit < 0 || limit > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "limit must be between 1 and 1000",
+		})
+		return
+	}
+	if limit == 0 {
+		limit = 50
+	}
+
+	ips, err := h.rateLimiter.TopIPs(limit)
+	if err != nil {
+		logger.Log.Error("Failed to fetch top IPs",
+			zap.Error(err),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch top IPs",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"top_ips": ips,
+	})
+}
+
+// GetRateLimitConfig returns the rate limits in effect on every node
+// GET /admin/ratelimit/config
+func (h *AdminHandler) GetRateLimitConfig(c *gin.Context) {
+	limits, err := h.rateLimiter.Limits()
+	if err != nil {
+		logger.Log.Error("Failed to load rate limits",
+			zap.Error(err),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to load rate limits",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, RateLimitConfig{
+		MaxRequests:     limits.MaxRequests,
+		Window:          limits.Window.String(),
+		UserMaxRequests: limits.UserMaxRequests,
+		UserWindow:      limits.UserWindow.String(),
+	})
+}
+
+// UpdateRateLimitConfig changes the rate limits without a redeploy
+// PUT /admin/ratelimit/config
+func (h *AdminHandler) UpdateRateLimitConfig(c *gin.Context) {
+	var req RateLimitConfig
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request body",
+		})
+		return
+	}
+
+	limits := middleware.RateLimits{
+		MaxRequests:     req.MaxRequests,
+		UserMaxRequests: req.UserMaxRequests,
+	}
+	var err error
+	if limits.Window, err = time.ParseDuration(req.Window); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid window (e.g. 1m, 30s)"})
+		return
+	}
+	if req.UserWindow != "" {
+		if limits.UserWindow, err = time.ParseDuration(req.UserWindow); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user_window (e.g. 1m, 30s)"})
+			return
+		}
+	}
+
+	logger.Log.Info("Admin updating rate limits",
+		zap.String("admin_id", c.GetString("user_id")),
+		zap.Int("max_requests", limits.MaxRequests),
+		zap.Duration("window", limits.Window),
+		zap.Int("user_max_requests", limits.UserMaxRequests),
+		zap.Duration("user_window", limits.UserWindow),
+	)
+
+	if err := h.rateLimiter.SetLimits(limits); err != nil {
+		if errors.Is(err, middleware.ErrInvalidRateLimits) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		logger.Log.Error("Failed to store rate limits",
+			zap.Error(err),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update rate limits"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Rate limits updated",
+	})
+}
+
+// ProcessBatch persists the WAL to PostgreSQL now instead of waiting for the next tick
+// POST /admin/batch/process
+func (h *AdminHandler) ProcessBatch(c *gin.Context) {
+	logger.Log.Info("Admin forcing batch write",
+		zap.String("admin_id", c.GetString("user_id")),
+	)
+
+	persisted, err := h.messageService.ProcessBatchNow()
+	if err != nil {
+		logger.Log.Error("Forced batch write failed",
+			zap.Error(err),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to process batch",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"persisted": persisted,
+	})
+}
+
+// PurgeCache clears the recent-messages cache; the next read repopulates it from PostgreSQL
+// POST /admin/cache/purge
+func (h *AdminHandler) PurgeCache(c *gin.Context) {
+	logger.Log.Info("Admin purging Redis cache",
+		zap.String("admin_id", c.GetString("user_id")),
+	)
+
+	if err := h.messageService.PurgeCache(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to purge cache",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Cache purged",
+	})
+}
+
+// GetCacheStats reports recent-messages cache hits and misses on this node,
+// for tuning the cache size and TTL
+// GET /admin/cache/stats
+func (h *AdminHandler) GetCacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, h.messageService.CacheStats())
+}
+
+// SearchMessages searches message content across rooms, deleted messages included on request.
+// Page size and depth are capped; ids_only=true returns just message IDs (larger pages allowed).
+// GET /admin/messages/search?q=spam&user_id=&room=&include_deleted=true&limit=50&offset=0&ids_only=false
+func (h *AdminHandler) SearchMessages(c *gin.Context) {
+	search := repository.MessageSearch{
+		Query:          c.Query("q"),
+		RoomID:         c.Query("room"),
+		IncludeDeleted: c.Query("include_deleted") == "true",
+	}
+	idsOnly := c.Query("ids_only") == "true"
+
+	if userIDStr := c.Query("user_id"); userIDStr != "" {
+		userID, err := uuid.Parse(userIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user_id"})
+			return
+		}
+		search.UserID = &userID
+	}
+
+	var err error
+	if search.Limit, err = queryInt(c, "limit"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+		return
+	}
+	if search.Offset, err = queryInt(c, "offset"); err != nil || search.Offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid offset"})
+		return
+	}
+
+	result, err := h.messageService.SearchMessages(search, idsOnly)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrSearchOffsetTooLarge), errors.Is(err, service.ErrInvalidRoom):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			logger.Log.Error("Message search failed",
+				zap.Error(err),
+			)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search messages"})
+		}
+		return
+	}
+
+	response := gin.H{
+		"total":    result.Total,
+		"limit":    result.Limit,
+		"offset":   result.Offset,
+		"has_more": int64(result.Offset+result.Limit) < result.Total,
+	}
+	if idsOnly {
+		response["ids"] = result.IDs
+	} else {
+		messages := make([]gin.H, 0, len(result.Messages))
+		for _, msg := range result.Messages {
+			messages = append(messages, gin.H{
+				"id":               msg.ID,
+				"message_id":       msg.MessageID,
+				"user_id":          msg.UserID,
+				"username":         msg.Username,
+				"room_id":          msg.RoomID,
+				"content":          msg.Content,
+				"content_length":   contentLength(msg.Content),
+				"created_at":       msg.CreatedAt,
+				"deleted":          msg.DeletedAt.Valid,
+				"deleted_by_admin": msg.IsDeletedByAdmin,
+			})
+		}
+		response["messages"] = messages
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// queryInt parses an optional integer query parameter (0 when absent)
+func queryInt(c *gin.Context, key string) (int, error) {
+	value := c.Query(key)
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
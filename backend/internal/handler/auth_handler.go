@@ -1,21 +1,35 @@
 package handler
 
 import (
+    "errors"
+    "math"
     "net/http"
+    "strconv"
+    "strings"
 
+    "github.com/Baaaki/digital-square/internal/middleware"
     "github.com/Baaaki/digital-square/internal/service"
+    "github.com/Baaaki/digital-square/internal/utils"
     "github.com/Baaaki/digital-square/pkg/logger"
     "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
     "go.uber.org/zap"
 )
 
+const (
+    refreshCookieName = "refresh_token"
+    refreshCookiePath = "/api/auth" // Only sent to auth endpoints, not every request
+)
+
 type AuthHandler struct {
     authService *service.AuthService
+    denylist    *middleware.TokenDenylist
 }
 
-func NewAuthHandler(authService *service.AuthService) *AuthHandler {
+func NewAuthHandler(authService *service.AuthService, denylist *middleware.TokenDenylist) *AuthHandler {
     return &AuthHandler{
         authService: authService,
+        denylist:    denylist,
     }
 }
 
@@ -30,12 +44,17 @@ type LoginRequest struct {
     Password string `json:"password" binding:"required"`
 }
 
+type ChangePasswordRequest struct {
+    CurrentPassword string `json:"current_password" binding:"required"`
+    NewPassword     string `json:"new_password" binding:"required"`
+}
+
 func (h *AuthHandler) Register(c *gin.Context) {
     var req RegisterRequest
 
     // 1. Parse JSON request
     if err := c.ShouldBindJSON(&req); err != nil {
-        logger.Log.Warn("Registration request parsing failed",
+        logger.FromContext(c).Warn("Registration request parsing failed",
             zap.String("ip", c.ClientIP()),
             zap.Error(err),
         )
@@ -45,7 +64,7 @@ func (h *AuthHandler) Register(c *gin.Context) {
         return
     }
 
-    logger.Log.Info("User registration attempt",
+    logger.FromContext(c).Info("User registration attempt",
         zap.String("username", req.Username),
         zap.String("email", req.Email),
         zap.String("ip", c.ClientIP()),
@@ -54,7 +73,7 @@ func (h *AuthHandler) Register(c *gin.Context) {
     // 2. Call service
     user, token, err := h.authService.Register(req.Username, req.Email, req.Password)
     if err != nil {
-        logger.Log.Error("Registration failed",
+        logger.FromContext(c).Error("Registration failed",
             zap.String("username", req.Username),
             zap.String("email", req.Email),
             zap.Error(err),
@@ -62,9 +81,9 @@ func (h *AuthHandler) Register(c *gin.Context) {
 
         // Handle different error types
         statusCode := http.StatusBadRequest
-
-        // You can add more specific error handling here
-        // For now, all service errors return 400
+        if errors.Is(err, service.ErrEmailAlreadyExists) || errors.Is(err, service.ErrUsernameAlreadyExists) {
+            statusCode = http.StatusConflict
+        }
 
         c.JSON(statusCode, gin.H{
             "error": err.Error(),
@@ -85,8 +104,9 @@ func (h *AuthHandler) Register(c *gin.Context) {
         isProduction,     // secure (HTTPS-only in production)
         true,             // httpOnly (JavaScript cannot access)
     )
+    h.setRefreshCookie(c, user.ID)
 
-    logger.Log.Info("User registered successfully",
+    logger.FromContext(c).Info("User registered successfully",
         zap.String("user_id", user.ID.String()),
         zap.String("username", user.Username),
         zap.String("role", string(user.Role)),
@@ -100,6 +120,8 @@ func (h *AuthHandler) Register(c *gin.Context) {
             "username": user.Username,
             "email":    user.Email,
             "role":     user.Role,
+
+            "email_verified": user.EmailVerified,
         },
     })
 }
@@ -110,7 +132,7 @@ func (h *AuthHandler) Login(c *gin.Context) {
 
     // 1. Parse JSON request
     if err := c.ShouldBindJSON(&req); err != nil {
-        logger.Log.Warn("Login request parsing failed",
+        logger.FromContext(c).Warn("Login request parsing failed",
             zap.String("ip", c.ClientIP()),
             zap.Error(err),
         )
@@ -120,7 +142,7 @@ func (h *AuthHandler) Login(c *gin.Context) {
         return
     }
 
-    logger.Log.Info("User login attempt",
+    logger.FromContext(c).Info("User login attempt",
         zap.String("email", req.Email),
         zap.String("ip", c.ClientIP()),
     )
@@ -128,7 +150,7 @@ func (h *AuthHandler) Login(c *gin.Context) {
     // 2. Call service
     user, token, err := h.authService.Login(req.Email, req.Password)
     if err != nil {
-        logger.Log.Warn("Login failed",
+        logger.FromContext(c).Warn("Login failed",
             zap.String("email", req.Email),
             zap.Error(err),
         )
@@ -141,6 +163,13 @@ func (h *AuthHandler) Login(c *gin.Context) {
             statusCode = http.StatusUnauthorized
         }
 
+        // Locked out: same answer whether or not the email has an account
+        var locked *service.AccountLockedError
+        if errors.As(err, &locked) {
+            statusCode = http.StatusTooManyRequests
+            c.Header("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+        }
+
         c.JSON(statusCode, gin.H{
             "error": err.Error(),
         })
@@ -160,8 +189,9 @@ func (h *AuthHandler) Login(c *gin.Context) {
         isProduction,     // secure (HTTPS-only in production)
         true,             // httpOnly (JavaScript cannot access)
     )
+    h.setRefreshCookie(c, user.ID)
 
-    logger.Log.Info("User logged in successfully",
+    logger.FromContext(c).Info("User logged in successfully",
         zap.String("user_id", user.ID.String()),
         zap.String("username", user.Username),
         zap.String("role", string(user.Role)),
@@ -175,6 +205,275 @@ func (h *AuthHandler) Login(c *gin.Context) {
             "username": user.Username,
             "email":    user.Email,
             "role":     user.Role,
+
+            "email_verified": user.EmailVerified,
         },
     })
-}
\ No newline at end of file
+}
+
+// VerifyEmail consumes the token from a verification email.
+// GET /api/auth/verify?token=...
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+    token := c.Query("token")
+    if token == "" {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error": "token is required",
+        })
+        return
+    }
+
+    if err := h.authService.VerifyEmail(token); err != nil {
+        if errors.Is(err, service.ErrInvalidVerification) {
+            logger.FromContext(c).Warn("Email verification failed",
+                zap.String("ip", c.ClientIP()),
+                zap.Error(err),
+            )
+            c.JSON(http.StatusBadRequest, gin.H{
+                "error": err.Error(),
+            })
+            return
+        }
+
+        logger.FromContext(c).Error("Email verification error",
+            zap.Error(err),
+        )
+        c.JSON(http.StatusInternalServerError, gin.H{
+            "error": "Failed to verify email",
+        })
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{
+        "message": "Email verified",
+    })
+}
+
+type ResendVerificationRequest struct {
+    Email string `json:"email" binding:"required"`
+}
+
+// ResendVerification emails a fresh verification link to an unverified
+// account. The answer is the same whether or not the email has one.
+// POST /api/auth/resend-verification
+func (h *AuthHandler) ResendVerification(c *gin.Context) {
+    var req ResendVerificationRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error": "Invalid request body",
+        })
+        return
+    }
+
+    if err := h.authService.ResendVerification(req.Email); err != nil {
+        var cooldown *service.ResendCooldownError
+        if errors.As(err, &cooldown) {
+            c.Header("Retry-After", strconv.Itoa(int(math.Ceil(cooldown.RetryAfter.Seconds()))))
+            c.JSON(http.StatusTooManyRequests, gin.H{
+                "error": err.Error(),
+            })
+            return
+        }
+
+        logger.FromContext(c).Error("Verification resend error",
+            zap.Error(err),
+        )
+        c.JSON(http.StatusInternalServerError, gin.H{
+            "error": "Failed to resend verification email",
+        })
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{
+        "message": "If an unverified account uses this email, a new verification link has been sent",
+    })
+}
+
+// Refresh rotates the refresh token and issues a new short-lived access token.
+// Lets the frontend renew the session (and reconnect the WebSocket) without logging in again.
+// POST /api/auth/refresh
+func (h *AuthHandler) Refresh(c *gin.Context) {
+    // 1. Access token from cookie (fallback: Authorization header, like AuthMiddleware)
+    accessToken := requestAccessToken(c)
+    refreshToken, refreshErr := c.Cookie(refreshCookieName)
+    if accessToken == "" || refreshErr != nil || refreshToken == "" {
+        c.JSON(http.StatusUnauthorized, gin.H{
+            "error": "authentication required",
+        })
+        return
+    }
+
+    // 2. Rotate
+    newAccessToken, newRefreshToken, err := h.authService.RefreshSession(accessToken, refreshToken)
+    if err != nil {
+        logger.FromContext(c).Warn("Token refresh failed",
+            zap.String("ip", c.ClientIP()),
+            zap.Error(err),
+        )
+
+        // A consumed or unknown refresh token is useless to the client - drop it
+        if errors.Is(err, service.ErrRefreshTokenReused) || errors.Is(err, service.ErrInvalidRefreshToken) {
+            c.SetCookie(refreshCookieName, "", -1, refreshCookiePath, "", h.authService.IsProduction(), true)
+        }
+
+        statusCode := http.StatusUnauthorized
+        if errors.Is(err, service.ErrUserBanned) {
+            statusCode = http.StatusForbidden
+        }
+        c.JSON(statusCode, gin.H{
+            "error": err.Error(),
+        })
+        return
+    }
+
+    // 3. Set both cookies
+    isProduction := h.authService.IsProduction()
+
+    c.SetSameSite(http.SameSiteLaxMode) // CSRF protection
+    c.SetCookie("token", newAccessToken, 7*24*60*60, "/", "", isProduction, true)
+    c.SetCookie(refreshCookieName, newRefreshToken, int(h.authService.RefreshTokenTTL().Seconds()), refreshCookiePath, "", isProduction, true)
+
+    c.JSON(http.StatusOK, gin.H{
+        "message": "Token refreshed",
+    })
+}
+
+// Logout revokes the presented access token and this device's refresh token.
+// Other sessions of the same user (other devices) stay valid.
+// POST /api/auth/logout (behind AuthMiddleware)
+func (h *AuthHandler) Logout(c *gin.Context) {
+    claims := c.MustGet("claims").(*utils.Claims)
+
+    // 1. Deny the access token until it expires on its own
+    if claims.ExpiresAt != nil {
+        if err := h.denylist.Revoke(claims.ID, claims.ExpiresAt.Time); err != nil {
+            logger.FromContext(c).Error("Failed to revoke access token",
+                zap.Error(err),
+            )
+            c.JSON(http.StatusInternalServerError, gin.H{
+                "error": "Failed to log out",
+            })
+            return
+        }
+    }
+
+    // 2. Consume this device's refresh token (best-effort, the access token is already dead)
+    if refreshToken, err := c.Cookie(refreshCookieName); err == nil && refreshToken != "" {
+        if err := h.authService.RevokeRefreshToken(refreshToken); err != nil {
+            logger.FromContext(c).Warn("Failed to revoke refresh token",
+                zap.Error(err),
+            )
+        }
+    }
+
+    // 3. Clear both cookies
+    isProduction := h.authService.IsProduction()
+
+    c.SetSameSite(http.SameSiteLaxMode)
+    c.SetCookie("token", "", -1, "/", "", isProduction, true)
+    c.SetCookie(refreshCookieName, "", -1, refreshCookiePath, "", isProduction, true)
+
+    logger.FromContext(c).Info("User logged out",
+        zap.String("jti", claims.ID),
+    )
+
+    c.JSON(http.StatusOK, gin.H{
+        "message": "Logged out",
+    })
+}
+
+// ChangePassword sets a new password after checking the current one.
+// Other devices lose their refresh tokens and this device's access token is
+// revoked; the caller gets a fresh pair of cookies and stays logged in.
+// POST /api/auth/change-password (behind AuthMiddleware)
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+    claims := c.MustGet("claims").(*utils.Claims)
+
+    var req ChangePasswordRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error": "Invalid request body",
+        })
+        return
+    }
+
+    // 1. Change it (revokes every refresh token of the user)
+    if err := h.authService.ChangePassword(claims.UserID, req.CurrentPassword, req.NewPassword); err != nil {
+        logger.FromContext(c).Warn("Password change failed",
+            zap.String("user_id", claims.UserID.String()),
+            zap.Error(err),
+        )
+
+        // Not 401: the session is fine, only the current password is wrong
+        statusCode := http.StatusBadRequest
+        if errors.Is(err, service.ErrInvalidCredentials) || errors.Is(err, service.ErrUserNotFound) {
+            statusCode = http.StatusForbidden
+        }
+        c.JSON(statusCode, gin.H{
+            "error": err.Error(),
+        })
+        return
+    }
+
+    // 2. Deny the access token that was used to change it
+    if claims.ExpiresAt != nil {
+        if err := h.denylist.Revoke(claims.ID, claims.ExpiresAt.Time); err != nil {
+            logger.FromContext(c).Warn("Failed to revoke access token after password change",
+                zap.Error(err),
+            )
+        }
+    }
+
+    // 3. Rotate this device's cookies
+    isProduction := h.authService.IsProduction()
+    if token, err := h.authService.RefreshToken(requestAccessToken(c)); err == nil {
+        c.SetSameSite(http.SameSiteLaxMode)
+        c.SetCookie("token", token, 7*24*60*60, "/", "", isProduction, true)
+    } else {
+        logger.FromContext(c).Warn("Failed to renew access token after password change",
+            zap.Error(err),
+        )
+        c.SetCookie("token", "", -1, "/", "", isProduction, true)
+    }
+    h.setRefreshCookie(c, claims.UserID)
+
+    logger.FromContext(c).Info("Password changed",
+        zap.String("user_id", claims.UserID.String()),
+    )
+
+    c.JSON(http.StatusOK, gin.H{
+        "message": "Password changed",
+    })
+}
+
+// requestAccessToken returns the access token from the cookie, or from the
+// Authorization header like AuthMiddleware
+func requestAccessToken(c *gin.Context) string {
+    if token, err := c.Cookie("token"); err == nil && token != "" {
+        return token
+    }
+    return strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+}
+
+// setRefreshCookie issues a refresh token and stores it in its own HttpOnly cookie.
+// Failure only disables silent refresh, so login/registration still succeed.
+func (h *AuthHandler) setRefreshCookie(c *gin.Context, userID uuid.UUID) {
+    refreshToken, err := h.authService.IssueRefreshToken(userID)
+    if err != nil {
+        logger.FromContext(c).Warn("Failed to issue refresh token",
+            zap.String("user_id", userID.String()),
+            zap.Error(err),
+        )
+        return
+    }
+
+    c.SetSameSite(http.SameSiteLaxMode)
+    c.SetCookie(
+        refreshCookieName,
+        refreshToken,
+        int(h.authService.RefreshTokenTTL().Seconds()),
+        refreshCookiePath,
+        "",
+        h.authService.IsProduction(),
+        true,
+    )
+}
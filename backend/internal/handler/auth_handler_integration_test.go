@@ -9,12 +9,14 @@ import (
 	"time"
 
 	"github.com/Baaaki/digital-square/internal/handler"
+	"github.com/Baaaki/digital-square/internal/middleware"
 	"github.com/Baaaki/digital-square/internal/models"
 	"github.com/Baaaki/digital-square/internal/repository"
 	"github.com/Baaaki/digital-square/internal/service"
 	"github.com/Baaaki/digital-square/internal/testutil"
 	"github.com/Baaaki/digital-square/pkg/logger"
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
@@ -23,6 +25,7 @@ import (
 type AuthHandlerIntegrationTestSuite struct {
 	suite.Suite
 	testDB      *testutil.TestDatabase
+	testRedis   *testutil.TestRedis
 	authHandler *handler.AuthHandler
 	router      *gin.Engine
 }
@@ -39,20 +42,40 @@ func (s *AuthHandlerIntegrationTestSuite) SetupSuite() {
 
 	// Setup repositories and services
 	userRepo := repository.NewUserRepository(s.testDB.DB)
-	authService := service.NewAuthService(userRepo, "test-secret-key", 1*time.Hour, "development")
+	messageRepo := repository.NewMessageRepository(s.testDB.DB)
+	auditRepo := repository.NewAuditLogRepository(s.testDB.DB)
+	refreshRepo := repository.NewRefreshTokenRepository(s.testDB.DB)
+	verifyRepo := repository.NewVerificationTokenRepository(s.testDB.DB)
+
+	// Start miniredis for the token denylist
+	s.testRedis = testutil.SetupTestRedis(s.T())
+	denylist := middleware.NewTokenDenylist(redis.NewClient(&redis.Options{Addr: s.testRedis.Server.Addr()}))
+
+	config := service.DefaultAuthServiceConfig()
+	config.TokenRevoker = denylist
+	authService := service.NewAuthService(userRepo, messageRepo, auditRepo, refreshRepo, verifyRepo, nil, "test-secret-key", 1*time.Hour, "development", config)
+	authMiddleware := middleware.AuthMiddleware("test-secret-key", denylist)
 
 	// Setup handler
-	s.authHandler = handler.NewAuthHandler(authService)
+	s.authHandler = handler.NewAuthHandler(authService, denylist)
 
 	// Setup router
 	s.router = gin.New()
 	s.router.POST("/api/auth/register", s.authHandler.Register)
 	s.router.POST("/api/auth/login", s.authHandler.Login)
+	s.router.GET("/api/auth/verify", s.authHandler.VerifyEmail)
+	s.router.POST("/api/auth/refresh", authMiddleware, s.authHandler.Refresh)
+	s.router.POST("/api/auth/logout", authMiddleware, s.authHandler.Logout)
+	s.router.POST("/api/auth/change-password", authMiddleware, s.authHandler.ChangePassword)
+	s.router.GET("/api/protected", authMiddleware, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
 }
 
 // TearDownSuite runs after all tests
 func (s *AuthHandlerIntegrationTestSuite) TearDownSuite() {
 	s.testDB.Teardown(s.T())
+	s.testRedis.Teardown(s.T())
 }
 
 // SetupTest runs before each test (clean database)
@@ -89,6 +112,7 @@ func (s *AuthHandlerIntegrationTestSuite) TestRegisterSuccess() {
 	assert.Equal(s.T(), "newuser", user["username"])
 	assert.Equal(s.T(), "newuser@example.com", user["email"])
 	assert.Equal(s.T(), "user", user["role"])
+	assert.Equal(s.T(), false, user["email_verified"])
 
 	// Check cookie
 	cookies := w.Result().Cookies()
@@ -125,7 +149,7 @@ func (s *AuthHandlerIntegrationTestSuite) TestRegisterDuplicateEmail() {
 	s.router.ServeHTTP(w, req)
 
 	// Assertions
-	assert.Equal(s.T(), http.StatusBadRequest, w.Code)
+	assert.Equal(s.T(), http.StatusConflict, w.Code)
 
 	var response map[string]interface{}
 	json.Unmarshal(w.Body.Bytes(), &response)
@@ -166,6 +190,24 @@ func (s *AuthHandlerIntegrationTestSuite) TestRegisterInvalidInput() {
 			},
 			expected: "password must be at least 8 characters",
 		},
+		{
+			name: "Weak password",
+			reqBody: map[string]string{
+				"username": "testuser",
+				"email":    "test@example.com",
+				"password": "pass123456",
+			},
+			expected: "password must contain an uppercase letter",
+		},
+		{
+			name: "Common password",
+			reqBody: map[string]string{
+				"username": "testuser",
+				"email":    "test@example.com",
+				"password": "Password123",
+			},
+			expected: "password is too common",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -268,6 +310,212 @@ func (s *AuthHandlerIntegrationTestSuite) TestLoginNonExistentUser() {
 	assert.Contains(s.T(), response["error"], "invalid credentials")
 }
 
+// TestRefreshRotatesCookies tests that /refresh issues new cookies and rejects a replayed refresh token
+func (s *AuthHandlerIntegrationTestSuite) TestRefreshRotatesCookies() {
+	testUser, _ := testutil.CreateTestUser("refreshuser", "refresh@example.com", "RefreshPass123", models.RoleUser)
+	s.testDB.DB.Create(testUser)
+
+	bodyBytes, _ := json.Marshal(map[string]string{
+		"email":    "refresh@example.com",
+		"password": "RefreshPass123",
+	})
+	req, _ := http.NewRequest(http.MethodPost, "/api/auth/login", bytes.NewBuffer(bodyBytes))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	assert.Equal(s.T(), http.StatusOK, w.Code)
+
+	loginCookies := cookieMap(w.Result().Cookies())
+	refreshCookie := loginCookies["refresh_token"]
+	if !assert.NotNil(s.T(), refreshCookie) {
+		return
+	}
+	assert.True(s.T(), refreshCookie.HttpOnly)
+	assert.Equal(s.T(), "/api/auth", refreshCookie.Path)
+
+	refresh := func(cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/api/auth/refresh", nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		return w
+	}
+
+	w = refresh(loginCookies["token"], refreshCookie)
+	assert.Equal(s.T(), http.StatusOK, w.Code)
+	rotated := cookieMap(w.Result().Cookies())
+	if assert.NotNil(s.T(), rotated["refresh_token"]) {
+		assert.NotEqual(s.T(), refreshCookie.Value, rotated["refresh_token"].Value)
+	}
+	assert.NotNil(s.T(), rotated["token"])
+
+	// Replaying the old refresh token is rejected
+	w = refresh(loginCookies["token"], refreshCookie)
+	assert.Equal(s.T(), http.StatusUnauthorized, w.Code)
+
+	// Missing refresh cookie
+	w = refresh(loginCookies["token"])
+	assert.Equal(s.T(), http.StatusUnauthorized, w.Code)
+}
+
+// TestLogoutRevokesOnlyPresentedToken tests that logging out one device keeps other sessions valid
+func (s *AuthHandlerIntegrationTestSuite) TestLogoutRevokesOnlyPresentedToken() {
+	testUser, _ := testutil.CreateTestUser("logoutuser", "logout@example.com", "LogoutPass123", models.RoleUser)
+	s.testDB.DB.Create(testUser)
+
+	login := func() map[string]*http.Cookie {
+		bodyBytes, _ := json.Marshal(map[string]string{
+			"email":    "logout@example.com",
+			"password": "LogoutPass123",
+		})
+		req, _ := http.NewRequest(http.MethodPost, "/api/auth/login", bytes.NewBuffer(bodyBytes))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		assert.Equal(s.T(), http.StatusOK, w.Code)
+		return cookieMap(w.Result().Cookies())
+	}
+	do := func(method, path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Same user on two devices: each token gets its own jti
+	laptop := login()
+	phone := login()
+	if !assert.NotNil(s.T(), laptop["token"]) || !assert.NotNil(s.T(), phone["token"]) {
+		return
+	}
+	assert.NotEqual(s.T(), laptop["token"].Value, phone["token"].Value)
+
+	// Logout on the laptop clears its cookies
+	w := do(http.MethodPost, "/api/auth/logout", laptop["token"], laptop["refresh_token"])
+	assert.Equal(s.T(), http.StatusOK, w.Code)
+	cleared := cookieMap(w.Result().Cookies())
+	if assert.NotNil(s.T(), cleared["token"]) {
+		assert.Empty(s.T(), cleared["token"].Value)
+	}
+
+	// The laptop's token is dead even if the client kept a copy...
+	w = do(http.MethodGet, "/api/protected", laptop["token"])
+	assert.Equal(s.T(), http.StatusUnauthorized, w.Code)
+	w = do(http.MethodPost, "/api/auth/refresh", laptop["token"], laptop["refresh_token"])
+	assert.Equal(s.T(), http.StatusUnauthorized, w.Code)
+
+	// ...while the phone stays logged in and can still refresh
+	w = do(http.MethodGet, "/api/protected", phone["token"])
+	assert.Equal(s.T(), http.StatusOK, w.Code)
+	w = do(http.MethodPost, "/api/auth/refresh", phone["token"], phone["refresh_token"])
+	assert.Equal(s.T(), http.StatusOK, w.Code)
+}
+
+// TestChangePassword tests a password change and the sessions it revokes
+func (s *AuthHandlerIntegrationTestSuite) TestChangePassword() {
+	testUser, _ := testutil.CreateTestUser("changer", "changer@example.com", "OldPass123", models.RoleUser)
+	s.testDB.DB.Create(testUser)
+
+	login := func(password string) (int, map[string]*http.Cookie) {
+		bodyBytes, _ := json.Marshal(map[string]string{
+			"email":    "changer@example.com",
+			"password": password,
+		})
+		req, _ := http.NewRequest(http.MethodPost, "/api/auth/login", bytes.NewBuffer(bodyBytes))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		return w.Code, cookieMap(w.Result().Cookies())
+	}
+	do := func(method, path string, body interface{}, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		bodyBytes, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(bodyBytes))
+		req.Header.Set("Content-Type", "application/json")
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		return w
+	}
+
+	_, laptop := login("OldPass123")
+	_, phone := login("OldPass123")
+	if !assert.NotNil(s.T(), laptop["token"]) || !assert.NotNil(s.T(), phone["refresh_token"]) {
+		return
+	}
+
+	// Wrong current password: nothing changes, the session stays valid
+	w := do(http.MethodPost, "/api/auth/change-password", map[string]string{
+		"current_password": "WrongPass123",
+		"new_password":     "NewPass456",
+	}, laptop["token"])
+	assert.Equal(s.T(), http.StatusForbidden, w.Code)
+	assert.Contains(s.T(), w.Body.String(), service.ErrInvalidCredentials.Error())
+	w = do(http.MethodGet, "/api/protected", nil, laptop["token"])
+	assert.Equal(s.T(), http.StatusOK, w.Code)
+
+	// The new password must meet the registration rules
+	w = do(http.MethodPost, "/api/auth/change-password", map[string]string{
+		"current_password": "OldPass123",
+		"new_password":     "short",
+	}, laptop["token"])
+	assert.Equal(s.T(), http.StatusBadRequest, w.Code)
+
+	// Happy path: the laptop gets new cookies
+	w = do(http.MethodPost, "/api/auth/change-password", map[string]string{
+		"current_password": "OldPass123",
+		"new_password":     "NewPass456",
+	}, laptop["token"], laptop["refresh_token"])
+	assert.Equal(s.T(), http.StatusOK, w.Code)
+	renewed := cookieMap(w.Result().Cookies())
+	if !assert.NotNil(s.T(), renewed["token"]) || !assert.NotNil(s.T(), renewed["refresh_token"]) {
+		return
+	}
+	assert.NotEqual(s.T(), laptop["token"].Value, renewed["token"].Value)
+
+	w = do(http.MethodGet, "/api/protected", nil, laptop["token"])
+	assert.Equal(s.T(), http.StatusUnauthorized, w.Code, "old access token is revoked")
+	w = do(http.MethodGet, "/api/protected", nil, renewed["token"])
+	assert.Equal(s.T(), http.StatusOK, w.Code)
+
+	// The phone is logged out right away, without waiting for its access
+	// token to expire, and can't renew its session
+	w = do(http.MethodGet, "/api/protected", nil, phone["token"])
+	assert.Equal(s.T(), http.StatusUnauthorized, w.Code, "other devices' access tokens are revoked")
+	w = do(http.MethodPost, "/api/auth/refresh", nil, phone["token"], phone["refresh_token"])
+	assert.Equal(s.T(), http.StatusUnauthorized, w.Code)
+
+	code, _ := login("OldPass123")
+	assert.Equal(s.T(), http.StatusUnauthorized, code)
+	code, _ = login("NewPass456")
+	assert.Equal(s.T(), http.StatusOK, code)
+}
+
+// TestVerifyEmailRejectsBadToken tests the verification endpoint's errors
+func (s *AuthHandlerIntegrationTestSuite) TestVerifyEmailRejectsBadToken() {
+	for _, target := range []string{"/api/auth/verify", "/api/auth/verify?token=bogus"} {
+		req, _ := http.NewRequest(http.MethodGet, target, nil)
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		assert.Equal(s.T(), http.StatusBadRequest, w.Code, target)
+	}
+}
+
+// cookieMap indexes response cookies by name
+func cookieMap(cookies []*http.Cookie) map[string]*http.Cookie {
+	m := make(map[string]*http.Cookie, len(cookies))
+	for _, cookie := range cookies {
+		m[cookie.Name] = cookie
+	}
+	return m
+}
+
 // TestSuite runs all tests in the suite
 func TestAuthHandlerIntegrationTestSuite(t *testing.T) {
 	suite.Run(t, new(AuthHandlerIntegrationTestSuite))
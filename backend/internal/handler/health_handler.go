@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/Baaaki/digital-square/internal/diagnostics"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// HealthHandler serves liveness and readiness probes for orchestrators and
+// load balancers. Routes are public and not rate limited.
+type HealthHandler struct {
+	db          *gorm.DB
+	redisClient *redis.Client
+	walPath     string
+}
+
+func NewHealthHandler(db *gorm.DB, redisClient *redis.Client, walPath string) *HealthHandler {
+	return &HealthHandler{
+		db:          db,
+		redisClient: redisClient,
+		walPath:     walPath,
+	}
+}
+
+// GET /healthz
+// Liveness: the process is up and serving requests
+func (h *HealthHandler) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// GET /readyz
+// Readiness: PostgreSQL and Redis answer a ping and the WAL is writable.
+// Any failure returns 503 so traffic is routed to other nodes.
+func (h *HealthHandler) Readyz(c *gin.Context) {
+	checks := []func() diagnostics.Result{
+		func() diagnostics.Result { return diagnostics.CheckDatabase(h.db) },
+		func() diagnostics.Result { return diagnostics.CheckRedis(h.redisClient) },
+		func() diagnostics.Result { return diagnostics.CheckWAL(h.walPath) },
+	}
+
+	// Run checks concurrently so one slow dependency doesn't add up with the others
+	results := make([]diagnostics.Result, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check func() diagnostics.Result) {
+			defer wg.Done()
+			results[i] = check()
+		}(i, check)
+	}
+	wg.Wait()
+
+	status := make(gin.H, len(results))
+	for _, r := range results {
+		entry := gin.H{
+			"ok":          r.OK,
+			"duration_ms": r.Duration.Milliseconds(),
+		}
+		if !r.OK {
+			entry["error"] = r.Detail
+		}
+		status[r.Name] = entry
+	}
+
+	if !diagnostics.AllPassed(results) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "checks": status})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready", "checks": status})
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/Baaaki/digital-square/internal/testutil"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestHealthProbes tests that readiness reports each dependency and turns
+// 503 when one fails, while liveness stays 200
+func TestHealthProbes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	testDB := testutil.SetupTestDatabase(t)
+	defer testDB.Teardown(t)
+	testRedis := testutil.SetupTestRedis(t)
+	client := redis.NewClient(&redis.Options{Addr: testRedis.Server.Addr()})
+	defer client.Close()
+
+	h := NewHealthHandler(testDB.DB, client, filepath.Join(t.TempDir(), "wal.log"))
+	router := gin.New()
+	router.GET("/healthz", h.Healthz)
+	router.GET("/readyz", h.Readyz)
+
+	probe := func(path string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return w.Code, body
+	}
+
+	code, body := probe("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ready", body["status"])
+	checks := body["checks"].(map[string]interface{})
+	for _, name := range []string{"database", "redis", "wal"} {
+		assert.Equal(t, true, checks[name].(map[string]interface{})["ok"], name)
+	}
+
+	// Redis goes away: not ready, but still alive
+	testRedis.Teardown(t)
+	code, body = probe("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "unavailable", body["status"])
+	checks = body["checks"].(map[string]interface{})
+	assert.Equal(t, false, checks["redis"].(map[string]interface{})["ok"])
+	assert.NotEmpty(t, checks["redis"].(map[string]interface{})["error"])
+	assert.Equal(t, true, checks["database"].(map[string]interface{})["ok"])
+
+	code, body = probe("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", body["status"])
+}
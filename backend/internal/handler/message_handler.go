@@ -1,26 +1,157 @@
 package handler
 
 import (
+	"errors"
+	"io"
+	"math"
 	"net/http"
 	"strconv"
+	"unicode/utf8"
 
 	"github.com/Baaaki/digital-square/internal/models"
 	"github.com/Baaaki/digital-square/internal/service"
 	"github.com/Baaaki/digital-square/internal/utils"
+	"github.com/Baaaki/digital-square/pkg/logger"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
 )
 
+// LiveChat is what REST sends need from the WebSocket side (implemented by
+// WebSocketHandler): the same per-user send limits, and live delivery so a
+// message sent over REST shows up for connected clients
+type LiveChat interface {
+	CheckSendLimits(userID uuid.UUID, username, content string) error
+	PublishSentMessage(msg *models.Message)
+}
+
 type MessageHandler struct {
 	messageService *service.MessageService
+	live           LiveChat
 }
 
-func NewMessageHandler(messageService *service.MessageService) *MessageHandler {
+func NewMessageHandler(messageService *service.MessageService, live LiveChat) *MessageHandler {
 	return &MessageHandler{
 		messageService: messageService,
+		live:           live,
 	}
 }
 
-// GET /api/messages/before/:id
+// GET /api/config
+// Limits the frontend enforces client-side, so they match server validation
+func (h *MessageHandler) GetConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"max_message_length": h.messageService.MaxMessageLength(),
+	})
+}
+
+// SendMessageRequest is the REST equivalent of a send_message frame
+type SendMessageRequest struct {
+	Content     string `json:"content" binding:"required"`
+	TempID      string `json:"temp_id"`       // Echoed back, like the WebSocket ACK
+	ClientMsgID string `json:"client_msg_id"` // Idempotency key: a retry returns the first message
+}
+
+// POST /api/messages?room=general
+func (h *MessageHandler) Send(c *gin.Context) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	userClaims := claims.(*utils.Claims)
+
+	// Same gate as the WebSocket: unverified accounts can read but not post
+	if userClaims.EmailUnverified {
+		c.JSON(http.StatusForbidden, gin.H{"error": "verify your email address to send messages"})
+		return
+	}
+
+	var req SendMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	// A retry of an earlier send is answered without counting against the
+	// limits again (on lookup errors SendMessageOnce still dedupes)
+	original, err := h.messageService.FindSentMessage(userClaims.UserID, req.ClientMsgID)
+	if err != nil {
+		logger.FromContext(c).Warn("Failed to look up resent message", zap.Error(err))
+	}
+	if original != nil {
+		respondSent(c, original, true, req.TempID)
+		return
+	}
+
+	if err := h.live.CheckSendLimits(userClaims.UserID, userClaims.Username, req.Content); err != nil {
+		var limitErr *SendLimitError
+		if errors.As(err, &limitErr) && limitErr.RetryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(limitErr.RetryAfter.Seconds()))))
+		}
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error(), "temp_id": req.TempID})
+		return
+	}
+
+	msg, duplicate, err := h.messageService.SendMessageOnce(userClaims.UserID, userClaims.Username, c.Query("room"), req.Content, req.ClientMsgID)
+	if err != nil {
+		logger.FromContext(c).Warn("Failed to send message over REST", zap.Error(err))
+		c.JSON(sendErrorStatus(err), gin.H{"error": sendFailureReason(err), "temp_id": req.TempID})
+		return
+	}
+
+	// A retry that raced its original: the room already has it
+	if !duplicate {
+		h.live.PublishSentMessage(msg)
+	}
+	respondSent(c, msg, duplicate, req.TempID)
+}
+
+// respondSent writes the response to a send: 201 for a new message, 200 for
+// a retry answered with the message sent first
+func respondSent(c *gin.Context, msg *models.Message, duplicate bool, tempID string) {
+	status := http.StatusOK
+	if !duplicate {
+		status = http.StatusCreated
+	}
+
+	c.JSON(status, gin.H{
+		"id":             msg.ID, // 0 until the batch writer persists it
+		"message_id":     msg.MessageID,
+		"user_id":        msg.UserID,
+		"username":       msg.Username,
+		"room_id":        msg.RoomID,
+		"content":        msg.Content,
+		"content_length": contentLength(msg.Content),
+		"lang":           msg.Lang,
+		"created_at":     msg.CreatedAt,
+		"temp_id":        tempID,
+		"duplicate":      duplicate,
+	})
+}
+
+// sendErrorStatus maps a SendMessage error to an HTTP status
+func sendErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrMessageTooShort),
+		errors.Is(err, service.ErrMessageTooLong),
+		errors.Is(err, service.ErrInvalidRoom),
+		errors.Is(err, service.ErrInvalidClientID):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrUserBanned),
+		errors.Is(err, service.ErrAccountTooNew),
+		errors.Is(err, service.ErrUserMuted),
+		errors.Is(err, service.ErrMessageBlocked),
+		errors.Is(err, service.ErrBlockedContent):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrModerationUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// GET /api/messages/before/:id?room=general
 func (h *MessageHandler) GetBefore(c *gin.Context) {
 	// 1. Auth check
 	claims, exists := c.Get("claims")
@@ -40,9 +171,16 @@ func (h *MessageHandler) GetBefore(c *gin.Context) {
 		return
 	}
 
+	// Pagination is scoped to the room the client is viewing (default general)
+	roomID, err := service.ResolveRoomID(c.Query("room"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	//3.step: Fetch 50 older messages fron postgreSQL
 	limit := 50
-	messages, err := h.messageService.GetMessagesBefore(messageID, limit, isAdmin)
+	messages, err := h.messageService.GetMessagesBefore(roomID, messageID, limit, isAdmin)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch messages"})
 		return
@@ -50,6 +188,107 @@ func (h *MessageHandler) GetBefore(c *gin.Context) {
 
 	// 4. Filter deleted messages based on role
 	filteredMessages := h.filterMessages(messages, isAdmin)
+	h.addReactions(c, filteredMessages, messages)
+
+	response := gin.H{
+		"messages": filteredMessages,
+		"count":    len(filteredMessages),
+		"has_more": len(filteredMessages) == limit,
+	}
+
+	// 5. Optional room total (for scrollbars); costs a count when not cached
+	if c.Query("include_total") == "true" {
+		total, err := h.messageService.CountRoomMessages(roomID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to count messages"})
+			return
+		}
+		response["total"] = total
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GET /api/messages/search?q=hello+world&limit=50&offset=0
+func (h *MessageHandler) Search(c *gin.Context) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	isAdmin := claims.(*utils.Claims).Role == models.RoleAdmin
+
+	limit, err := queryInt(c, "limit")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+		return
+	}
+	offset, err := queryInt(c, "offset")
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid offset"})
+		return
+	}
+
+	result, err := h.messageService.FullTextSearch(c.Query("q"), limit, offset, isAdmin)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrEmptySearch), errors.Is(err, service.ErrSearchOffsetTooLarge):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to search messages"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"messages": h.filterMessages(result.Messages, isAdmin),
+		"total":    result.Total,
+		"limit":    result.Limit,
+		"offset":   result.Offset,
+		"has_more": int64(result.Offset+result.Limit) < result.Total,
+	})
+}
+
+// GET /api/messages/after/:id?room=general&limit=50
+func (h *MessageHandler) GetAfter(c *gin.Context) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	isAdmin := claims.(*utils.Claims).Role == models.RoleAdmin
+
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message ID"})
+		return
+	}
+
+	roomID, err := service.ResolveRoomID(c.Query("room"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Default 50 like GetBefore; the service caps larger requests
+	limit, err := queryInt(c, "limit")
+	if err != nil || limit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+		return
+	}
+	if limit == 0 {
+		limit = 50
+	}
+	limit = min(limit, service.MaxPageSize)
+
+	messages, err := h.messageService.GetMessagesAfter(roomID, messageID, limit, isAdmin)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch messages"})
+		return
+	}
+
+	filteredMessages := h.filterMessages(messages, isAdmin)
+	h.addReactions(c, filteredMessages, messages)
 
 	c.JSON(http.StatusOK, gin.H{
 		"messages": filteredMessages,
@@ -58,6 +297,13 @@ func (h *MessageHandler) GetBefore(c *gin.Context) {
 	})
 }
 
+// contentLength is the content_length reported with message payloads: the
+// rune count of the content as stored (normalized and HTML-escaped), which
+// is what clients display
+func contentLength(content string) int {
+	return utf8.RuneCountInString(content)
+}
+
 // filterMessages masks deleted message content based on user role
 func (h *MessageHandler) filterMessages(messages []models.Message, isAdmin bool) []gin.H {
 	result := make([]gin.H, 0, len(messages))
@@ -68,12 +314,16 @@ func (h *MessageHandler) filterMessages(messages []models.Message, isAdmin bool)
 			"message_id": msg.MessageID,
 			"user_id":    msg.UserID,
 			"username":   msg.Username, // ✅ Use denormalized username field
-			"content":    msg.Content,
+			"room_id":    msg.RoomID,
 			"created_at": msg.CreatedAt,
 			"deleted":    msg.DeletedAt.Valid,
 		}
+		if msg.Lang != "" {
+			msgData["lang"] = msg.Lang
+		}
 
 		// Handle deleted messages
+		content := msg.Content
 		if msg.DeletedAt.Valid {
 			if isAdmin {
 				// Admin sees content + metadata
@@ -82,15 +332,154 @@ func (h *MessageHandler) filterMessages(messages []models.Message, isAdmin bool)
 			} else {
 				// User sees placeholder
 				if msg.IsDeletedByAdmin {
-					msgData["content"] = "This message was deleted by admin"
+					content = "This message was deleted by admin"
 				} else {
-					msgData["content"] = "This message was deleted"
+					content = "This message was deleted"
 				}
 			}
 		}
+		msgData["content"] = content
+		msgData["content_length"] = contentLength(content)
 
 		result = append(result, msgData)
 	}
 
 	return result
 }
+
+// addReactions sets "reactions" (counts by emoji) on each filtered message
+// that has any. Best effort: the page is returned without them on failure.
+func (h *MessageHandler) addReactions(c *gin.Context, filtered []gin.H, messages []models.Message) {
+	messageIDs := make([]string, len(messages))
+	for i, msg := range messages {
+		messageIDs[i] = msg.MessageID
+	}
+
+	counts, err := h.messageService.GetReactionCounts(messageIDs)
+	if err != nil {
+		logger.FromContext(c).Warn("Failed to load reaction counts", zap.Error(err))
+		return
+	}
+	for i, msg := range messages {
+		if reactions := counts[msg.MessageID]; len(reactions) > 0 {
+			filtered[i]["reactions"] = reactions
+		}
+	}
+}
+
+// GET /api/users/me/messages/deleted
+func (h *MessageHandler) GetMyDeleted(c *gin.Context) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	userClaims := claims.(*utils.Claims)
+
+	messages, err := h.messageService.GetDeletedMessages(userClaims.UserID, 50)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch messages"})
+		return
+	}
+
+	// Authors always see their own content
+	result := make([]gin.H, 0, len(messages))
+	for _, msg := range messages {
+		result = append(result, gin.H{
+			"id":               msg.ID,
+			"message_id":       msg.MessageID,
+			"content":          msg.Content,
+			"content_length":   contentLength(msg.Content),
+			"created_at":       msg.CreatedAt,
+			"deleted_at":       msg.DeletedAt.Time,
+			"deleted_by_admin": msg.IsDeletedByAdmin,
+			"restorable":       !msg.IsDeletedByAdmin && msg.DeletedBy != nil && *msg.DeletedBy == userClaims.UserID,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"messages": result,
+		"count":    len(result),
+	})
+}
+
+// POST /api/users/me/messages/:id/restore
+func (h *MessageHandler) RestoreMine(c *gin.Context) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	userClaims := claims.(*utils.Claims)
+
+	msg, err := h.messageService.RestoreMessage(c.Param("id"), userClaims.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrMessageNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrRestoreDenied):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrNotDeleted):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to restore message"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Message restored",
+		"message_id": msg.MessageID,
+	})
+}
+
+// MarkNotificationsReadRequest selects the mentions to mark as read.
+// No IDs (or no body) marks every unread mention.
+type MarkNotificationsReadRequest struct {
+	IDs []uint64 `json:"ids" binding:"max=100"`
+}
+
+// GET /api/notifications
+func (h *MessageHandler) GetNotifications(c *gin.Context) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	userClaims := claims.(*utils.Claims)
+
+	mentions, err := h.messageService.GetUnreadMentions(userClaims.UserID, 50)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch notifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"notifications": mentions,
+		"count":         len(mentions),
+	})
+}
+
+// POST /api/notifications/read
+func (h *MessageHandler) MarkNotificationsRead(c *gin.Context) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	userClaims := claims.(*utils.Claims)
+
+	var req MarkNotificationsReadRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	marked, err := h.messageService.MarkMentionsRead(userClaims.UserID, req.IDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to mark notifications as read"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"marked": marked})
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+	"github.com/ugorji/go/codec"
+)
+
+// WebSocket subprotocols selecting the frame encoding.
+// Clients that don't request one get JSON.
+const (
+	SubprotocolJSON    = "json"
+	SubprotocolMsgpack = "msgpack"
+)
+
+// frameCodec encodes and decodes WebSocket data frames for one connection
+type frameCodec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	FrameType() int // websocket.TextMessage or websocket.BinaryMessage
+}
+
+// codecFor returns the codec for a negotiated subprotocol
+func codecFor(subprotocol string) frameCodec {
+	if subprotocol == SubprotocolMsgpack {
+		return msgpackCodec{}
+	}
+	return jsonCodec{}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) FrameType() int                             { return websocket.TextMessage }
+
+// msgpackHandle is shared by all connections (safe for concurrent use once configured).
+// Struct fields use their json tags, so both encodings carry the same keys.
+var msgpackHandle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	h.WriteExt = true // Use the str8/bin types from the current msgpack spec
+	return h
+}()
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var data []byte
+	err := codec.NewEncoderBytes(&data, msgpackHandle).Encode(v)
+	return data, err
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return codec.NewDecoderBytes(data, msgpackHandle).Decode(v)
+}
+
+func (msgpackCodec) FrameType() int { return websocket.BinaryMessage }
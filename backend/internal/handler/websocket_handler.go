@@ -1,66 +1,303 @@
 package handler
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Baaaki/digital-square/internal/broker"
+	"github.com/Baaaki/digital-square/internal/middleware"
 	"github.com/Baaaki/digital-square/internal/models"
 	"github.com/Baaaki/digital-square/internal/service"
 	"github.com/Baaaki/digital-square/internal/utils"
 	"github.com/Baaaki/digital-square/pkg/logger"
+	"github.com/Baaaki/digital-square/pkg/metrics"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"go.uber.org/zap"
 )
 
+// Defaults for WSConfig fields left at zero
 const (
-	maxSessionLifetime = 15 * time.Minute
-	writeWait          = 10 * time.Second // Time allowed to write a message to the peer
-	pongWait           = 60 * time.Second
-	pingPeriod         = (pongWait * 9) / 10 // 54 seconds
-	maxMessageSize     = 512 * 1024          // 512 KB
+	defaultSessionLifetime = 15 * time.Minute
+	defaultSessionMax      = 24 * time.Hour // Absolute cap in SessionModeSliding
+	defaultWriteWait       = 10 * time.Second
+	defaultPongWait        = 60 * time.Second
+	defaultMaxMessageSize  = 512 * 1024 // 512 KB on the wire (compressed size when deflate is on)
+	defaultSendBufferSize  = 256        // Outbound frames queued per client
 )
 
+const maxCloseReasonBytes = 123 // RFC 6455: control frame payload 125 bytes minus 2-byte code
+
+// errMessageTooLarge is returned when an inbound message decompresses past the limit
+var errMessageTooLarge = errors.New("message exceeds size limit")
+
+// WSConfig holds tunable WebSocket behavior.
+// Zero durations and sizes fall back to the defaults; zero limits mean unlimited.
+type WSConfig struct {
+	// Connection lifecycle
+	SessionMode     string        // How SessionLifetime applies (empty = SessionModeFixed)
+	SessionLifetime time.Duration // Connections are closed with session_expired after this long (idle time in sliding mode)
+	SessionMax      time.Duration // Sliding mode: absolute cap however active the client is (0 = 24h)
+	PongWait        time.Duration // Read deadline, extended by every pong
+	PingPeriod      time.Duration // Must be below PongWait (0 = 90% of PongWait)
+	WriteWait       time.Duration // Time allowed to write a frame to the peer
+
+	// Frame and buffer sizes
+	MaxMessageSize  int64 // Inbound frame bytes on the wire (compressed size when deflate is on)
+	ReadBufferSize  int   // Upgrader I/O buffers (0 = gorilla's 4 KB)
+	WriteBufferSize int
+
+	// Outbound frames queued per client before it is dropped as a slow consumer
+	SendBufferSize int
+
+	// Connection limits, enforced when a connection registers
+	MaxConnections        int // Open connections on this node
+	MaxConnectionsPerUser int // Open connections per user (e.g. tabs and devices)
+
+	ReconnectBase   time.Duration // Minimum reconnect delay suggested on server-initiated close
+	ReconnectJitter time.Duration // Random extra delay added to spread out reconnects
+
+	// SetReadLimit only bounds frame bytes on the wire, so with permessage-deflate
+	// a tiny frame can inflate to gigabytes. MaxDecompressedSize caps what we read.
+	EnableCompression   bool
+	MaxDecompressedSize int64
+
+	// Joins/leaves within this window are sent as one presence_delta
+	// (0 = send each immediately as user_online/user_offline)
+	PresenceWindow time.Duration
+
+	// Offer the msgpack subprotocol (binary MessagePack frames); JSON is always available
+	EnableMessagePack bool
+
+	// Minimum role per request type (nil = defaultWSRequiredRoles). Types not listed are open to everyone.
+	RequiredRoles map[WSMessageType]models.Role
+
+	// StrictOrigin (production) accepts only upgrades whose Origin is in the
+	// allowed list. Otherwise localhost origins and a missing Origin are allowed too.
+	StrictOrigin bool
+
+	Metrics *metrics.Metrics // Connection and send counters (nil = disabled)
+}
+
+// Session modes, selected with WSConfig.SessionMode
+const (
+	SessionModeFixed   = "fixed"   // Hard cap: closed SessionLifetime after connecting (default)
+	SessionModeSliding = "sliding" // Keep-alive while active: every handled request restarts SessionLifetime, up to SessionMax
+	SessionModeOff     = "off"     // Never expire; connections last until closed or the token is revoked
+)
+
+// DefaultWSConfig returns the default WebSocket settings
+func DefaultWSConfig() WSConfig {
+	return WSConfig{
+		SessionMode:         SessionModeFixed,
+		SessionLifetime:     defaultSessionLifetime,
+		SessionMax:          defaultSessionMax,
+		PongWait:            defaultPongWait,
+		PingPeriod:          defaultPongWait * 9 / 10,
+		WriteWait:           defaultWriteWait,
+		MaxMessageSize:      defaultMaxMessageSize,
+		SendBufferSize:      defaultSendBufferSize,
+		ReconnectBase:       1 * time.Second,
+		ReconnectJitter:     5 * time.Second,
+		MaxDecompressedSize: defaultMaxMessageSize,
+		PresenceWindow:      500 * time.Millisecond,
+		EnableMessagePack:   true,
+	}
+}
+
+// withDefaults fills unset lifecycle and size fields
+func (c WSConfig) withDefaults() WSConfig {
+	if c.SessionMode != SessionModeSliding && c.SessionMode != SessionModeOff {
+		c.SessionMode = SessionModeFixed
+	}
+	if c.SessionLifetime <= 0 {
+		c.SessionLifetime = defaultSessionLifetime
+	}
+	if c.SessionMax <= 0 {
+		c.SessionMax = defaultSessionMax
+	}
+	if c.PongWait <= 0 {
+		c.PongWait = defaultPongWait
+	}
+	if c.PingPeriod <= 0 || c.PingPeriod >= c.PongWait {
+		c.PingPeriod = c.PongWait * 9 / 10
+	}
+	if c.WriteWait <= 0 {
+		c.WriteWait = defaultWriteWait
+	}
+	if c.MaxMessageSize <= 0 {
+		c.MaxMessageSize = defaultMaxMessageSize
+	}
+	if c.MaxDecompressedSize <= 0 {
+		c.MaxDecompressedSize = c.MaxMessageSize
+	}
+	if c.SendBufferSize <= 0 {
+		c.SendBufferSize = defaultSendBufferSize
+	}
+	return c
+}
+
 type WSMessageType string
 
 const (
-	WSMessageTypeSend   WSMessageType = "send_message"
-	WSMessageTypeDelete WSMessageType = "delete_message"
+	WSMessageTypeSend       WSMessageType = "send_message"
+	WSMessageTypeDelete     WSMessageType = "delete_message"
+	WSMessageTypeDeleteLast WSMessageType = "delete_last" // Delete the sender's latest message in the room
+	WSMessageTypeEdit       WSMessageType = "edit_message"
+	WSMessageTypeAnnounce   WSMessageType = "announce"  // Admin: post as the system user
+	WSMessageTypeMarkSeen   WSMessageType = "mark_seen" // Read receipt for displayed messages
+	WSMessageTypeReact      WSMessageType = "react"     // Add an emoji reaction to a message
+	WSMessageTypeUnreact    WSMessageType = "unreact"   // Withdraw an emoji reaction
 )
 
+// supportedWSMessageTypes lists every request type handleClient dispatches.
+// Returned to clients that send an unknown type.
+var supportedWSMessageTypes = []WSMessageType{
+	WSMessageTypeSend,
+	WSMessageTypeDelete,
+	WSMessageTypeDeleteLast,
+	WSMessageTypeEdit,
+	WSMessageTypeAnnounce,
+	WSMessageTypeMarkSeen,
+	WSMessageTypeReact,
+	WSMessageTypeUnreact,
+}
+
+// defaultWSRequiredRoles gates admin-only request types
+var defaultWSRequiredRoles = map[WSMessageType]models.Role{
+	WSMessageTypeAnnounce: models.RoleAdmin,
+}
+
+// WSRequest is the client → server frame.
+// It deliberately has no timestamp field: message time is always assigned by
+// the server in SendMessage, so any client-supplied "timestamp"/"created_at"
+// is dropped during JSON decoding.
 type WSRequest struct {
 	Type      WSMessageType `json:"type"`
 	TempID    string        `json:"temp_id,omitempty"`
-	Content   string        `json:"content,omitempty"`    // For send_message
-	MessageID string        `json:"message_id,omitempty"` // For delete_message
+	Content   string        `json:"content,omitempty"`    // For send_message, announce, edit_message
+	MessageID string        `json:"message_id,omitempty"` // For delete_message, edit_message, react, unreact
+	Emoji     string        `json:"emoji,omitempty"`      // For react, unreact (see service.ReactionEmojis)
+
+	// For send_message: client-generated idempotency key (e.g. a UUID). A
+	// resend with the same key is acked with the original message_id.
+	ClientMsgID string `json:"client_msg_id,omitempty"`
+
+	MessageIDs []string `json:"message_ids,omitempty"` // For mark_seen
 }
 
 type WSResponse struct {
-	Type      string `json:"type"` // "message", "ack", "error", "message_deleted", "session_expired"
+	Type      string `json:"type"` // "message", "ack", "error", "message_deleted", "message_edited", "session_expired", "presence_delta", "user_online", "user_offline", "reaction_updated", "mention"
 	ID        uint64 `json:"id,omitempty"`        // PostgreSQL auto-increment ID (for pagination)
 	MessageID string `json:"message_id,omitempty"` // UUID (global unique identifier)
 	UserID    string `json:"user_id,omitempty"`
 	Username  string `json:"username,omitempty"`
+	RoomID    string `json:"room_id,omitempty"`
 	Content   string `json:"content,omitempty"`
+	Lang      string `json:"lang,omitempty"` // Detected language, for client-side translation
 	Timestamp string `json:"timestamp,omitempty"`
 	Error     string `json:"error,omitempty"`
 
+	// For message payloads: rune count of Content as stored (after
+	// normalization and HTML escaping), for character counters
+	ContentLength int `json:"content_length,omitempty"`
+
 	// For delete events and initial messages
 	Deleted        bool `json:"deleted,omitempty"`
 	DeletedByAdmin bool `json:"deleted_by_admin,omitempty"`
 
+	// For edit events and initial messages (RFC3339, empty = never edited)
+	EditedAt string `json:"edited_at,omitempty"`
+
+	// For initial messages: approximate number of distinct users who have seen it
+	SeenCount int64 `json:"seen_count,omitempty"`
+
+	// For initial messages: reaction counts by emoji
+	Reactions map[string]int64 `json:"reactions,omitempty"`
+
+	// For reaction_updated: the emoji and its new count (0 is sent, so a pointer)
+	Emoji string `json:"emoji,omitempty"`
+	Count *int64 `json:"count,omitempty"`
+
 	//For ACK
 	TempID string `json:"temp_id,omitempty"`
 	Status string `json:"status,omitempty"`
+
+	// For server-initiated close (session_expired, server_closing)
+	ReconnectAfterMs int64 `json:"reconnect_after_ms,omitempty"`
+
+	// For unknown request types (developer hints)
+	ReceivedType   string          `json:"received_type,omitempty"`
+	SupportedTypes []WSMessageType `json:"supported_types,omitempty"`
+
+	// For presence_delta
+	Joined []PresenceUser `json:"joined,omitempty"`
+	Left   []PresenceUser `json:"left,omitempty"`
+
+	// For user_online / user_offline / presence_delta: distinct users online on this node after the change
+	OnlineCount int `json:"online_count,omitempty"`
+
+	// Set on every frame: 1, 2, 3... per connection, in write order. Unlike
+	// the message ID it counts this connection's frames only, so a gap (5
+	// then 7) means a frame was lost; the client resyncs through the history
+	// endpoints (/api/messages/after/:id).
+	DeliverySeq uint64 `json:"delivery_seq,omitempty"`
+}
+
+// PresenceUser identifies a user in a presence_delta
+type PresenceUser struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+}
+
+// PresenceInfo describes an online user for GET /api/presence.
+// A user with several connections is listed once.
+type PresenceInfo struct {
+	UserID      string    `json:"user_id"`
+	Username    string    `json:"username"`
+	ConnectedAt time.Time `json:"connected_at"` // Oldest open connection
+	Connections int       `json:"connections"`
+}
+
+// presenceChange is a join or leave waiting for the next presence_delta
+type presenceChange struct {
+	user   PresenceUser
+	joined bool
 }
 
 type WebSocketHandler struct {
 	messageService *service.MessageService
+	byteBudget     *middleware.ByteBudget  // optional (nil = no bandwidth limit)
+	sendMetrics    *middleware.SendMetrics // optional (nil = no per-user send rates)
+	messageRate    *middleware.MessageRate // optional (nil = no per-user message rate limit)
+	relay          broker.MessageBroker    // optional (nil = single node, no cross-node broadcast)
 	jwtSecret      string
+	config         WSConfig
+	upgrader       websocket.Upgrader
 	clients        map[*websocket.Conn]*Client
+	userConns      map[uuid.UUID]int // Open connections per user (guarded by mu)
 	mu             sync.RWMutex
+
+	// Presence changes coalesced until presenceTimer fires
+	presencePending map[uuid.UUID]presenceChange
+	presenceTimer   *time.Timer
+	presenceMu      sync.Mutex
 }
 
 type Client struct {
@@ -68,23 +305,204 @@ type Client struct {
 	userID      uuid.UUID
 	username    string
 	role        models.Role
+	unverified  bool   // Email not verified yet: may read but not post
+	roomID      string // Fixed for the connection's lifetime (?room=, default general)
+	mode        string // Subscription mode (?mode=, default SubscriptionAll)
 	connectedAt time.Time
+	codec       frameCodec    // Frame encoding negotiated via subprotocol
+	writeWait   time.Duration // Deadline for each write
+	writeMu     sync.Mutex    // gorilla allows only one concurrent writer
+	deliverySeq uint64        // Last DeliverySeq written (guarded by writeMu)
+
+	// Frames are written by writePump in queue order, so a slow socket only
+	// delays this client. done is closed when the client stops (see stop),
+	// pumpDone when writePump has returned.
+	outbound chan interface{}
+	done     chan struct{}
+	pumpDone chan struct{}
+	stopOnce sync.Once
+	slow     atomic.Bool // Set once the client has been dropped as a slow consumer
+
+	closedByPeer   bool        // The client sent a close frame (read loop only)
+	closedByServer atomic.Bool // closeClient sent a close frame
+
+	log *zap.Logger // Tagged with the upgrade request's ID and the user, for connection logs
+}
+
+// Subscription modes, selected on connect with ?mode=
+const (
+	SubscriptionAll      = "all"      // Every broadcast in the client's room (default)
+	SubscriptionMentions = "mentions" // Low-bandwidth: only messages mentioning the user, plus replies to its own requests
+)
+
+// wants reports whether a broadcast frame should be queued for the client.
+// Replies to the client's own requests (acks, errors) bypass this.
+func (c *Client) wants(frame WSResponse) bool {
+	if c.mode != SubscriptionMentions {
+		return true
+	}
+	return frame.Type == "message" && slices.Contains(service.ExtractMentions(frame.Content), strings.ToLower(c.username))
+}
+
+// errClientStopped is returned when queueing a frame for a client that has stopped
+var errClientStopped = errors.New("client stopped")
+
+// send queues v, waiting while the queue is full. Used for replies to the
+// client's own requests: the wait only slows down that client.
+func (c *Client) send(v interface{}) error {
+	select {
+	case c.outbound <- v:
+		return nil
+	case <-c.done:
+		return errClientStopped
+	}
+}
+
+// trySend queues v without blocking (broadcasts). Returns false only when
+// the queue is full; frames for a stopped client are silently discarded.
+func (c *Client) trySend(v interface{}) bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+	}
+
+	select {
+	case c.outbound <- v:
+		return true
+	default:
+		return false
+	}
+}
+
+// flush stops writePump, lets it finish the frame it is writing, then writes
+// the frames still queued, stopping at the first failed write (used on close)
+func (c *Client) flush() {
+	c.stop()
+	<-c.pumpDone
+
+	for {
+		select {
+		case frame := <-c.outbound:
+			if err := c.writeFrame(frame); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// stop ends writePump and fails pending sends (safe to call more than once)
+func (c *Client) stop() {
+	c.stopOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// writeFrame encodes v with the client's codec and writes it, serialized with
+// the client's other writes. WSResponse frames get the next DeliverySeq.
+func (c *Client) writeFrame(v interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if frame, ok := v.(WSResponse); ok {
+		c.deliverySeq++
+		frame.DeliverySeq = c.deliverySeq
+		v = frame
+	}
+
+	data, err := c.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(c.writeWait))
+	return c.conn.WriteMessage(c.codec.FrameType(), data)
 }
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // add origin check in production
-	},
+// writeMessage writes a raw frame (ping, close), serialized with the client's other writes
+func (c *Client) writeMessage(messageType int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(c.writeWait))
+	return c.conn.WriteMessage(messageType, data)
 }
 
 func NewWebSocketHandler(
 	messageService *service.MessageService,
+	byteBudget *middleware.ByteBudget,
+	sendMetrics *middleware.SendMetrics,
+	messageRate *middleware.MessageRate,
+	relay broker.MessageBroker,
 	jwtSecret string,
+	allowedOrigins []string,
+	config WSConfig,
 ) *WebSocketHandler {
+	config = config.withDefaults()
+	if config.RequiredRoles == nil {
+		config.RequiredRoles = defaultWSRequiredRoles
+	}
+
+	subprotocols := []string{SubprotocolJSON}
+	if config.EnableMessagePack {
+		subprotocols = []string{SubprotocolMsgpack, SubprotocolJSON}
+	}
+
 	return &WebSocketHandler{
 		messageService: messageService,
+		byteBudget:     byteBudget,
+		sendMetrics:    sendMetrics,
+		messageRate:    messageRate,
+		relay:          relay,
 		jwtSecret:      jwtSecret,
-		clients:        make(map[*websocket.Conn]*Client),
+		config:         config,
+		upgrader: websocket.Upgrader{
+			CheckOrigin:       originChecker(allowedOrigins, config.StrictOrigin),
+			ReadBufferSize:    config.ReadBufferSize,
+			WriteBufferSize:   config.WriteBufferSize,
+			EnableCompression: config.EnableCompression,
+			Subprotocols:      subprotocols,
+		},
+		clients:         make(map[*websocket.Conn]*Client),
+		userConns:       make(map[uuid.UUID]int),
+		presencePending: make(map[uuid.UUID]presenceChange),
+	}
+}
+
+// originChecker validates the Origin header of upgrade requests against the
+// allowed origins (the CORS list), so other sites can't open a socket with the
+// user's cookies. Rejected upgrades get 403 from the upgrader.
+func originChecker(allowedOrigins []string, strict bool) func(r *http.Request) bool {
+	allowed := make(map[string]bool, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		allowed[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+	}
+
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			// Browsers always send Origin; its absence means a non-browser client
+			return !strict
+		}
+		if allowed[strings.ToLower(origin)] {
+			return true
+		}
+		if !strict {
+			if u, err := url.Parse(origin); err == nil {
+				switch u.Hostname() {
+				case "localhost", "127.0.0.1", "::1":
+					return true
+				}
+			}
+		}
+
+		logger.Log.Warn("WebSocket upgrade rejected: origin not allowed",
+			zap.String("origin", origin),
+			zap.String("ip", r.RemoteAddr),
+		)
+		return false
 	}
 }
 
@@ -103,10 +521,48 @@ func (h *WebSocketHandler) HandleWebSocket(c *gin.Context) {
 		return
 	}
 
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	// One room per connection; switching rooms means reconnecting
+	roomID, err := service.ResolveRoomID(c.Query("room"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	mode := c.DefaultQuery("mode", SubscriptionAll)
+	if mode != SubscriptionAll && mode != SubscriptionMentions {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid mode (all or mentions)"})
+		return
+	}
+
+	// Reconnects pass the newest message ID they have, to get only what they missed
+	var lastSeenID uint64
+	if raw := c.Query("last_seen_id"); raw != "" {
+		lastSeenID, err = strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid last_seen_id"})
+			return
+		}
+	}
+
+	// WatchBans closes a banned user's sockets, but their access token stays
+	// valid until it expires: don't let them straight back in
+	active, err := h.messageService.IsActiveUser(claims.UserID)
+	if err != nil {
+		logger.FromContext(c).Error("Failed to check account before WebSocket upgrade",
+			zap.String("username", claims.Username),
+			zap.Error(err),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check account"})
+		return
+	}
+	if !active {
+		c.JSON(http.StatusForbidden, gin.H{"error": "account is banned"})
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
-		logger.Log.Error("Failed to upgrade WebSocket connection",
-			zap.String("user_id", claims.UserID.String()),
+		logger.FromContext(c).Error("Failed to upgrade WebSocket connection",
 			zap.String("username", claims.Username),
 			zap.Error(err),
 		)
@@ -118,23 +574,53 @@ func (h *WebSocketHandler) HandleWebSocket(c *gin.Context) {
 		userID:      claims.UserID,
 		username:    claims.Username,
 		role:        claims.Role,
+		unverified:  claims.EmailUnverified,
+		roomID:      roomID,
+		mode:        mode,
 		connectedAt: time.Now(),
+		codec:       codecFor(conn.Subprotocol()),
+		writeWait:   h.config.WriteWait,
+		outbound:    make(chan interface{}, h.config.SendBufferSize),
+		done:        make(chan struct{}),
+		pumpDone:    make(chan struct{}),
+		log:         logger.FromContext(c),
 	}
 
 	h.mu.Lock()
+	if reason := h.connectionLimitReasonLocked(client.userID); reason != "" {
+		h.mu.Unlock()
+		client.log.Warn("WebSocket connection rejected",
+			zap.String("reason", reason),
+		)
+		h.closeClient(client, "connection_limit", websocket.CloseTryAgainLater, reason)
+		conn.Close()
+		return
+	}
 	h.clients[conn] = client
+	h.userConns[client.userID]++
+	cameOnline := h.userConns[client.userID] == 1
 	totalClients := len(h.clients)
+	h.config.Metrics.SetActiveConnections(totalClients)
+	onlineCount := len(h.userConns)
 	h.mu.Unlock()
 
-	logger.Log.Info("WebSocket client connected",
-		zap.String("user_id", client.userID.String()),
+	// Presence counts users, not connections: only the first one announces
+	if cameOnline {
+		h.announcePresence(client, true, onlineCount)
+	}
+
+	client.log.Info("WebSocket client connected",
 		zap.String("username", client.username),
 		zap.String("role", string(client.role)),
+		zap.String("room_id", client.roomID),
+		zap.String("mode", client.mode),
 		zap.Int("total_clients", totalClients),
 	)
-	
-	// ✅ SEND INITIAL 100 MESSAGES FROM REDIS/POSTGRESQL
-	go h.sendInitialMessages(client)
+
+	go h.writePump(client)
+
+	// ✅ SEND INITIAL 100 MESSAGES FROM REDIS/POSTGRESQL (or only the missed ones)
+	go h.sendInitialMessages(client, lastSeenID)
 
 	defer h.removeClient(conn)
 
@@ -143,66 +629,145 @@ func (h *WebSocketHandler) HandleWebSocket(c *gin.Context) {
 
 // handleClient listens for messages from a specific client
 func (h *WebSocketHandler) handleClient(client *Client) {
-	client.conn.SetReadDeadline(time.Now().Add(pongWait))
-	client.conn.SetReadLimit(maxMessageSize)
+	client.conn.SetReadDeadline(time.Now().Add(h.config.PongWait))
+	client.conn.SetReadLimit(h.config.MaxMessageSize)
 
 	client.conn.SetPongHandler(func(string) error {
-		client.conn.SetReadDeadline(time.Now().Add(pongWait))
+		client.conn.SetReadDeadline(time.Now().Add(h.config.PongWait))
 		return nil
 	})
 
-	ticker := time.NewTicker(pingPeriod)
-	defer ticker.Stop()
+	// The client is leaving: deliver what is queued for it (acks of its last
+	// requests) before answering its close frame. The read then fails with
+	// the close error and the loop ends.
+	client.conn.SetCloseHandler(func(code int, text string) error {
+		client.closedByPeer = true
+		client.flush()
 
-	sessionTimer := time.NewTimer(maxSessionLifetime)
-	defer sessionTimer.Stop()
+		message := []byte{}
+		if code != websocket.CloseNoStatusReceived {
+			message = websocket.FormatCloseMessage(code, "")
+		}
+		if err := client.writeMessage(websocket.CloseMessage, message); err != nil {
+			logger.Log.Debug("Failed to answer close frame", zap.Error(err))
+		}
+
+		client.log.Info("WebSocket client closed the connection",
+			zap.String("username", client.username),
+			zap.Int("code", code),
+			zap.String("reason", text),
+		)
+		return nil
+	})
+
+	var readErr error
+	defer func() { h.recordDisconnect(client, readErr) }()
+
+	ticker := time.NewTicker(h.config.PingPeriod)
+	defer ticker.Stop()
 
 	done := make(chan struct{})
 	defer close(done)
 
 	go h.pingClient(client, ticker, done)
 
+	// Session timers run on their own: an idle client is closed on time,
+	// not only once it sends something. Requests slide the session.
+	activity := make(chan struct{}, 1)
+	if h.config.SessionMode != SessionModeOff {
+		go h.watchSession(client, activity, done)
+	}
+
 	for {
-		select {
-		case <-sessionTimer.C:
-			logger.Log.Info("WebSocket session expired",
-				zap.String("user_id", client.userID.String()),
+		client.conn.SetReadDeadline(time.Now().Add(h.config.PongWait))
+
+		var req WSRequest
+		err := h.readRequest(client, &req)
+		if errors.Is(err, errMessageTooLarge) {
+			client.log.Warn("WebSocket message exceeds decompressed size limit",
 				zap.String("username", client.username),
-				zap.Duration("session_duration", time.Since(client.connectedAt)),
+				zap.Int64("limit", h.config.MaxDecompressedSize),
 			)
-			h.closeClientGracefully(client, "session expired after 15 minutes")
+			h.closeClient(client, "message_too_large", websocket.CloseMessageTooBig, err.Error())
+			return
+		}
+		if err != nil {
+			readErr = err
 			return
+		}
 
-		default:
-			client.conn.SetReadDeadline(time.Now().Add(pongWait))
-
-			var req WSRequest
-			err := client.conn.ReadJSON(&req)
-			if err != nil {
-				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-					logger.Log.Warn("WebSocket unexpected close",
-						zap.String("user_id", client.userID.String()),
-						zap.String("username", client.username),
-						zap.Error(err),
-					)
-				}
-				return
-			}
+		// Closed meanwhile (session expired, shutdown): nothing more is handled
+		if client.closedByServer.Load() {
+			return
+		}
+
+		if !h.canSend(client, req.Type) {
+			h.sendForbidden(client, req.Type)
+			continue
+		}
+		if client.unverified && postsContent(req.Type) {
+			h.sendUnverified(client, req.Type)
+			continue
+		}
+
+		switch req.Type {
+		case WSMessageTypeSend:
+			h.handleSendMessage(client, req)
+
+		case WSMessageTypeDelete:
+			h.handleDeleteMessage(client, req)
+
+		case WSMessageTypeDeleteLast:
+			h.handleDeleteLast(client)
+
+		case WSMessageTypeEdit:
+			h.handleEditMessage(client, req)
+
+		case WSMessageTypeAnnounce:
+			h.handleAnnounce(client, req)
+
+		case WSMessageTypeMarkSeen:
+			h.handleMarkSeen(client, req)
 
-			switch req.Type {
-			case WSMessageTypeSend:
-				h.handleSendMessage(client, req)
+		case WSMessageTypeReact, WSMessageTypeUnreact:
+			h.handleReaction(client, req)
 
-			case WSMessageTypeDelete:
-				h.handleDeleteMessage(client, req)
+		default:
+			h.sendUnknownTypeError(client, req.Type)
+			continue
+		}
 
-			default:
-				h.sendError(client, "unknown message type")
+		// Activity keeps a sliding session alive
+		if h.config.SessionMode == SessionModeSliding {
+			select {
+			case activity <- struct{}{}:
+			default: // A reset is already pending
 			}
 		}
 	}
 }
 
+// readRequest reads and decodes one request, stopping once the decompressed
+// payload exceeds MaxDecompressedSize (decompression bomb guard)
+func (h *WebSocketHandler) readRequest(client *Client, req *WSRequest) error {
+	_, r, err := client.conn.NextReader()
+	if err != nil {
+		return err
+	}
+
+	limit := h.config.MaxDecompressedSize
+
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > limit {
+		return errMessageTooLarge
+	}
+
+	return client.codec.Unmarshal(data, req)
+}
+
 func (h *WebSocketHandler) handleSendMessage(client *Client, req WSRequest) {
 	logger.Log.Debug("Message received",
 		zap.String("user_id", client.userID.String()),
@@ -215,126 +780,677 @@ func (h *WebSocketHandler) handleSendMessage(client *Client, req WSRequest) {
 		return
 	}
 
-	msg, err := h.messageService.SendMessage(client.userID, client.username, req.Content)
+	// A resend after a missed ACK is re-ACKed without counting against the
+	// limits again (on lookup errors SendMessageOnce still dedupes)
+	original, err := h.messageService.FindSentMessage(client.userID, req.ClientMsgID)
 	if err != nil {
-		logger.Log.Error("Failed to send message (WAL Error)",
+		logger.Log.Warn("Failed to look up resent message",
 			zap.String("user_id", client.userID.String()),
-			zap.String("username", client.username),
 			zap.Error(err),
 		)
-		h.sendAck(client, req.TempID, "", "error", "failed to write to WAL")
+	}
+	if original != nil {
+		h.sendAck(client, req.TempID, original.MessageID, "success", "")
 		return
 	}
 
-	logger.Log.Info("Message written to WAL",
-		zap.String("message_id", msg.MessageID),
-		zap.String("user_id", client.userID.String()),
-		zap.String("username", client.username),
-	)
-
-	// Direct broadcast to all connected clients (in-memory, same node)
-	h.mu.RLock()
-	clientCount := len(h.clients)
-	h.mu.RUnlock()
-
-	h.broadcastToAll(WSResponse{
-		Type:      "message",
-		ID:        msg.ID,        // PostgreSQL ID (for pagination)
-		MessageID: msg.MessageID, // UUID (global unique identifier)
-		UserID:    client.userID.String(),
-		Username:  client.username,
-		Content:   msg.Content,
-		Timestamp: msg.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
-	})
-
-	logger.Log.Debug("Broadcasted message to all clients",
-		zap.String("message_id", msg.MessageID),
-		zap.Int("client_count", clientCount),
-	)
-
-	h.sendAck(client, req.TempID, msg.MessageID, "success", "")
-}
-
-func (h *WebSocketHandler) handleDeleteMessage(client *Client, req WSRequest) {
-	// Validate message ID
-	if req.MessageID == "" {
-		h.sendError(client, "message_id is required")
+	// Over a limit only this send fails; the connection stays open
+	if err := h.CheckSendLimits(client.userID, client.username, req.Content); err != nil {
+		h.sendAck(client, req.TempID, "", "error", err.Error())
 		return
 	}
 
-	isAdmin := client.role == models.RoleAdmin
-	err := h.messageService.DeleteMessage(req.MessageID, client.userID, isAdmin)
+	msg, duplicate, err := h.messageService.SendMessageOnce(client.userID, client.username, client.roomID, req.Content, req.ClientMsgID)
 	if err != nil {
-		logger.Log.Error("Failed to delete message",
-			zap.String("message_id", req.MessageID),
+		logger.Log.Error("Failed to send message (WAL Error)",
 			zap.String("user_id", client.userID.String()),
+			zap.String("username", client.username),
 			zap.Error(err),
 		)
-		h.sendError(client, err.Error())
+		h.sendAck(client, req.TempID, "", "error", sendFailureReason(err))
 		return
 	}
 
-	logger.Log.Info("Message deleted",
-		zap.String("message_id", req.MessageID),
+	// A resend after a missed ACK: the room already has it, only re-ACK
+	if duplicate {
+		h.sendAck(client, req.TempID, msg.MessageID, "success", "")
+		return
+	}
+
+	logger.Log.Info("Message written to WAL",
+		zap.String("message_id", msg.MessageID),
 		zap.String("user_id", client.userID.String()),
-		zap.Bool("is_admin", isAdmin),
+		zap.String("username", client.username),
 	)
+	h.PublishSentMessage(msg)
 
-	// Direct broadcast delete event to all connected clients (in-memory)
-	h.broadcastDeleteEvent(req.MessageID, isAdmin)
-
-	// Send success response to deleter
-	client.conn.SetWriteDeadline(time.Now().Add(writeWait))
-	if err := client.conn.WriteJSON(WSResponse{
-		Type:      "delete_success",
-		MessageID: req.MessageID,
-	}); err != nil {
-		logger.Log.Warn("Failed to send delete success response", zap.Error(err))
-	}
+	h.sendAck(client, req.TempID, msg.MessageID, "success", "")
 }
 
-func (h *WebSocketHandler) broadcastToAll(msg WSResponse) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+// SendLimitError rejects a send over the user's message rate or byte budget
+type SendLimitError struct {
+	Reason     string        // Client-safe explanation
+	RetryAfter time.Duration // When the send may succeed (0 = unknown)
+}
 
-	for conn := range h.clients {
+func (e *SendLimitError) Error() string {
+	return e.Reason
+}
 
-		conn.SetWriteDeadline(time.Now().Add(writeWait))
+// CheckSendLimits applies the per-user send limits (message rate, then byte
+// budget) to one send of content. Returns a *SendLimitError when over a
+// limit; a send rejected by either limit counts against neither. Redis
+// errors fail open, like the rate limiter.
+func (h *WebSocketHandler) CheckSendLimits(userID uuid.UUID, username, content string) error {
+	slot, err := h.reserveMessageRate(userID, username)
+	if err != nil {
+		return err
+	}
 
-		err := conn.WriteJSON(msg)
+	if h.byteBudget != nil {
+		allowed, used, err := h.byteBudget.Consume(userID.String(), len(content))
 		if err != nil {
-			logger.Log.Debug("Failed to send message to client", zap.Error(err))
-			// Don't remove client here, handleClient will do cleanup
+			logger.Log.Warn("Byte budget check failed",
+				zap.String("user_id", userID.String()),
+				zap.Error(err),
+			)
+		} else if !allowed {
+			logger.Log.Warn("Byte budget exceeded",
+				zap.String("user_id", userID.String()),
+				zap.String("username", username),
+				zap.Int64("bytes_used", used),
+				zap.Int("message_bytes", len(content)),
+			)
+			h.releaseMessageRate(userID, slot)
+			return &SendLimitError{Reason: "byte budget exceeded, please slow down"}
 		}
 	}
+	return nil
 }
 
-func (h *WebSocketHandler) broadcastDeleteEvent(messageID string, deletedByAdmin bool) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+// checkMessageRate records one action (a send or a reaction) against the
+// user's message rate. Returns a *SendLimitError when over it.
+func (h *WebSocketHandler) checkMessageRate(userID uuid.UUID, username string) error {
+	_, err := h.reserveMessageRate(userID, username)
+	return err
+}
 
-	deleteMsg := WSResponse{
-		Type:           "message_deleted",
-		MessageID:      messageID,
-		DeletedByAdmin: deletedByAdmin,
+// reserveMessageRate is checkMessageRate for an action a later check may
+// still reject: it also returns the slot to hand to releaseMessageRate
+func (h *WebSocketHandler) reserveMessageRate(userID uuid.UUID, username string) (string, error) {
+	if h.messageRate == nil {
+		return "", nil
 	}
 
-	for conn := range h.clients {
-		conn.SetWriteDeadline(time.Now().Add(writeWait))
-		if err := conn.WriteJSON(deleteMsg); err != nil {
-			logger.Log.Debug("Failed to broadcast delete event", zap.Error(err))
-		}
-	}
-}
+	allowed, retryAfter, slot, err := h.messageRate.Reserve(userID.String())
+	if err != nil {
+		logger.Log.Warn("Message rate check failed",
+			zap.String("user_id", userID.String()),
+			zap.Error(err),
+		)
+	} else if !allowed {
+		logger.Log.Warn("Message rate exceeded",
+			zap.String("user_id", userID.String()),
+			zap.String("username", username),
+			zap.Duration("retry_after", retryAfter),
+		)
+		return "", &SendLimitError{
+			Reason:     fmt.Sprintf("sending too fast, try again in %ds", int(math.Ceil(retryAfter.Seconds()))),
+			RetryAfter: retryAfter,
+		}
+	}
+	return slot, nil
+}
+
+// releaseMessageRate gives back a slot from reserveMessageRate whose action
+// was rejected after all (best effort: at worst the slot ages out)
+func (h *WebSocketHandler) releaseMessageRate(userID uuid.UUID, slot string) {
+	if h.messageRate == nil {
+		return
+	}
+	if err := h.messageRate.Release(userID.String(), slot); err != nil {
+		logger.Log.Warn("Failed to release message rate slot",
+			zap.String("user_id", userID.String()),
+			zap.Error(err),
+		)
+	}
+}
+
+// PublishSentMessage records a user's new message in the send metrics and
+// delivers it live: to the room on this node, mention events, and the other
+// nodes. Used for sends over WebSocket and REST alike.
+func (h *WebSocketHandler) PublishSentMessage(msg *models.Message) {
+	h.config.Metrics.MessageSent()
+
+	// Per-user send rate for abuse dashboards (best effort)
+	if h.sendMetrics != nil {
+		if err := h.sendMetrics.Record(msg.UserID.String()); err != nil {
+			logger.Log.Warn("Failed to record send metrics",
+				zap.String("user_id", msg.UserID.String()),
+				zap.Error(err),
+			)
+		}
+	}
+
+	// Direct broadcast to the room's connected clients (in-memory, same node)
+	frame := WSResponse{
+		Type:          "message",
+		ID:            msg.ID,        // PostgreSQL ID (for pagination)
+		MessageID:     msg.MessageID, // UUID (global unique identifier)
+		UserID:        msg.UserID.String(),
+		Username:      msg.Username,
+		RoomID:        msg.RoomID,
+		Content:       msg.Content,
+		ContentLength: contentLength(msg.Content),
+		Lang:          msg.Lang,
+		Timestamp:     msg.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+	}
+	clientCount := h.broadcastToRoom(msg.RoomID, frame)
+	h.notifyMentions(frame)
+
+	logger.Log.Debug("Broadcasted message to room",
+		zap.String("message_id", msg.MessageID),
+		zap.String("room_id", msg.RoomID),
+		zap.Int("client_count", clientCount),
+	)
+	h.relayToNodes(*msg)
+}
+
+// canSend reports whether the client's role may send the request type.
+// Admins may send every type.
+func (h *WebSocketHandler) canSend(client *Client, msgType WSMessageType) bool {
+	required, gated := h.config.RequiredRoles[msgType]
+	if !gated {
+		return true
+	}
+	return client.role == required || client.role == models.RoleAdmin
+}
+
+// postsContent reports whether the request type publishes text to the room
+// (what unverified accounts may not do)
+func postsContent(msgType WSMessageType) bool {
+	switch msgType {
+	case WSMessageTypeSend, WSMessageTypeEdit, WSMessageTypeAnnounce:
+		return true
+	}
+	return false
+}
+
+// handleAnnounce posts an admin announcement as the system user
+func (h *WebSocketHandler) handleAnnounce(client *Client, req WSRequest) {
+	if req.Content == "" {
+		h.sendAck(client, req.TempID, "", "error", "content cannot be empty")
+		return
+	}
+
+	msg, err := h.messageService.SendSystemMessage(client.roomID, req.Content)
+	if err != nil {
+		logger.Log.Error("Failed to send announcement",
+			zap.String("admin_id", client.userID.String()),
+			zap.Error(err),
+		)
+		h.sendAck(client, req.TempID, "", "error", sendFailureReason(err))
+		return
+	}
+
+	logger.Log.Info("Announcement sent",
+		zap.String("message_id", msg.MessageID),
+		zap.String("admin_id", client.userID.String()),
+		zap.String("room_id", msg.RoomID),
+	)
+
+	frame := WSResponse{
+		Type:          "message",
+		ID:            msg.ID,
+		MessageID:     msg.MessageID,
+		UserID:        msg.UserID.String(),
+		Username:      msg.Username,
+		RoomID:        msg.RoomID,
+		Content:       msg.Content,
+		ContentLength: contentLength(msg.Content),
+		Lang:          msg.Lang,
+		Timestamp:     msg.CreatedAt.Format(time.RFC3339),
+	}
+	h.broadcastToRoom(msg.RoomID, frame)
+	h.notifyMentions(frame)
+	h.relayToNodes(*msg)
+
+	h.sendAck(client, req.TempID, msg.MessageID, "success", "")
+}
+
+// handleMarkSeen records a read receipt (no reply on success)
+func (h *WebSocketHandler) handleMarkSeen(client *Client, req WSRequest) {
+	if err := h.messageService.MarkSeen(client.userID, req.MessageIDs); err != nil {
+		logger.Log.Debug("Failed to record read receipt",
+			zap.String("user_id", client.userID.String()),
+			zap.Int("message_count", len(req.MessageIDs)),
+			zap.Error(err),
+		)
+		if errors.Is(err, service.ErrTooManySeen) || errors.Is(err, service.ErrInvalidSeenID) {
+			h.sendError(client, err.Error())
+		} else {
+			h.sendError(client, "failed to record read receipt")
+		}
+	}
+}
+
+// handleReaction adds or removes the client's emoji reaction and broadcasts
+// the new count to the message's room, on this node and the others.
+// Reactions count against the user's message rate like sends do.
+func (h *WebSocketHandler) handleReaction(client *Client, req WSRequest) {
+	if req.MessageID == "" {
+		h.sendError(client, "message_id is required")
+		return
+	}
+	if err := h.checkMessageRate(client.userID, client.username); err != nil {
+		h.sendError(client, err.Error())
+		return
+	}
+
+	var msg *models.Message
+	var count int64
+	var err error
+	if req.Type == WSMessageTypeReact {
+		msg, count, err = h.messageService.AddReaction(req.MessageID, client.userID, req.Emoji)
+	} else {
+		msg, count, err = h.messageService.RemoveReaction(req.MessageID, client.userID, req.Emoji)
+	}
+	if err != nil {
+		logger.Log.Debug("Failed to update reaction",
+			zap.String("message_id", req.MessageID),
+			zap.String("user_id", client.userID.String()),
+			zap.String("type", string(req.Type)),
+			zap.Error(err),
+		)
+		switch {
+		case errors.Is(err, service.ErrInvalidEmoji),
+			errors.Is(err, service.ErrMessageNotFound),
+			errors.Is(err, service.ErrAlreadyReacted),
+			errors.Is(err, service.ErrReactionNotFound):
+			h.sendError(client, err.Error())
+		default:
+			h.sendError(client, "failed to update reaction")
+		}
+		return
+	}
+
+	event := broker.ReactionEvent{
+		MessageID: msg.MessageID,
+		RoomID:    msg.RoomID,
+		Emoji:     req.Emoji,
+		Count:     count,
+	}
+	h.broadcastToRoom(msg.RoomID, reactionResponse(event))
+
+	if h.relay != nil {
+		if err := h.relay.PublishReaction(event); err != nil {
+			logger.Log.Warn("Failed to relay reaction to other nodes",
+				zap.String("message_id", msg.MessageID),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// reactionResponse builds the reaction_updated frame for a count change
+func reactionResponse(event broker.ReactionEvent) WSResponse {
+	return WSResponse{
+		Type:      "reaction_updated",
+		MessageID: event.MessageID,
+		RoomID:    event.RoomID,
+		Emoji:     event.Emoji,
+		Count:     &event.Count,
+	}
+}
+
+// sendFailureReason maps a SendMessage error to a client-safe ACK message.
+// Validation/policy errors are returned as-is; anything else is internal.
+func sendFailureReason(err error) string {
+	switch {
+	case errors.Is(err, service.ErrMessageTooShort),
+		errors.Is(err, service.ErrMessageTooLong),
+		errors.Is(err, service.ErrUserBanned),
+		errors.Is(err, service.ErrAccountTooNew),
+		errors.Is(err, service.ErrUserMuted),
+		errors.Is(err, service.ErrMessageBlocked),
+		errors.Is(err, service.ErrBlockedContent),
+		errors.Is(err, service.ErrModerationUnavailable),
+		errors.Is(err, service.ErrInvalidClientID),
+		errors.Is(err, service.ErrInvalidRoom):
+		return err.Error()
+	default:
+		return "failed to write to WAL"
+	}
+}
+
+// handleDeleteLast deletes the client's most recent message in its room
+func (h *WebSocketHandler) handleDeleteLast(client *Client) {
+	isAdmin := client.role == models.RoleAdmin
+	msg, err := h.messageService.DeleteLastMessage(client.userID, client.roomID, isAdmin)
+	if err != nil {
+		logger.Log.Warn("Failed to delete last message",
+			zap.String("user_id", client.userID.String()),
+			zap.String("room_id", client.roomID),
+			zap.Error(err),
+		)
+		h.sendError(client, err.Error())
+		return
+	}
+
+	h.broadcastDeleteEvent(msg.RoomID, msg.MessageID, isAdmin)
+	h.relayToNodes(*msg)
+
+	if err := client.send(WSResponse{
+		Type:      "delete_success",
+		MessageID: msg.MessageID,
+	}); err != nil {
+		logger.Log.Warn("Failed to send delete success response", zap.Error(err))
+	}
+}
+
+func (h *WebSocketHandler) handleDeleteMessage(client *Client, req WSRequest) {
+	// Validate message ID
+	if req.MessageID == "" {
+		h.sendError(client, "message_id is required")
+		return
+	}
+
+	isAdmin := client.role == models.RoleAdmin
+	msg, err := h.messageService.DeleteMessage(req.MessageID, client.userID, isAdmin)
+	if err != nil {
+		logger.Log.Error("Failed to delete message",
+			zap.String("message_id", req.MessageID),
+			zap.String("user_id", client.userID.String()),
+			zap.Error(err),
+		)
+		h.sendError(client, err.Error())
+		return
+	}
+
+	logger.Log.Info("Message deleted",
+		zap.String("message_id", req.MessageID),
+		zap.String("user_id", client.userID.String()),
+		zap.Bool("is_admin", isAdmin),
+	)
+
+	// Direct broadcast delete event to the message's room (in-memory)
+	h.broadcastDeleteEvent(msg.RoomID, req.MessageID, isAdmin)
+	h.relayToNodes(*msg)
+
+	// Send success response to deleter
+	if err := client.send(WSResponse{
+		Type:      "delete_success",
+		MessageID: req.MessageID,
+	}); err != nil {
+		logger.Log.Warn("Failed to send delete success response", zap.Error(err))
+	}
+}
+
+func (h *WebSocketHandler) handleEditMessage(client *Client, req WSRequest) {
+	if req.MessageID == "" {
+		h.sendError(client, "message_id is required")
+		return
+	}
+
+	isAdmin := client.role == models.RoleAdmin
+	msg, err := h.messageService.EditMessage(req.MessageID, client.userID, isAdmin, req.Content)
+	if err != nil {
+		logger.Log.Error("Failed to edit message",
+			zap.String("message_id", req.MessageID),
+			zap.String("user_id", client.userID.String()),
+			zap.Error(err),
+		)
+		h.sendError(client, err.Error())
+		return
+	}
+
+	logger.Log.Info("Message edited",
+		zap.String("message_id", req.MessageID),
+		zap.String("user_id", client.userID.String()),
+		zap.Bool("is_admin", isAdmin),
+	)
+
+	h.broadcastToRoom(msg.RoomID, WSResponse{
+		Type:          "message_edited",
+		ID:            msg.ID,
+		MessageID:     msg.MessageID,
+		UserID:        msg.UserID.String(),
+		RoomID:        msg.RoomID,
+		Content:       msg.Content,
+		ContentLength: contentLength(msg.Content),
+		Lang:          msg.Lang,
+		EditedAt:      msg.EditedAt.Format(time.RFC3339),
+	})
+	h.relayToNodes(*msg)
+}
+
+// broadcastToRoom queues msg for every client in the room. It never waits
+// on a socket: clients whose queue is full are dropped as slow consumers.
+// Returns the number of clients it was queued for.
+func (h *WebSocketHandler) broadcastToRoom(roomID string, msg WSResponse) int {
+	h.mu.RLock()
+	sent := 0
+	var slow []*Client
+	for _, client := range h.clients {
+		if client.roomID != roomID || !client.wants(msg) {
+			continue
+		}
+		if !client.trySend(msg) {
+			slow = append(slow, client)
+			continue
+		}
+		sent++
+	}
+	h.mu.RUnlock()
+
+	h.dropSlowConsumers(slow)
+	return sent
+}
+
+// notifyMentions queues a mention event (a copy of the new message frame)
+// for this node's clients @mentioned in it, whatever room they are in. The
+// author isn't notified. Offline users find it in their notification feed.
+func (h *WebSocketHandler) notifyMentions(frame WSResponse) {
+	usernames := service.ExtractMentions(frame.Content)
+	if len(usernames) == 0 {
+		return
+	}
+	frame.Type = "mention"
+
+	h.mu.RLock()
+	var slow []*Client
+	for _, client := range h.clients {
+		if client.userID.String() == frame.UserID || !slices.Contains(usernames, strings.ToLower(client.username)) {
+			continue
+		}
+		// Mentions-only clients in the room already got it as a message
+		if client.mode == SubscriptionMentions && client.roomID == frame.RoomID {
+			continue
+		}
+		if !client.trySend(frame) {
+			slow = append(slow, client)
+		}
+	}
+	h.mu.RUnlock()
+
+	h.dropSlowConsumers(slow)
+}
+
+// broadcastToAll queues msg for every client on this node regardless of room (presence)
+func (h *WebSocketHandler) broadcastToAll(msg WSResponse) {
+	h.mu.RLock()
+	var slow []*Client
+	for _, client := range h.clients {
+		if !client.wants(msg) {
+			continue
+		}
+		if !client.trySend(msg) {
+			slow = append(slow, client)
+		}
+	}
+	h.mu.RUnlock()
+
+	h.dropSlowConsumers(slow)
+}
+
+// dropSlowConsumers closes clients whose outbound queue overflowed, instead
+// of letting them stall broadcasts. Their sockets are likely backed up, so the
+// close runs in the background and the connection is torn down regardless.
+func (h *WebSocketHandler) dropSlowConsumers(clients []*Client) {
+	for _, client := range clients {
+		if !client.slow.CompareAndSwap(false, true) {
+			continue // Already being dropped
+		}
+
+		client.log.Warn("Dropping slow WebSocket consumer",
+			zap.String("username", client.username),
+			zap.Int("queued_frames", len(client.outbound)),
+		)
+
+		go func(client *Client) {
+			client.stop()
+			h.closeClient(client, "slow_consumer", websocket.CloseTryAgainLater, "client is not reading fast enough")
+			client.conn.Close()
+		}(client)
+	}
+}
+
+// writePump writes queued frames to the socket in order until the client
+// stops. A failed write closes the connection so the reader exits too.
+func (h *WebSocketHandler) writePump(client *Client) {
+	defer close(client.pumpDone)
+	defer client.stop()
+
+	for {
+		select {
+		case frame := <-client.outbound:
+			if err := client.writeFrame(frame); err != nil {
+				logger.Log.Debug("Failed to write frame to client",
+					zap.String("username", client.username),
+					zap.Error(err),
+				)
+				client.conn.Close()
+				return
+			}
+
+		case <-client.done:
+			return
+		}
+	}
+}
+
+// relayToNodes publishes a message event for clients connected to other
+// nodes (best effort: local clients already have it)
+func (h *WebSocketHandler) relayToNodes(msg models.Message) {
+	if h.relay == nil {
+		return
+	}
+	if err := h.relay.Publish(msg); err != nil {
+		logger.Log.Warn("Failed to relay message event to other nodes",
+			zap.String("message_id", msg.MessageID),
+			zap.Error(err),
+		)
+	}
+}
+
+// WatchBroadcasts delivers chat events relayed by other nodes to this
+// node's clients in the event's room. Runs until the channel is closed.
+func (h *WebSocketHandler) WatchBroadcasts(events <-chan broker.BroadcastEvent) {
+	for event := range events {
+		if event.Reaction != nil {
+			h.broadcastToRoom(event.Reaction.RoomID, reactionResponse(*event.Reaction))
+			continue
+		}
+
+		msg := event.Message
+		frame := relayedResponse(msg)
+		h.broadcastToRoom(msg.RoomID, frame)
+		if frame.Type == "message" {
+			h.notifyMentions(frame)
+		}
+	}
+}
+
+// relayedResponse builds the frame a local client would have received for a
+// relayed message event
+func relayedResponse(msg models.Message) WSResponse {
+	switch {
+	case msg.DeletedAt.Valid:
+		return WSResponse{
+			Type:           "message_deleted",
+			MessageID:      msg.MessageID,
+			RoomID:         msg.RoomID,
+			DeletedByAdmin: msg.IsDeletedByAdmin,
+		}
+	case msg.EditedAt != nil:
+		return WSResponse{
+			Type:          "message_edited",
+			ID:            msg.ID,
+			MessageID:     msg.MessageID,
+			UserID:        msg.UserID.String(),
+			RoomID:        msg.RoomID,
+			Content:       msg.Content,
+			ContentLength: contentLength(msg.Content),
+			Lang:          msg.Lang,
+			EditedAt:      msg.EditedAt.Format(time.RFC3339),
+		}
+	default:
+		return WSResponse{
+			Type:          "message",
+			ID:            msg.ID,
+			MessageID:     msg.MessageID,
+			UserID:        msg.UserID.String(),
+			Username:      msg.Username,
+			RoomID:        msg.RoomID,
+			Content:       msg.Content,
+			ContentLength: contentLength(msg.Content),
+			Lang:          msg.Lang,
+			Timestamp:     msg.CreatedAt.Format(time.RFC3339),
+		}
+	}
+}
+
+func (h *WebSocketHandler) broadcastDeleteEvent(roomID, messageID string, deletedByAdmin bool) {
+	h.broadcastToRoom(roomID, WSResponse{
+		Type:           "message_deleted",
+		MessageID:      messageID,
+		RoomID:         roomID,
+		DeletedByAdmin: deletedByAdmin,
+	})
+}
+
+// recordDisconnect logs and counts why handleClient ended: the server closed
+// the connection, the client closed it cleanly, it missed the pong deadline,
+// or it failed
+func (h *WebSocketHandler) recordDisconnect(client *Client, readErr error) {
+	var netErr net.Error
+	reason := "error"
+	switch {
+	case client.closedByServer.Load():
+		reason = "server_close" // Logged by closeClient
+	case client.closedByPeer && !websocket.IsUnexpectedCloseError(readErr,
+		websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived):
+		reason = "client_close" // Logged by the close handler
+	case errors.As(readErr, &netErr) && netErr.Timeout():
+		reason = "timeout"
+		client.log.Info("WebSocket client timed out",
+			zap.String("username", client.username),
+		)
+	case websocket.IsUnexpectedCloseError(readErr, websocket.CloseGoingAway, websocket.CloseAbnormalClosure):
+		client.log.Warn("WebSocket unexpected close",
+			zap.String("username", client.username),
+			zap.Error(readErr),
+		)
+	}
+	h.config.Metrics.WebSocketDisconnected(reason)
+}
 
 func (h *WebSocketHandler) pingClient(client *Client, ticker *time.Ticker, done <-chan struct{}) {
 	for {
 		select {
 		case <-ticker.C:
-			client.conn.SetWriteDeadline(time.Now().Add(writeWait))
-
 			// Send ping message
-			if err := client.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			if err := client.writeMessage(websocket.PingMessage, nil); err != nil {
 				logger.Log.Debug("Ping failed",
 					zap.String("username", client.username),
 					zap.Error(err),
@@ -349,52 +1465,394 @@ func (h *WebSocketHandler) pingClient(client *Client, ticker *time.Ticker, done
 	}
 }
 
+// watchSession closes the client once its session expires or, in sliding
+// mode, reaches SessionMax, whether or not the client is sending. Each
+// signal on activity restarts the sliding inactivity timer.
+func (h *WebSocketHandler) watchSession(client *Client, activity <-chan struct{}, done <-chan struct{}) {
+	sessionTimer := time.NewTimer(h.config.SessionLifetime)
+	defer sessionTimer.Stop()
+
+	// A nil channel never fires, so SessionMax stays out of the select unless sliding
+	var sessionMaxReached <-chan time.Time
+	if h.config.SessionMode == SessionModeSliding {
+		maxTimer := time.NewTimer(h.config.SessionMax)
+		defer maxTimer.Stop()
+		sessionMaxReached = maxTimer.C
+	}
+
+	for {
+		select {
+		case <-activity:
+			sessionTimer.Reset(h.config.SessionLifetime)
+
+		case <-sessionTimer.C:
+			reason := fmt.Sprintf("session expired after %s", h.config.SessionLifetime)
+			if h.config.SessionMode == SessionModeSliding {
+				reason = fmt.Sprintf("session expired after %s of inactivity", h.config.SessionLifetime)
+			}
+			client.log.Info("WebSocket session expired",
+				zap.String("username", client.username),
+				zap.String("session_mode", h.config.SessionMode),
+				zap.Duration("session_duration", time.Since(client.connectedAt)),
+			)
+			h.closeClientGracefully(client, reason)
+			return
+
+		case <-sessionMaxReached:
+			client.log.Info("WebSocket session reached its maximum lifetime",
+				zap.String("username", client.username),
+				zap.Duration("session_duration", time.Since(client.connectedAt)),
+			)
+			h.closeClientGracefully(client, fmt.Sprintf("session reached its maximum lifetime of %s", h.config.SessionMax))
+			return
+
+		case <-done:
+			// handleClient exited, the session ended with it
+			return
+		}
+	}
+}
+
 func (h *WebSocketHandler) closeClientGracefully(client *Client, reason string) {
-	client.conn.SetWriteDeadline(time.Now().Add(writeWait))
-	if err := client.conn.WriteJSON(WSResponse{
-		Type:  "session_expired",
-		Error: reason,
+	h.closeClient(client, "session_expired", websocket.CloseNormalClosure, reason)
+}
+
+// CloseAllClients closes every connection with a jittered reconnect hint
+// (used for maintenance / shedding load so clients don't reconnect at once)
+func (h *WebSocketHandler) CloseAllClients(reason string) {
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.clients))
+	for _, client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.mu.RUnlock()
+
+	for _, client := range clients {
+		h.closeClient(client, "server_closing", websocket.CloseServiceRestart, reason)
+	}
+
+	logger.Log.Info("Closed all WebSocket clients",
+		zap.Int("client_count", len(clients)),
+		zap.String("reason", reason),
+	)
+}
+
+// Shutdown closes every connection with a close frame and waits for their
+// read loops to finish, so messages already received are written to the WAL.
+// Connections still open when ctx expires are closed abruptly.
+func (h *WebSocketHandler) Shutdown(ctx context.Context) error {
+	h.CloseAllClients("server shutting down")
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for h.ClientCount() > 0 {
+		select {
+		case <-ctx.Done():
+			h.mu.RLock()
+			remaining := make([]*Client, 0, len(h.clients))
+			for _, client := range h.clients {
+				remaining = append(remaining, client)
+			}
+			h.mu.RUnlock()
+
+			for _, client := range remaining {
+				client.conn.Close()
+			}
+			logger.Log.Warn("WebSocket clients did not close in time",
+				zap.Int("client_count", len(remaining)),
+			)
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// WatchBans closes the connections of users announced on the ban channel.
+// Runs until the channel is closed.
+func (h *WebSocketHandler) WatchBans(bannedUserIDs <-chan string) {
+	for userID := range bannedUserIDs {
+		h.closeUserClients(userID, "banned")
+	}
+}
+
+// closeUserClients closes every connection belonging to the given user
+func (h *WebSocketHandler) closeUserClients(userID, reason string) {
+	h.mu.RLock()
+	var clients []*Client
+	for _, client := range h.clients {
+		if client.userID.String() == userID {
+			clients = append(clients, client)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, client := range clients {
+		h.closeClient(client, reason, websocket.ClosePolicyViolation, reason)
+		// Don't wait for the peer's close reply - a banned client may never send it
+		client.conn.Close()
+	}
+
+	if len(clients) > 0 {
+		logger.Log.Info("Closed WebSocket connections for user",
+			zap.String("user_id", userID),
+			zap.Int("client_count", len(clients)),
+			zap.String("reason", reason),
+		)
+	}
+}
+
+// closeClient sends a final JSON event and a close frame, both carrying a
+// suggested reconnect delay
+func (h *WebSocketHandler) closeClient(client *Client, eventType string, code int, reason string) {
+	client.closedByServer.Store(true)
+	reconnectAfter := h.reconnectDelay()
+
+	if err := client.writeFrame(WSResponse{
+		Type:             eventType,
+		Error:            reason,
+		ReconnectAfterMs: reconnectAfter.Milliseconds(),
 	}); err != nil {
-		logger.Log.Debug("Failed to send session_expired message", zap.Error(err))
+		logger.Log.Debug("Failed to send close event message",
+			zap.String("type", eventType),
+			zap.Error(err),
+		)
 	}
 
 	// Send WebSocket close frame (Gorilla WebSocket protocol)
-	client.conn.SetWriteDeadline(time.Now().Add(writeWait))
-	if err := client.conn.WriteMessage(
+	if err := client.writeMessage(
 		websocket.CloseMessage,
-		websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason),
+		websocket.FormatCloseMessage(code, formatCloseReason(reason, reconnectAfter)),
 	); err != nil {
 		logger.Log.Debug("Failed to send close frame", zap.Error(err))
 	}
 
-	logger.Log.Info("Closed WebSocket connection gracefully",
+	client.log.Info("Closed WebSocket connection gracefully",
 		zap.String("username", client.username),
 		zap.String("reason", reason),
+		zap.Duration("reconnect_after", reconnectAfter),
 	)
 }
 
+// reconnectDelay returns ReconnectBase plus a random jitter in [0, ReconnectJitter)
+func (h *WebSocketHandler) reconnectDelay() time.Duration {
+	delay := h.config.ReconnectBase
+	if h.config.ReconnectJitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(h.config.ReconnectJitter)))
+	}
+	return delay
+}
+
+// CloseReason is the JSON payload carried in server-initiated close frames
+type CloseReason struct {
+	Reason           string `json:"reason"`
+	ReconnectAfterMs int64  `json:"reconnect_after_ms"`
+}
+
+// formatCloseReason encodes the close payload, truncating the reason so the
+// frame stays within the 123-byte control frame limit
+func formatCloseReason(reason string, reconnectAfter time.Duration) string {
+	payload := CloseReason{Reason: reason, ReconnectAfterMs: reconnectAfter.Milliseconds()}
+	for {
+		data, _ := json.Marshal(payload)
+		if len(data) <= maxCloseReasonBytes || payload.Reason == "" {
+			return string(data)
+		}
+		payload.Reason = payload.Reason[:len(payload.Reason)-1]
+	}
+}
+
+// connectionLimitReasonLocked returns why a new connection for the user would
+// exceed MaxConnections or MaxConnectionsPerUser ("" = allowed). Caller holds mu.
+func (h *WebSocketHandler) connectionLimitReasonLocked(userID uuid.UUID) string {
+	if h.config.MaxConnections > 0 && len(h.clients) >= h.config.MaxConnections {
+		return "server is at its connection limit"
+	}
+	if h.config.MaxConnectionsPerUser > 0 && h.userConns[userID] >= h.config.MaxConnectionsPerUser {
+		return fmt.Sprintf("too many connections (max %d per user)", h.config.MaxConnectionsPerUser)
+	}
+	return ""
+}
+
+// ClientCount returns the number of currently connected clients
+func (h *WebSocketHandler) ClientCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients)
+}
+
 func (h *WebSocketHandler) removeClient(conn *websocket.Conn) {
 	h.mu.Lock()
-	defer h.mu.Unlock()
 
 	client, exists := h.clients[conn]
+	wentOffline := false
+	onlineCount := 0
 	if exists {
 		delete(h.clients, conn)
+		client.stop()
 		conn.Close()
+		h.config.Metrics.SetActiveConnections(len(h.clients))
+
+		h.userConns[client.userID]--
+		if h.userConns[client.userID] <= 0 {
+			delete(h.userConns, client.userID)
+			wentOffline = true
+		}
+		onlineCount = len(h.userConns)
 
 		// Calculate session duration
 		duration := time.Since(client.connectedAt)
-		logger.Log.Info("WebSocket client disconnected",
+		client.log.Info("WebSocket client disconnected",
 			zap.String("username", client.username),
 			zap.Duration("session_duration", duration),
 			zap.Int("remaining_clients", len(h.clients)),
 		)
 	}
+
+	h.mu.Unlock()
+
+	// Outside mu: an immediate flush broadcasts, which takes mu
+	if wentOffline {
+		h.announcePresence(client, false, onlineCount)
+	}
+}
+
+// announcePresence tells every client that a user came online or went offline.
+// Without a PresenceWindow each change is sent right away as user_online or
+// user_offline; otherwise it waits for the next presence_delta, so a burst of
+// connects costs each client one frame instead of one per user.
+func (h *WebSocketHandler) announcePresence(client *Client, joined bool, onlineCount int) {
+	if h.config.PresenceWindow > 0 {
+		h.recordPresence(client, joined)
+		return
+	}
+
+	eventType := "user_online"
+	if !joined {
+		eventType = "user_offline"
+	}
+	h.broadcastToAll(WSResponse{
+		Type:        eventType,
+		UserID:      client.userID.String(),
+		Username:    client.username,
+		OnlineCount: onlineCount,
+	})
+}
+
+// GetOnlineUsers lists users connected to this node, sorted by username.
+// Multiple connections of a user are counted once, with their number in Connections.
+func (h *WebSocketHandler) GetOnlineUsers() []PresenceInfo {
+	h.mu.RLock()
+	byUser := make(map[uuid.UUID]*PresenceInfo, len(h.userConns))
+	for _, client := range h.clients {
+		info, ok := byUser[client.userID]
+		if !ok {
+			info = &PresenceInfo{
+				UserID:      client.userID.String(),
+				Username:    client.username,
+				ConnectedAt: client.connectedAt,
+			}
+			byUser[client.userID] = info
+		}
+		info.Connections++
+		if client.connectedAt.Before(info.ConnectedAt) {
+			info.ConnectedAt = client.connectedAt
+		}
+	}
+	h.mu.RUnlock()
+
+	users := make([]PresenceInfo, 0, len(byUser))
+	for _, info := range byUser {
+		users = append(users, *info)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+	return users
+}
+
+// GetPresence returns the online users and their connection counts
+// GET /api/presence
+func (h *WebSocketHandler) GetPresence(c *gin.Context) {
+	users := h.GetOnlineUsers()
+
+	connections := 0
+	for _, user := range users {
+		connections += user.Connections
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":            users,
+		"online_count":     len(users),
+		"connection_count": connections,
+	})
+}
+
+// recordPresence queues a user's join or leave for the next presence_delta.
+// The first change in a quiet period starts the PresenceWindow timer.
+func (h *WebSocketHandler) recordPresence(client *Client, joined bool) {
+	h.presenceMu.Lock()
+
+	if pending, ok := h.presencePending[client.userID]; ok && pending.joined != joined {
+		// Joined and left (or left and rejoined) within one window: no net change
+		delete(h.presencePending, client.userID)
+	} else {
+		h.presencePending[client.userID] = presenceChange{
+			user:   PresenceUser{UserID: client.userID.String(), Username: client.username},
+			joined: joined,
+		}
+	}
+
+	if h.presenceTimer == nil {
+		h.presenceTimer = time.AfterFunc(h.config.PresenceWindow, h.flushPresence)
+	}
+	h.presenceMu.Unlock()
+}
+
+// flushPresence broadcasts the pending changes as a single presence_delta
+func (h *WebSocketHandler) flushPresence() {
+	h.presenceMu.Lock()
+	pending := h.presencePending
+	h.presencePending = make(map[uuid.UUID]presenceChange)
+	h.presenceTimer = nil
+	h.presenceMu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	h.mu.RLock()
+	onlineCount := len(h.userConns)
+	h.mu.RUnlock()
+
+	delta := WSResponse{Type: "presence_delta", OnlineCount: onlineCount}
+	for _, change := range pending {
+		if change.joined {
+			delta.Joined = append(delta.Joined, change.user)
+		} else {
+			delta.Left = append(delta.Left, change.user)
+		}
+	}
+	sortPresenceUsers(delta.Joined)
+	sortPresenceUsers(delta.Left)
+
+	h.broadcastToAll(delta)
+
+	logger.Log.Debug("Broadcasted presence delta",
+		zap.Int("joined", len(delta.Joined)),
+		zap.Int("left", len(delta.Left)),
+	)
+}
+
+// sortPresenceUsers orders users by username so deltas are deterministic
+func sortPresenceUsers(users []PresenceUser) {
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
 }
 
 func (h *WebSocketHandler) sendError(client *Client, errorMsg string) {
-	client.conn.SetWriteDeadline(time.Now().Add(writeWait))
-	if err := client.conn.WriteJSON(WSResponse{
+	if err := client.send(WSResponse{
 		Type:  "error",
 		Error: errorMsg,
 	}); err != nil {
@@ -402,9 +1860,55 @@ func (h *WebSocketHandler) sendError(client *Client, errorMsg string) {
 	}
 }
 
-func (h *WebSocketHandler) sendAck(client *Client, tempID, messageID, status, errorMsg string) {
-	client.conn.SetWriteDeadline(time.Now().Add(writeWait))
+// sendUnknownTypeError tells the client which type it sent and which are supported
+func (h *WebSocketHandler) sendUnknownTypeError(client *Client, received WSMessageType) {
+	logger.Log.Debug("Unknown WebSocket message type",
+		zap.String("username", client.username),
+		zap.String("received_type", string(received)),
+	)
+
+	if err := client.send(WSResponse{
+		Type:           "error",
+		Error:          "unknown message type",
+		ReceivedType:   string(received),
+		SupportedTypes: supportedWSMessageTypes,
+	}); err != nil {
+		logger.Log.Debug("Failed to send error message", zap.Error(err))
+	}
+}
+
+// sendForbidden tells the client its role may not send the request type
+func (h *WebSocketHandler) sendForbidden(client *Client, msgType WSMessageType) {
+	logger.Log.Warn("Forbidden WebSocket message type",
+		zap.String("user_id", client.userID.String()),
+		zap.String("role", string(client.role)),
+		zap.String("received_type", string(msgType)),
+	)
+
+	if err := client.send(WSResponse{
+		Type:         "error",
+		Error:        fmt.Sprintf("forbidden: %s requires %s role", msgType, h.config.RequiredRoles[msgType]),
+		ReceivedType: string(msgType),
+	}); err != nil {
+		logger.Log.Debug("Failed to send error message", zap.Error(err))
+	}
+}
+
+func (h *WebSocketHandler) sendUnverified(client *Client, msgType WSMessageType) {
+	client.log.Debug("Unverified account tried to post",
+		zap.String("received_type", string(msgType)),
+	)
+
+	if err := client.send(WSResponse{
+		Type:         "error",
+		Error:        "verify your email address to send messages",
+		ReceivedType: string(msgType),
+	}); err != nil {
+		logger.Log.Debug("Failed to send error message", zap.Error(err))
+	}
+}
 
+func (h *WebSocketHandler) sendAck(client *Client, tempID, messageID, status, errorMsg string) {
 	ackResponse := WSResponse{
 		Type:      "ack",
 		TempID:    tempID,
@@ -416,15 +1920,26 @@ func (h *WebSocketHandler) sendAck(client *Client, tempID, messageID, status, er
 		ackResponse.Error = errorMsg
 	}
 
-	if err := client.conn.WriteJSON(ackResponse); err != nil {
+	if err := client.send(ackResponse); err != nil {
 		logger.Log.Debug("Failed to send ACK", zap.Error(err))
 	}
 }
 
-// sendInitialMessages sends last 100 messages from Redis/PostgreSQL to newly connected client
-func (h *WebSocketHandler) sendInitialMessages(client *Client) {
+// sendInitialMessages sends last 100 messages from Redis/PostgreSQL to newly
+// connected client. A reconnect with lastSeenID gets only the messages after
+// it, then a sync_complete event: "caught_up" when only missed messages were
+// sent, or "reset" when the gap was too large and the client should replace
+// what it has with the latest 100.
+func (h *WebSocketHandler) sendInitialMessages(client *Client, lastSeenID uint64) {
 	// Get last 100 messages from database (Redis cache or PostgreSQL)
-	messages, err := h.messageService.GetRecentMessages(100)
+	var messages []models.Message
+	var err error
+	caughtUp := false
+	if lastSeenID > 0 {
+		messages, caughtUp, err = h.messageService.GetMessagesSince(client.roomID, lastSeenID, 100)
+	} else {
+		messages, err = h.messageService.GetRecentMessages(client.roomID, 100)
+	}
 	if err != nil {
 		logger.Log.Error("Failed to load initial messages",
 			zap.String("username", client.username),
@@ -435,6 +1950,28 @@ func (h *WebSocketHandler) sendInitialMessages(client *Client) {
 
 	isAdmin := client.role == models.RoleAdmin
 
+	// Seen counts are best effort - send history without them on failure
+	messageIDs := make([]string, len(messages))
+	for i, msg := range messages {
+		messageIDs[i] = msg.MessageID
+	}
+	seenCounts, err := h.messageService.GetSeenCounts(messageIDs)
+	if err != nil {
+		logger.Log.Warn("Failed to load seen counts",
+			zap.String("username", client.username),
+			zap.Error(err),
+		)
+	}
+
+	// Reactions are best effort too
+	reactions, err := h.messageService.GetReactionCounts(messageIDs)
+	if err != nil {
+		logger.Log.Warn("Failed to load reaction counts",
+			zap.String("username", client.username),
+			zap.Error(err),
+		)
+	}
+
 	// Reverse messages so newest is sent first (frontend expects newest at top)
 	for i := len(messages) - 1; i >= 0; i-- {
 		msg := messages[i]
@@ -459,14 +1996,24 @@ func (h *WebSocketHandler) sendInitialMessages(client *Client) {
 			MessageID:      msg.MessageID, // UUID (global unique identifier)
 			UserID:         msg.UserID.String(),
 			Username:       msg.Username, // ✅ Use denormalized username field
+			RoomID:         msg.RoomID,
 			Content:        content,
+			ContentLength:  contentLength(content),
+			Lang:           msg.Lang,
 			Timestamp:      msg.CreatedAt.Format(time.RFC3339),
 			Deleted:        deleted,        // ✅ Send deleted flag
 			DeletedByAdmin: deletedByAdmin, // ✅ Send deleted_by_admin flag
+			SeenCount:      seenCounts[msg.MessageID],
+			Reactions:      reactions[msg.MessageID],
+		}
+		if msg.EditedAt != nil {
+			wsMsg.EditedAt = msg.EditedAt.Format(time.RFC3339)
+		}
+		if !client.wants(wsMsg) {
+			continue
 		}
 
-		client.conn.SetWriteDeadline(time.Now().Add(writeWait))
-		if err := client.conn.WriteJSON(wsMsg); err != nil {
+		if err := client.send(wsMsg); err != nil {
 			logger.Log.Warn("Failed to send initial message",
 				zap.String("username", client.username),
 				zap.Error(err),
@@ -475,8 +2022,24 @@ func (h *WebSocketHandler) sendInitialMessages(client *Client) {
 		}
 	}
 
+	if lastSeenID > 0 {
+		syncStatus := "reset"
+		if caughtUp {
+			syncStatus = "caught_up"
+		}
+		if err := client.send(WSResponse{Type: "sync_complete", RoomID: client.roomID, Status: syncStatus}); err != nil {
+			logger.Log.Warn("Failed to send sync_complete",
+				zap.String("username", client.username),
+				zap.Error(err),
+			)
+			return
+		}
+	}
+
 	logger.Log.Info("Sent initial messages to new client",
 		zap.String("username", client.username),
 		zap.Int("message_count", len(messages)),
+		zap.Uint64("last_seen_id", lastSeenID),
+		zap.Bool("caught_up", caughtUp),
 	)
 }
\ No newline at end of file
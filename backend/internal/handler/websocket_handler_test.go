@@ -0,0 +1,1661 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Baaaki/digital-square/internal/broker"
+	"github.com/Baaaki/digital-square/internal/handler"
+	"github.com/Baaaki/digital-square/internal/middleware"
+	"github.com/Baaaki/digital-square/internal/models"
+	"github.com/Baaaki/digital-square/internal/repository"
+	"github.com/Baaaki/digital-square/internal/service"
+	"github.com/Baaaki/digital-square/internal/testutil"
+	"github.com/Baaaki/digital-square/internal/utils"
+	"github.com/Baaaki/digital-square/internal/wal"
+	"github.com/Baaaki/digital-square/pkg/logger"
+	"github.com/Baaaki/digital-square/pkg/metrics"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/ugorji/go/codec"
+)
+
+const wsTestSecret = "test-secret-key"
+
+// wsTestOrigin is the only origin on the test server's allowed list
+const wsTestOrigin = "https://chat.example.com"
+
+// WebSocketHandlerTestSuite runs a real WebSocket server (httptest) against
+// in-memory SQLite, miniredis and a temporary WAL
+type WebSocketHandlerTestSuite struct {
+	suite.Suite
+	testDB         *testutil.TestDatabase
+	testRedis      *testutil.TestRedis
+	walInstance    *wal.WAL
+	redisBroker    *broker.RedisMessageBroker
+	messageService *service.MessageService
+	wsHandler      *handler.WebSocketHandler
+	byteBudget     *middleware.ByteBudget
+	messageRate    *middleware.MessageRate
+	server         *httptest.Server
+	testUser       *testutil.TestUser
+}
+
+// SetupSuite runs before all tests
+func (s *WebSocketHandlerTestSuite) SetupSuite() {
+	gin.SetMode(gin.TestMode)
+	logger.Init(false)
+
+	s.testDB = testutil.SetupTestDatabase(s.T())
+}
+
+// TearDownSuite runs after all tests
+func (s *WebSocketHandlerTestSuite) TearDownSuite() {
+	s.testDB.Teardown(s.T())
+}
+
+// SetupTest builds a fresh handler + server for each test
+func (s *WebSocketHandlerTestSuite) SetupTest() {
+	testutil.CleanDatabase(s.T(), s.testDB.DB)
+
+	// A fresh Redis per test: async cache writes still in flight from the
+	// previous test land on its server instead of leaking into this one
+	s.testRedis = testutil.SetupTestRedis(s.T())
+
+	walInstance, err := wal.NewWAL(filepath.Join(s.T().TempDir(), "wal.log"))
+	require.NoError(s.T(), err)
+	s.walInstance = walInstance
+
+	redisBroker, err := broker.NewRedisMessageBroker(s.testRedis.URL, broker.BrokerConfig{})
+	require.NoError(s.T(), err)
+	s.redisBroker = redisBroker
+
+	messageRepo := repository.NewMessageRepository(s.testDB.DB)
+	userRepo := repository.NewUserRepository(s.testDB.DB)
+	s.messageService = service.NewMessageService(messageRepo, userRepo, s.redisBroker, s.walInstance, service.MessageServiceConfig{})
+	s.startServer(handler.DefaultWSConfig())
+
+	s.testUser, _ = testutil.CreateTestUser("wsuser", "ws@example.com", "Test123456", models.RoleUser)
+	s.testDB.DB.Create(s.testUser)
+}
+
+// startServer (re)starts the test server with a handler using the given config
+func (s *WebSocketHandlerTestSuite) startServer(config handler.WSConfig) {
+	if s.server != nil {
+		s.server.Close()
+	}
+
+	s.wsHandler = handler.NewWebSocketHandler(s.messageService, s.byteBudget, nil, s.messageRate, s.redisBroker, wsTestSecret, []string{wsTestOrigin}, config)
+
+	router := gin.New()
+	router.GET("/api/ws", middleware.AuthMiddleware(wsTestSecret, nil), s.wsHandler.HandleWebSocket)
+	router.GET("/api/presence", middleware.AuthMiddleware(wsTestSecret, nil), s.wsHandler.GetPresence)
+	router.POST("/api/messages", middleware.AuthMiddleware(wsTestSecret, nil), handler.NewMessageHandler(s.messageService, s.wsHandler).Send)
+	s.server = httptest.NewServer(router)
+}
+
+// TearDownTest stops the server and closes the WAL
+func (s *WebSocketHandlerTestSuite) TearDownTest() {
+	s.server.Close()
+	s.server = nil
+	s.byteBudget = nil
+	s.messageRate = nil
+	s.walInstance.Close()
+	s.redisBroker.Close()
+	s.testRedis.Teardown(s.T())
+}
+
+// dial opens a WebSocket connection authenticated as the given test user
+func (s *WebSocketHandlerTestSuite) dial(user *testutil.TestUser) *websocket.Conn {
+	return s.dialWith(websocket.DefaultDialer, user)
+}
+
+// dialWith is dial using a custom dialer (e.g. with compression enabled)
+func (s *WebSocketHandlerTestSuite) dialWith(dialer *websocket.Dialer, user *testutil.TestUser) *websocket.Conn {
+	conn, _, err := dialer.Dial(s.wsURL(""), s.authHeader(user))
+	require.NoError(s.T(), err)
+	return conn
+}
+
+// dialRoom opens a WebSocket connection joined to the given room
+func (s *WebSocketHandlerTestSuite) dialRoom(user *testutil.TestUser, room string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial(s.wsURL("?room="+room), s.authHeader(user))
+	require.NoError(s.T(), err)
+	return conn
+}
+
+// wsURL is the test server's WebSocket endpoint with an optional query string
+func (s *WebSocketHandlerTestSuite) wsURL(query string) string {
+	return "ws" + strings.TrimPrefix(s.server.URL, "http") + "/api/ws" + query
+}
+
+// authHeader carries a fresh token for the given test user
+func (s *WebSocketHandlerTestSuite) authHeader(user *testutil.TestUser) http.Header {
+	token, err := utils.GenerateToken(&models.User{
+		ID:       testutil.ParseUUID(s.T(), user.ID),
+		Username: user.Username,
+		Email:    user.Email,
+		Role:     models.Role(user.Role),
+
+		EmailVerified: user.EmailVerified,
+	}, wsTestSecret, time.Hour)
+	require.NoError(s.T(), err)
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+token)
+	return header
+}
+
+// readUntil reads frames until one with the given type arrives
+func (s *WebSocketHandlerTestSuite) readUntil(conn *websocket.Conn, msgType string) map[string]interface{} {
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		require.NoError(s.T(), err, "waiting for %q frame", msgType)
+
+		var frame map[string]interface{}
+		require.NoError(s.T(), json.Unmarshal(data, &frame))
+		if frame["type"] == msgType {
+			return frame
+		}
+	}
+}
+
+// TestSendMessageIgnoresClientTimestamp ensures a client cannot backdate or
+// forward-date its messages by sending its own timestamp fields
+func (s *WebSocketHandlerTestSuite) TestSendMessageIgnoresClientTimestamp() {
+	conn := s.dial(s.testUser)
+	defer conn.Close()
+
+	spoofed := "2001-01-01T00:00:00Z"
+	before := time.Now().Add(-time.Second)
+
+	err := conn.WriteJSON(map[string]interface{}{
+		"type":       "send_message",
+		"temp_id":    "temp-1",
+		"content":    "hello",
+		"timestamp":  spoofed,
+		"created_at": spoofed,
+	})
+	require.NoError(s.T(), err)
+
+	frame := s.readUntil(conn, "message")
+	assert.NotEqual(s.T(), spoofed, frame["timestamp"])
+
+	ts, err := time.Parse(time.RFC3339, frame["timestamp"].(string))
+	require.NoError(s.T(), err)
+	assert.True(s.T(), ts.After(before), "broadcast timestamp must come from the server clock")
+
+	// The durable copy in the WAL must carry server time as well
+	entries, err := s.walInstance.GetAllEntries()
+	require.NoError(s.T(), err)
+	require.Len(s.T(), entries, 1)
+	assert.True(s.T(), entries[0].Timestamp.After(before))
+}
+
+// TestResendWithClientMsgIDIsAckedOnce tests that a resend after a missed ACK
+// is acked with the original message_id without a second broadcast, and
+// doesn't count against the message rate
+func (s *WebSocketHandlerTestSuite) TestResendWithClientMsgIDIsAckedOnce() {
+	redisClient := redis.NewClient(&redis.Options{Addr: s.testRedis.Server.Addr()})
+	defer redisClient.Close()
+	s.messageRate = middleware.NewMessageRate(redisClient, middleware.MessageRateConfig{
+		MaxMessages: 1,
+		Window:      10 * time.Second,
+	})
+	s.startServer(handler.DefaultWSConfig())
+
+	conn := s.dial(s.testUser)
+	defer conn.Close()
+
+	for _, tempID := range []string{"try-1", "try-2"} {
+		require.NoError(s.T(), conn.WriteJSON(map[string]string{
+			"type": "send_message", "temp_id": tempID, "client_msg_id": "flaky-42", "content": "made it?",
+		}))
+	}
+
+	// Collect both ACKs and any broadcasts in between
+	var acks []map[string]interface{}
+	broadcasts := 0
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for len(acks) < 2 {
+		var frame map[string]interface{}
+		require.NoError(s.T(), conn.ReadJSON(&frame))
+		switch frame["type"] {
+		case "ack":
+			acks = append(acks, frame)
+		case "message":
+			broadcasts++
+		}
+	}
+
+	assert.Equal(s.T(), 1, broadcasts, "the resend must not be broadcast again")
+	assert.Equal(s.T(), "success", acks[1]["status"])
+	assert.Equal(s.T(), "try-2", acks[1]["temp_id"])
+	assert.Equal(s.T(), acks[0]["message_id"], acks[1]["message_id"], "both ACKs carry the canonical message_id")
+
+	entries, err := s.walInstance.GetAllEntries()
+	require.NoError(s.T(), err)
+	assert.Len(s.T(), entries, 1)
+}
+
+// TestReconnectWithLastSeenIDCatchesUp tests that a reconnect only gets the
+// messages after the one it last saw, followed by sync_complete
+func (s *WebSocketHandlerTestSuite) TestReconnectWithLastSeenIDCatchesUp() {
+	userID := testutil.ParseUUID(s.T(), s.testUser.ID)
+	for _, content := range []string{"seen", "missed 1", "missed 2"} {
+		_, err := s.messageService.SendMessage(userID, s.testUser.Username, models.DefaultRoomID, content)
+		require.NoError(s.T(), err)
+	}
+	_, err := s.messageService.ProcessBatchNow()
+	require.NoError(s.T(), err)
+	persisted, err := s.messageService.GetMessagesAfter(models.DefaultRoomID, 0, 10, false)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), persisted, 3)
+
+	conn, _, err := websocket.DefaultDialer.Dial(s.wsURL(fmt.Sprintf("?last_seen_id=%d", persisted[0].ID)), s.authHeader(s.testUser))
+	require.NoError(s.T(), err)
+	defer conn.Close()
+
+	var received []interface{}
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		var frame map[string]interface{}
+		require.NoError(s.T(), conn.ReadJSON(&frame))
+		if frame["type"] == "message" {
+			received = append(received, frame["content"])
+		}
+		if frame["type"] == "sync_complete" {
+			assert.Equal(s.T(), "caught_up", frame["status"])
+			break
+		}
+	}
+	assert.ElementsMatch(s.T(), []interface{}{"missed 1", "missed 2"}, received)
+
+	// Malformed IDs are rejected before the upgrade
+	_, resp, err := websocket.DefaultDialer.Dial(s.wsURL("?last_seen_id=latest"), s.authHeader(s.testUser))
+	require.Error(s.T(), err)
+	require.NotNil(s.T(), resp)
+	assert.Equal(s.T(), http.StatusBadRequest, resp.StatusCode)
+}
+
+// postMessage sends a message through the REST endpoint as the given user
+func (s *WebSocketHandlerTestSuite) postMessage(user *testutil.TestUser, query, body string) (*http.Response, map[string]interface{}) {
+	req, err := http.NewRequest(http.MethodPost, s.server.URL+"/api/messages"+query, strings.NewReader(body))
+	require.NoError(s.T(), err)
+	req.Header = s.authHeader(user)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(s.T(), err)
+	defer resp.Body.Close()
+
+	var payload map[string]interface{}
+	require.NoError(s.T(), json.NewDecoder(resp.Body).Decode(&payload))
+	return resp, payload
+}
+
+// TestRESTSendIsBroadcastLive tests that a message sent over REST reaches
+// WebSocket clients in its room, under the same gates as WebSocket sends
+func (s *WebSocketHandlerTestSuite) TestRESTSendIsBroadcastLive() {
+	redisClient := redis.NewClient(&redis.Options{Addr: s.testRedis.Server.Addr()})
+	defer redisClient.Close()
+	s.messageRate = middleware.NewMessageRate(redisClient, middleware.MessageRateConfig{
+		MaxMessages: 1,
+		Window:      10 * time.Second,
+	})
+	s.startServer(handler.DefaultWSConfig())
+
+	conn := s.dialRoom(s.testUser, "bots")
+	defer conn.Close()
+
+	resp, payload := s.postMessage(s.testUser, "?room=bots", `{"content":"hello from curl","temp_id":"t-1","client_msg_id":"curl-1"}`)
+	require.Equal(s.T(), http.StatusCreated, resp.StatusCode)
+	assert.Equal(s.T(), "t-1", payload["temp_id"])
+	assert.Equal(s.T(), "bots", payload["room_id"])
+
+	frame := s.readUntil(conn, "message")
+	assert.Equal(s.T(), payload["message_id"], frame["message_id"])
+	assert.Equal(s.T(), "hello from curl", frame["content"])
+
+	// A retry isn't a new message, so the rate doesn't apply to it
+	resp, retry := s.postMessage(s.testUser, "?room=bots", `{"content":"hello from curl","temp_id":"t-2","client_msg_id":"curl-1"}`)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	assert.Equal(s.T(), true, retry["duplicate"])
+	assert.Equal(s.T(), payload["message_id"], retry["message_id"])
+
+	// Shares the per-user message rate with WebSocket sends
+	resp, _ = s.postMessage(s.testUser, "?room=bots", `{"content":"again"}`)
+	assert.Equal(s.T(), http.StatusTooManyRequests, resp.StatusCode)
+	assert.NotEmpty(s.T(), resp.Header.Get("Retry-After"))
+
+	newcomer, _ := testutil.CreateTestUser("restnewcomer", "restnewcomer@example.com", "Test123456", models.RoleUser)
+	newcomer.EmailVerified = false
+	s.testDB.DB.Create(newcomer)
+	resp, payload = s.postMessage(newcomer, "", `{"content":"first!"}`)
+	assert.Equal(s.T(), http.StatusForbidden, resp.StatusCode)
+	assert.Contains(s.T(), payload["error"], "verify your email")
+}
+
+// TestContentLengthCountsStoredRunes tests that content_length is the rune
+// count of the stored (escaped) content, over WebSocket and REST
+func (s *WebSocketHandlerTestSuite) TestContentLengthCountsStoredRunes() {
+	conn := s.dial(s.testUser)
+	defer conn.Close()
+
+	require.NoError(s.T(), conn.WriteJSON(map[string]string{
+		"type":    "send_message",
+		"temp_id": "temp-1",
+		"content": "<b>grüß</b> & 👋",
+	}))
+	frame := s.readUntil(conn, "message")
+	stored := "&lt;b&gt;grüß&lt;/b&gt; &amp; 👋"
+	require.Equal(s.T(), stored, frame["content"])
+	assert.Equal(s.T(), float64(utf8.RuneCountInString(stored)), frame["content_length"])
+	assert.Equal(s.T(), float64(31), frame["content_length"], "Escaping lengthens the content")
+
+	resp, payload := s.postMessage(s.testUser, "", `{"content":"it's \"5 > 3\""}`)
+	require.Equal(s.T(), http.StatusCreated, resp.StatusCode)
+	content := payload["content"].(string)
+	assert.Equal(s.T(), "it&#39;s &#34;5 &gt; 3&#34;", content)
+	assert.Equal(s.T(), float64(utf8.RuneCountInString(content)), payload["content_length"])
+}
+
+// TestMessagesStayInTheirRoom tests that broadcasts only reach clients in the sender's room
+func (s *WebSocketHandlerTestSuite) TestMessagesStayInTheirRoom() {
+	other, _ := testutil.CreateTestUser("wsroomie", "roomie@example.com", "Test123456", models.RoleUser)
+	s.testDB.DB.Create(other)
+
+	randomConn := s.dialRoom(other, "random")
+	defer randomConn.Close()
+	generalConn := s.dial(s.testUser) // No ?room= joins general
+	defer generalConn.Close()
+
+	require.NoError(s.T(), randomConn.WriteJSON(map[string]string{
+		"type": "send_message", "temp_id": "r-1", "content": "hi random",
+	}))
+	frame := s.readUntil(randomConn, "message")
+	assert.Equal(s.T(), "hi random", frame["content"])
+	assert.Equal(s.T(), "random", frame["room_id"])
+	s.readUntil(randomConn, "ack") // Broadcast is done once the sender is acked
+
+	// The general client's first message is its own, so it never saw the other room's
+	require.NoError(s.T(), generalConn.WriteJSON(map[string]string{
+		"type": "send_message", "temp_id": "g-1", "content": "hi general",
+	}))
+	frame = s.readUntil(generalConn, "message")
+	assert.Equal(s.T(), "hi general", frame["content"])
+	assert.Equal(s.T(), models.DefaultRoomID, frame["room_id"])
+
+	entries, err := s.walInstance.GetAllEntries()
+	require.NoError(s.T(), err)
+	require.Len(s.T(), entries, 2)
+	assert.Equal(s.T(), "random", entries[0].RoomID)
+	assert.Equal(s.T(), models.DefaultRoomID, entries[1].RoomID)
+}
+
+// TestInvalidRoomRejected tests that a malformed room is refused before the upgrade
+func (s *WebSocketHandlerTestSuite) TestInvalidRoomRejected() {
+	_, resp, err := websocket.DefaultDialer.Dial(s.wsURL("?room=Bad%20Room"), s.authHeader(s.testUser))
+	require.Error(s.T(), err)
+	require.NotNil(s.T(), resp)
+	assert.Equal(s.T(), http.StatusBadRequest, resp.StatusCode)
+}
+
+// TestCustomWSConfigLifetimeAndLimits tests that session lifetime and
+// per-user connection limits come from WSConfig
+func (s *WebSocketHandlerTestSuite) TestCustomWSConfigLifetimeAndLimits() {
+	config := handler.DefaultWSConfig()
+	config.SessionLifetime = 200 * time.Millisecond
+	config.MaxConnectionsPerUser = 1
+	s.startServer(config)
+
+	conn := s.dial(s.testUser)
+	defer conn.Close()
+
+	// A second tab is over the per-user limit
+	second := s.dial(s.testUser)
+	defer second.Close()
+	rejected := s.readUntil(second, "connection_limit")
+	assert.Contains(s.T(), rejected["error"], "max 1 per user")
+	_, _, err := second.ReadMessage()
+	assert.True(s.T(), websocket.IsCloseError(err, websocket.CloseTryAgainLater), "got %v", err)
+
+	// An idle client is closed on time, without sending anything
+	start := time.Now()
+	expired := s.readUntil(conn, "session_expired")
+	assert.Contains(s.T(), expired["error"], "200ms")
+	assert.Less(s.T(), time.Since(start), config.SessionLifetime+300*time.Millisecond)
+}
+
+// TestSlidingSessionKeepsActiveClients tests that in sliding mode activity
+// keeps a client connected past SessionLifetime, until SessionMax
+func (s *WebSocketHandlerTestSuite) TestSlidingSessionKeepsActiveClients() {
+	config := handler.DefaultWSConfig()
+	config.SessionMode = handler.SessionModeSliding
+	config.SessionLifetime = 400 * time.Millisecond
+	config.SessionMax = time.Second
+	s.startServer(config)
+
+	conn := s.dial(s.testUser)
+	defer conn.Close()
+	start := time.Now()
+
+	// Active for well over a SessionLifetime, until shortly before SessionMax
+	for i := 0; time.Since(start) < config.SessionMax-config.SessionLifetime/4; i++ {
+		require.NoError(s.T(), conn.WriteJSON(map[string]string{
+			"type": "send_message", "temp_id": fmt.Sprintf("active-%d", i), "content": "still here",
+		}))
+		ack := s.readUntil(conn, "ack")
+		assert.Equal(s.T(), "success", ack["status"])
+		time.Sleep(config.SessionLifetime / 4)
+	}
+
+	// Keeping busy doesn't outlast SessionMax, which closes the client on
+	// time without it sending anything more
+	expired := s.readUntil(conn, "session_expired")
+	assert.Contains(s.T(), expired["error"], "maximum lifetime of 1s")
+	assert.Less(s.T(), time.Since(start), config.SessionMax+300*time.Millisecond)
+}
+
+// TestSessionModeOff tests that sessions don't expire when disabled
+func (s *WebSocketHandlerTestSuite) TestSessionModeOff() {
+	config := handler.DefaultWSConfig()
+	config.SessionMode = handler.SessionModeOff
+	config.SessionLifetime = 100 * time.Millisecond
+	s.startServer(config)
+
+	conn := s.dial(s.testUser)
+	defer conn.Close()
+
+	time.Sleep(3 * config.SessionLifetime)
+	require.NoError(s.T(), conn.WriteJSON(map[string]string{"type": "send_message", "temp_id": "late", "content": "hi"}))
+	ack := s.readUntil(conn, "ack")
+	assert.Equal(s.T(), "late", ack["temp_id"])
+}
+
+// TestCustomWSConfigMaxMessageSize tests that the frame size limit comes from WSConfig
+func (s *WebSocketHandlerTestSuite) TestCustomWSConfigMaxMessageSize() {
+	config := handler.DefaultWSConfig()
+	config.MaxMessageSize = 1024
+	s.startServer(config)
+
+	conn := s.dial(s.testUser)
+	defer conn.Close()
+
+	require.NoError(s.T(), conn.WriteJSON(map[string]string{"type": "send_message", "content": strings.Repeat("a", 2048)}))
+
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err != nil {
+			assert.True(s.T(), websocket.IsCloseError(err, websocket.CloseMessageTooBig), "got %v", err)
+			break
+		}
+	}
+}
+
+// TestUnknownMessageTypeListsSupportedTypes tests the developer-facing error
+func (s *WebSocketHandlerTestSuite) TestUnknownMessageTypeListsSupportedTypes() {
+	conn := s.dial(s.testUser)
+	defer conn.Close()
+
+	require.NoError(s.T(), conn.WriteJSON(map[string]string{"type": "bogus_type"}))
+
+	frame := s.readUntil(conn, "error")
+	assert.Equal(s.T(), "unknown message type", frame["error"])
+	assert.Equal(s.T(), "bogus_type", frame["received_type"])
+	assert.Subset(s.T(), frame["supported_types"], []interface{}{"send_message", "delete_message"})
+}
+
+// TestCloseAllClientsIncludesReconnectHint tests the jittered reconnect delay
+// carried in the close frame payload
+func (s *WebSocketHandlerTestSuite) TestCloseAllClientsIncludesReconnectHint() {
+	config := handler.DefaultWSConfig()
+	config.ReconnectBase = 2 * time.Second
+	config.ReconnectJitter = 1 * time.Second
+	s.startServer(config)
+
+	conn := s.dial(s.testUser)
+	defer conn.Close()
+
+	// Wait until the server has registered the client
+	require.Eventually(s.T(), func() bool { return s.wsHandler.ClientCount() == 1 }, time.Second, 10*time.Millisecond)
+
+	s.wsHandler.CloseAllClients("server maintenance")
+
+	event := s.readUntil(conn, "server_closing")
+	assert.Equal(s.T(), "server maintenance", event["error"])
+
+	// Next read surfaces the close frame
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, _, err := conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	require.ErrorAs(s.T(), err, &closeErr)
+	assert.Equal(s.T(), websocket.CloseServiceRestart, closeErr.Code)
+
+	var reason handler.CloseReason
+	require.NoError(s.T(), json.Unmarshal([]byte(closeErr.Text), &reason))
+	assert.Equal(s.T(), "server maintenance", reason.Reason)
+	assert.GreaterOrEqual(s.T(), reason.ReconnectAfterMs, int64(2000))
+	assert.Less(s.T(), reason.ReconnectAfterMs, int64(3000))
+}
+
+// TestShutdownWaitsForClients tests that Shutdown returns once clients answer
+// the close frame, and force-closes clients that never do
+func (s *WebSocketHandlerTestSuite) TestShutdownWaitsForClients() {
+	s.startServer(handler.DefaultWSConfig())
+
+	// Reading lets gorilla echo the close frame back
+	conn := s.dial(s.testUser)
+	defer conn.Close()
+	require.Eventually(s.T(), func() bool { return s.wsHandler.ClientCount() == 1 }, time.Second, 10*time.Millisecond)
+
+	closed := make(chan int, 1)
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				var closeErr *websocket.CloseError
+				if errors.As(err, &closeErr) {
+					closed <- closeErr.Code
+				}
+				close(closed)
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	require.NoError(s.T(), s.wsHandler.Shutdown(ctx))
+	assert.Equal(s.T(), 0, s.wsHandler.ClientCount())
+	assert.Equal(s.T(), websocket.CloseServiceRestart, <-closed)
+
+	// A client that never reads never answers; Shutdown gives up at the deadline
+	silent := s.dial(s.testUser)
+	defer silent.Close()
+	require.Eventually(s.T(), func() bool { return s.wsHandler.ClientCount() == 1 }, time.Second, 10*time.Millisecond)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(s.T(), s.wsHandler.Shutdown(ctx), context.DeadlineExceeded)
+	assert.Eventually(s.T(), func() bool { return s.wsHandler.ClientCount() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+// TestByteBudgetRejectsLargeMessages tests that a few maximal-size messages
+// trip the byte budget long before any message-count limit would
+func (s *WebSocketHandlerTestSuite) TestByteBudgetRejectsLargeMessages() {
+	redisClient := redis.NewClient(&redis.Options{Addr: s.testRedis.Server.Addr()})
+	defer redisClient.Close()
+	s.byteBudget = middleware.NewByteBudget(redisClient, middleware.ByteBudgetConfig{
+		MaxBytes: 10 * 1024,
+		Window:   time.Minute,
+	})
+	s.startServer(handler.DefaultWSConfig())
+
+	conn := s.dial(s.testUser)
+	defer conn.Close()
+
+	content := strings.Repeat("a", 4000)
+	statuses := make([]string, 0, 3)
+	for i := 0; i < 3; i++ {
+		require.NoError(s.T(), conn.WriteJSON(map[string]string{
+			"type":    "send_message",
+			"temp_id": fmt.Sprintf("temp-%d", i),
+			"content": content,
+		}))
+		ack := s.readUntil(conn, "ack")
+		statuses = append(statuses, ack["status"].(string))
+		if ack["status"] == "error" {
+			assert.Contains(s.T(), ack["error"], "byte budget exceeded")
+		}
+	}
+
+	// 4000 + 4000 fit in 10 KB, the third message does not
+	assert.Equal(s.T(), []string{"success", "success", "error"}, statuses)
+
+	flagged, err := s.byteBudget.FlaggedUsers(10)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), flagged, 1)
+	assert.Equal(s.T(), s.testUser.ID, flagged[0].UserID)
+}
+
+// TestByteBudgetRejectionKeepsMessageRate tests that a send rejected by the
+// byte budget doesn't use up a message rate slot
+func (s *WebSocketHandlerTestSuite) TestByteBudgetRejectionKeepsMessageRate() {
+	redisClient := redis.NewClient(&redis.Options{Addr: s.testRedis.Server.Addr()})
+	defer redisClient.Close()
+	s.byteBudget = middleware.NewByteBudget(redisClient, middleware.ByteBudgetConfig{
+		MaxBytes: 1024,
+		Window:   time.Minute,
+	})
+	s.messageRate = middleware.NewMessageRate(redisClient, middleware.MessageRateConfig{
+		MaxMessages: 2,
+		Window:      10 * time.Second,
+	})
+	s.startServer(handler.DefaultWSConfig())
+
+	conn := s.dial(s.testUser)
+	defer conn.Close()
+
+	send := func(tempID, content string) map[string]interface{} {
+		require.NoError(s.T(), conn.WriteJSON(map[string]string{"type": "send_message", "temp_id": tempID, "content": content}))
+		return s.readUntil(conn, "ack")
+	}
+
+	for i := 0; i < 3; i++ {
+		ack := send(fmt.Sprintf("big-%d", i), strings.Repeat("a", 2000))
+		assert.Contains(s.T(), ack["error"], "byte budget exceeded")
+	}
+
+	// The rejected sends left the whole message rate
+	assert.Equal(s.T(), "success", send("small-1", "hello")["status"])
+	assert.Equal(s.T(), "success", send("small-2", "hello again")["status"])
+	assert.Contains(s.T(), send("small-3", "and again")["error"], "sending too fast")
+}
+
+// TestMessageRateRejectsWithoutClosing tests that sends over the per-user
+// message rate get an error ACK while the connection stays usable
+func (s *WebSocketHandlerTestSuite) TestMessageRateRejectsWithoutClosing() {
+	redisClient := redis.NewClient(&redis.Options{Addr: s.testRedis.Server.Addr()})
+	defer redisClient.Close()
+	s.messageRate = middleware.NewMessageRate(redisClient, middleware.MessageRateConfig{
+		MaxMessages: 3,
+		Window:      10 * time.Second,
+	})
+	s.startServer(handler.DefaultWSConfig())
+
+	// The limit is per user, shared by all of their connections
+	first := s.dial(s.testUser)
+	defer first.Close()
+	second := s.dial(s.testUser)
+	defer second.Close()
+
+	statuses := make([]string, 0, 5)
+	for i := 0; i < 5; i++ {
+		conn := first
+		if i%2 == 1 {
+			conn = second
+		}
+		require.NoError(s.T(), conn.WriteJSON(map[string]string{
+			"type":    "send_message",
+			"temp_id": fmt.Sprintf("temp-%d", i),
+			"content": fmt.Sprintf("Message %d", i),
+		}))
+		ack := s.readUntil(conn, "ack")
+		statuses = append(statuses, ack["status"].(string))
+		if ack["status"] == "error" {
+			assert.Contains(s.T(), ack["error"], "sending too fast")
+		}
+	}
+	assert.Equal(s.T(), []string{"success", "success", "success", "error", "error"}, statuses)
+
+	// Still connected: other requests keep working
+	require.NoError(s.T(), first.WriteJSON(map[string]string{"type": "send_message", "temp_id": "temp-empty"}))
+	ack := s.readUntil(first, "ack")
+	assert.Equal(s.T(), "content cannot be empty", ack["error"])
+}
+
+// TestSlowConsumerIsDropped tests that a client which stops reading is closed
+// once its outbound queue fills, while other clients keep getting every
+// message in order
+func (s *WebSocketHandlerTestSuite) TestSlowConsumerIsDropped() {
+	config := handler.DefaultWSConfig()
+	config.SendBufferSize = 4
+	config.WriteWait = 200 * time.Millisecond
+	s.startServer(config)
+
+	slowUser, _ := testutil.CreateTestUser("slowuser", "slow@example.com", "Test123456", models.RoleUser)
+	s.testDB.DB.Create(slowUser)
+
+	// A tiny receive buffer makes the server's socket back up quickly
+	// (set before connecting, so the advertised window stays small)
+	netDialer := &net.Dialer{
+		Control: func(network, address string, c syscall.RawConn) error {
+			return c.Control(func(fd uintptr) {
+				syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, 4096)
+			})
+		},
+	}
+	slowDialer := &websocket.Dialer{NetDialContext: netDialer.DialContext}
+	slow := s.dialWith(slowDialer, slowUser)
+	defer slow.Close()
+
+	fast := s.dial(s.testUser)
+	defer fast.Close()
+	require.Eventually(s.T(), func() bool { return s.wsHandler.ClientCount() == 2 }, time.Second, 10*time.Millisecond)
+
+	// Keep sending until the slow client is dropped (its kernel buffers have
+	// to fill before the server-side queue can). The fast client reads each
+	// broadcast before sending the next, so it never falls behind.
+	const maxSends = 3000
+	content := strings.Repeat("x", 4900)
+	sent := 0
+	for ; sent < maxSends && s.wsHandler.ClientCount() == 2; sent++ {
+		require.NoError(s.T(), fast.WriteJSON(map[string]string{
+			"type":    "send_message",
+			"temp_id": fmt.Sprintf("temp-%d", sent),
+			"content": fmt.Sprintf("seq-%04d %s", sent, content),
+		}))
+
+		// The fast client gets every broadcast, in send order (history frames
+		// sent on connect are skipped)
+		msg := s.readUntil(fast, "message")
+		for !strings.HasPrefix(msg["content"].(string), "seq-") {
+			msg = s.readUntil(fast, "message")
+		}
+		require.True(s.T(), strings.HasPrefix(msg["content"].(string), fmt.Sprintf("seq-%04d ", sent)), "messages must arrive in send order")
+	}
+
+	// The slow client was disconnected instead of stalling the broadcasts
+	assert.Less(s.T(), sent, maxSends, "slow client should have been dropped")
+	assert.Equal(s.T(), 1, s.wsHandler.ClientCount())
+
+	slow.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		if _, _, err := slow.ReadMessage(); err != nil {
+			assert.False(s.T(), errors.Is(err, os.ErrDeadlineExceeded), "connection should be closed, not idle")
+			break
+		}
+	}
+}
+
+// TestBanClosesLiveConnection tests that a ban published through the broker
+// closes the banned user's open connection right away
+func (s *WebSocketHandlerTestSuite) TestBanClosesLiveConnection() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bannedUsers, err := s.redisBroker.SubscribeUserBanned(ctx)
+	require.NoError(s.T(), err)
+	go s.wsHandler.WatchBans(bannedUsers)
+
+	conn := s.dial(s.testUser)
+	defer conn.Close()
+	require.Eventually(s.T(), func() bool { return s.wsHandler.ClientCount() == 1 }, time.Second, 10*time.Millisecond)
+
+	authService := service.NewAuthService(
+		repository.NewUserRepository(s.testDB.DB),
+		repository.NewMessageRepository(s.testDB.DB),
+		repository.NewAuditLogRepository(s.testDB.DB),
+		repository.NewRefreshTokenRepository(s.testDB.DB),
+		repository.NewVerificationTokenRepository(s.testDB.DB),
+		s.redisBroker,
+		wsTestSecret, time.Hour, "development", service.DefaultAuthServiceConfig(),
+	)
+	_, err = authService.BanUser(s.testUser.ID, uuid.New().String(), "spam")
+	require.NoError(s.T(), err)
+
+	event := s.readUntil(conn, "banned")
+	assert.Equal(s.T(), "banned", event["error"])
+
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, _, err = conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	require.ErrorAs(s.T(), err, &closeErr)
+	assert.Equal(s.T(), websocket.ClosePolicyViolation, closeErr.Code)
+
+	require.Eventually(s.T(), func() bool { return s.wsHandler.ClientCount() == 0 }, time.Second, 10*time.Millisecond)
+
+	// The access token is still valid, but reconnecting is refused
+	_, resp, err := websocket.DefaultDialer.Dial(s.wsURL(""), s.authHeader(s.testUser))
+	require.Error(s.T(), err)
+	require.NotNil(s.T(), resp)
+	assert.Equal(s.T(), http.StatusForbidden, resp.StatusCode)
+
+	// ...and so is sending over REST
+	resp, payload := s.postMessage(s.testUser, "", `{"content":"still here"}`)
+	assert.Equal(s.T(), http.StatusForbidden, resp.StatusCode)
+	assert.Equal(s.T(), service.ErrUserBanned.Error(), payload["error"])
+}
+
+// TestCompressedOversizedMessageRejected tests the decompression bomb guard:
+// a tiny compressed frame inflating past the limit closes the connection
+func (s *WebSocketHandlerTestSuite) TestCompressedOversizedMessageRejected() {
+	config := handler.DefaultWSConfig()
+	config.EnableCompression = true
+	config.MaxDecompressedSize = 4 * 1024
+	s.startServer(config)
+
+	dialer := *websocket.DefaultDialer
+	dialer.EnableCompression = true
+	conn := s.dialWith(&dialer, s.testUser)
+	defer conn.Close()
+
+	// A normal message under the limit still works
+	require.NoError(s.T(), conn.WriteJSON(map[string]string{
+		"type": "send_message", "temp_id": "small", "content": "hello",
+	}))
+	ack := s.readUntil(conn, "ack")
+	assert.Equal(s.T(), "success", ack["status"])
+
+	// 1 MB of one byte compresses to about 1 KB on the wire
+	require.NoError(s.T(), conn.WriteJSON(map[string]string{
+		"type": "send_message", "temp_id": "bomb", "content": strings.Repeat("a", 1024*1024),
+	}))
+
+	event := s.readUntil(conn, "message_too_large")
+	assert.Contains(s.T(), event["error"], "size limit")
+
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, _, err := conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	require.ErrorAs(s.T(), err, &closeErr)
+	assert.Equal(s.T(), websocket.CloseMessageTooBig, closeErr.Code)
+
+	// Nothing from the oversized message reached the WAL
+	entries, err := s.walInstance.GetAllEntries()
+	require.NoError(s.T(), err)
+	assert.Len(s.T(), entries, 1)
+}
+
+// TestSuite runs all tests in the suite
+// TestPresenceBurstIsCoalesced tests that a burst of connects produces one
+// presence_delta and no individual user_online frames
+func (s *WebSocketHandlerTestSuite) TestPresenceBurstIsCoalesced() {
+	config := handler.DefaultWSConfig()
+	config.PresenceWindow = 300 * time.Millisecond
+	s.startServer(config)
+
+	// Create users up front so the burst isn't slowed by password hashing
+	users := make([]*testutil.TestUser, 5)
+	for i := range users {
+		users[i], _ = testutil.CreateTestUser(fmt.Sprintf("burst%d", i), fmt.Sprintf("burst%d@example.com", i), "Test123456", models.RoleUser)
+		s.testDB.DB.Create(users[i])
+	}
+	flaky, _ := testutil.CreateTestUser("flaky", "flaky@example.com", "Test123456", models.RoleUser)
+	s.testDB.DB.Create(flaky)
+
+	observer := s.dial(s.testUser)
+	defer observer.Close()
+	own := s.readUntil(observer, "presence_delta")
+	assert.Len(s.T(), own["joined"], 1)
+	assert.EqualValues(s.T(), 1, own["online_count"])
+
+	// Burst: five users join, one joins and leaves again within the window
+	for _, user := range users {
+		conn := s.dial(user)
+		defer conn.Close()
+	}
+	s.dial(flaky).Close()
+
+	// Read frame by frame: readUntil would skip over per-user events
+	var delta map[string]interface{}
+	observer.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for delta == nil {
+		_, data, err := observer.ReadMessage()
+		require.NoError(s.T(), err, "waiting for presence_delta")
+		var frame map[string]interface{}
+		require.NoError(s.T(), json.Unmarshal(data, &frame))
+		require.NotContains(s.T(), []interface{}{"user_online", "user_offline"}, frame["type"], "per-user event with a presence window: %s", data)
+		if frame["type"] == "presence_delta" {
+			delta = frame
+		}
+	}
+	assert.EqualValues(s.T(), 1+len(users), delta["online_count"])
+	joined := delta["joined"].([]interface{})
+	require.Len(s.T(), joined, len(users), "all joins should arrive in one delta")
+	for i, entry := range joined {
+		assert.Equal(s.T(), users[i].Username, entry.(map[string]interface{})["username"])
+	}
+	assert.Nil(s.T(), delta["left"], "join+leave within the window should cancel out")
+
+	// No further presence frames for the burst
+	observer.SetReadDeadline(time.Now().Add(2 * config.PresenceWindow))
+	for {
+		_, data, err := observer.ReadMessage()
+		if err != nil {
+			break
+		}
+		var frame map[string]interface{}
+		require.NoError(s.T(), json.Unmarshal(data, &frame))
+		assert.NotContains(s.T(), []interface{}{"presence_delta", "user_online", "user_offline"}, frame["type"], "unexpected presence frame: %s", data)
+	}
+}
+
+// TestPresenceCountsUsersOnce tests user_online/user_offline events and
+// GET /api/presence for a user with several connections
+func (s *WebSocketHandlerTestSuite) TestPresenceCountsUsersOnce() {
+	// Per-user events are only sent without a presence window
+	config := handler.DefaultWSConfig()
+	config.PresenceWindow = 0
+	s.startServer(config)
+
+	multi, _ := testutil.CreateTestUser("multidevice", "multi@example.com", "Test123456", models.RoleUser)
+	s.testDB.DB.Create(multi)
+
+	observer := s.dial(s.testUser)
+	defer observer.Close()
+	own := s.readUntil(observer, "user_online")
+	assert.Equal(s.T(), s.testUser.Username, own["username"])
+	assert.EqualValues(s.T(), 1, own["online_count"])
+
+	laptop := s.dial(multi)
+	online := s.readUntil(observer, "user_online")
+	assert.Equal(s.T(), multi.Username, online["username"])
+	assert.EqualValues(s.T(), 2, online["online_count"])
+
+	phone := s.dial(multi)
+	require.Eventually(s.T(), func() bool { return s.wsHandler.ClientCount() == 3 }, time.Second, 10*time.Millisecond)
+
+	// Second device: same user, one more connection
+	req, _ := http.NewRequest(http.MethodGet, s.server.URL+"/api/presence", nil)
+	req.Header = s.authHeader(s.testUser)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(s.T(), err)
+	defer resp.Body.Close()
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+
+	var presence struct {
+		Users           []handler.PresenceInfo `json:"users"`
+		OnlineCount     int                    `json:"online_count"`
+		ConnectionCount int                    `json:"connection_count"`
+	}
+	require.NoError(s.T(), json.NewDecoder(resp.Body).Decode(&presence))
+	assert.Equal(s.T(), 2, presence.OnlineCount)
+	assert.Equal(s.T(), 3, presence.ConnectionCount)
+	require.Len(s.T(), presence.Users, 2)
+	assert.Equal(s.T(), multi.Username, presence.Users[0].Username)
+	assert.Equal(s.T(), 2, presence.Users[0].Connections)
+	assert.False(s.T(), presence.Users[0].ConnectedAt.IsZero())
+
+	// Closing one device keeps the user online; closing the last one announces it
+	laptop.Close()
+	require.Eventually(s.T(), func() bool { return s.wsHandler.ClientCount() == 2 }, time.Second, 10*time.Millisecond)
+	phone.Close()
+
+	offline := s.readUntil(observer, "user_offline")
+	assert.Equal(s.T(), multi.Username, offline["username"])
+	assert.EqualValues(s.T(), 1, offline["online_count"])
+	assert.Len(s.T(), s.wsHandler.GetOnlineUsers(), 1)
+}
+
+// TestAnnounceRejectedForRegularUser tests role gating of admin-only types
+func (s *WebSocketHandlerTestSuite) TestAnnounceRejectedForRegularUser() {
+	conn := s.dial(s.testUser)
+	defer conn.Close()
+
+	require.NoError(s.T(), conn.WriteJSON(map[string]string{
+		"type":    "announce",
+		"temp_id": "temp-1",
+		"content": "free pizza",
+	}))
+
+	frame := s.readUntil(conn, "error")
+	assert.Contains(s.T(), frame["error"], "forbidden")
+	assert.Equal(s.T(), "announce", frame["received_type"])
+
+	entries, err := s.walInstance.GetAllEntries()
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), entries, "rejected announcement must not be written")
+}
+
+// TestAnnounceAllowedForAdmin tests that admins can post as the system user
+func (s *WebSocketHandlerTestSuite) TestAnnounceAllowedForAdmin() {
+	admin, _ := testutil.CreateTestUser("wsadmin", "wsadmin@example.com", "Test123456", models.RoleAdmin)
+	s.testDB.DB.Create(admin)
+
+	conn := s.dial(admin)
+	defer conn.Close()
+
+	require.NoError(s.T(), conn.WriteJSON(map[string]string{
+		"type":    "announce",
+		"temp_id": "temp-1",
+		"content": "Maintenance tonight",
+	}))
+
+	frame := s.readUntil(conn, "message")
+	assert.Equal(s.T(), models.SystemUsername, frame["username"])
+	assert.Equal(s.T(), models.SystemUserID.String(), frame["user_id"])
+	assert.Equal(s.T(), "Maintenance tonight", frame["content"])
+
+	ack := s.readUntil(conn, "ack")
+	assert.Equal(s.T(), "success", ack["status"])
+}
+
+// TestEditMessageBroadcast tests that edits reach every client as message_edited
+func (s *WebSocketHandlerTestSuite) TestEditMessageBroadcast() {
+	msg := testutil.CreateTestMessage(s.testUser.ID, "typo hree")
+	s.testDB.DB.Create(msg)
+
+	watcher, _ := testutil.CreateTestUser("watcher", "watcher@example.com", "Test123456", models.RoleUser)
+	s.testDB.DB.Create(watcher)
+
+	conn := s.dial(s.testUser)
+	defer conn.Close()
+	other := s.dial(watcher)
+	defer other.Close()
+
+	// A different user may not edit it
+	require.NoError(s.T(), other.WriteJSON(map[string]string{
+		"type":       "edit_message",
+		"message_id": msg.MessageID,
+		"content":    "vandalized",
+	}))
+	errFrame := s.readUntil(other, "error")
+	assert.Equal(s.T(), service.ErrUnauthorized.Error(), errFrame["error"])
+
+	require.NoError(s.T(), conn.WriteJSON(map[string]string{
+		"type":       "edit_message",
+		"message_id": msg.MessageID,
+		"content":    "typo here",
+	}))
+
+	for _, c := range []*websocket.Conn{conn, other} {
+		frame := s.readUntil(c, "message_edited")
+		assert.Equal(s.T(), msg.MessageID, frame["message_id"])
+		assert.Equal(s.T(), "typo here", frame["content"])
+		assert.NotEmpty(s.T(), frame["edited_at"])
+	}
+}
+
+// TestMarkSeenIncrementsSeenCount tests that read receipts show up in the history payload
+func (s *WebSocketHandlerTestSuite) TestMarkSeenIncrementsSeenCount() {
+	conn := s.dial(s.testUser)
+	defer conn.Close()
+
+	require.NoError(s.T(), conn.WriteJSON(map[string]string{
+		"type":    "send_message",
+		"temp_id": "temp-1",
+		"content": "look at me",
+	}))
+	messageID := s.readUntil(conn, "ack")["message_id"].(string)
+
+	reader, _ := testutil.CreateTestUser("reader", "reader@example.com", "Test123456", models.RoleUser)
+	s.testDB.DB.Create(reader)
+
+	// Two distinct readers; a repeated receipt doesn't count twice
+	for _, user := range []*testutil.TestUser{s.testUser, reader, reader} {
+		c := s.dial(user)
+		require.NoError(s.T(), c.WriteJSON(map[string]interface{}{
+			"type":        "mark_seen",
+			"message_ids": []string{messageID},
+		}))
+		c.Close()
+	}
+
+	require.Eventually(s.T(), func() bool {
+		counts, err := s.messageService.GetSeenCounts([]string{messageID})
+		return err == nil && counts[messageID] == 2
+	}, 3*time.Second, 20*time.Millisecond)
+
+	// New connections get the count with the history
+	late := s.dial(s.testUser)
+	defer late.Close()
+	frame := s.readUntil(late, "message")
+	assert.Equal(s.T(), messageID, frame["message_id"])
+	assert.Equal(s.T(), float64(2), frame["seen_count"])
+}
+
+// TestMarkSeenRejectsInvalidIDs tests read receipt bounds
+func (s *WebSocketHandlerTestSuite) TestMarkSeenRejectsInvalidIDs() {
+	conn := s.dial(s.testUser)
+	defer conn.Close()
+
+	require.NoError(s.T(), conn.WriteJSON(map[string]interface{}{
+		"type":        "mark_seen",
+		"message_ids": []string{"not-a-uuid"},
+	}))
+	frame := s.readUntil(conn, "error")
+	assert.Equal(s.T(), service.ErrInvalidSeenID.Error(), frame["error"])
+}
+
+// TestReactionsBroadcastCounts tests react/unreact broadcasts and the counts in the history payload
+func (s *WebSocketHandlerTestSuite) TestReactionsBroadcastCounts() {
+	msg := testutil.CreateTestMessage(s.testUser.ID, "react to me")
+	s.testDB.DB.Create(msg)
+
+	watcher, _ := testutil.CreateTestUser("watcher", "watcher@example.com", "Test123456", models.RoleUser)
+	s.testDB.DB.Create(watcher)
+
+	conn := s.dial(s.testUser)
+	defer conn.Close()
+	other := s.dial(watcher)
+	defer other.Close()
+
+	require.NoError(s.T(), conn.WriteJSON(map[string]string{
+		"type":       "react",
+		"message_id": msg.MessageID,
+		"emoji":      "<script>",
+	}))
+	assert.Equal(s.T(), service.ErrInvalidEmoji.Error(), s.readUntil(conn, "error")["error"])
+
+	require.NoError(s.T(), conn.WriteJSON(map[string]string{
+		"type":       "react",
+		"message_id": msg.MessageID,
+		"emoji":      "🔥",
+	}))
+	for _, c := range []*websocket.Conn{conn, other} {
+		frame := s.readUntil(c, "reaction_updated")
+		assert.Equal(s.T(), msg.MessageID, frame["message_id"])
+		assert.Equal(s.T(), "🔥", frame["emoji"])
+		assert.Equal(s.T(), float64(1), frame["count"])
+	}
+
+	// New connections get the counts with the history
+	late := s.dial(watcher)
+	frame := s.readUntil(late, "message")
+	late.Close()
+	assert.Equal(s.T(), msg.MessageID, frame["message_id"])
+	assert.Equal(s.T(), map[string]interface{}{"🔥": float64(1)}, frame["reactions"])
+
+	// Withdrawing the last reaction still reports the count (0)
+	require.NoError(s.T(), conn.WriteJSON(map[string]string{
+		"type":       "unreact",
+		"message_id": msg.MessageID,
+		"emoji":      "🔥",
+	}))
+	frame = s.readUntil(other, "reaction_updated")
+	assert.Equal(s.T(), float64(0), frame["count"])
+}
+
+// TestReactionsCountAgainstMessageRate tests that react and unreact share
+// the per-user message rate with sends
+func (s *WebSocketHandlerTestSuite) TestReactionsCountAgainstMessageRate() {
+	redisClient := redis.NewClient(&redis.Options{Addr: s.testRedis.Server.Addr()})
+	defer redisClient.Close()
+	s.messageRate = middleware.NewMessageRate(redisClient, middleware.MessageRateConfig{
+		MaxMessages: 3,
+		Window:      10 * time.Second,
+	})
+	s.startServer(handler.DefaultWSConfig())
+
+	msg := testutil.CreateTestMessage(s.testUser.ID, "react to me")
+	s.testDB.DB.Create(msg)
+
+	conn := s.dial(s.testUser)
+	defer conn.Close()
+
+	for _, reqType := range []string{"react", "unreact", "react"} {
+		require.NoError(s.T(), conn.WriteJSON(map[string]string{"type": reqType, "message_id": msg.MessageID, "emoji": "🔥"}))
+		s.readUntil(conn, "reaction_updated")
+	}
+
+	require.NoError(s.T(), conn.WriteJSON(map[string]string{"type": "unreact", "message_id": msg.MessageID, "emoji": "🔥"}))
+	assert.Contains(s.T(), s.readUntil(conn, "error")["error"], "sending too fast")
+
+	// Sends draw from the same window
+	require.NoError(s.T(), conn.WriteJSON(map[string]string{"type": "send_message", "temp_id": "temp-1", "content": "hello"}))
+	assert.Contains(s.T(), s.readUntil(conn, "ack")["error"], "sending too fast")
+
+	counts, err := s.messageService.GetReactionCounts([]string{msg.MessageID})
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), map[string]int64{"🔥": 1}, counts[msg.MessageID], "the rejected unreact changed nothing")
+}
+
+// TestMentionPushedToOtherRoom tests that a mentioned user gets a mention
+// event even when connected to a different room
+func (s *WebSocketHandlerTestSuite) TestMentionPushedToOtherRoom() {
+	mentioned, _ := testutil.CreateTestUser("pinged", "pinged@example.com", "Test123456", models.RoleUser)
+	s.testDB.DB.Create(mentioned)
+
+	conn := s.dial(s.testUser)
+	defer conn.Close()
+	elsewhere := s.dialRoom(mentioned, "elsewhere")
+	defer elsewhere.Close()
+
+	require.NoError(s.T(), conn.WriteJSON(map[string]string{
+		"type":    "send_message",
+		"temp_id": "temp-1",
+		"content": "hey @Pinged, look",
+	}))
+	messageID := s.readUntil(conn, "ack")["message_id"].(string)
+
+	frame := s.readUntil(elsewhere, "mention")
+	assert.Equal(s.T(), messageID, frame["message_id"])
+	assert.Equal(s.T(), models.DefaultRoomID, frame["room_id"])
+	assert.Equal(s.T(), s.testUser.Username, frame["username"])
+	assert.Equal(s.T(), "hey @Pinged, look", frame["content"])
+}
+
+// TestMessageRoundTripEncodings tests sending and receiving a message in each frame encoding
+func (s *WebSocketHandlerTestSuite) TestMessageRoundTripEncodings() {
+	msgpack := &codec.MsgpackHandle{}
+	msgpack.WriteExt = true
+	msgpack.RawToString = true
+
+	cases := []struct {
+		name        string
+		subprotocol string
+		frameType   int
+		marshal     func(v interface{}) ([]byte, error)
+		unmarshal   func(data []byte, v interface{}) error
+	}{
+		{"default", "", websocket.TextMessage, json.Marshal, json.Unmarshal},
+		{"json", handler.SubprotocolJSON, websocket.TextMessage, json.Marshal, json.Unmarshal},
+		{"msgpack", handler.SubprotocolMsgpack, websocket.BinaryMessage,
+			func(v interface{}) ([]byte, error) {
+				var data []byte
+				err := codec.NewEncoderBytes(&data, msgpack).Encode(v)
+				return data, err
+			},
+			func(data []byte, v interface{}) error {
+				return codec.NewDecoderBytes(data, msgpack).Decode(v)
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		s.Run(tc.name, func() {
+			dialer := &websocket.Dialer{}
+			if tc.subprotocol != "" {
+				dialer.Subprotocols = []string{tc.subprotocol}
+			}
+			conn := s.dialWith(dialer, s.testUser)
+			defer conn.Close()
+			assert.Equal(s.T(), tc.subprotocol, conn.Subprotocol())
+
+			content := "hello via " + tc.name
+			data, err := tc.marshal(map[string]string{
+				"type":    "send_message",
+				"temp_id": "temp-" + tc.name,
+				"content": content,
+			})
+			require.NoError(s.T(), err)
+			require.NoError(s.T(), conn.WriteMessage(tc.frameType, data))
+
+			conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+			for {
+				frameType, data, err := conn.ReadMessage()
+				require.NoError(s.T(), err)
+				assert.Equal(s.T(), tc.frameType, frameType)
+
+				var frame map[string]interface{}
+				require.NoError(s.T(), tc.unmarshal(data, &frame))
+				if frame["type"] == "message" && frame["content"] == content {
+					assert.Equal(s.T(), s.testUser.Username, frame["username"])
+					return
+				}
+			}
+		})
+	}
+}
+
+// TestOriginCheck tests that upgrades from foreign origins are rejected with
+// 403, and that only strict mode rejects localhost and a missing Origin
+func (s *WebSocketHandlerTestSuite) TestOriginCheck() {
+	dialOrigin := func(origin string) (int, error) {
+		header := s.authHeader(s.testUser)
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial(s.wsURL(""), header)
+		if conn != nil {
+			conn.Close()
+		}
+		if resp == nil {
+			return 0, err
+		}
+		return resp.StatusCode, err
+	}
+
+	// Development: the allowed list plus localhost
+	s.startServer(handler.DefaultWSConfig())
+
+	status, err := dialOrigin("https://evil.example.net")
+	assert.Error(s.T(), err)
+	assert.Equal(s.T(), http.StatusForbidden, status)
+
+	for _, origin := range []string{wsTestOrigin, "http://localhost:5173", ""} {
+		status, err = dialOrigin(origin)
+		assert.NoError(s.T(), err, origin)
+		assert.Equal(s.T(), http.StatusSwitchingProtocols, status, origin)
+	}
+
+	// Production: the allowed list only
+	config := handler.DefaultWSConfig()
+	config.StrictOrigin = true
+	s.startServer(config)
+
+	for _, origin := range []string{"https://evil.example.net", "http://localhost:5173", ""} {
+		status, err = dialOrigin(origin)
+		assert.Error(s.T(), err, origin)
+		assert.Equal(s.T(), http.StatusForbidden, status, origin)
+	}
+
+	status, err = dialOrigin(wsTestOrigin)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), http.StatusSwitchingProtocols, status)
+}
+
+// TestBroadcastAcrossNodes tests that messages, reactions and deletes from one node reach
+// clients on another node through Redis, and that the sending node doesn't
+// deliver its own events twice
+func (s *WebSocketHandlerTestSuite) TestBroadcastAcrossNodes() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Node B: its own broker and handler, sharing Redis and the database
+	brokerB, err := broker.NewRedisMessageBroker(s.testRedis.URL, broker.BrokerConfig{NodeID: "node-b"})
+	require.NoError(s.T(), err)
+	defer brokerB.Close()
+	handlerB := handler.NewWebSocketHandler(s.messageService, nil, nil, nil, brokerB, wsTestSecret, nil, handler.DefaultWSConfig())
+	routerB := gin.New()
+	routerB.GET("/api/ws", middleware.AuthMiddleware(wsTestSecret, nil), handlerB.HandleWebSocket)
+	serverB := httptest.NewServer(routerB)
+	defer serverB.Close()
+
+	eventsA, err := s.redisBroker.Subscribe(ctx)
+	require.NoError(s.T(), err)
+	go s.wsHandler.WatchBroadcasts(eventsA)
+	eventsB, err := brokerB.Subscribe(ctx)
+	require.NoError(s.T(), err)
+	go handlerB.WatchBroadcasts(eventsB)
+
+	userB, _ := testutil.CreateTestUser("nodebuser", "nodeb@example.com", "Test123456", models.RoleUser)
+	s.testDB.DB.Create(userB)
+
+	connA := s.dial(s.testUser)
+	defer connA.Close()
+	connB, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(serverB.URL, "http")+"/api/ws", s.authHeader(userB))
+	require.NoError(s.T(), err)
+	defer connB.Close()
+
+	// Sent on A, delivered on B
+	require.NoError(s.T(), connA.WriteJSON(map[string]string{"type": "send_message", "temp_id": "temp-a", "content": "hello from A"}))
+	ack := s.readUntil(connA, "ack")
+	messageID := ack["message_id"].(string)
+
+	relayed := s.readUntil(connB, "message")
+	assert.Equal(s.T(), messageID, relayed["message_id"])
+	assert.Equal(s.T(), "hello from A", relayed["content"])
+	assert.Equal(s.T(), s.testUser.Username, relayed["username"])
+
+	// Reacted to on A, counted on B
+	_, err = s.messageService.ProcessBatchNow()
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), connA.WriteJSON(map[string]string{"type": "react", "message_id": messageID, "emoji": "🔥"}))
+	s.readUntil(connA, "reaction_updated")
+
+	reaction := s.readUntil(connB, "reaction_updated")
+	assert.Equal(s.T(), messageID, reaction["message_id"])
+	assert.Equal(s.T(), "🔥", reaction["emoji"])
+	assert.Equal(s.T(), float64(1), reaction["count"])
+
+	// Deleted on A, removed on B
+	require.NoError(s.T(), connA.WriteJSON(map[string]string{"type": "delete_message", "message_id": messageID}))
+
+	deleted := s.readUntil(connB, "message_deleted")
+	assert.Equal(s.T(), messageID, deleted["message_id"])
+
+	// Sent on B, delivered on A. A's subscription sees events in publish
+	// order, so a redelivery of A's own events would show up before this.
+	require.NoError(s.T(), connB.WriteJSON(map[string]string{"type": "send_message", "temp_id": "temp-b", "content": "hello from B"}))
+
+	counts := map[string]int{}
+	connA.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		_, data, err := connA.ReadMessage()
+		require.NoError(s.T(), err)
+		var frame map[string]interface{}
+		require.NoError(s.T(), json.Unmarshal(data, &frame))
+		if frame["content"] == "hello from B" {
+			break
+		}
+		if frame["message_id"] == messageID {
+			counts[frame["type"].(string)]++
+		}
+	}
+	// The local "message" frame was read before the ack; nothing came back via Redis
+	assert.Equal(s.T(), map[string]int{"message_deleted": 1, "delete_success": 1}, counts)
+}
+
+// TestDeleteLast tests that delete_last removes the sender's newest message,
+// even one still in the WAL, and fails when there is nothing left
+func (s *WebSocketHandlerTestSuite) TestDeleteLast() {
+	otherUser, _ := testutil.CreateTestUser("wswatcher", "watcher@example.com", "Test123456", models.RoleUser)
+	s.testDB.DB.Create(otherUser)
+
+	conn := s.dial(s.testUser)
+	defer conn.Close()
+	watcher := s.dial(otherUser)
+	defer watcher.Close()
+
+	var ids []string
+	for i, content := range []string{"first", "second"} {
+		require.NoError(s.T(), conn.WriteJSON(map[string]string{
+			"type": "send_message", "temp_id": fmt.Sprintf("temp-%d", i), "content": content,
+		}))
+		ids = append(ids, s.readUntil(conn, "ack")["message_id"].(string))
+		if i == 0 {
+			_, err := s.messageService.ProcessBatchNow()
+			require.NoError(s.T(), err)
+		}
+	}
+
+	// "second" is only in the WAL; it is still the one deleted
+	require.NoError(s.T(), conn.WriteJSON(map[string]string{"type": "delete_last"}))
+	assert.Equal(s.T(), ids[1], s.readUntil(conn, "delete_success")["message_id"])
+	assert.Equal(s.T(), ids[1], s.readUntil(watcher, "message_deleted")["message_id"])
+
+	var live []string
+	s.testDB.DB.Model(&testutil.TestMessage{}).Where("deleted_at IS NULL").Pluck("message_id", &live)
+	assert.Equal(s.T(), []string{ids[0]}, live)
+
+	// The watcher has never sent anything
+	require.NoError(s.T(), watcher.WriteJSON(map[string]string{"type": "delete_last"}))
+	assert.Equal(s.T(), "you have no messages to delete", s.readUntil(watcher, "error")["error"])
+}
+
+// TestMentionsOnlyMode tests that a ?mode=mentions client skips ordinary
+// broadcasts and presence but receives messages mentioning it, once
+func (s *WebSocketHandlerTestSuite) TestMentionsOnlyMode() {
+	mobileUser, _ := testutil.CreateTestUser("wsmobile", "mobile@example.com", "Test123456", models.RoleUser)
+	s.testDB.DB.Create(mobileUser)
+
+	mobile, _, err := websocket.DefaultDialer.Dial(s.wsURL("?mode=mentions"), s.authHeader(mobileUser))
+	require.NoError(s.T(), err)
+	defer mobile.Close()
+	sender := s.dial(s.testUser) // Announces presence, which mobile skips too
+	defer sender.Close()
+
+	for i, content := range []string{"hello everyone", "not for @wsmobilex", "run `ping @wsmobile`", "ping @WSMobile, are you there?"} {
+		require.NoError(s.T(), sender.WriteJSON(map[string]string{
+			"type": "send_message", "temp_id": fmt.Sprintf("temp-%d", i), "content": content,
+		}))
+		s.readUntil(sender, "ack")
+	}
+
+	// The first frame mobile gets is the mention
+	mobile.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, data, err := mobile.ReadMessage()
+	require.NoError(s.T(), err)
+	var frame map[string]interface{}
+	require.NoError(s.T(), json.Unmarshal(data, &frame))
+	assert.Equal(s.T(), "message", frame["type"])
+	assert.Equal(s.T(), "ping @WSMobile, are you there?", frame["content"])
+
+	// Its own requests are still answered. The ack is the next frame: no
+	// separate mention event repeats the message
+	require.NoError(s.T(), mobile.WriteJSON(map[string]string{"type": "send_message", "temp_id": "temp-mobile"}))
+	_, data, err = mobile.ReadMessage()
+	require.NoError(s.T(), err)
+	var ack map[string]interface{}
+	require.NoError(s.T(), json.Unmarshal(data, &ack))
+	assert.Equal(s.T(), "ack", ack["type"])
+	assert.Equal(s.T(), "temp-mobile", ack["temp_id"])
+
+	// Unknown modes are refused before the upgrade
+	_, resp, err := websocket.DefaultDialer.Dial(s.wsURL("?mode=firehose"), s.authHeader(mobileUser))
+	require.Error(s.T(), err)
+	require.NotNil(s.T(), resp)
+	assert.Equal(s.T(), http.StatusBadRequest, resp.StatusCode)
+}
+
+// TestMessagePackDisabledFallsBackToJSON tests that the subprotocol isn't negotiated when disabled
+func (s *WebSocketHandlerTestSuite) TestMessagePackDisabledFallsBackToJSON() {
+	config := handler.DefaultWSConfig()
+	config.EnableMessagePack = false
+	s.startServer(config)
+
+	conn := s.dialWith(&websocket.Dialer{Subprotocols: []string{handler.SubprotocolMsgpack}}, s.testUser)
+	defer conn.Close()
+	assert.Equal(s.T(), "", conn.Subprotocol())
+
+	require.NoError(s.T(), conn.WriteJSON(map[string]string{"type": "bogus_type"}))
+	frame := s.readUntil(conn, "error")
+	assert.Equal(s.T(), "bogus_type", frame["received_type"])
+}
+
+// TestDeliverySeqIncrementsPerConnection tests that every frame carries the
+// connection's delivery sequence, going up by one per frame and counted
+// separately for each connection
+func (s *WebSocketHandlerTestSuite) TestDeliverySeqIncrementsPerConnection() {
+	other, _ := testutil.CreateTestUser("wsseq", "seq@example.com", "Test123456", models.RoleUser)
+	s.testDB.DB.Create(other)
+
+	// Read every frame up to the given number of acks, returning their sequences
+	readSeqs := func(conn *websocket.Conn, acks int) []uint64 {
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		var seqs []uint64
+		for acks > 0 {
+			_, data, err := conn.ReadMessage()
+			require.NoError(s.T(), err)
+
+			var frame handler.WSResponse
+			require.NoError(s.T(), json.Unmarshal(data, &frame))
+			seqs = append(seqs, frame.DeliverySeq)
+			if frame.Type == "ack" {
+				acks--
+			}
+		}
+		return seqs
+	}
+	assertConsecutive := func(seqs []uint64) {
+		for i, seq := range seqs {
+			assert.Equal(s.T(), uint64(i+1), seq, "frame %d of %v", i, seqs)
+		}
+	}
+
+	conn := s.dial(s.testUser)
+	defer conn.Close()
+	for i := 0; i < 3; i++ {
+		require.NoError(s.T(), conn.WriteJSON(map[string]string{
+			"type": "send_message", "temp_id": fmt.Sprintf("seq-%d", i), "content": "hello",
+		}))
+	}
+	seqs := readSeqs(conn, 3)
+	assert.GreaterOrEqual(s.T(), len(seqs), 6, "Each send produces a broadcast and an ack")
+	assertConsecutive(seqs)
+
+	// A new connection starts its own sequence, history and all
+	otherConn := s.dial(other)
+	defer otherConn.Close()
+	require.NoError(s.T(), otherConn.WriteJSON(map[string]string{
+		"type": "send_message", "temp_id": "other-1", "content": "hi",
+	}))
+	assertConsecutive(readSeqs(otherConn, 1))
+}
+
+// TestUnverifiedUserCanReadButNotPost tests the email verification gate
+func (s *WebSocketHandlerTestSuite) TestUnverifiedUserCanReadButNotPost() {
+	newcomer, _ := testutil.CreateTestUser("wsnewcomer", "newcomer@example.com", "Test123456", models.RoleUser)
+	newcomer.EmailVerified = false
+	s.testDB.DB.Create(newcomer)
+
+	conn := s.dial(newcomer)
+	defer conn.Close()
+
+	for _, msgType := range []string{"send_message", "edit_message"} {
+		require.NoError(s.T(), conn.WriteJSON(map[string]string{
+			"type": msgType, "temp_id": "temp-1", "message_id": "any", "content": "first!",
+		}))
+		frame := s.readUntil(conn, "error")
+		assert.Contains(s.T(), frame["error"], "verify your email")
+		assert.Equal(s.T(), msgType, frame["received_type"])
+	}
+
+	entries, err := s.walInstance.GetAllEntries()
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), entries, "rejected message must not be written")
+
+	// Other users' messages still arrive
+	verifiedConn := s.dial(s.testUser)
+	defer verifiedConn.Close()
+	require.NoError(s.T(), verifiedConn.WriteJSON(map[string]string{
+		"type": "send_message", "temp_id": "temp-2", "content": "welcome",
+	}))
+	frame := s.readUntil(conn, "message")
+	assert.Equal(s.T(), "welcome", frame["content"])
+}
+
+// TestClientCloseIsClean tests that a close frame from the client gets its
+// pending ack and a close reply, and is counted as a clean client close
+// rather than an error
+func (s *WebSocketHandlerTestSuite) TestClientCloseIsClean() {
+	config := handler.DefaultWSConfig()
+	config.Metrics = metrics.New(prometheus.NewRegistry())
+	s.startServer(config)
+
+	disconnects := func(reason string) float64 {
+		w := httptest.NewRecorder()
+		config.Metrics.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		prefix := fmt.Sprintf("digital_square_websocket_disconnects_total{reason=%q} ", reason)
+		for _, line := range strings.Split(w.Body.String(), "\n") {
+			if value, ok := strings.CutPrefix(line, prefix); ok {
+				var n float64
+				fmt.Sscan(value, &n)
+				return n
+			}
+		}
+		return 0
+	}
+
+	conn := s.dial(s.testUser)
+	defer conn.Close()
+	require.NoError(s.T(), conn.WriteJSON(map[string]string{
+		"type": "send_message", "temp_id": "last-words", "content": "bye",
+	}))
+	require.NoError(s.T(), conn.WriteMessage(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, "leaving")))
+
+	// The ack still arrives, then the server's close reply
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	var acked bool
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			require.ErrorAs(s.T(), err, &closeErr)
+			assert.Equal(s.T(), websocket.CloseNormalClosure, closeErr.Code)
+			break
+		}
+		var frame map[string]interface{}
+		require.NoError(s.T(), json.Unmarshal(data, &frame))
+		if frame["type"] == "ack" && frame["temp_id"] == "last-words" {
+			acked = true
+		}
+	}
+	assert.True(s.T(), acked, "pending ack must be flushed before the close reply")
+
+	require.Eventually(s.T(), func() bool { return disconnects("client_close") == 1 }, time.Second, 10*time.Millisecond)
+	assert.Zero(s.T(), disconnects("error"))
+
+	// Dropping the connection without a close frame is not clean
+	abrupt := s.dial(s.testUser)
+	abrupt.Close()
+	require.Eventually(s.T(), func() bool { return disconnects("error") == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(s.T(), 1.0, disconnects("client_close"))
+}
+
+func TestWebSocketHandlerTestSuite(t *testing.T) {
+	suite.Run(t, new(WebSocketHandlerTestSuite))
+}
@@ -0,0 +1,27 @@
+package mailer
+
+import (
+	"github.com/Baaaki/digital-square/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// Mailer delivers account emails. Implementations must be safe for
+// concurrent use; an error means the email was not sent.
+type Mailer interface {
+	SendVerification(to, username, link string) error
+}
+
+// LogMailer logs emails instead of sending them. It is the default until a
+// real mailer is plugged in, so verification links can be picked up from the
+// server log in development.
+type LogMailer struct{}
+
+// SendVerification implements Mailer
+func (LogMailer) SendVerification(to, username, link string) error {
+	logger.Log.Info("Verification email (logged, not sent)",
+		zap.String("to", to),
+		zap.String("username", username),
+		zap.String("link", link),
+	)
+	return nil
+}
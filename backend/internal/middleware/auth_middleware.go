@@ -5,10 +5,14 @@ import (
     "strings"
 
     "github.com/Baaaki/digital-square/internal/utils"
+    "github.com/Baaaki/digital-square/pkg/logger"
     "github.com/gin-gonic/gin"
+    "go.uber.org/zap"
 )
 
-func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
+// AuthMiddleware validates the JWT and rejects tokens revoked via logout or
+// by a password change. denylist is optional (nil = revocation not checked).
+func AuthMiddleware(jwtSecret string, denylist *TokenDenylist) gin.HandlerFunc {
     return func(c *gin.Context) {
         var tokenString string
 
@@ -48,14 +52,34 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
             c.Abort()
             return
         }
+
+        // 5. Reject revoked tokens (fail open on Redis errors, like the rate limiter)
+        if denylist != nil {
+            revoked, err := denylist.IsRevoked(claims.ID)
+            if err == nil && !revoked {
+                revoked, err = denylist.IsRevokedForUser(claims.UserID.String(), claims.IssuedAtTime())
+            }
+            if err != nil {
+                logger.Log.Warn("Token denylist check failed",
+                    zap.String("user_id", claims.UserID.String()),
+                    zap.Error(err),
+                )
+            } else if revoked {
+                c.JSON(http.StatusUnauthorized, gin.H{
+                    "error": "Token has been revoked",
+                })
+                c.Abort()
+                return
+            }
+        }
         
-        // 4. Add claims to context (handlers can access)
+        // 6. Add claims to context (handlers can access)
         c.Set("user_id", claims.UserID.String())
         c.Set("user_email", claims.Email)
         c.Set("user_role", string(claims.Role)) // Convert Role type to string
         c.Set("claims", claims)
         
-        // 5. Continue to handler
+        // 7. Continue to handler
         c.Next()
     }
 }
@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	byteBudgetFlaggedKey = "bytebudget:flagged"
+	maxFlaggedUsers      = 1000 // Cap flagged set size to bound Redis memory
+)
+
+// ByteBudgetConfig defines the per-user bandwidth budget
+type ByteBudgetConfig struct {
+	MaxBytes int64         // Maximum bytes a user may send within the window
+	Window   time.Duration // Rolling window (e.g., 1 minute)
+}
+
+// ByteUsage is a user's byte total, as shown to admins
+type ByteUsage struct {
+	UserID string `json:"user_id"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// ByteBudget tracks bytes sent per user over a rolling window using Redis.
+// It catches bandwidth abuse (many maximal-length messages) that
+// count-based limits miss.
+type ByteBudget struct {
+	redis  *redis.Client
+	ctx    context.Context
+	config ByteBudgetConfig
+}
+
+// NewByteBudget creates a new byte budget tracker
+func NewByteBudget(redisClient *redis.Client, config ByteBudgetConfig) *ByteBudget {
+	return &ByteBudget{
+		redis:  redisClient,
+		ctx:    context.Background(),
+		config: config,
+	}
+}
+
+// Config returns the active budget settings
+func (b *ByteBudget) Config() ByteBudgetConfig {
+	return b.config
+}
+
+// consumeScript trims expired entries, checks the budget and records the
+// bytes in one step, so concurrent sends from several connections can't all
+// pass the check. The window total is kept in a counter next to the sorted
+// set (rebuilt from the members if it is missing) instead of summing every
+// member per send.
+//
+// KEYS: window set, total counter
+// ARGV: window start (ms), now (ms), member, bytes, max bytes, window (ms)
+// Returns {allowed (0/1), bytes used in window}
+var consumeScript = redis.NewScript(`
+local function bytes(member)
+	return tonumber(string.match(member, ":(%d+)$")) or 0
+end
+
+local used = redis.call("GET", KEYS[2])
+if used then
+	used = tonumber(used)
+	for _, member in ipairs(redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1])) do
+		used = used - bytes(member)
+	end
+	redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", ARGV[1])
+else
+	redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", ARGV[1])
+	used = 0
+	for _, member in ipairs(redis.call("ZRANGE", KEYS[1], 0, -1)) do
+		used = used + bytes(member)
+	end
+end
+if redis.call("ZCARD", KEYS[1]) == 0 then
+	used = 0
+end
+
+local n = tonumber(ARGV[4])
+if used + n > tonumber(ARGV[5]) then
+	redis.call("SET", KEYS[2], used, "PX", ARGV[6])
+	return {0, used}
+end
+
+used = used + n
+redis.call("ZADD", KEYS[1], ARGV[2], ARGV[3])
+redis.call("PEXPIRE", KEYS[1], ARGV[6])
+redis.call("SET", KEYS[2], used, "PX", ARGV[6])
+return {1, used}
+`)
+
+// Consume records n bytes for the user if they fit in the budget.
+// Users who would exceed the budget are rejected and added to the flagged set.
+// Returns: (allowed bool, bytes used in window, error)
+func (b *ByteBudget) Consume(userID string, n int) (bool, int64, error) {
+	key := fmt.Sprintf("bytebudget:%s", userID)
+	now := time.Now()
+	windowStart := now.Add(-b.config.Window).UnixMilli()
+
+	// Member encodes a unique timestamp and the byte count
+	result, err := consumeScript.Run(b.ctx, b.redis, []string{key, key + ":total"},
+		windowStart,
+		now.UnixMilli(),
+		fmt.Sprintf("%d:%d", now.UnixNano(), n),
+		n,
+		b.config.MaxBytes,
+		b.config.Window.Milliseconds(),
+	).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	allowed, used := result[0] == 1, result[1]
+	if allowed {
+		return true, used, nil
+	}
+
+	pipe := b.redis.Pipeline()
+	pipe.ZAdd(b.ctx, byteBudgetFlaggedKey, redis.Z{Score: float64(used + int64(n)), Member: userID})
+	pipe.ZRemRangeByRank(b.ctx, byteBudgetFlaggedKey, 0, -maxFlaggedUsers-1)
+	if _, err := pipe.Exec(b.ctx); err != nil {
+		return false, used, err
+	}
+	return false, used, nil
+}
+
+// Usage returns bytes the user has sent in the current window. It only
+// reads, so Consume's running total stays in step with the window set.
+func (b *ByteBudget) Usage(userID string) (int64, error) {
+	key := fmt.Sprintf("bytebudget:%s", userID)
+	windowStart := time.Now().Add(-b.config.Window).UnixMilli()
+	members, err := b.redis.ZRangeByScore(b.ctx, key, &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(windowStart, 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+	return sumBytes(members), nil
+}
+
+// FlaggedUsers returns users who exceeded the budget, highest attempted usage first
+func (b *ByteBudget) FlaggedUsers(limit int) ([]ByteUsage, error) {
+	results, err := b.redis.ZRevRangeWithScores(b.ctx, byteBudgetFlaggedKey, 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]ByteUsage, 0, len(results))
+	for _, z := range results {
+		users = append(users, ByteUsage{
+			UserID: z.Member.(string),
+			Bytes:  int64(z.Score),
+		})
+	}
+	return users, nil
+}
+
+// sumBytes adds up the byte counts encoded in window set members
+func sumBytes(members []string) int64 {
+	var total int64
+	for _, member := range members {
+		idx := strings.LastIndexByte(member, ':')
+		if idx < 0 {
+			continue
+		}
+		n, err := strconv.ParseInt(member[idx+1:], 10, 64)
+		if err != nil {
+			continue
+		}
+		total += n
+	}
+	return total
+}
@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupTestByteBudget creates a byte budget with miniredis for testing
+func setupTestByteBudget(t *testing.T, maxBytes int64, window time.Duration) (*ByteBudget, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	return NewByteBudget(client, ByteBudgetConfig{MaxBytes: maxBytes, Window: window}), mr
+}
+
+// TestByteBudget_Consume tests that bytes accumulate until the budget is hit
+func TestByteBudget_Consume(t *testing.T) {
+	bb, mr := setupTestByteBudget(t, 1000, time.Minute)
+	defer mr.Close()
+
+	allowed, used, err := bb.Consume("user-1", 600)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, int64(600), used)
+
+	allowed, used, err = bb.Consume("user-1", 500)
+	require.NoError(t, err)
+	assert.False(t, allowed, "600 + 500 exceeds the 1000 byte budget")
+	assert.Equal(t, int64(600), used)
+
+	// Rejected bytes are not counted
+	usage, err := bb.Usage("user-1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(600), usage)
+
+	// Other users have independent budgets
+	allowed, _, err = bb.Consume("user-2", 900)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+}
+
+// TestByteBudget_FlaggedUsers tests that over-budget users are reported
+func TestByteBudget_FlaggedUsers(t *testing.T) {
+	bb, mr := setupTestByteBudget(t, 100, time.Minute)
+	defer mr.Close()
+
+	bb.Consume("small", 50)
+	bb.Consume("heavy", 90)
+	bb.Consume("heavy", 500)
+	bb.Consume("medium", 150)
+
+	flagged, err := bb.FlaggedUsers(10)
+	require.NoError(t, err)
+	require.Len(t, flagged, 2)
+	assert.Equal(t, "heavy", flagged[0].UserID)
+	assert.Equal(t, int64(590), flagged[0].Bytes)
+	assert.Equal(t, "medium", flagged[1].UserID)
+}
+
+// TestByteBudget_WindowExpiry tests that old entries leave the rolling window
+func TestByteBudget_WindowExpiry(t *testing.T) {
+	bb, mr := setupTestByteBudget(t, 100, 200*time.Millisecond)
+	defer mr.Close()
+
+	allowed, _, _ := bb.Consume("user-1", 100)
+	assert.True(t, allowed)
+
+	allowed, _, _ = bb.Consume("user-1", 10)
+	assert.False(t, allowed)
+
+	time.Sleep(250 * time.Millisecond)
+
+	allowed, _, err := bb.Consume("user-1", 10)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+}
+
+// TestByteBudget_ConcurrentConsume tests that concurrent sends can't overshoot
+// the budget: the check and the add happen together
+func TestByteBudget_ConcurrentConsume(t *testing.T) {
+	bb, mr := setupTestByteBudget(t, 1000, time.Minute)
+	defer mr.Close()
+
+	var accepted atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			allowed, _, err := bb.Consume("user-1", 100)
+			assert.NoError(t, err)
+			if allowed {
+				accepted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(10), accepted.Load(), "Exactly 1000 bytes worth of sends fit")
+	usage, err := bb.Usage("user-1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), usage)
+
+	// The running total follows entries out of the window
+	mr.FastForward(2 * time.Minute)
+	allowed, used, err := bb.Consume("user-1", 100)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, int64(100), used)
+}
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClientIPConfig controls how c.ClientIP() resolves the client address behind
+// reverse proxies. Rate limiting, request logs and geo tags all use it.
+type ClientIPConfig struct {
+	Header         string   // Header carrying the client IP, e.g. "X-Real-IP" (empty = Gin default: X-Forwarded-For, then X-Real-IP)
+	TrustedProxies []string // Proxy IPs/CIDRs whose header is believed (empty = Gin default: every peer)
+}
+
+// ConfigureClientIP applies cfg to the router. The header is only read when the
+// request comes from a trusted proxy; otherwise the peer address is the client.
+// A custom header without trusted proxies is rejected, since any client could
+// then pick its own rate-limit key by setting the header.
+func ConfigureClientIP(router *gin.Engine, cfg ClientIPConfig) error {
+	if cfg.Header != "" {
+		if len(cfg.TrustedProxies) == 0 {
+			return errors.New("a client IP header requires trusted proxies")
+		}
+		router.RemoteIPHeaders = []string{http.CanonicalHeaderKey(cfg.Header)}
+	}
+	if len(cfg.TrustedProxies) == 0 {
+		return nil
+	}
+	return router.SetTrustedProxies(cfg.TrustedProxies)
+}
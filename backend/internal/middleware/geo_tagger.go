@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"github.com/Baaaki/digital-square/internal/geoip"
+	"github.com/Baaaki/digital-square/pkg/logger"
+	"github.com/gin-gonic/gin"
+)
+
+// GeoTagger resolves the client IP with provider and stores the coarse
+// location under logger.GeoCountryKey and logger.GeoASNKey, so request and
+// connection logs carry it. A nil provider disables tagging.
+func GeoTagger(provider geoip.Provider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if location, ok := geoip.LookupString(provider, c.ClientIP()); ok {
+			if location.Country != "" {
+				c.Set(logger.GeoCountryKey, location.Country)
+			}
+			if location.ASN != 0 {
+				c.Set(logger.GeoASNKey, location.ASN)
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/Baaaki/digital-square/internal/geoip"
+	"github.com/Baaaki/digital-square/pkg/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// stubGeo resolves IPs from a fixed map
+type stubGeo map[string]geoip.Location
+
+func (s stubGeo) Lookup(ip netip.Addr) (geoip.Location, bool) {
+	location, ok := s[ip.String()]
+	return location, ok
+}
+
+// TestGeoTagger tests that the client's location is attached to request logs
+// and left out for unknown IPs
+func TestGeoTagger(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	previous := logger.Log
+	logger.Log = zap.New(core)
+	defer func() { logger.Log = previous }()
+
+	router := gin.New()
+	router.Use(RequestLogger())
+	router.Use(GeoTagger(stubGeo{"203.0.113.7": {Country: "NL", ASN: 64500}}))
+	router.GET("/test", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	send := func(remoteAddr string) map[string]interface{} {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.RemoteAddr = remoteAddr
+		router.ServeHTTP(httptest.NewRecorder(), req)
+
+		entries := logs.TakeAll()
+		require.Len(t, entries, 1)
+		return entries[0].ContextMap()
+	}
+
+	fields := send("203.0.113.7:1234")
+	assert.Equal(t, "NL", fields[logger.GeoCountryKey])
+	assert.EqualValues(t, 64500, fields[logger.GeoASNKey])
+
+	fields = send("192.0.2.1:1234")
+	assert.NotContains(t, fields, logger.GeoCountryKey)
+	assert.NotContains(t, fields, logger.GeoASNKey)
+}
+
+// TestRateLimiter_TopIPsGeoTags tests that the admin top-IPs view carries the provider's tags
+func TestRateLimiter_TopIPsGeoTags(t *testing.T) {
+	rl, mr := setupTestRateLimiter(100, 1*time.Minute)
+	defer mr.Close()
+	rl.config.MaxTrackedIPs = 100
+	rl.config.Geo = stubGeo{"203.0.113.7": {Country: "NL", ASN: 64500}}
+
+	for _, ip := range []string{"203.0.113.7", "203.0.113.7", "192.0.2.1"} {
+		_, _, err := rl.CheckLimit(ip)
+		require.NoError(t, err)
+	}
+
+	top, err := rl.TopIPs(10)
+	require.NoError(t, err)
+	require.Len(t, top, 2)
+	assert.Equal(t, IPCount{IP: "203.0.113.7", Requests: 2, Location: geoip.Location{Country: "NL", ASN: 64500}}, top[0])
+	assert.Equal(t, IPCount{IP: "192.0.2.1", Requests: 1}, top[1], "Unknown IPs are untagged")
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Baaaki/digital-square/pkg/logger"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// GlobalThrottleConfig defines how many requests the whole process accepts
+type GlobalThrottleConfig struct {
+	MaxRequests int           // Maximum requests across all clients within the window (0 = disabled)
+	Window      time.Duration // Rolling window (e.g., 1 minute)
+}
+
+// GlobalThrottle caps a route's request rate for this process regardless of
+// who is asking. It sits behind the per-IP limiter on expensive routes like
+// registration, where many IPs together can still exhaust hashing CPU.
+// State is in memory: each node enforces its own limit.
+type GlobalThrottle struct {
+	mu     sync.Mutex
+	config GlobalThrottleConfig
+	hits   []time.Time // Ring of the last MaxRequests accepted request times
+	next   int         // Slot of the oldest hit (overwritten by the next one)
+}
+
+// NewGlobalThrottle creates a new process-wide throttle
+func NewGlobalThrottle(config GlobalThrottleConfig) *GlobalThrottle {
+	t := &GlobalThrottle{config: config}
+	if config.MaxRequests > 0 {
+		t.hits = make([]time.Time, config.MaxRequests)
+	}
+	return t
+}
+
+// Allow records a request if it fits in the window.
+// Returns: (allowed bool, retryAfter duration)
+func (t *GlobalThrottle) Allow() (bool, time.Duration) {
+	if t.config.MaxRequests <= 0 {
+		return true, 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	oldest := t.hits[t.next]
+	if !oldest.IsZero() && now.Sub(oldest) < t.config.Window {
+		return false, t.config.Window - now.Sub(oldest)
+	}
+
+	t.hits[t.next] = now
+	t.next = (t.next + 1) % len(t.hits)
+	return true, 0
+}
+
+// Middleware rejects requests with 503 once the process-wide limit is hit
+func (t *GlobalThrottle) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed, retryAfter := t.Allow()
+		if !allowed {
+			// Round up so a client that waits exactly this long gets through
+			retrySeconds := int(math.Ceil(retryAfter.Seconds()))
+			c.Header("Retry-After", fmt.Sprintf("%d", retrySeconds))
+			logger.FromContext(c).Warn("Global throttle rejected request",
+				zap.String("path", c.FullPath()),
+				zap.Int("max_requests", t.config.MaxRequests),
+				zap.Duration("window", t.config.Window),
+			)
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"error":       "Service is busy. Please try again later.",
+				"retry_after": retrySeconds,
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Baaaki/digital-square/pkg/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGlobalThrottle_Middleware tests that requests from many IPs together
+// are throttled with 503 once the process-wide limit is exceeded
+func TestGlobalThrottle_Middleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger.Init(false)
+
+	throttle := NewGlobalThrottle(GlobalThrottleConfig{MaxRequests: 3, Window: time.Minute})
+
+	router := gin.New()
+	router.POST("/register", throttle.Middleware(), func(c *gin.Context) {
+		c.JSON(http.StatusCreated, gin.H{"message": "created"})
+	})
+
+	register := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/register", nil)
+		req.RemoteAddr = ip + ":12345"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		assert.Equal(t, http.StatusCreated, register(ip).Code)
+	}
+
+	// A fresh IP is still throttled: the limit is global
+	w := register("10.0.0.4")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+}
+
+// TestGlobalThrottle_WindowSlides tests that capacity returns as old requests age out
+func TestGlobalThrottle_WindowSlides(t *testing.T) {
+	throttle := NewGlobalThrottle(GlobalThrottleConfig{MaxRequests: 2, Window: 100 * time.Millisecond})
+
+	allowed, _ := throttle.Allow()
+	assert.True(t, allowed)
+	allowed, _ = throttle.Allow()
+	assert.True(t, allowed)
+
+	allowed, retryAfter := throttle.Allow()
+	assert.False(t, allowed)
+	assert.Greater(t, retryAfter, time.Duration(0))
+	assert.LessOrEqual(t, retryAfter, 100*time.Millisecond)
+
+	time.Sleep(retryAfter + 10*time.Millisecond)
+	allowed, _ = throttle.Allow()
+	assert.True(t, allowed)
+}
+
+// TestGlobalThrottle_Disabled tests that a zero limit never throttles
+func TestGlobalThrottle_Disabled(t *testing.T) {
+	throttle := NewGlobalThrottle(GlobalThrottleConfig{})
+
+	for i := 0; i < 100; i++ {
+		allowed, _ := throttle.Allow()
+		assert.True(t, allowed)
+	}
+}
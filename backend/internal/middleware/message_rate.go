@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// MessageRateConfig defines how many messages a user may send
+type MessageRateConfig struct {
+	MaxMessages int           // Maximum messages per user within the window
+	Window      time.Duration // Rolling window (e.g., 10 seconds)
+}
+
+// MessageRate limits WebSocket message sends per user across all of their
+// connections, using the same sliding window as the HTTP rate limiter.
+type MessageRate struct {
+	redis  *redis.Client
+	ctx    context.Context
+	config MessageRateConfig
+	seq    atomic.Uint64 // Keeps window members unique within one nanosecond
+}
+
+// NewMessageRate creates a new per-user message rate limiter
+func NewMessageRate(redisClient *redis.Client, config MessageRateConfig) *MessageRate {
+	return &MessageRate{
+		redis:  redisClient,
+		ctx:    context.Background(),
+		config: config,
+	}
+}
+
+// Allow records a send for the user if it fits in the window.
+// Returns: (allowed bool, retryAfter duration, error)
+func (m *MessageRate) Allow(userID string) (bool, time.Duration, error) {
+	allowed, retryAfter, _, err := m.Reserve(userID)
+	return allowed, retryAfter, err
+}
+
+// Reserve is Allow for a send that may still be rejected by a later check.
+// An allowed send also returns its slot, for Release if it doesn't happen.
+func (m *MessageRate) Reserve(userID string) (bool, time.Duration, string, error) {
+	now := time.Now()
+	slot := fmt.Sprintf("%d-%d", now.UnixNano(), m.seq.Add(1))
+
+	allowed, retryAfter, err := slidingWindow(m.ctx, m.redis, messageRateKey(userID), slot, m.config.MaxMessages, m.config.Window, now)
+	if !allowed {
+		slot = ""
+	}
+	return allowed, retryAfter, slot, err
+}
+
+// Release gives back a slot taken by Reserve, so a send that didn't happen
+// doesn't count against the user's rate
+func (m *MessageRate) Release(userID, slot string) error {
+	if slot == "" {
+		return nil
+	}
+	return m.redis.ZRem(m.ctx, messageRateKey(userID), slot).Err()
+}
+
+func messageRateKey(userID string) string {
+	return fmt.Sprintf("msgrate:%s", userID)
+}
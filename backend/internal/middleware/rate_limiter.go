@@ -2,37 +2,209 @@ package middleware
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Baaaki/digital-square/internal/geoip"
+	"github.com/Baaaki/digital-square/internal/utils"
+	"github.com/Baaaki/digital-square/pkg/logger"
+	"github.com/Baaaki/digital-square/pkg/metrics"
 	"github.com/gin-gonic/gin"
 	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
 )
 
+const (
+	bannedIPsKey    = "banned_ips"        // Permanent bans (set)
+	tempBannedIPKey = "banned_ips:until"  // Temporary bans (zset, score = expiry unix seconds)
+	topIPsKey       = "ratelimit:top_ips" // Request counts per IP (zset)
+	limitsKey       = "ratelimit:config"  // Limits set at runtime by an admin (JSON RateLimits)
+)
+
+// Bounds for limits set at runtime
+const (
+	defaultLimitsRefresh = 5 * time.Second
+	minLimitWindow       = time.Second
+	maxLimitWindow       = 24 * time.Hour
+)
+
+// ErrInvalidRateLimits is returned by SetLimits for out-of-range values
+var ErrInvalidRateLimits = errors.New("invalid rate limits")
+
+// RateLimits are the limits that can be changed at runtime. They are stored in
+// Redis so every node applies the same values; until an admin sets them the
+// configured ones apply.
+type RateLimits struct {
+	MaxRequests     int           `json:"max_requests"` // Per IP in Window
+	Window          time.Duration `json:"window"`
+	UserMaxRequests int           `json:"user_max_requests"` // Per user in UserWindow (0 = limit by IP only)
+	UserWindow      time.Duration `json:"user_window"`
+}
+
+// Validate checks that the limits are usable
+func (l RateLimits) Validate() error {
+	if l.MaxRequests < 1 {
+		return fmt.Errorf("%w: max_requests must be at least 1", ErrInvalidRateLimits)
+	}
+	if l.Window < minLimitWindow || l.Window > maxLimitWindow {
+		return fmt.Errorf("%w: window must be between %s and %s", ErrInvalidRateLimits, minLimitWindow, maxLimitWindow)
+	}
+	if l.UserMaxRequests < 0 {
+		return fmt.Errorf("%w: user_max_requests cannot be negative", ErrInvalidRateLimits)
+	}
+	if l.UserMaxRequests > 0 && (l.UserWindow < minLimitWindow || l.UserWindow > maxLimitWindow) {
+		return fmt.Errorf("%w: user_window must be between %s and %s", ErrInvalidRateLimits, minLimitWindow, maxLimitWindow)
+	}
+	return nil
+}
+
 // RateLimiterConfig defines rate limiting rules
 type RateLimiterConfig struct {
 	MaxRequests int           // Maximum requests allowed in the window
 	Window      time.Duration // Time window (e.g., 1 minute)
 	BlockTime   time.Duration // How long to block after exceeding limit
+
+	// Authenticated requests are limited per user instead of per IP
+	UserMaxRequests int           // Maximum requests per user in UserWindow (0 = limit by IP only)
+	UserWindow      time.Duration // Per-user time window
+
+	// Maintenance bounds the Redis structures that don't expire on their own
+	MaxTrackedIPs       int           // Max entries kept in the top-IPs set (0 = don't track)
+	MaintenanceInterval time.Duration // How often to prune (0 = no background pruning)
+
+	// Optional response overrides (zero values keep the JSON defaults)
+	LimitedResponse RejectResponse // Rate limit exceeded (default 429)
+	BannedResponse  RejectResponse // Banned IP (default 403)
+
+	Metrics *metrics.Metrics // Counts rejections (nil = disabled)
+	Geo     geoip.Provider   // Tags TopIPs with country/ASN (nil = no tags)
+
+	// How often limits set at runtime are re-read from Redis (0 = 5s).
+	// A change made on another node applies here within this interval.
+	LimitsRefresh time.Duration
 }
 
-// RateLimiter provides IP-based rate limiting using Redis
+// IPCount is an IP's request count, as shown to admins
+type IPCount struct {
+	IP       string `json:"ip"`
+	Requests int64  `json:"requests"`
+
+	geoip.Location // Country/ASN, empty unless a geo provider is configured
+}
+
+// RejectResponse customizes the response sent when a request is rejected,
+// for clients or load balancers that expect a specific format
+type RejectResponse struct {
+	Status      int    // HTTP status code (0 = default)
+	ContentType string // Content-Type of Body (empty = application/json)
+	Body        string // Raw body, "{retry_after}" is replaced with seconds (empty = default JSON)
+}
+
+// RateLimiter provides IP-based (and optionally per-user) rate limiting using Redis
 type RateLimiter struct {
 	redis  *redis.Client
 	ctx    context.Context
 	config RateLimiterConfig
+
+	now func() time.Time // Swappable for tests (defaults to time.Now)
+	seq atomic.Uint64    // Keeps window members unique within one nanosecond
+
+	limitsMu       sync.Mutex
+	limits         RateLimits // Effective limits, cached from Redis
+	limitsLoadedAt time.Time  // Zero = not loaded yet
 }
 
 // NewRateLimiter creates a new rate limiter instance
 func NewRateLimiter(redisClient *redis.Client, config RateLimiterConfig) *RateLimiter {
+	if config.LimitsRefresh <= 0 {
+		config.LimitsRefresh = defaultLimitsRefresh
+	}
 	return &RateLimiter{
 		redis:  redisClient,
 		ctx:    context.Background(),
 		config: config,
+		now:    time.Now,
+		limits: configuredLimits(config),
 	}
 }
 
+// configuredLimits returns the limits from the startup config
+func configuredLimits(config RateLimiterConfig) RateLimits {
+	return RateLimits{
+		MaxRequests:     config.MaxRequests,
+		Window:          config.Window,
+		UserMaxRequests: config.UserMaxRequests,
+		UserWindow:      config.UserWindow,
+	}
+}
+
+// currentLimits returns the effective limits, re-reading Redis at most every
+// LimitsRefresh. If Redis fails the last known limits stay in effect.
+func (rl *RateLimiter) currentLimits() RateLimits {
+	rl.limitsMu.Lock()
+	defer rl.limitsMu.Unlock()
+
+	now := rl.now()
+	if !rl.limitsLoadedAt.IsZero() && now.Sub(rl.limitsLoadedAt) < rl.config.LimitsRefresh {
+		return rl.limits
+	}
+
+	limits, err := rl.Limits()
+	if err != nil {
+		logger.Log.Warn("Failed to load rate limits, keeping current ones", zap.Error(err))
+	} else {
+		rl.limits = limits
+	}
+	rl.limitsLoadedAt = now
+	return rl.limits
+}
+
+// Limits returns the limits stored in Redis, or the configured ones if none were set
+func (rl *RateLimiter) Limits() (RateLimits, error) {
+	data, err := rl.redis.Get(rl.ctx, limitsKey).Bytes()
+	if err == redis.Nil {
+		return configuredLimits(rl.config), nil
+	}
+	if err != nil {
+		return RateLimits{}, err
+	}
+
+	var limits RateLimits
+	if err := json.Unmarshal(data, &limits); err != nil {
+		return RateLimits{}, err
+	}
+	return limits, nil
+}
+
+// SetLimits validates and stores new limits. They apply on this node at once
+// and on other nodes within LimitsRefresh.
+func (rl *RateLimiter) SetLimits(limits RateLimits) error {
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(limits)
+	if err != nil {
+		return err
+	}
+	if err := rl.redis.Set(rl.ctx, limitsKey, data, 0).Err(); err != nil {
+		return err
+	}
+
+	rl.limitsMu.Lock()
+	rl.limits = limits
+	rl.limitsLoadedAt = rl.now()
+	rl.limitsMu.Unlock()
+	return nil
+}
+
 // Middleware returns a Gin middleware function for rate limiting
 func (rl *RateLimiter) Middleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -41,15 +213,23 @@ func (rl *RateLimiter) Middleware() gin.HandlerFunc {
 
 		// Check if IP is banned first (Phase 2 feature)
 		if banned, _ := rl.IsIPBanned(clientIP); banned {
-			c.JSON(http.StatusForbidden, gin.H{
+			rl.config.Metrics.RateLimitRejected("banned")
+			rl.reject(c, rl.config.BannedResponse, http.StatusForbidden, gin.H{
 				"error": "Your IP address has been banned",
-			})
-			c.Abort()
+			}, 0)
 			return
 		}
 
-		// Rate limit check
-		allowed, retryAfter, err := rl.CheckLimit(clientIP)
+		// Rate limit check: per user once authenticated (users behind one NAT
+		// don't share a budget), per IP otherwise
+		var allowed bool
+		var retryAfter time.Duration
+		var err error
+		if userID, ok := rl.authenticatedUser(c, rl.currentLimits()); ok {
+			allowed, retryAfter, err = rl.CheckUserLimit(userID)
+		} else {
+			allowed, retryAfter, err = rl.CheckLimit(clientIP)
+		}
 		if err != nil {
 			// Log error but don't block request (fail open strategy)
 			// In production, you might want to fail closed instead
@@ -58,12 +238,14 @@ func (rl *RateLimiter) Middleware() gin.HandlerFunc {
 		}
 
 		if !allowed {
-			c.Header("Retry-After", fmt.Sprintf("%d", int(retryAfter.Seconds())))
-			c.JSON(http.StatusTooManyRequests, gin.H{
+			// Round up so a client that waits exactly this long gets through
+			retrySeconds := int(math.Ceil(retryAfter.Seconds()))
+			c.Header("Retry-After", fmt.Sprintf("%d", retrySeconds))
+			rl.config.Metrics.RateLimitRejected("limited")
+			rl.reject(c, rl.config.LimitedResponse, http.StatusTooManyRequests, gin.H{
 				"error":       "Too many requests. Please try again later.",
-				"retry_after": int(retryAfter.Seconds()),
-			})
-			c.Abort()
+				"retry_after": retrySeconds,
+			}, retrySeconds)
 			return
 		}
 
@@ -71,50 +253,203 @@ func (rl *RateLimiter) Middleware() gin.HandlerFunc {
 	}
 }
 
-// CheckLimit uses token bucket algorithm via Redis
+// authenticatedUser returns the user ID from AuthMiddleware's claims when
+// per-user limiting is enabled. The limiter must run after AuthMiddleware.
+func (rl *RateLimiter) authenticatedUser(c *gin.Context, limits RateLimits) (string, bool) {
+	if limits.UserMaxRequests <= 0 {
+		return "", false
+	}
+	value, exists := c.Get("claims")
+	if !exists {
+		return "", false
+	}
+	claims, ok := value.(*utils.Claims)
+	if !ok {
+		return "", false
+	}
+	return claims.UserID.String(), true
+}
+
+// reject aborts the request with the configured response, or the default JSON body
+func (rl *RateLimiter) reject(c *gin.Context, resp RejectResponse, defaultStatus int, defaultBody gin.H, retryAfter int) {
+	status := defaultStatus
+	if resp.Status != 0 {
+		status = resp.Status
+	}
+
+	if resp.Body == "" {
+		c.AbortWithStatusJSON(status, defaultBody)
+		return
+	}
+
+	contentType := resp.ContentType
+	if contentType == "" {
+		contentType = "application/json; charset=utf-8"
+	}
+	body := strings.ReplaceAll(resp.Body, "{retry_after}", strconv.Itoa(retryAfter))
+	c.Data(status, contentType, []byte(body))
+	c.Abort()
+}
+
+// CheckLimit applies the IP limit (MaxRequests per Window, as currently set)
 // Returns: (allowed bool, retryAfter duration, error)
 func (rl *RateLimiter) CheckLimit(ip string) (bool, time.Duration, error) {
 	key := fmt.Sprintf("ratelimit:%s", ip)
+	now := rl.now()
+	member := fmt.Sprintf("%d-%d", now.UnixNano(), rl.seq.Add(1))
 
-	// Use Redis INCR with EXPIRE for atomic counter
-	// This implements a simple sliding window counter
-	count, err := rl.redis.Incr(rl.ctx, key).Result()
+	limits := rl.currentLimits()
+	allowed, retryAfter, err := slidingWindow(rl.ctx, rl.redis, key, member, limits.MaxRequests, limits.Window, now)
 	if err != nil {
 		return false, 0, err
 	}
 
-	// Set expiry on first request (count = 1)
-	if count == 1 {
-		if err := rl.redis.Expire(rl.ctx, key, rl.config.Window).Err(); err != nil {
-			return false, 0, err
-		}
+	// Track busiest IPs (pruned to MaxTrackedIPs by maintenance)
+	if rl.config.MaxTrackedIPs > 0 {
+		rl.redis.ZIncrBy(rl.ctx, topIPsKey, 1, ip)
 	}
 
-	// Check if limit exceeded
-	if count > int64(rl.config.MaxRequests) {
-		// Get TTL to calculate retry-after
-		ttl, err := rl.redis.TTL(rl.ctx, key).Result()
-		if err != nil {
-			ttl = rl.config.Window // Fallback to window size
-		}
-		return false, ttl, nil
+	return allowed, retryAfter, nil
+}
+
+// CheckUserLimit applies the per-user limit (UserMaxRequests per UserWindow, as currently set)
+// Returns: (allowed bool, retryAfter duration, error)
+func (rl *RateLimiter) CheckUserLimit(userID string) (bool, time.Duration, error) {
+	key := fmt.Sprintf("ratelimit:user:%s", userID)
+	now := rl.now()
+	member := fmt.Sprintf("%d-%d", now.UnixNano(), rl.seq.Add(1))
+
+	limits := rl.currentLimits()
+	return slidingWindow(rl.ctx, rl.redis, key, member, limits.UserMaxRequests, limits.UserWindow, now)
+}
+
+// slidingWindow records member in key's window if fewer than limit members are
+// newer than window: each event is a sorted-set member scored by its timestamp,
+// so the limit holds across any rolling interval. Rejected events are not
+// recorded. retryAfter is when the oldest counted event leaves the window.
+func slidingWindow(ctx context.Context, client *redis.Client, key, member string, limit int, window time.Duration, now time.Time) (bool, time.Duration, error) {
+	windowStart := now.Add(-window)
+
+	// Trim, record and count atomically
+	pipe := client.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(windowStart.UnixMicro(), 10))
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.UnixMicro()), Member: member})
+	count := pipe.ZCard(ctx, key)
+	pipe.PExpire(ctx, key, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, 0, err
+	}
+
+	if count.Val() <= int64(limit) {
+		return true, 0, nil
 	}
 
-	return true, 0, nil
+	// Over the limit: take the event back out so it doesn't extend the block
+	if err := client.ZRem(ctx, key, member).Err(); err != nil {
+		return false, 0, err
+	}
+
+	retryAfter := window // Fallback to window size
+	oldest, err := client.ZRangeWithScores(ctx, key, 0, 0).Result()
+	if err == nil && len(oldest) > 0 {
+		// Anything left is newer than windowStart, so this is always positive
+		retryAfter = time.UnixMicro(int64(oldest[0].Score)).Add(window).Sub(now)
+	}
+	return false, retryAfter, nil
 }
 
-// IsIPBanned checks if an IP address is in the ban list (Phase 2 feature)
+// IsIPBanned checks if an IP address is banned, permanently or temporarily (Phase 2 feature)
 func (rl *RateLimiter) IsIPBanned(ip string) (bool, error) {
-	exists, err := rl.redis.SIsMember(rl.ctx, "banned_ips", ip).Result()
-	return exists, err
+	exists, err := rl.redis.SIsMember(rl.ctx, bannedIPsKey, ip).Result()
+	if err != nil || exists {
+		return exists, err
+	}
+
+	// Temporary ban still active? (expired entries are pruned by maintenance)
+	until, err := rl.redis.ZScore(rl.ctx, tempBannedIPKey, ip).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return int64(until) > time.Now().Unix(), nil
 }
 
 // BanIP adds an IP to the ban list (Phase 2 feature)
 func (rl *RateLimiter) BanIP(ip string) error {
-	return rl.redis.SAdd(rl.ctx, "banned_ips", ip).Err()
+	return rl.redis.SAdd(rl.ctx, bannedIPsKey, ip).Err()
+}
+
+// BanIPFor bans an IP until the duration elapses
+func (rl *RateLimiter) BanIPFor(ip string, duration time.Duration) error {
+	until := time.Now().Add(duration).Unix()
+	return rl.redis.ZAdd(rl.ctx, tempBannedIPKey, redis.Z{Score: float64(until), Member: ip}).Err()
 }
 
 // UnbanIP removes an IP from the ban list (Phase 2 feature)
 func (rl *RateLimiter) UnbanIP(ip string) error {
-	return rl.redis.SRem(rl.ctx, "banned_ips", ip).Err()
+	pipe := rl.redis.Pipeline()
+	pipe.SRem(rl.ctx, bannedIPsKey, ip)
+	pipe.ZRem(rl.ctx, tempBannedIPKey, ip)
+	_, err := pipe.Exec(rl.ctx)
+	return err
+}
+
+// TopIPs returns the busiest IPs, most requests first
+func (rl *RateLimiter) TopIPs(limit int) ([]IPCount, error) {
+	results, err := rl.redis.ZRevRangeWithScores(rl.ctx, topIPsKey, 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	ips := make([]IPCount, 0, len(results))
+	for _, z := range results {
+		ip := z.Member.(string)
+		location, _ := geoip.LookupString(rl.config.Geo, ip)
+		ips = append(ips, IPCount{IP: ip, Requests: int64(z.Score), Location: location})
+	}
+	return ips, nil
+}
+
+// Prune trims the top-IPs set to MaxTrackedIPs and drops expired temporary bans.
+// Returns the number of entries removed from each.
+func (rl *RateLimiter) Prune() (trimmedIPs int64, expiredBans int64, err error) {
+	pipe := rl.redis.Pipeline()
+	trim := pipe.ZRemRangeByRank(rl.ctx, topIPsKey, 0, int64(-rl.config.MaxTrackedIPs-1))
+	expire := pipe.ZRemRangeByScore(rl.ctx, tempBannedIPKey, "-inf", strconv.FormatInt(time.Now().Unix(), 10))
+	if _, err := pipe.Exec(rl.ctx); err != nil {
+		return 0, 0, err
+	}
+	return trim.Val(), expire.Val(), nil
+}
+
+// StartMaintenance prunes Redis structures every MaintenanceInterval until ctx is cancelled
+func (rl *RateLimiter) StartMaintenance(ctx context.Context) {
+	if rl.config.MaintenanceInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(rl.config.MaintenanceInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				trimmed, expired, err := rl.Prune()
+				if err != nil {
+					logger.Log.Warn("Rate limiter maintenance failed", zap.Error(err))
+					continue
+				}
+				if trimmed > 0 || expired > 0 {
+					logger.Log.Debug("Rate limiter maintenance completed",
+						zap.Int64("trimmed_ips", trimmed),
+						zap.Int64("expired_bans", expired),
+					)
+				}
+			}
+		}
+	}()
 }
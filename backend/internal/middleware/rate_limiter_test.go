@@ -1,13 +1,18 @@
 package middleware
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/Baaaki/digital-square/internal/utils"
+	"github.com/Baaaki/digital-square/pkg/logger"
 	"github.com/alicebob/miniredis/v2"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -286,6 +291,94 @@ func TestRateLimiter_WindowExpiry(t *testing.T) {
 	assert.True(t, allowed, "Request should be allowed after window expires")
 }
 
+// TestRateLimiter_SlidingWindowAcrossBoundary tests that a burst straddling where a
+// fixed window would reset still can't exceed MaxRequests in any rolling interval
+func TestRateLimiter_SlidingWindowAcrossBoundary(t *testing.T) {
+	rl, mr := setupTestRateLimiter(3, 10*time.Second)
+	defer mr.Close()
+
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := base
+	rl.now = func() time.Time { return now }
+	ip := "192.168.1.100"
+
+	// Fill the limit just before a fixed window would have reset
+	now = base.Add(9 * time.Second)
+	for i := 0; i < 3; i++ {
+		allowed, _, err := rl.CheckLimit(ip)
+		require.NoError(t, err)
+		assert.True(t, allowed, "Request %d should be allowed", i+1)
+	}
+
+	// Just past the boundary: all 3 are still inside the rolling window
+	now = base.Add(11 * time.Second)
+	for i := 0; i < 3; i++ {
+		allowed, retryAfter, err := rl.CheckLimit(ip)
+		require.NoError(t, err)
+		assert.False(t, allowed, "Request after the boundary should be denied")
+		assert.Equal(t, 8*time.Second, retryAfter, "Retry once the oldest request leaves the window")
+	}
+
+	// Once the first burst ages out, the full limit is available again
+	now = base.Add(19*time.Second + time.Millisecond)
+	for i := 0; i < 3; i++ {
+		allowed, _, err := rl.CheckLimit(ip)
+		require.NoError(t, err)
+		assert.True(t, allowed, "Request %d should be allowed after the window slides", i+1)
+	}
+	allowed, _, err := rl.CheckLimit(ip)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+}
+
+// TestRateLimiter_PerUserLimit tests that authenticated requests are limited
+// per user, so users behind one IP don't share a budget
+func TestRateLimiter_PerUserLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	rl, mr := setupTestRateLimiter(100, 1*time.Minute)
+	defer mr.Close()
+	rl.config.UserMaxRequests = 2
+	rl.config.UserWindow = time.Minute
+
+	alice := &utils.Claims{UserID: uuid.New()}
+	bob := &utils.Claims{UserID: uuid.New()}
+
+	router := gin.New()
+	router.GET("/test", func(c *gin.Context) {
+		switch c.GetHeader("X-Test-User") {
+		case "alice":
+			c.Set("claims", alice)
+		case "bob":
+			c.Set("claims", bob)
+		}
+	}, rl.Middleware(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"message": "success"})
+	})
+
+	request := func(user string) int {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.RemoteAddr = "10.0.0.1:12345" // Same NAT for everyone
+		if user != "" {
+			req.Header.Set("X-Test-User", user)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, request("alice"))
+	assert.Equal(t, http.StatusOK, request("alice"))
+	assert.Equal(t, http.StatusTooManyRequests, request("alice"), "Alice is over her own limit")
+
+	assert.Equal(t, http.StatusOK, request("bob"), "Bob has a separate budget on the same IP")
+	assert.Equal(t, http.StatusOK, request(""), "Anonymous requests still use the IP limit")
+
+	ipCount, err := rl.redis.ZCard(rl.ctx, "ratelimit:10.0.0.1").Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), ipCount, "Authenticated requests don't count against the IP")
+}
+
 // TestRateLimiter_ConcurrentRequests tests rate limiting under concurrent load
 func TestRateLimiter_ConcurrentRequests(t *testing.T) {
 	gin.SetMode(gin.TestMode)
@@ -392,3 +485,270 @@ func BenchmarkRateLimiter_Middleware(b *testing.B) {
 		router.ServeHTTP(w, req)
 	}
 }
+
+// TestRateLimiter_CustomLimitedResponse tests a configured plain-text 429 body
+func TestRateLimiter_CustomLimitedResponse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	rl, mr := setupTestRateLimiter(1, 1*time.Minute)
+	defer mr.Close()
+	rl.config.LimitedResponse = RejectResponse{
+		Status:      http.StatusServiceUnavailable,
+		ContentType: "text/plain; charset=utf-8",
+		Body:        "slow down, retry in {retry_after}s",
+	}
+
+	router := gin.New()
+	router.Use(rl.Middleware())
+	router.GET("/test", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.RemoteAddr = "192.168.1.1:12345"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if i == 0 {
+			assert.Equal(t, http.StatusOK, w.Code)
+			continue
+		}
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Equal(t, "slow down, retry in 60s", w.Body.String())
+		assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	}
+}
+
+// TestRateLimiter_CustomBannedResponse tests a configured 403 body and that
+// the default stays JSON
+func TestRateLimiter_CustomBannedResponse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	rl, mr := setupTestRateLimiter(10, 1*time.Minute)
+	defer mr.Close()
+	require.NoError(t, rl.BanIP("10.0.0.1"))
+
+	router := gin.New()
+	router.Use(rl.Middleware())
+	router.GET("/test", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.RemoteAddr = "10.0.0.1:12345"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Default: JSON body
+	w := send()
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "application/json")
+	assert.JSONEq(t, `{"error":"Your IP address has been banned"}`, w.Body.String())
+
+	// Custom body keeps the default status
+	rl.config.BannedResponse = RejectResponse{Body: `{"code":"IP_BANNED"}`}
+	w = send()
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "application/json")
+	assert.JSONEq(t, `{"code":"IP_BANNED"}`, w.Body.String())
+}
+
+// TestRateLimiter_RuntimeLimits tests that limits set at runtime apply to the
+// next requests, are validated and reach other nodes after the refresh interval
+func TestRateLimiter_RuntimeLimits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	rl, mr := setupTestRateLimiter(2, 1*time.Minute)
+	defer mr.Close()
+
+	router := gin.New()
+	router.Use(rl.Middleware())
+	router.GET("/test", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	send := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.RemoteAddr = "10.0.0.1:12345"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Configured limits apply until changed
+	limits, err := rl.Limits()
+	require.NoError(t, err)
+	assert.Equal(t, RateLimits{MaxRequests: 2, Window: time.Minute}, limits)
+	assert.Equal(t, http.StatusOK, send())
+	assert.Equal(t, http.StatusOK, send())
+	assert.Equal(t, http.StatusTooManyRequests, send())
+
+	// Invalid values are rejected and change nothing
+	for _, bad := range []RateLimits{
+		{MaxRequests: 0, Window: time.Minute},
+		{MaxRequests: 5, Window: time.Millisecond},
+		{MaxRequests: 5, Window: time.Minute, UserMaxRequests: -1},
+		{MaxRequests: 5, Window: time.Minute, UserMaxRequests: 5},
+	} {
+		assert.ErrorIs(t, rl.SetLimits(bad), ErrInvalidRateLimits, "%+v", bad)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, send())
+
+	// Raising the limit lets the same client through right away
+	require.NoError(t, rl.SetLimits(RateLimits{MaxRequests: 5, Window: time.Minute}))
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, send(), "request %d", i+1)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, send())
+
+	// Another node sharing Redis picks the change up once its cache refreshes
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	other := NewRateLimiter(client, RateLimiterConfig{MaxRequests: 100, Window: time.Minute, LimitsRefresh: 10 * time.Second})
+	now := time.Now()
+	other.now = func() time.Time { return now }
+	assert.Equal(t, 5, other.currentLimits().MaxRequests)
+
+	require.NoError(t, rl.SetLimits(RateLimits{MaxRequests: 1, Window: time.Minute}))
+	assert.Equal(t, 5, other.currentLimits().MaxRequests, "Cached until the refresh interval passes")
+
+	now = now.Add(10 * time.Second)
+	assert.Equal(t, 1, other.currentLimits().MaxRequests)
+	allowed, _, err := other.CheckLimit("10.0.0.2")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	allowed, _, err = other.CheckLimit("10.0.0.2")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+}
+
+// TestRateLimiter_PruneBoundsTopIPs tests that the top-IPs set is trimmed to MaxTrackedIPs
+func TestRateLimiter_PruneBoundsTopIPs(t *testing.T) {
+	rl, mr := setupTestRateLimiter(100, 1*time.Minute)
+	defer mr.Close()
+	rl.config.MaxTrackedIPs = 100
+
+	// One busy IP, many one-off IPs
+	for i := 0; i < 5; i++ {
+		_, _, err := rl.CheckLimit("10.0.0.1")
+		require.NoError(t, err)
+	}
+	for i := 0; i < 5000; i++ {
+		_, _, err := rl.CheckLimit(fmt.Sprintf("172.16.%d.%d", i/256, i%256))
+		require.NoError(t, err)
+	}
+
+	size, err := rl.redis.ZCard(rl.ctx, topIPsKey).Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(5001), size, "All IPs should be tracked before pruning")
+
+	trimmed, _, err := rl.Prune()
+	require.NoError(t, err)
+	assert.Equal(t, int64(4901), trimmed)
+
+	size, err = rl.redis.ZCard(rl.ctx, topIPsKey).Result()
+	require.NoError(t, err)
+	assert.LessOrEqual(t, size, int64(100), "Set should be bounded by MaxTrackedIPs")
+
+	// The busiest IP survives the trim
+	top, err := rl.TopIPs(1)
+	require.NoError(t, err)
+	require.Len(t, top, 1)
+	assert.Equal(t, "10.0.0.1", top[0].IP)
+	assert.Equal(t, int64(5), top[0].Requests)
+}
+
+// TestRateLimiter_TemporaryBanExpires tests that elapsed temporary bans stop applying and are pruned
+func TestRateLimiter_TemporaryBanExpires(t *testing.T) {
+	rl, mr := setupTestRateLimiter(100, 1*time.Minute)
+	defer mr.Close()
+
+	require.NoError(t, rl.BanIPFor("192.168.1.1", time.Hour))
+	require.NoError(t, rl.BanIPFor("192.168.1.2", -time.Minute)) // Already elapsed
+
+	banned, err := rl.IsIPBanned("192.168.1.1")
+	require.NoError(t, err)
+	assert.True(t, banned, "Active temporary ban should apply")
+
+	banned, err = rl.IsIPBanned("192.168.1.2")
+	require.NoError(t, err)
+	assert.False(t, banned, "Expired temporary ban should not apply")
+
+	_, expired, err := rl.Prune()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), expired)
+
+	size, err := rl.redis.ZCard(rl.ctx, tempBannedIPKey).Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), size, "Only the active ban should remain")
+}
+
+// TestRateLimiter_StartMaintenance tests that the background loop keeps the set bounded
+func TestRateLimiter_StartMaintenance(t *testing.T) {
+	logger.Init(false)
+
+	rl, mr := setupTestRateLimiter(100, 1*time.Minute)
+	defer mr.Close()
+	rl.config.MaxTrackedIPs = 10
+	rl.config.MaintenanceInterval = 10 * time.Millisecond
+
+	for i := 0; i < 500; i++ {
+		_, _, err := rl.CheckLimit(fmt.Sprintf("172.16.%d.%d", i/256, i%256))
+		require.NoError(t, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rl.StartMaintenance(ctx)
+
+	require.Eventually(t, func() bool {
+		size, err := rl.redis.ZCard(rl.ctx, topIPsKey).Result()
+		return err == nil && size <= 10
+	}, time.Second, 10*time.Millisecond, "Maintenance should trim the top-IPs set")
+}
+
+// TestRateLimiter_ClientIPHeaderBehindTrustedProxy tests that the configured
+// header is the rate-limit key for requests from a trusted proxy, and is
+// ignored (peer address used) for requests from anyone else
+func TestRateLimiter_ClientIPHeaderBehindTrustedProxy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	rl, mr := setupTestRateLimiter(1, 1*time.Minute)
+	defer mr.Close()
+
+	router := gin.New()
+	require.NoError(t, ConfigureClientIP(router, ClientIPConfig{
+		Header:         "x-real-ip",
+		TrustedProxies: []string{"10.0.0.0/8"},
+	}))
+	router.Use(rl.Middleware())
+	router.GET("/test", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"message": "success"})
+	})
+
+	get := func(remoteAddr, realIP, forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Real-IP", realIP)
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Via the proxy: keyed by X-Real-IP, X-Forwarded-For is ignored
+	assert.Equal(t, http.StatusOK, get("10.0.0.1:12345", "203.0.113.1", "198.51.100.1"))
+	assert.Equal(t, http.StatusTooManyRequests, get("10.0.0.1:12345", "203.0.113.1", "198.51.100.2"))
+	assert.Equal(t, http.StatusOK, get("10.0.0.1:12345", "203.0.113.2", "198.51.100.1"))
+
+	// Direct peer: the header can't be used to dodge the limit
+	assert.Equal(t, http.StatusOK, get("192.0.2.7:12345", "203.0.113.3", ""))
+	assert.Equal(t, http.StatusTooManyRequests, get("192.0.2.7:12345", "203.0.113.4", ""))
+
+	// A custom header without trusted proxies would let any client pick its key
+	assert.Error(t, ConfigureClientIP(gin.New(), ClientIPConfig{Header: "X-Real-IP"}))
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/Baaaki/digital-square/pkg/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// RequestIDHeader carries the request ID in both directions
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds client-supplied request IDs kept in logs
+const maxRequestIDLength = 128
+
+// RequestLogger tags each request with an ID and logs it once it completes.
+// An incoming X-Request-ID (e.g. from a proxy) is kept if it looks sane,
+// otherwise a new one is generated. The ID is echoed in the response header
+// and stored under logger.RequestIDKey for logger.FromContext.
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+		c.Set(logger.RequestIDKey, requestID)
+		c.Header(RequestIDHeader, requestID)
+
+		c.Next()
+
+		// Read after c.Next so the user ID set by AuthMiddleware is included
+		logger.FromContext(c).Info("HTTP request",
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.Request.URL.Path),
+			zap.Int("status", c.Writer.Status()),
+			zap.Duration("latency", time.Since(start)),
+			zap.String("client_ip", c.ClientIP()),
+			zap.Int("response_bytes", c.Writer.Size()),
+		)
+	}
+}
+
+// validRequestID accepts IDs of printable ASCII without spaces, so a client
+// can't forge log lines or bloat them
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Baaaki/digital-square/pkg/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// TestRequestLogger tests request ID generation and propagation, and that the
+// access log and handler logs carry the request and user IDs
+func TestRequestLogger(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	previous := logger.Log
+	logger.Log = zap.New(core)
+	defer func() { logger.Log = previous }()
+
+	router := gin.New()
+	router.Use(RequestLogger())
+	router.GET("/test", func(c *gin.Context) {
+		c.Set(logger.UserIDKey, "user-1") // As AuthMiddleware does
+		logger.FromContext(c).Info("Handler log")
+		c.String(http.StatusTeapot, "ok")
+	})
+
+	send := func(requestID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		if requestID != "" {
+			req.Header.Set(RequestIDHeader, requestID)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Generated when missing
+	w := send("")
+	generated := w.Header().Get(RequestIDHeader)
+	_, err := uuid.Parse(generated)
+	require.NoError(t, err)
+
+	entries := logs.TakeAll()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "Handler log", entries[0].Message)
+	assert.Equal(t, "HTTP request", entries[1].Message)
+	for _, entry := range entries {
+		fields := entry.ContextMap()
+		assert.Equal(t, generated, fields[logger.RequestIDKey])
+		assert.Equal(t, "user-1", fields[logger.UserIDKey])
+	}
+	access := entries[1].ContextMap()
+	assert.Equal(t, "GET", access["method"])
+	assert.Equal(t, "/test", access["path"])
+	assert.EqualValues(t, http.StatusTeapot, access["status"])
+
+	// Honored when sane
+	w = send("proxy-abc.123")
+	assert.Equal(t, "proxy-abc.123", w.Header().Get(RequestIDHeader))
+	assert.Equal(t, "proxy-abc.123", logs.TakeAll()[1].ContextMap()[logger.RequestIDKey])
+
+	// Replaced when it could pollute logs
+	for _, bad := range []string{"has space", "line\tbreak", strings.Repeat("a", maxRequestIDLength+1)} {
+		w = send(bad)
+		assert.NotEqual(t, bad, w.Header().Get(RequestIDHeader))
+		_, err := uuid.Parse(w.Header().Get(RequestIDHeader))
+		assert.NoError(t, err, bad)
+	}
+}
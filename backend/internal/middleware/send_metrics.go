@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Baaaki/digital-square/pkg/logger"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	sendMetricsBucketPrefix  = "sendrate:"
+	defaultMaxTrackedSenders = 1000
+	reportedTopTalkers       = 5
+)
+
+// SendMetricsConfig defines the rolling window for per-user send rates
+type SendMetricsConfig struct {
+	Window         time.Duration // Rolling window, rounded up to whole minutes (e.g., 5 minutes)
+	MaxTracked     int           // Users kept per minute bucket (bounds Redis memory)
+	ReportInterval time.Duration // How often top talkers are logged (0 = disabled)
+}
+
+// SendRate is a user's message count in the window, as shown to admins
+type SendRate struct {
+	UserID    string  `json:"user_id"`
+	Messages  int64   `json:"messages"`
+	PerMinute float64 `json:"messages_per_min"`
+}
+
+// SendMetrics counts messages sent per user in per-minute sorted sets.
+// The top talkers over the window are the union of the recent buckets,
+// which makes spam accounts stand out on abuse dashboards.
+type SendMetrics struct {
+	redis  *redis.Client
+	ctx    context.Context
+	config SendMetricsConfig
+}
+
+// NewSendMetrics creates a new per-user send rate tracker
+func NewSendMetrics(redisClient *redis.Client, config SendMetricsConfig) *SendMetrics {
+	if config.Window < time.Minute {
+		config.Window = time.Minute
+	}
+	if config.MaxTracked <= 0 {
+		config.MaxTracked = defaultMaxTrackedSenders
+	}
+	return &SendMetrics{
+		redis:  redisClient,
+		ctx:    context.Background(),
+		config: config,
+	}
+}
+
+// Config returns the active metrics settings
+func (m *SendMetrics) Config() SendMetricsConfig {
+	return m.config
+}
+
+// Record counts one sent message for the user in the current minute bucket
+func (m *SendMetrics) Record(userID string) error {
+	key := sendRateBucketKey(time.Now())
+
+	// Lowest counts are dropped once the bucket is full
+	pipe := m.redis.Pipeline()
+	pipe.ZIncrBy(m.ctx, key, 1, userID)
+	pipe.ZRemRangeByRank(m.ctx, key, 0, int64(-m.config.MaxTracked-1))
+	pipe.Expire(m.ctx, key, m.windowMinutes()*time.Minute+time.Minute)
+	_, err := pipe.Exec(m.ctx)
+	return err
+}
+
+// TopTalkers returns the users who sent the most messages in the window, highest first
+func (m *SendMetrics) TopTalkers(limit int) ([]SendRate, error) {
+	if limit <= 0 {
+		return []SendRate{}, nil
+	}
+
+	minutes := m.windowMinutes()
+	now := time.Now()
+	keys := make([]string, 0, minutes)
+	for i := time.Duration(0); i < minutes; i++ {
+		keys = append(keys, sendRateBucketKey(now.Add(-i*time.Minute)))
+	}
+
+	// Sum the buckets into a scratch key, read it, and drop it in one transaction
+	tmpKey := fmt.Sprintf("%stop:%d", sendMetricsBucketPrefix, now.UnixNano())
+	pipe := m.redis.TxPipeline()
+	pipe.ZUnionStore(m.ctx, tmpKey, &redis.ZStore{Keys: keys})
+	top := pipe.ZRevRangeWithScores(m.ctx, tmpKey, 0, int64(limit-1))
+	pipe.Del(m.ctx, tmpKey)
+	if _, err := pipe.Exec(m.ctx); err != nil {
+		return nil, err
+	}
+
+	results := top.Val()
+	rates := make([]SendRate, 0, len(results))
+	for _, z := range results {
+		rates = append(rates, SendRate{
+			UserID:    z.Member.(string),
+			Messages:  int64(z.Score),
+			PerMinute: z.Score / float64(minutes),
+		})
+	}
+	return rates, nil
+}
+
+// StartReporter logs the top talkers every ReportInterval until ctx is cancelled
+func (m *SendMetrics) StartReporter(ctx context.Context) {
+	if m.config.ReportInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(m.config.ReportInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				rates, err := m.TopTalkers(reportedTopTalkers)
+				if err != nil {
+					logger.Log.Warn("Failed to read send metrics", zap.Error(err))
+					continue
+				}
+				for i, rate := range rates {
+					logger.Log.Info("Top talker",
+						zap.Int("rank", i+1),
+						zap.String("user_id", rate.UserID),
+						zap.Int64("messages", rate.Messages),
+						zap.Float64("messages_per_min", rate.PerMinute),
+						zap.Duration("window", m.config.Window),
+					)
+				}
+			}
+		}
+	}()
+}
+
+// windowMinutes is the number of minute buckets covering the window
+func (m *SendMetrics) windowMinutes() time.Duration {
+	return (m.config.Window + time.Minute - 1) / time.Minute
+}
+
+// sendRateBucketKey names the sorted set holding counts for the minute containing t
+func sendRateBucketKey(t time.Time) string {
+	return fmt.Sprintf("%s%d", sendMetricsBucketPrefix, t.Unix()/60)
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupTestSendMetrics creates send metrics with miniredis for testing
+func setupTestSendMetrics(t *testing.T, config SendMetricsConfig) (*SendMetrics, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	return NewSendMetrics(client, config), mr
+}
+
+// TestSendMetrics_TopTalkersRanking tests that uneven traffic is ranked by message count
+func TestSendMetrics_TopTalkersRanking(t *testing.T) {
+	sm, mr := setupTestSendMetrics(t, SendMetricsConfig{Window: 5 * time.Minute})
+	defer mr.Close()
+
+	traffic := map[string]int{
+		"spammer": 50,
+		"chatty":  12,
+		"regular": 3,
+		"lurker":  1,
+	}
+	for userID, count := range traffic {
+		for i := 0; i < count; i++ {
+			require.NoError(t, sm.Record(userID))
+		}
+	}
+
+	top, err := sm.TopTalkers(3)
+	require.NoError(t, err)
+	require.Len(t, top, 3)
+
+	assert.Equal(t, "spammer", top[0].UserID)
+	assert.Equal(t, int64(50), top[0].Messages)
+	assert.InDelta(t, 10.0, top[0].PerMinute, 0.001, "50 messages over a 5 minute window")
+	assert.Equal(t, "chatty", top[1].UserID)
+	assert.Equal(t, int64(12), top[1].Messages)
+	assert.Equal(t, "regular", top[2].UserID)
+	assert.Equal(t, int64(3), top[2].Messages)
+
+	// Scratch key from the union is not left behind
+	for _, key := range mr.Keys() {
+		assert.NotContains(t, key, "sendrate:top:")
+	}
+}
+
+// TestSendMetrics_BucketIsCapped tests that each minute bucket keeps only the busiest users
+func TestSendMetrics_BucketIsCapped(t *testing.T) {
+	sm, mr := setupTestSendMetrics(t, SendMetricsConfig{Window: time.Minute, MaxTracked: 5})
+	defer mr.Close()
+
+	for i := 0; i < 20; i++ {
+		userID := fmt.Sprintf("user-%02d", i)
+		// Earlier users send more, so they survive trimming
+		for j := 0; j < 20-i; j++ {
+			require.NoError(t, sm.Record(userID))
+		}
+	}
+
+	top, err := sm.TopTalkers(100)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(top), 5)
+	require.NotEmpty(t, top)
+	assert.Equal(t, "user-00", top[0].UserID)
+}
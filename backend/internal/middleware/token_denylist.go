@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// revokedTokensKey holds revoked JWT IDs (jti) scored by the token's expiry (unix seconds).
+// Members can't carry their own TTL in a set, so entries past their expiry are pruned on write.
+const revokedTokensKey = "revoked_tokens"
+
+// revokedBeforeKey holds the time (unix milliseconds) before which every
+// access token of a user is revoked, e.g. all devices after a password change
+func revokedBeforeKey(userID string) string {
+	return "tokens_revoked_before:" + userID
+}
+
+// TokenDenylist tracks revoked access tokens in Redis until they would have expired anyway
+type TokenDenylist struct {
+	redis *redis.Client
+	ctx   context.Context
+}
+
+// NewTokenDenylist creates a new Redis-backed token denylist
+func NewTokenDenylist(redisClient *redis.Client) *TokenDenylist {
+	return &TokenDenylist{
+		redis: redisClient,
+		ctx:   context.Background(),
+	}
+}
+
+// Revoke denies the token with the given jti for the rest of its lifetime
+func (d *TokenDenylist) Revoke(jti string, expiresAt time.Time) error {
+	now := time.Now()
+	if jti == "" || !expiresAt.After(now) {
+		return nil // Nothing to deny: no ID, or already expired
+	}
+
+	pipe := d.redis.Pipeline()
+	pipe.ZAdd(d.ctx, revokedTokensKey, redis.Z{Score: float64(expiresAt.Unix()), Member: jti})
+	pipe.ZRemRangeByScore(d.ctx, revokedTokensKey, "-inf", strconv.FormatInt(now.Unix(), 10))
+	_, err := pipe.Exec(d.ctx)
+	return err
+}
+
+// RevokeIssuedBefore denies every token of the user issued before the given
+// time. The cutoff is kept for ttl, the longest access token lifetime: any
+// token it covers has expired by then.
+func (d *TokenDenylist) RevokeIssuedBefore(userID string, before time.Time, ttl time.Duration) error {
+	return d.redis.Set(d.ctx, revokedBeforeKey(userID), before.UnixMilli(), ttl).Err()
+}
+
+// IsRevokedForUser reports whether a token of the user issued at issuedAt
+// falls under a RevokeIssuedBefore cutoff
+func (d *TokenDenylist) IsRevokedForUser(userID string, issuedAt time.Time) (bool, error) {
+	cutoff, err := d.redis.Get(d.ctx, revokedBeforeKey(userID)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return issuedAt.UnixMilli() < cutoff, nil
+}
+
+// IsRevoked reports whether the token with the given jti has been revoked
+func (d *TokenDenylist) IsRevoked(jti string) (bool, error) {
+	if jti == "" {
+		return false, nil
+	}
+
+	expiresAt, err := d.redis.ZScore(d.ctx, revokedTokensKey, jti).Result()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return int64(expiresAt) > time.Now().Unix(), nil
+}
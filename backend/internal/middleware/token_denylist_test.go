@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupTestTokenDenylist creates a token denylist with miniredis for testing
+func setupTestTokenDenylist(t *testing.T) (*TokenDenylist, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	return NewTokenDenylist(client), mr
+}
+
+// TestTokenDenylist_RevokeOnlyPresentedToken tests that revoking one jti leaves others valid
+func TestTokenDenylist_RevokeOnlyPresentedToken(t *testing.T) {
+	dl, mr := setupTestTokenDenylist(t)
+	defer mr.Close()
+
+	require.NoError(t, dl.Revoke("device-a", time.Now().Add(time.Hour)))
+
+	revoked, err := dl.IsRevoked("device-a")
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	revoked, err = dl.IsRevoked("device-b")
+	require.NoError(t, err)
+	assert.False(t, revoked, "Other sessions must stay valid")
+
+	// Tokens without a jti (issued before revocation existed) are never revoked
+	revoked, err = dl.IsRevoked("")
+	require.NoError(t, err)
+	assert.False(t, revoked)
+}
+
+// TestTokenDenylist_RevokeIssuedBefore tests that a per-user cutoff revokes
+// the user's older tokens only, and expires with the longest token lifetime
+func TestTokenDenylist_RevokeIssuedBefore(t *testing.T) {
+	dl, mr := setupTestTokenDenylist(t)
+	defer mr.Close()
+
+	cutoff := time.Now()
+	require.NoError(t, dl.RevokeIssuedBefore("user-1", cutoff, time.Hour))
+
+	revoked, err := dl.IsRevokedForUser("user-1", cutoff.Add(-time.Millisecond))
+	require.NoError(t, err)
+	assert.True(t, revoked, "Tokens issued before the cutoff are revoked")
+
+	revoked, err = dl.IsRevokedForUser("user-1", cutoff)
+	require.NoError(t, err)
+	assert.False(t, revoked, "Tokens issued from the cutoff on stay valid")
+
+	revoked, err = dl.IsRevokedForUser("user-2", cutoff.Add(-time.Millisecond))
+	require.NoError(t, err)
+	assert.False(t, revoked, "Other users are unaffected")
+
+	mr.FastForward(time.Hour)
+	revoked, err = dl.IsRevokedForUser("user-1", cutoff.Add(-time.Millisecond))
+	require.NoError(t, err)
+	assert.False(t, revoked, "The cutoff expires with the tokens it covers")
+}
+
+// TestTokenDenylist_ExpiredEntriesPruned tests that entries only live as long as the token
+func TestTokenDenylist_ExpiredEntriesPruned(t *testing.T) {
+	dl, mr := setupTestTokenDenylist(t)
+	defer mr.Close()
+
+	// Already expired tokens aren't stored at all
+	require.NoError(t, dl.Revoke("expired", time.Now().Add(-time.Minute)))
+	assert.False(t, mr.Exists(revokedTokensKey))
+
+	// A stale entry is pruned by the next revocation
+	mr.ZAdd(revokedTokensKey, float64(time.Now().Add(-time.Minute).Unix()), "stale")
+	require.NoError(t, dl.Revoke("fresh", time.Now().Add(time.Hour)))
+
+	members, err := mr.ZMembers(revokedTokensKey)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"fresh"}, members)
+}
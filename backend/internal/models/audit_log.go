@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AuditAction string
+
+const (
+	AuditActionBan    AuditAction = "ban"
+	AuditActionUnban  AuditAction = "unban"
+	AuditActionMute   AuditAction = "mute"
+	AuditActionUnmute AuditAction = "unmute"
+)
+
+// AuditLog records an admin action against a user
+type AuditLog struct {
+	ID        uint64      `gorm:"primaryKey;autoIncrement" json:"id"`
+	Action    AuditAction `gorm:"type:varchar(50);not null;index" json:"action"`
+	ActorID   uuid.UUID   `gorm:"type:uuid;not null;index" json:"actor_id"`  // Admin who acted
+	TargetID  uuid.UUID   `gorm:"type:uuid;not null;index" json:"target_id"` // Affected user
+	Reason    string      `gorm:"type:text" json:"reason"`
+	CreatedAt time.Time   `gorm:"index" json:"created_at"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Mention records that a message @mentioned a user, for their notification feed.
+// One row per message and mentioned user (UserID); ReadAt is nil while unread.
+type Mention struct {
+	ID             uint64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	MessageID      string     `gorm:"type:varchar(50);not null;uniqueIndex:idx_mentions_message_user,priority:1" json:"message_id"`
+	UserID         uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_mentions_message_user,priority:2;index:idx_mentions_user_read,priority:1" json:"user_id"`
+	AuthorID       uuid.UUID  `gorm:"type:uuid;not null" json:"author_id"`
+	AuthorUsername string     `gorm:"type:varchar(50)" json:"author_username"`
+	RoomID         string     `gorm:"type:varchar(32);not null" json:"room_id"`
+	ReadAt         *time.Time `gorm:"index:idx_mentions_user_read,priority:2" json:"read_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
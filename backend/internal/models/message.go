@@ -7,15 +7,22 @@ import (
 	"gorm.io/gorm"
 )
 
+// DefaultRoomID is the room used when none is given.
+// Messages from before rooms existed belong to it.
+const DefaultRoomID = "general"
+
 type Message struct {
     ID                uint64         `gorm:"primaryKey;autoIncrement"`
     MessageID         string         `gorm:"type:varchar(50);uniqueIndex;not null"`
-    UserID            uuid.UUID      `gorm:"type:uuid;not null;index"`
+    UserID            uuid.UUID      `gorm:"type:uuid;not null;index;index:idx_messages_user_deleted,priority:1"`
     Username          string         `gorm:"type:varchar(50)"` // Denormalized for performance
+    RoomID            string         `gorm:"type:varchar(32);not null;default:'general';index:idx_messages_room_created,priority:1"`
 	Content           string         `gorm:"type:text;not null"`
-    CreatedAt         time.Time      `gorm:"index:idx_created_time"`
+    Lang              string         `gorm:"type:varchar(10)"` // Detected language (ISO 639-1 or "unknown"; empty = detection off)
+    CreatedAt         time.Time      `gorm:"index:idx_created_time;index:idx_messages_room_created,priority:2"` // Composite index serves per-room history
+    EditedAt          *time.Time     // Last edit (nil = never edited)
 
-	DeletedAt         gorm.DeletedAt `gorm:"index"`
+	DeletedAt         gorm.DeletedAt `gorm:"index;index:idx_messages_user_deleted,priority:2"` // Composite index serves per-user counts
     DeletedBy         *uuid.UUID     `gorm:"type:uuid;index"`
     IsDeletedByAdmin  bool           `gorm:"default:false"`
 
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MessageEdit keeps the content a message had before an edit (audit trail)
+type MessageEdit struct {
+	ID              uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
+	MessageID       string    `gorm:"type:varchar(50);not null;index" json:"message_id"` // Message UUID
+	PreviousContent string    `gorm:"type:text;not null" json:"previous_content"`
+	EditedBy        uuid.UUID `gorm:"type:uuid;not null;index" json:"edited_by"` // Author or admin
+	EditedAt        time.Time `gorm:"index" json:"edited_at"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MessageReaction is one user's emoji reaction to a message. The unique
+// index allows each user a given emoji at most once per message.
+type MessageReaction struct {
+	ID        uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
+	MessageID string    `gorm:"type:varchar(50);not null;uniqueIndex:idx_reactions_message_user_emoji,priority:1" json:"message_id"` // Message UUID
+	UserID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_reactions_message_user_emoji,priority:2" json:"user_id"`
+	Emoji     string    `gorm:"type:varchar(16);not null;uniqueIndex:idx_reactions_message_user_emoji,priority:3" json:"emoji"`
+	CreatedAt time.Time `json:"created_at"`
+}
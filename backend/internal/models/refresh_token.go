@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RefreshToken is a single-use token for renewing access tokens.
+// Only the SHA-256 hash is stored; the raw token lives in the client's cookie.
+type RefreshToken struct {
+	ID         uint64     `gorm:"primaryKey;autoIncrement"`
+	UserID     uuid.UUID  `gorm:"type:uuid;not null;index"`
+	TokenHash  string     `gorm:"type:varchar(64);uniqueIndex;not null"`
+	ExpiresAt  time.Time  `gorm:"not null"`
+	ConsumedAt *time.Time // Set when rotated or revoked; presenting it again is reuse
+	CreatedAt  time.Time
+}
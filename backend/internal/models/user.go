@@ -10,17 +10,27 @@ import (
 type Role string
 
 const (
-	RoleUser  Role = "user"
-	RoleAdmin Role = "admin"
+	RoleUser   Role = "user"
+	RoleAdmin  Role = "admin"
+	RoleSystem Role = "system" // Author of announcements; cannot log in
+)
+
+// Reserved identity of the system user (seeded by UserRepository.EnsureSystemUser)
+var SystemUserID = uuid.MustParse("00000000-0000-0000-0000-000000000001")
+
+const (
+	SystemUsername = "system"
+	SystemEmail    = "system@digital-square.invalid"
 )
 
 type User struct {
-	ID           uuid.UUID      `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
-	Username     string         `gorm:"type:varchar(50);uniqueIndex;not null" json:"username"`
-	Email        string         `gorm:"type:varchar(100);uniqueIndex;not null" json:"email"`
-	PasswordHash string         `gorm:"type:varchar(255);not null" json:"-"` // Never expose password hash in JSON
-	Role         Role           `gorm:"type:varchar(20);not null;default:'user'" json:"role"`
-	CreatedAt    time.Time      `json:"created_at"`
-	UpdatedAt    time.Time      `json:"updated_at"`
-	DeletedAt    gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+	ID            uuid.UUID      `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	Username      string         `gorm:"type:varchar(50);uniqueIndex;not null" json:"username"`
+	Email         string         `gorm:"type:varchar(100);uniqueIndex;not null" json:"email"`
+	PasswordHash  string         `gorm:"type:varchar(255);not null" json:"-"` // Never expose password hash in JSON
+	Role          Role           `gorm:"type:varchar(20);not null;default:'user'" json:"role"`
+	EmailVerified bool           `gorm:"not null;default:false" json:"email_verified"` // Unverified accounts can read but not send
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+	DeletedAt     gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
 }
\ No newline at end of file
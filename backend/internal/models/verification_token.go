@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VerificationToken is a single-use token proving the owner reads the
+// account's email. Only the SHA-256 hash is stored; the raw token is in the
+// emailed link.
+type VerificationToken struct {
+	ID         uint64     `gorm:"primaryKey;autoIncrement"`
+	UserID     uuid.UUID  `gorm:"type:uuid;not null;index"`
+	TokenHash  string     `gorm:"type:varchar(64);uniqueIndex;not null"`
+	ExpiresAt  time.Time  `gorm:"not null"`
+	ConsumedAt *time.Time // Set when used, or superseded by a newer token
+	CreatedAt  time.Time
+}
@@ -0,0 +1,109 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Decision is a moderator's verdict on a message
+type Decision string
+
+const (
+	DecisionAllow Decision = "allow" // Deliver normally
+	DecisionBlock Decision = "block" // Reject the send
+	DecisionFlag  Decision = "flag"  // Deliver, but record it for review
+)
+
+// DefaultTimeout bounds a webhook call when none is configured
+const DefaultTimeout = 2 * time.Second
+
+// maxResponseBytes caps how much of a webhook response is read
+const maxResponseBytes = 64 * 1024
+
+// Request is the message being checked. Content is the normalized text
+// before HTML escaping, i.e. what the user actually typed.
+type Request struct {
+	MessageID string `json:"message_id"`
+	UserID    string `json:"user_id"`
+	Username  string `json:"username"`
+	RoomID    string `json:"room_id"`
+	Content   string `json:"content"`
+}
+
+// Result is a moderator's answer
+type Result struct {
+	Decision Decision `json:"decision"`
+	Reason   string   `json:"reason,omitempty"` // Optional, for logs and the client on block
+}
+
+// Moderator checks message content before it is accepted.
+// An error means no decision could be made; the caller applies its fail-open/closed policy.
+type Moderator interface {
+	Moderate(ctx context.Context, req Request) (Result, error)
+}
+
+// WebhookConfig holds the external moderation endpoint settings
+type WebhookConfig struct {
+	URL     string        // Endpoint that receives a POSTed Request as JSON
+	Timeout time.Duration // Max time per call (0 = DefaultTimeout)
+}
+
+// WebhookModerator asks an external HTTP service to moderate each message.
+// The service answers 200 with {"decision": "allow"|"block"|"flag", "reason": "..."}.
+type WebhookModerator struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookModerator creates a moderator that POSTs to config.URL
+func NewWebhookModerator(config WebhookConfig) *WebhookModerator {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	return &WebhookModerator{
+		url:    config.URL,
+		client: &http.Client{Timeout: config.Timeout},
+	}
+}
+
+// Moderate POSTs the request and parses the decision. Non-200 responses and
+// unknown decisions are errors, so a misbehaving service follows the fail policy.
+func (m *WebhookModerator) Moderate(ctx context.Context, req Request) (Result, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return Result{}, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, m.url, bytes.NewReader(body))
+	if err != nil {
+		return Result{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(httpReq)
+	if err != nil {
+		return Result{}, fmt.Errorf("moderation webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Result{}, fmt.Errorf("moderation webhook: unexpected status %d", resp.StatusCode)
+	}
+
+	var result Result
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(&result); err != nil {
+		return Result{}, fmt.Errorf("moderation webhook: invalid response: %w", err)
+	}
+
+	switch result.Decision {
+	case DecisionAllow, DecisionBlock, DecisionFlag:
+		return result, nil
+	default:
+		return Result{}, fmt.Errorf("moderation webhook: unknown decision %q", result.Decision)
+	}
+}
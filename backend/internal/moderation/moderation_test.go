@@ -0,0 +1,86 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubWebhook answers every request with the given status and body
+func stubWebhook(t *testing.T, status int, body string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestWebhookModerator_Decisions(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected Result
+	}{
+		{"allow", `{"decision":"allow"}`, Result{Decision: DecisionAllow}},
+		{"block", `{"decision":"block","reason":"spam"}`, Result{Decision: DecisionBlock, Reason: "spam"}},
+		{"flag", `{"decision":"flag"}`, Result{Decision: DecisionFlag}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := stubWebhook(t, http.StatusOK, tt.body)
+			m := NewWebhookModerator(WebhookConfig{URL: server.URL})
+
+			result, err := m.Moderate(context.Background(), Request{Content: "hello"})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestWebhookModerator_BadResponses(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{"server error", http.StatusInternalServerError, `{"decision":"allow"}`},
+		{"unknown decision", http.StatusOK, `{"decision":"maybe"}`},
+		{"not json", http.StatusOK, `ok`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := stubWebhook(t, tt.status, tt.body)
+			m := NewWebhookModerator(WebhookConfig{URL: server.URL})
+
+			_, err := m.Moderate(context.Background(), Request{Content: "hello"})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestWebhookModerator_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	m := NewWebhookModerator(WebhookConfig{URL: server.URL, Timeout: 50 * time.Millisecond})
+
+	start := time.Now()
+	_, err := m.Moderate(context.Background(), Request{Content: "hello"})
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second, "Call should give up at the timeout")
+}
@@ -0,0 +1,31 @@
+package repository
+
+import (
+	"github.com/Baaaki/digital-square/internal/models"
+	"gorm.io/gorm"
+)
+
+type AuditLogRepository struct {
+	db *gorm.DB
+}
+
+func NewAuditLogRepository(db *gorm.DB) *AuditLogRepository {
+	return &AuditLogRepository{db: db}
+}
+
+// WithTx returns a repository bound to the given transaction
+func (r *AuditLogRepository) WithTx(tx *gorm.DB) *AuditLogRepository {
+	return &AuditLogRepository{db: tx}
+}
+
+func (r *AuditLogRepository) Create(entry *models.AuditLog) error {
+	return mapError(r.db.Create(entry).Error)
+}
+
+// CreateBatch inserts several audit entries at once
+func (r *AuditLogRepository) CreateBatch(entries []models.AuditLog) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	return mapError(r.db.Create(&entries).Error)
+}
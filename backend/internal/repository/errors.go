@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Domain errors returned by repositories, so callers don't depend on GORM.
+// The original GORM error stays wrapped: errors.Is matches both.
+var (
+	ErrNotFound   = errors.New("record not found")
+	ErrDuplicate  = errors.New("duplicate record")
+	ErrConstraint = errors.New("constraint violation")
+)
+
+// mapError translates GORM errors into the domain errors above. Driver
+// errors are only recognized when the connection enables TranslateError.
+func mapError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return fmt.Errorf("%w: %w", ErrDuplicate, err)
+	case errors.Is(err, gorm.ErrForeignKeyViolated), errors.Is(err, gorm.ErrCheckConstraintViolated):
+		return fmt.Errorf("%w: %w", ErrConstraint, err)
+	default:
+		return err
+	}
+}
@@ -1,23 +1,92 @@
 package repository
 
 import (
+    "errors"
+    "strings"
     "time"
 
     "github.com/Baaaki/digital-square/internal/models"
     "github.com/google/uuid"
     "gorm.io/gorm"
+    "gorm.io/gorm/clause"
 )
 
+// MessageFilter narrows message counts. The zero value matches all live
+// (not soft-deleted) messages.
+type MessageFilter struct {
+    UserID         *uuid.UUID // Only this user's messages (nil = all users)
+    Since          time.Time  // Only messages created at or after this time (zero = no bound)
+    IncludeDeleted bool       // Also count soft-deleted messages
+    OnlyDeleted    bool       // Count only soft-deleted messages (implies IncludeDeleted)
+}
+
+// MessageSearch selects messages for search. Content matches are
+// case-insensitive substring matches unless FullText is set; empty fields
+// don't filter.
+type MessageSearch struct {
+    Query          string     // Substring of the content (or words, with FullText)
+    FullText       bool       // Match every word of Query using full-text search
+    UserID         *uuid.UUID // Only this user's messages
+    RoomID         string     // Only this room's messages
+    IncludeDeleted bool       // Also match soft-deleted messages
+    Limit          int
+    Offset         int
+}
+
+// likeEscaper escapes LIKE wildcards so the query matches literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// MessageRepositoryConfig holds optional read settings
+type MessageRepositoryConfig struct {
+    // SkipUserPreload drops the users join from history reads and relies on
+    // the denormalized Message.Username alone. Cheaper at scale, but the name
+    // is the one the author had when sending: renames don't show up on old
+    // messages, and rows persisted before usernames were carried through the
+    // WAL have an empty Username. Message.User is left zero-valued.
+    SkipUserPreload bool
+}
+
 type MessageRepository struct {
-    db *gorm.DB
+    db     *gorm.DB
+    config MessageRepositoryConfig
 }
 
 func NewMessageRepository(db *gorm.DB) *MessageRepository {
-    return &MessageRepository{db: db}
+    return NewMessageRepositoryWithConfig(db, MessageRepositoryConfig{})
+}
+
+// NewMessageRepositoryWithConfig creates a repository with the given read settings
+func NewMessageRepositoryWithConfig(db *gorm.DB, config MessageRepositoryConfig) *MessageRepository {
+    return &MessageRepository{db: db, config: config}
+}
+
+// WithTx returns a repository bound to the given transaction
+func (r *MessageRepository) WithTx(tx *gorm.DB) *MessageRepository {
+    return &MessageRepository{db: tx, config: r.config}
+}
+
+// withAuthors preloads message authors unless SkipUserPreload is set
+func (r *MessageRepository) withAuthors(query *gorm.DB) *gorm.DB {
+    if r.config.SkipUserPreload {
+        return query
+    }
+    return query.Preload("User")
+}
+
+// fillUsernames backfills empty denormalized usernames from the preloaded author
+func (r *MessageRepository) fillUsernames(messages []models.Message) {
+    if r.config.SkipUserPreload {
+        return
+    }
+    for i := range messages {
+        if messages[i].Username == "" {
+            messages[i].Username = messages[i].User.Username
+        }
+    }
 }
 
 func (r *MessageRepository) CreateMessage(message *models.Message) error {
-    return r.db.Create(message).Error
+    return mapError(r.db.Create(message).Error)
 }
 
 // GetMessageByID retrieves a message by ID
@@ -33,28 +102,43 @@ func (r *MessageRepository) GetMessageByID(id uint64) (*models.Message, error) {
     return &message, nil
 }
 
-// GetMessagesBefore retrieves messages before a given ID (for infinite scroll)
-func (r *MessageRepository) GetMessagesBefore(beforeID uint64, limit int) ([]models.Message, error) {
+// GetMessagesBefore retrieves a room's messages before a given ID (for infinite scroll)
+func (r *MessageRepository) GetMessagesBefore(roomID string, beforeID uint64, limit int) ([]models.Message, error) {
     var messages []models.Message
-    err := r.db.
-        Preload("User").
-        Where("id < ?", beforeID).
+    err := r.withAuthors(r.db).
+        Where("room_id = ? AND id < ?", roomID, beforeID).
         Order("created_at DESC").
         Limit(limit).
         Find(&messages).Error
-    
+
+    r.fillUsernames(messages)
+    return messages, err
+}
+
+// GetMessagesAfter retrieves a room's messages after a given ID, oldest first
+// (for catching up after a reconnect)
+func (r *MessageRepository) GetMessagesAfter(roomID string, afterID uint64, limit int) ([]models.Message, error) {
+    var messages []models.Message
+    err := r.withAuthors(r.db).
+        Where("room_id = ? AND id > ?", roomID, afterID).
+        Order("id ASC").
+        Limit(limit).
+        Find(&messages).Error
+
+    r.fillUsernames(messages)
     return messages, err
 }
 
-// GetRecentMessages retrieves the most recent messages
-func (r *MessageRepository) GetRecentMessages(limit int) ([]models.Message, error) {
+// GetRecentMessages retrieves a room's most recent messages
+func (r *MessageRepository) GetRecentMessages(roomID string, limit int) ([]models.Message, error) {
     var messages []models.Message
-    err := r.db.
-        Preload("User").
+    err := r.withAuthors(r.db).
+        Where("room_id = ?", roomID).
         Order("created_at DESC").
         Limit(limit).
         Find(&messages).Error
-    
+
+    r.fillUsernames(messages)
     return messages, err
 }
 
@@ -70,19 +154,325 @@ func (r *MessageRepository) SoftDeleteMessage(messageID uint64, deletedBy uuid.U
         }).Error
 }
 
+// SoftDeleteByUserID soft deletes all live messages of a user (e.g. on ban).
+// Returns the number of messages affected.
+func (r *MessageRepository) SoftDeleteByUserID(userID uuid.UUID, deletedByAdmin bool) (int64, error) {
+    return r.SoftDeleteByUserIDs([]uuid.UUID{userID}, deletedByAdmin)
+}
+
+// SoftDeleteByUserIDs soft deletes all live messages of several users in one
+// UPDATE. Returns the number of messages affected.
+func (r *MessageRepository) SoftDeleteByUserIDs(userIDs []uuid.UUID, deletedByAdmin bool) (int64, error) {
+    if len(userIDs) == 0 {
+        return 0, nil
+    }
+    result := r.db.Model(&models.Message{}).
+        Where("user_id IN ?", userIDs).
+        Updates(map[string]interface{}{
+            "deleted_at":          gorm.DeletedAt{Time: time.Now(), Valid: true},
+            "is_deleted_by_admin": deletedByAdmin,
+        })
+    return result.RowsAffected, result.Error
+}
+
 // BatchInsert bulk inserts messages (for WAL → PostgreSQL)
 func (r *MessageRepository) BatchInsert(messages []models.Message) error {
     if len(messages) == 0 {
         return nil
     }
-    return r.db.CreateInBatches(messages, 500).Error
+    return mapError(r.db.CreateInBatches(messages, 500).Error)
+}
+
+// BatchInsertIgnoreDuplicates bulk inserts messages, skipping any whose
+// MessageID already exists. Returns the number of rows actually inserted.
+func (r *MessageRepository) BatchInsertIgnoreDuplicates(messages []models.Message) (int64, error) {
+    if len(messages) == 0 {
+        return 0, nil
+    }
+    result := r.db.
+        Omit("User").
+        Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "message_id"}}, DoNothing: true}).
+        CreateInBatches(messages, 500)
+    return result.RowsAffected, mapError(result.Error)
 }
 
 func (r*MessageRepository) GetByMessageID (messageID string) (*models.Message, error) {
     var message models.Message
     err:= r.db.Where("message_id = ?", messageID).First(&message).Error
     if err != nil {
+        return nil, mapError(err)
+    }
+    return &message, nil
+}
+
+// GetLatestByUser retrieves a user's most recent live message in a room
+func (r *MessageRepository) GetLatestByUser(userID uuid.UUID, roomID string) (*models.Message, error) {
+    var message models.Message
+    err := r.db.Where("user_id = ? AND room_id = ?", userID, roomID).
+        Order("created_at DESC, id DESC").
+        First(&message).Error
+    if err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil, nil
+        }
+        return nil, err
+    }
+    return &message, nil
+}
+
+// GetByMessageIDUnscoped retrieves a message by UUID including soft-deleted ones
+func (r *MessageRepository) GetByMessageIDUnscoped(messageID string) (*models.Message, error) {
+    var message models.Message
+    err := r.db.Unscoped().Where("message_id = ?", messageID).First(&message).Error
+    if err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil, nil
+        }
         return nil, err
     }
     return &message, nil
-}
\ No newline at end of file
+}
+
+// GetDeletedByUser retrieves a user's own soft-deleted messages (newest first)
+func (r *MessageRepository) GetDeletedByUser(userID uuid.UUID, limit int) ([]models.Message, error) {
+    var messages []models.Message
+    err := r.db.Unscoped().
+        Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+        Order("created_at DESC").
+        Limit(limit).
+        Find(&messages).Error
+
+    return messages, err
+}
+
+// RestoreMessage clears soft-delete fields on a message
+func (r *MessageRepository) RestoreMessage(messageID uint64) error {
+    return r.db.Unscoped().Model(&models.Message{}).
+        Where("id = ?", messageID).
+        Updates(map[string]interface{}{
+            "deleted_at":          nil,
+            "deleted_by":          nil,
+            "is_deleted_by_admin": false,
+        }).Error
+}
+
+// EditMessage replaces a message's content and records the previous content in message_edits
+func (r *MessageRepository) EditMessage(msg *models.Message, newContent, lang string, editedBy uuid.UUID, editedAt time.Time) error {
+    return r.db.Transaction(func(tx *gorm.DB) error {
+        edit := models.MessageEdit{
+            MessageID:       msg.MessageID,
+            PreviousContent: msg.Content,
+            EditedBy:        editedBy,
+            EditedAt:        editedAt,
+        }
+        if err := tx.Create(&edit).Error; err != nil {
+            return mapError(err)
+        }
+
+        return tx.Model(&models.Message{}).
+            Where("id = ?", msg.ID).
+            Updates(map[string]interface{}{
+                "content":   newContent,
+                "lang":      lang,
+                "edited_at": editedAt,
+            }).Error
+    })
+}
+
+// GetEdits returns a message's previous versions (oldest first)
+func (r *MessageRepository) GetEdits(messageID string) ([]models.MessageEdit, error) {
+    var edits []models.MessageEdit
+    err := r.db.Where("message_id = ?", messageID).
+        Order("edited_at ASC, id ASC").
+        Find(&edits).Error
+
+    return edits, err
+}
+
+// AddReaction stores a reaction. Returns ErrDuplicate if the user already
+// reacted to the message with that emoji.
+func (r *MessageRepository) AddReaction(reaction *models.MessageReaction) error {
+    return mapError(r.db.Create(reaction).Error)
+}
+
+// RemoveReaction deletes a user's reaction. Returns false if there was none.
+func (r *MessageRepository) RemoveReaction(messageID string, userID uuid.UUID, emoji string) (bool, error) {
+    result := r.db.
+        Where("message_id = ? AND user_id = ? AND emoji = ?", messageID, userID, emoji).
+        Delete(&models.MessageReaction{})
+    return result.RowsAffected > 0, result.Error
+}
+
+// CountReactions returns how many users reacted to a message with the emoji
+func (r *MessageRepository) CountReactions(messageID, emoji string) (int64, error) {
+    var count int64
+    err := r.db.Model(&models.MessageReaction{}).
+        Where("message_id = ? AND emoji = ?", messageID, emoji).
+        Count(&count).Error
+    return count, err
+}
+
+// GetReactionCounts returns reaction counts keyed by message ID, then emoji.
+// Messages without reactions are absent from the map.
+func (r *MessageRepository) GetReactionCounts(messageIDs []string) (map[string]map[string]int64, error) {
+    counts := make(map[string]map[string]int64)
+    if len(messageIDs) == 0 {
+        return counts, nil
+    }
+
+    var rows []struct {
+        MessageID string
+        Emoji     string
+        Count     int64
+    }
+    err := r.db.Model(&models.MessageReaction{}).
+        Select("message_id, emoji, COUNT(*) AS count").
+        Where("message_id IN ?", messageIDs).
+        Group("message_id, emoji").
+        Scan(&rows).Error
+    if err != nil {
+        return nil, err
+    }
+
+    for _, row := range rows {
+        if counts[row.MessageID] == nil {
+            counts[row.MessageID] = make(map[string]int64)
+        }
+        counts[row.MessageID][row.Emoji] = row.Count
+    }
+    return counts, nil
+}
+
+// CreateMentions stores mention rows, skipping any (message, user) pair
+// that already exists so a replayed send doesn't notify twice
+func (r *MessageRepository) CreateMentions(mentions []models.Mention) error {
+    if len(mentions) == 0 {
+        return nil
+    }
+    return mapError(r.db.
+        Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "message_id"}, {Name: "user_id"}}, DoNothing: true}).
+        Create(&mentions).Error)
+}
+
+// GetUnreadMentions returns a user's unread mentions (newest first)
+func (r *MessageRepository) GetUnreadMentions(userID uuid.UUID, limit int) ([]models.Mention, error) {
+    var mentions []models.Mention
+    err := r.db.Where("user_id = ? AND read_at IS NULL", userID).
+        Order("created_at DESC, id DESC").
+        Limit(limit).
+        Find(&mentions).Error
+
+    return mentions, err
+}
+
+// MarkMentionsRead marks a user's unread mentions as read: the given IDs,
+// or all of them when ids is empty. Returns how many were marked.
+func (r *MessageRepository) MarkMentionsRead(userID uuid.UUID, ids []uint64, readAt time.Time) (int64, error) {
+    query := r.db.Model(&models.Mention{}).Where("user_id = ? AND read_at IS NULL", userID)
+    if len(ids) > 0 {
+        query = query.Where("id IN ?", ids)
+    }
+    result := query.Update("read_at", readAt)
+    return result.RowsAffected, result.Error
+}
+
+// Count returns the number of messages matching the filter using COUNT(*)
+func (r *MessageRepository) Count(filter MessageFilter) (int64, error) {
+    query := r.db.Model(&models.Message{})
+    if filter.IncludeDeleted || filter.OnlyDeleted {
+        query = query.Unscoped()
+    }
+    if filter.OnlyDeleted {
+        query = query.Where("deleted_at IS NOT NULL")
+    }
+    if filter.UserID != nil {
+        query = query.Where("user_id = ?", *filter.UserID)
+    }
+    if !filter.Since.IsZero() {
+        query = query.Where("created_at >= ?", filter.Since)
+    }
+
+    var count int64
+    err := query.Count(&count).Error
+    return count, err
+}
+
+// CountMessages counts a room's live (not soft-deleted) messages
+func (r *MessageRepository) CountMessages(roomID string) (int64, error) {
+    var count int64
+    err := r.db.Model(&models.Message{}).Where("room_id = ?", roomID).Count(&count).Error
+    return count, err
+}
+
+// CountByUser returns the number of a user's live (not soft-deleted) messages
+func (r *MessageRepository) CountByUser(userID uuid.UUID) (int64, error) {
+    return r.Count(MessageFilter{UserID: &userID})
+}
+
+// searchQuery builds the filtered (unpaginated) query for a search
+func (r *MessageRepository) searchQuery(search MessageSearch) *gorm.DB {
+    query := r.db.Model(&models.Message{})
+    if search.IncludeDeleted {
+        query = query.Unscoped()
+    }
+    switch {
+    case search.Query == "":
+    case search.FullText:
+        query = r.matchWords(query, search.Query)
+    default:
+        pattern := "%" + likeEscaper.Replace(strings.ToLower(search.Query)) + "%"
+        query = query.Where(`LOWER(content) LIKE ? ESCAPE '\'`, pattern)
+    }
+    if search.UserID != nil {
+        query = query.Where("user_id = ?", *search.UserID)
+    }
+    if search.RoomID != "" {
+        query = query.Where("room_id = ?", search.RoomID)
+    }
+    return query
+}
+
+// matchWords keeps messages containing every word of the query. PostgreSQL
+// uses full-text search (served by idx_messages_content_fts); other
+// dialects (SQLite in tests) fall back to one LIKE per word.
+func (r *MessageRepository) matchWords(query *gorm.DB, words string) *gorm.DB {
+    if r.db.Dialector.Name() == "postgres" {
+        return query.Where("to_tsvector('simple', content) @@ plainto_tsquery('simple', ?)", words)
+    }
+    for _, word := range strings.Fields(strings.ToLower(words)) {
+        query = query.Where(`LOWER(content) LIKE ? ESCAPE '\'`, "%"+likeEscaper.Replace(word)+"%")
+    }
+    return query
+}
+
+// SearchMessages returns one page of matching messages (newest first)
+func (r *MessageRepository) SearchMessages(search MessageSearch) ([]models.Message, error) {
+    var messages []models.Message
+    err := r.searchQuery(search).
+        Order("id DESC").
+        Limit(search.Limit).
+        Offset(search.Offset).
+        Find(&messages).Error
+
+    return messages, err
+}
+
+// SearchMessageIDs returns one page of matching message IDs (newest first),
+// without loading content
+func (r *MessageRepository) SearchMessageIDs(search MessageSearch) ([]uint64, error) {
+    var ids []uint64
+    err := r.searchQuery(search).
+        Order("id DESC").
+        Limit(search.Limit).
+        Offset(search.Offset).
+        Pluck("id", &ids).Error
+
+    return ids, err
+}
+
+// CountSearch returns the total number of messages matching a search (ignores Limit/Offset)
+func (r *MessageRepository) CountSearch(search MessageSearch) (int64, error) {
+    var count int64
+    err := r.searchQuery(search).Count(&count).Error
+    return count, err
+}
@@ -0,0 +1,189 @@
+package repository_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Baaaki/digital-square/internal/models"
+	"github.com/Baaaki/digital-square/internal/repository"
+	"github.com/Baaaki/digital-square/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+)
+
+// MessageRepositoryIntegrationTestSuite defines test suite
+type MessageRepositoryIntegrationTestSuite struct {
+	suite.Suite
+	testDB      *testutil.TestDatabase
+	messageRepo *repository.MessageRepository
+	alice       *testutil.TestUser
+	bob         *testutil.TestUser
+}
+
+// SetupSuite runs before all tests
+func (s *MessageRepositoryIntegrationTestSuite) SetupSuite() {
+	s.testDB = testutil.SetupTestDatabase(s.T())
+	s.messageRepo = repository.NewMessageRepository(s.testDB.DB)
+}
+
+// TearDownSuite runs after all tests
+func (s *MessageRepositoryIntegrationTestSuite) TearDownSuite() {
+	s.testDB.Teardown(s.T())
+}
+
+// SetupTest runs before each test (clean database)
+func (s *MessageRepositoryIntegrationTestSuite) SetupTest() {
+	testutil.CleanDatabase(s.T(), s.testDB.DB)
+
+	s.alice, _ = testutil.CreateTestUser("alice", "alice@example.com", "Test123456", models.RoleUser)
+	s.bob, _ = testutil.CreateTestUser("bob", "bob@example.com", "Test123456", models.RoleUser)
+	s.testDB.DB.Create(s.alice)
+	s.testDB.DB.Create(s.bob)
+}
+
+// TestCount tests counts with and without soft-deleted rows
+func (s *MessageRepositoryIntegrationTestSuite) TestCount() {
+	// alice: 3 live + 1 deleted, bob: 1 live + 1 deleted (one old)
+	for i := 0; i < 3; i++ {
+		s.testDB.DB.Create(testutil.CreateTestMessage(s.alice.ID, "hi"))
+	}
+	s.testDB.DB.Create(testutil.CreateTestMessageWithDelete(s.alice.ID, "oops", s.alice.ID, false))
+	old := testutil.CreateTestMessage(s.bob.ID, "old")
+	old.CreatedAt = time.Now().Add(-48 * time.Hour)
+	s.testDB.DB.Create(old)
+	s.testDB.DB.Create(testutil.CreateTestMessageWithDelete(s.bob.ID, "spam", s.alice.ID, true))
+
+	aliceID := testutil.ParseUUID(s.T(), s.alice.ID)
+
+	testCases := []struct {
+		name     string
+		filter   repository.MessageFilter
+		expected int64
+	}{
+		{"All live", repository.MessageFilter{}, 4},
+		{"Including deleted", repository.MessageFilter{IncludeDeleted: true}, 6},
+		{"Only deleted", repository.MessageFilter{OnlyDeleted: true}, 2},
+		{"User live", repository.MessageFilter{UserID: &aliceID}, 3},
+		{"User including deleted", repository.MessageFilter{UserID: &aliceID, IncludeDeleted: true}, 4},
+		{"Since excludes old", repository.MessageFilter{Since: time.Now().Add(-time.Hour)}, 3},
+	}
+
+	for _, tc := range testCases {
+		s.Run(tc.name, func() {
+			count, err := s.messageRepo.Count(tc.filter)
+			require.NoError(s.T(), err)
+			assert.Equal(s.T(), tc.expected, count)
+		})
+	}
+
+	// CountByUser excludes soft-deleted rows
+	count, err := s.messageRepo.CountByUser(aliceID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(3), count)
+
+	count, err = s.messageRepo.CountByUser(testutil.ParseUUID(s.T(), s.bob.ID))
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(1), count)
+}
+
+// TestSkipUserPreload tests that history reads use only the denormalized
+// username when the preload is skipped, and backfill it when it isn't
+func (s *MessageRepositoryIntegrationTestSuite) TestSkipUserPreload() {
+	withName := testutil.CreateTestMessage(s.alice.ID, "sent with a name")
+	withName.Username = "alice_at_send_time"
+	withName.CreatedAt = time.Now().Add(-time.Minute)
+	s.testDB.DB.Create(withName)
+	legacy := testutil.CreateTestMessage(s.bob.ID, "persisted without a name")
+	s.testDB.DB.Create(legacy)
+
+	// Default: authors are preloaded and fill in missing names
+	messages, err := s.messageRepo.GetRecentMessages(models.DefaultRoomID, 10)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), messages, 2)
+	assert.Equal(s.T(), "bob", messages[0].Username)
+	assert.Equal(s.T(), "bob", messages[0].User.Username)
+	assert.Equal(s.T(), "alice_at_send_time", messages[1].Username, "Denormalized name wins when present")
+
+	// Skipped: only what was stored at send time
+	denormalized := repository.NewMessageRepositoryWithConfig(s.testDB.DB, repository.MessageRepositoryConfig{SkipUserPreload: true})
+	messages, err = denormalized.GetMessagesBefore(models.DefaultRoomID, legacy.ID+1, 10)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), messages, 2)
+	assert.Empty(s.T(), messages[0].Username)
+	assert.Empty(s.T(), messages[0].User.ID, "No author is loaded")
+	assert.Equal(s.T(), "alice_at_send_time", messages[1].Username)
+}
+
+// TestErrorMapping tests that GORM errors come back as repository errors
+func (s *MessageRepositoryIntegrationTestSuite) TestErrorMapping() {
+	msg := &models.Message{
+		MessageID: "dup-message-id",
+		UserID:    testutil.ParseUUID(s.T(), s.alice.ID),
+		RoomID:    models.DefaultRoomID,
+		Content:   "first",
+	}
+	require.NoError(s.T(), s.messageRepo.CreateMessage(msg))
+
+	// Duplicate insert
+	dup := &models.Message{
+		MessageID: msg.MessageID,
+		UserID:    msg.UserID,
+		RoomID:    models.DefaultRoomID,
+		Content:   "second",
+	}
+	err := s.messageRepo.CreateMessage(dup)
+	assert.ErrorIs(s.T(), err, repository.ErrDuplicate)
+	assert.ErrorIs(s.T(), err, gorm.ErrDuplicatedKey, "GORM error stays wrapped")
+
+	// Not found
+	found, err := s.messageRepo.GetByMessageID("no-such-message")
+	assert.Nil(s.T(), found)
+	assert.ErrorIs(s.T(), err, repository.ErrNotFound)
+	assert.NotErrorIs(s.T(), err, repository.ErrDuplicate)
+
+	found, err = s.messageRepo.GetByMessageID(msg.MessageID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), "first", found.Content)
+}
+
+// TestSuite runs all tests in the suite
+func TestMessageRepositoryIntegrationTestSuite(t *testing.T) {
+	suite.Run(t, new(MessageRepositoryIntegrationTestSuite))
+}
+
+// benchmarkRecentMessages reads one page of history from a room of 100 messages by 20 authors
+func benchmarkRecentMessages(b *testing.B, config repository.MessageRepositoryConfig) {
+	testDB := testutil.SetupTestDatabase(b)
+	defer testDB.Teardown(b)
+
+	for u := 0; u < 20; u++ {
+		user, _ := testutil.CreateTestUser(fmt.Sprintf("bench%d", u), fmt.Sprintf("bench%d@example.com", u), "Test123456", models.RoleUser)
+		testDB.DB.Create(user)
+		for m := 0; m < 5; m++ {
+			msg := testutil.CreateTestMessage(user.ID, "benchmark message")
+			msg.Username = user.Username
+			testDB.DB.Create(msg)
+		}
+	}
+
+	repo := repository.NewMessageRepositoryWithConfig(testDB.DB, config)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := repo.GetRecentMessages(models.DefaultRoomID, 100); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkGetRecentMessages_Preload benchmarks history reads with the users preload
+func BenchmarkGetRecentMessages_Preload(b *testing.B) {
+	benchmarkRecentMessages(b, repository.MessageRepositoryConfig{})
+}
+
+// BenchmarkGetRecentMessages_Denormalized benchmarks history reads using only the denormalized username
+func BenchmarkGetRecentMessages_Denormalized(b *testing.B) {
+	benchmarkRecentMessages(b, repository.MessageRepositoryConfig{SkipUserPreload: true})
+}
@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Baaaki/digital-square/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type RefreshTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewRefreshTokenRepository(db *gorm.DB) *RefreshTokenRepository {
+	return &RefreshTokenRepository{db: db}
+}
+
+// WithTx returns a repository bound to the given transaction
+func (r *RefreshTokenRepository) WithTx(tx *gorm.DB) *RefreshTokenRepository {
+	return &RefreshTokenRepository{db: tx}
+}
+
+func (r *RefreshTokenRepository) Create(token *models.RefreshToken) error {
+	return mapError(r.db.Create(token).Error)
+}
+
+// GetByHash returns the token with the given hash, or nil if there is none
+func (r *RefreshTokenRepository) GetByHash(tokenHash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	err := r.db.Where("token_hash = ?", tokenHash).First(&token).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+// Consume marks the token as used. Returns false if it was already consumed,
+// so of two concurrent rotations only one wins.
+func (r *RefreshTokenRepository) Consume(id uint64) (bool, error) {
+	result := r.db.Model(&models.RefreshToken{}).
+		Where("id = ? AND consumed_at IS NULL", id).
+		Update("consumed_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+// RevokeAllForUser consumes every outstanding refresh token of the user
+func (r *RefreshTokenRepository) RevokeAllForUser(userID uuid.UUID) (int64, error) {
+	result := r.db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND consumed_at IS NULL", userID).
+		Update("consumed_at", time.Now())
+	return result.RowsAffected, result.Error
+}
+
+// RevokeAllForUsers consumes every outstanding refresh token of the users
+// in a single UPDATE
+func (r *RefreshTokenRepository) RevokeAllForUsers(userIDs []uuid.UUID) (int64, error) {
+	result := r.db.Model(&models.RefreshToken{}).
+		Where("user_id IN ? AND consumed_at IS NULL", userIDs).
+		Update("consumed_at", time.Now())
+	return result.RowsAffected, result.Error
+}
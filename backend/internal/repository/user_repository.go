@@ -16,8 +16,18 @@ func NewUserRepository(db *gorm.DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
+// WithTx returns a repository bound to the given transaction
+func (r *UserRepository) WithTx(tx *gorm.DB) *UserRepository {
+	return &UserRepository{db: tx}
+}
+
+// Transaction runs fn inside a database transaction (rolled back if fn returns an error)
+func (r *UserRepository) Transaction(fn func(tx *gorm.DB) error) error {
+	return r.db.Transaction(fn)
+}
+
 func (r *UserRepository) CreateUser(user *models.User) error {
-	return r.db.Create(user).Error
+	return mapError(r.db.Create(user).Error)
 }
 
 func (r *UserRepository) GetUserByEmail(email string) (*models.User, error) {
@@ -35,6 +45,22 @@ func (r *UserRepository) GetUserByEmail(email string) (*models.User, error) {
 	return &user, nil
 }
 
+// EmailExists reports whether any user, including soft-deleted ones, holds the email.
+// Soft-deleted rows still occupy the unique index.
+func (r *UserRepository) EmailExists(email string) (bool, error) {
+	var count int64
+	err := r.db.Unscoped().Model(&models.User{}).Where("email = ?", email).Count(&count).Error
+	return count > 0, err
+}
+
+// CountAccounts counts registered accounts, including soft-deleted ones.
+// The reserved system user is not an account.
+func (r *UserRepository) CountAccounts() (int64, error) {
+	var count int64
+	err := r.db.Unscoped().Model(&models.User{}).Where("role <> ?", models.RoleSystem).Count(&count).Error
+	return count, err
+}
+
 func (r *UserRepository) GetUserByUsername(username string) (*models.User, error) {
 	var user models.User
 	err := r.db.Where("username = ?", username).First(&user).Error
@@ -82,4 +108,110 @@ func (r *UserRepository) SoftDeleteUser(id uuid.UUID) error {
 // BulkSoftDelete marks multiple users as deleted
 func (r *UserRepository) BulkSoftDelete(ids []uuid.UUID) error {
 	return r.db.Delete(&models.User{}, ids).Error
-}
\ No newline at end of file
+}
+
+// GetUserByIDUnscoped retrieves a user by ID including soft-deleted (banned) ones
+func (r *UserRepository) GetUserByIDUnscoped(id uuid.UUID) (*models.User, error) {
+	var user models.User
+	err := r.db.Unscoped().Where("id = ?", id).First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// SetEmailVerified marks the user's email as verified. Returns false if
+// there is no such (live) user.
+func (r *UserRepository) SetEmailVerified(id uuid.UUID) (bool, error) {
+	result := r.db.Model(&models.User{}).
+		Where("id = ?", id).
+		Update("email_verified", true)
+	return result.RowsAffected == 1, result.Error
+}
+
+// UpdatePasswordHash replaces the user's password hash. Returns false if
+// there is no such (live) user.
+func (r *UserRepository) UpdatePasswordHash(id uuid.UUID, passwordHash string) (bool, error) {
+	result := r.db.Model(&models.User{}).
+		Where("id = ?", id).
+		Update("password_hash", passwordHash)
+	return result.RowsAffected == 1, result.Error
+}
+
+// RestoreUser clears a user's soft delete (unban)
+func (r *UserRepository) RestoreUser(id uuid.UUID) error {
+	err := r.db.Unscoped().Model(&models.User{}).
+		Where("id = ?", id).
+		Update("deleted_at", nil).Error
+	return mapError(err)
+}
+
+// BulkRestore clears deleted_at on several banned users in one UPDATE.
+// Returns the number of users restored.
+func (r *UserRepository) BulkRestore(ids []uuid.UUID) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	result := r.db.Unscoped().Model(&models.User{}).
+		Where("id IN ? AND deleted_at IS NOT NULL", ids).
+		Update("deleted_at", nil)
+	return result.RowsAffected, mapError(result.Error)
+}
+
+// IdentityTaken reports whether another live user holds the username or email
+func (r *UserRepository) IdentityTaken(username, email string, exceptID uuid.UUID) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.User{}).
+		Where("(username = ? OR email = ?) AND id <> ?", username, email, exceptID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// ExistingIDs returns which of the given user IDs exist (including banned users)
+func (r *UserRepository) ExistingIDs(ids []uuid.UUID) (map[uuid.UUID]bool, error) {
+	existing := make(map[uuid.UUID]bool, len(ids))
+	if len(ids) == 0 {
+		return existing, nil
+	}
+
+	var found []uuid.UUID
+	if err := r.db.Unscoped().Model(&models.User{}).Where("id IN ?", ids).Pluck("id", &found).Error; err != nil {
+		return nil, err
+	}
+	for _, id := range found {
+		existing[id] = true
+	}
+	return existing, nil
+}
+
+// GetUsersByUsernames returns the users with any of the given usernames,
+// compared case-insensitively (usernames must be lowercase)
+func (r *UserRepository) GetUsersByUsernames(usernames []string) ([]models.User, error) {
+	var users []models.User
+	if len(usernames) == 0 {
+		return users, nil
+	}
+	err := r.db.Where("LOWER(username) IN ?", usernames).Find(&users).Error
+	return users, err
+}
+
+// EnsureSystemUser creates the reserved system user if it doesn't exist yet.
+// Its password hash is not a valid Argon2 hash, so it can never log in.
+func (r *UserRepository) EnsureSystemUser() (*models.User, error) {
+	user := models.User{
+		ID:            models.SystemUserID,
+		Username:      models.SystemUsername,
+		Email:         models.SystemEmail,
+		PasswordHash:  "!",
+		Role:          models.RoleSystem,
+		EmailVerified: true,
+	}
+	err := r.db.Unscoped().Where("id = ?", models.SystemUserID).FirstOrCreate(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
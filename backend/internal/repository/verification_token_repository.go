@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Baaaki/digital-square/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type VerificationTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewVerificationTokenRepository(db *gorm.DB) *VerificationTokenRepository {
+	return &VerificationTokenRepository{db: db}
+}
+
+// WithTx returns a repository bound to the given transaction
+func (r *VerificationTokenRepository) WithTx(tx *gorm.DB) *VerificationTokenRepository {
+	return &VerificationTokenRepository{db: tx}
+}
+
+func (r *VerificationTokenRepository) Create(token *models.VerificationToken) error {
+	return mapError(r.db.Create(token).Error)
+}
+
+// GetByHash returns the token with the given hash, or nil if there is none
+func (r *VerificationTokenRepository) GetByHash(tokenHash string) (*models.VerificationToken, error) {
+	var token models.VerificationToken
+	err := r.db.Where("token_hash = ?", tokenHash).First(&token).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+// Consume marks the token as used. Returns false if it was already consumed,
+// so a link only verifies once.
+func (r *VerificationTokenRepository) Consume(id uint64) (bool, error) {
+	result := r.db.Model(&models.VerificationToken{}).
+		Where("id = ? AND consumed_at IS NULL", id).
+		Update("consumed_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+// ConsumeAllForUser retires the user's outstanding tokens (before a new one is sent)
+func (r *VerificationTokenRepository) ConsumeAllForUser(userID uuid.UUID) (int64, error) {
+	result := r.db.Model(&models.VerificationToken{}).
+		Where("user_id = ? AND consumed_at IS NULL", userID).
+		Update("consumed_at", time.Now())
+	return result.RowsAffected, result.Error
+}
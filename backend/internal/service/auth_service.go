@@ -2,41 +2,237 @@ package service
 
 import (
 	"errors"
+	"fmt"
+	"net/url"
 	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
+	"github.com/Baaaki/digital-square/internal/broker"
+	"github.com/Baaaki/digital-square/internal/mailer"
 	"github.com/Baaaki/digital-square/internal/models"
 	"github.com/Baaaki/digital-square/internal/repository"
 	"github.com/Baaaki/digital-square/internal/utils"
 	"github.com/Baaaki/digital-square/pkg/logger"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 var (
 	ErrEmailAlreadyExists    = errors.New("email already exists")
 	ErrUsernameAlreadyExists = errors.New("username already exists")
 	ErrInvalidCredentials    = errors.New("invalid credentials")
+	ErrInvalidRefreshToken   = errors.New("invalid refresh token")
+	ErrRefreshTokenReused    = errors.New("refresh token already used")
+	ErrUserBanned            = errors.New("user is banned")
+	ErrNotBanned             = errors.New("user is not banned")
+	ErrUnbanConflict         = errors.New("username or email now belongs to another account")
+	ErrInvalidMuteDuration   = fmt.Errorf("mute duration must be between 1s and %s", maxMuteDuration)
+	ErrNotMuted              = errors.New("user is not muted")
+	ErrMuteUnavailable       = errors.New("muting requires Redis")
+	ErrAccountLocked         = errors.New("too many failed login attempts")
+	ErrInvalidVerification   = errors.New("invalid or expired verification link")
+	ErrAlreadyVerified       = errors.New("email is already verified")
+	ErrResendTooSoon         = errors.New("a verification email was sent recently")
 	
 	emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
 )
 
+// dummyPasswordHash is verified against when no account has the email, so an
+// unknown email fails as slowly as a wrong password (one Argon2 run) and
+// response times don't reveal which emails are registered. Made on first use
+// with the configured Argon2 parameters, like real hashes.
+var dummyPasswordHash = sync.OnceValues(func() (string, error) {
+	return utils.HashPassword("no account has this email")
+})
+
+// AuthServiceConfig holds tunable account rules
+type AuthServiceConfig struct {
+	UsernameMinLength int // In runes (Unicode-aware)
+	UsernameMaxLength int // In runes; must fit the users.username column (varchar(50))
+
+	DefaultRole    models.Role // Role for new registrations (empty = user)
+	FirstUserAdmin bool        // The very first registered account becomes admin
+
+	AccessTokenTTL  time.Duration // Lifetime of refreshed access tokens (0 = same as login tokens)
+	RefreshTokenTTL time.Duration // Lifetime of a refresh token (0 = defaultRefreshTokenTTL)
+
+	// Revokes the access tokens of every device on a password change
+	// (nil = they stay valid until they expire)
+	TokenRevoker TokenRevoker
+
+	// FailedLoginDelay slows down every failed login by the same fixed amount,
+	// unknown email or wrong password alike, to raise the cost of online
+	// guessing without locking accounts (0 = disabled)
+	FailedLoginDelay time.Duration
+	Sleep            func(time.Duration) // Waits out FailedLoginDelay (nil = time.Sleep); swappable for tests
+
+	// Account lockout: LockoutThreshold consecutive failed logins for an email
+	// lock it for LockoutDuration. Tracked in Redis per email whether or not
+	// an account exists, so a lockout reveals nothing (0 = disabled, or no broker).
+	LockoutThreshold int
+	LockoutDuration  time.Duration // 0 = defaultLockoutDuration
+
+	// Email verification: new accounts start unverified (read-only) and get
+	// a link to VerificationURL?token=... by Mailer. Off = created verified.
+	RequireEmailVerification bool
+	VerificationTokenTTL     time.Duration // Link lifetime (0 = defaultVerificationTokenTTL)
+	VerificationURL          string        // Absolute URL of GET /api/auth/verify (empty = relative path)
+	Mailer                   mailer.Mailer // nil = mailer.LogMailer
+
+	// ResendVerification cooldown per email, tracked in Redis whether or not
+	// an account exists, like the lockout (0 = defaultVerificationResendCooldown;
+	// no broker = no cooldown)
+	VerificationResendCooldown time.Duration
+}
+
+// TokenRevoker invalidates a user's access tokens before they expire
+// (middleware.TokenDenylist)
+type TokenRevoker interface {
+	RevokeIssuedBefore(userID string, before time.Time, ttl time.Duration) error
+}
+
+// AccountLockedError is returned by Login while an email is locked out.
+// errors.Is(err, ErrAccountLocked) matches it.
+type AccountLockedError struct {
+	RetryAfter time.Duration // Remaining lockout
+}
+
+func (e *AccountLockedError) Error() string {
+	return fmt.Sprintf("%s, try again in %s", ErrAccountLocked, e.RetryAfter.Round(time.Second))
+}
+
+// Is lets errors.Is(err, ErrAccountLocked) match
+func (e *AccountLockedError) Is(target error) bool {
+	return target == ErrAccountLocked
+}
+
+// ResendCooldownError is returned by ResendVerification when the email asked
+// for a link too recently. errors.Is(err, ErrResendTooSoon) matches it.
+type ResendCooldownError struct {
+	RetryAfter time.Duration // Remaining cooldown
+}
+
+func (e *ResendCooldownError) Error() string {
+	return fmt.Sprintf("%s, try again in %s", ErrResendTooSoon, e.RetryAfter.Round(time.Second))
+}
+
+// Is lets errors.Is(err, ErrResendTooSoon) match
+func (e *ResendCooldownError) Is(target error) bool {
+	return target == ErrResendTooSoon
+}
+
+// maxMuteDuration caps mutes; anything longer is a ban
+const maxMuteDuration = 30 * 24 * time.Hour
+
+// defaultRefreshTokenTTL matches the access cookie lifetime
+const defaultRefreshTokenTTL = 7 * 24 * time.Hour
+
+// defaultLockoutDuration is how long an email stays locked out
+const defaultLockoutDuration = 15 * time.Minute
+
+// Email verification link defaults
+const (
+	defaultVerificationTokenTTL = 24 * time.Hour
+	defaultVerificationURL      = "/api/auth/verify"
+
+	defaultVerificationResendCooldown = 2 * time.Minute
+)
+
+// DefaultAuthServiceConfig returns the default account rules
+func DefaultAuthServiceConfig() AuthServiceConfig {
+	return AuthServiceConfig{
+		UsernameMinLength: 3,
+		UsernameMaxLength: 50,
+		DefaultRole:       models.RoleUser,
+		LockoutThreshold:  5,
+		LockoutDuration:   defaultLockoutDuration,
+
+		RequireEmailVerification: true,
+		VerificationTokenTTL:     defaultVerificationTokenTTL,
+	}
+}
+
 type AuthService struct {
 	userRepo      *repository.UserRepository
+	messageRepo   *repository.MessageRepository  // for ban cascade
+	auditRepo     *repository.AuditLogRepository // for admin action audit trail
+	refreshRepo   *repository.RefreshTokenRepository
+	verifyRepo    *repository.VerificationTokenRepository
+	broker        broker.MessageBroker // Optional: nil disables ban events
 	jwtSecret     string
 	jwtExpiration time.Duration
 	environment   string
+	config        AuthServiceConfig
+
+	// First-user bootstrap: bootstrapMu serializes the count+insert until an account exists
+	bootstrapMu  sync.Mutex
+	bootstrapped atomic.Bool
 }
 
-func NewAuthService(userRepo *repository.UserRepository, jwtSecret string, jwtExpiration time.Duration, environment string) *AuthService {
+func NewAuthService(
+	userRepo *repository.UserRepository,
+	messageRepo *repository.MessageRepository,
+	auditRepo *repository.AuditLogRepository,
+	refreshRepo *repository.RefreshTokenRepository,
+	verifyRepo *repository.VerificationTokenRepository,
+	broker broker.MessageBroker,
+	jwtSecret string,
+	jwtExpiration time.Duration,
+	environment string,
+	config AuthServiceConfig,
+) *AuthService {
+	if config.DefaultRole == "" {
+		config.DefaultRole = models.RoleUser
+	}
+	if config.AccessTokenTTL <= 0 {
+		config.AccessTokenTTL = jwtExpiration
+	}
+	if config.RefreshTokenTTL <= 0 {
+		config.RefreshTokenTTL = defaultRefreshTokenTTL
+	}
+	if config.Sleep == nil {
+		config.Sleep = time.Sleep
+	}
+	if config.LockoutDuration <= 0 {
+		config.LockoutDuration = defaultLockoutDuration
+	}
+	if config.VerificationTokenTTL <= 0 {
+		config.VerificationTokenTTL = defaultVerificationTokenTTL
+	}
+	if config.VerificationURL == "" {
+		config.VerificationURL = defaultVerificationURL
+	}
+	if config.Mailer == nil {
+		config.Mailer = mailer.LogMailer{}
+	}
+	if config.VerificationResendCooldown <= 0 {
+		config.VerificationResendCooldown = defaultVerificationResendCooldown
+	}
 	return &AuthService{
 		userRepo:      userRepo,
+		messageRepo:   messageRepo,
+		auditRepo:     auditRepo,
+		refreshRepo:   refreshRepo,
+		verifyRepo:    verifyRepo,
+		broker:        broker,
 		jwtSecret:     jwtSecret,
 		jwtExpiration: jwtExpiration,
 		environment:   environment,
+		config:        config,
 	}
 }
 
+// RefreshTokenTTL returns how long a refresh token (and its cookie) stays valid
+func (s *AuthService) RefreshTokenTTL() time.Duration {
+	return s.config.RefreshTokenTTL
+}
+
 // IsProduction returns true if running in production environment
 func (s *AuthService) IsProduction() bool {
 	return s.environment == "production"
@@ -113,10 +309,20 @@ func (s *AuthService) Register(username, email, password string) (*models.User,
 		Username:     username,
 		Email:        email,
 		PasswordHash: hashedPassword,
-		Role:         models.RoleUser, // Default role
+		Role:         s.config.DefaultRole,
+
+		EmailVerified: !s.config.RequireEmailVerification,
 	}
 
-	if err := s.userRepo.CreateUser(user); err != nil {
+	if err := s.createUser(user); err != nil {
+		// A concurrent registration can pass the checks above and insert first
+		if errors.Is(err, repository.ErrDuplicate) {
+			logger.Log.Warn("Registration lost race on unique constraint",
+				zap.String("username", username),
+				zap.String("email", email),
+			)
+			return nil, "", s.duplicateUserError(email)
+		}
 		logger.Log.Error("Failed to create user in database",
 			zap.String("username", username),
 			zap.String("email", email),
@@ -125,7 +331,18 @@ func (s *AuthService) Register(username, email, password string) (*models.User,
 		return nil, "", err
 	}
 
-	// 6. Generate JWT token
+	// 6. Email the verification link (the account exists either way; a
+	// failed send can be retried with SendVerificationToken)
+	if !user.EmailVerified {
+		if err := s.sendVerification(user); err != nil {
+			logger.Log.Warn("Failed to send verification email",
+				zap.String("user_id", user.ID.String()),
+				zap.Error(err),
+			)
+		}
+	}
+
+	// 7. Generate JWT token
 	token, err := utils.GenerateToken(user, s.jwtSecret, s.jwtExpiration)
 	if err != nil {
 		logger.Log.Error("Failed to generate JWT token",
@@ -146,7 +363,376 @@ func (s *AuthService) Register(username, email, password string) (*models.User,
 	return user, token, nil
 }
 
+// createUser inserts the user, promoting the very first account to admin when bootstrap is enabled
+func (s *AuthService) createUser(user *models.User) error {
+	if !s.config.FirstUserAdmin || s.bootstrapped.Load() {
+		return s.userRepo.CreateUser(user)
+	}
+
+	// Concurrent first registrations must not both see an empty table
+	s.bootstrapMu.Lock()
+	defer s.bootstrapMu.Unlock()
+
+	err := s.userRepo.Transaction(func(tx *gorm.DB) error {
+		txRepo := s.userRepo.WithTx(tx)
+
+		count, err := txRepo.CountAccounts()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			user.Role = models.RoleAdmin
+		}
+		return txRepo.CreateUser(user)
+	})
+	if err != nil {
+		user.Role = s.config.DefaultRole
+		return err
+	}
+
+	if user.Role == models.RoleAdmin {
+		logger.Log.Info("First registered user promoted to admin",
+			zap.String("user_id", user.ID.String()),
+			zap.String("username", user.Username),
+		)
+	}
+	s.bootstrapped.Store(true)
+	return nil
+}
+
+// duplicateUserError resolves which unique column a failed insert collided on
+func (s *AuthService) duplicateUserError(email string) error {
+	emailTaken, err := s.userRepo.EmailExists(email)
+	if err != nil {
+		return err
+	}
+	if emailTaken {
+		return ErrEmailAlreadyExists
+	}
+	return ErrUsernameAlreadyExists
+}
+
+// IssueRefreshToken creates a new single-use refresh token for the user.
+// Only its hash is stored.
+func (s *AuthService) IssueRefreshToken(userID uuid.UUID) (string, error) {
+	token, tokenHash, err := utils.GenerateRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.refreshRepo.Create(&models.RefreshToken{
+		UserID:    userID,
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().Add(s.config.RefreshTokenTTL),
+	}); err != nil {
+		logger.Log.Error("Failed to store refresh token",
+			zap.String("user_id", userID.String()),
+			zap.Error(err),
+		)
+		return "", err
+	}
+	return token, nil
+}
+
+// RefreshToken issues a new short-lived access token for a still-valid one,
+// unless the user has been banned since it was issued.
+func (s *AuthService) RefreshToken(oldToken string) (string, error) {
+	claims, err := utils.ValidateToken(oldToken, s.jwtSecret)
+	if err != nil {
+		return "", err
+	}
+
+	// Banned users are soft-deleted, so the scoped lookup misses them
+	user, err := s.userRepo.GetUserByID(claims.UserID)
+	if err != nil {
+		return "", err
+	}
+	if user == nil {
+		logger.Log.Warn("Token refresh denied: user banned",
+			zap.String("user_id", claims.UserID.String()),
+		)
+		return "", ErrUserBanned
+	}
+
+	return utils.GenerateToken(user, s.jwtSecret, s.config.AccessTokenTTL)
+}
+
+// RevokeRefreshToken consumes a single refresh token (logout of one device).
+// Unknown or already consumed tokens are not an error.
+func (s *AuthService) RevokeRefreshToken(refreshToken string) error {
+	stored, err := s.refreshRepo.GetByHash(utils.HashRefreshToken(refreshToken))
+	if err != nil || stored == nil {
+		return err
+	}
+	_, err = s.refreshRepo.Consume(stored.ID)
+	return err
+}
+
+// RefreshSession rotates the refresh token and renews the access token.
+// Both tokens must belong to the same user. A refresh token presented a second
+// time has leaked, so every outstanding refresh token of its user is revoked.
+// Returns: (new access token, new refresh token, error)
+func (s *AuthService) RefreshSession(accessToken, refreshToken string) (string, string, error) {
+	claims, err := utils.ValidateToken(accessToken, s.jwtSecret)
+	if err != nil {
+		return "", "", err
+	}
+
+	stored, err := s.refreshRepo.GetByHash(utils.HashRefreshToken(refreshToken))
+	if err != nil {
+		return "", "", err
+	}
+	if stored == nil || stored.UserID != claims.UserID || time.Now().After(stored.ExpiresAt) {
+		return "", "", ErrInvalidRefreshToken
+	}
+
+	consumed, err := s.refreshRepo.Consume(stored.ID)
+	if err != nil {
+		return "", "", err
+	}
+	if !consumed {
+		revoked, err := s.refreshRepo.RevokeAllForUser(stored.UserID)
+		logger.Log.Warn("Refresh token reuse detected, revoking all refresh tokens",
+			zap.String("user_id", stored.UserID.String()),
+			zap.Int64("revoked", revoked),
+			zap.Error(err),
+		)
+		return "", "", ErrRefreshTokenReused
+	}
+
+	newAccessToken, err := s.RefreshToken(accessToken)
+	if err != nil {
+		return "", "", err
+	}
+
+	newRefreshToken, err := s.IssueRefreshToken(stored.UserID)
+	if err != nil {
+		return "", "", err
+	}
+
+	logger.Log.Info("Session refreshed",
+		zap.String("user_id", stored.UserID.String()),
+	)
+
+	return newAccessToken, newRefreshToken, nil
+}
+
+// SendVerificationToken emails the user a new verification link. Links sent
+// earlier stop working.
+func (s *AuthService) SendVerificationToken(userID uuid.UUID) error {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+	if user.EmailVerified {
+		return ErrAlreadyVerified
+	}
+	return s.sendVerification(user)
+}
+
+// ResendVerification emails a new verification link to the unverified
+// account registered with email, invalidating earlier links. Unknown and
+// already verified emails are silently ignored, so the result reveals nothing
+// about the account; only the per-email cooldown (ResendCooldownError), which
+// applies to every email alike, is reported.
+func (s *AuthService) ResendVerification(email string) error {
+	cooldownKey := strings.ToLower(strings.TrimSpace(email))
+
+	if s.broker != nil {
+		retryAfter, err := s.broker.ClaimVerificationResend(cooldownKey, s.config.VerificationResendCooldown)
+		if err != nil {
+			// Fail open: a Redis outage shouldn't lock users out of verifying
+			logger.Log.Warn("Failed to check verification resend cooldown",
+				zap.String("email", email),
+				zap.Error(err),
+			)
+		} else if retryAfter > 0 {
+			return &ResendCooldownError{RetryAfter: retryAfter}
+		}
+	}
+
+	user, err := s.userRepo.GetUserByEmail(email)
+	if err != nil {
+		return err
+	}
+	if user == nil || user.EmailVerified {
+		return nil
+	}
+
+	if err := s.sendVerification(user); err != nil {
+		logger.Log.Warn("Failed to resend verification email",
+			zap.String("user_id", user.ID.String()),
+			zap.Error(err),
+		)
+		return nil
+	}
+
+	logger.Log.Info("Verification email resent",
+		zap.String("user_id", user.ID.String()),
+	)
+	return nil
+}
+
+// sendVerification stores a fresh token for user and mails its link
+func (s *AuthService) sendVerification(user *models.User) error {
+	token, tokenHash, err := utils.GenerateRefreshToken() // Same shape: random, stored hashed
+	if err != nil {
+		return err
+	}
+
+	err = s.userRepo.Transaction(func(tx *gorm.DB) error {
+		txRepo := s.verifyRepo.WithTx(tx)
+		if _, err := txRepo.ConsumeAllForUser(user.ID); err != nil {
+			return err
+		}
+		return txRepo.Create(&models.VerificationToken{
+			UserID:    user.ID,
+			TokenHash: tokenHash,
+			ExpiresAt: time.Now().Add(s.config.VerificationTokenTTL),
+		})
+	})
+	if err != nil {
+		logger.Log.Error("Failed to store verification token",
+			zap.String("user_id", user.ID.String()),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	link := s.config.VerificationURL + "?token=" + url.QueryEscape(token)
+	return s.config.Mailer.SendVerification(user.Email, user.Username, link)
+}
+
+// VerifyEmail marks the account behind a verification token as verified.
+// Tokens are single-use; unknown, used and expired ones are all
+// ErrInvalidVerification. The new status shows up in access tokens issued
+// afterwards (login or refresh).
+func (s *AuthService) VerifyEmail(token string) error {
+	stored, err := s.verifyRepo.GetByHash(utils.HashRefreshToken(token))
+	if err != nil {
+		return err
+	}
+	if stored == nil || stored.ConsumedAt != nil || time.Now().After(stored.ExpiresAt) {
+		return ErrInvalidVerification
+	}
+
+	err = s.userRepo.Transaction(func(tx *gorm.DB) error {
+		consumed, err := s.verifyRepo.WithTx(tx).Consume(stored.ID)
+		if err != nil {
+			return err
+		}
+		if !consumed {
+			return ErrInvalidVerification // Used concurrently
+		}
+
+		found, err := s.userRepo.WithTx(tx).SetEmailVerified(stored.UserID)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return ErrInvalidVerification // Account banned since
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Log.Info("Email verified",
+		zap.String("user_id", stored.UserID.String()),
+	)
+	return nil
+}
+
+// Login checks credentials and issues an access token. Failed attempts are
+// held for FailedLoginDelay before returning, and enough of them in a row
+// lock the email out (AccountLockedError).
 func (s *AuthService) Login(email, password string) (*models.User, string, error) {
+	lockKey := strings.ToLower(strings.TrimSpace(email))
+
+	// Locked emails are rejected before the lookup, so known and unknown
+	// emails answer alike and the password isn't checked at all
+	if err := s.checkLockout(lockKey); err != nil {
+		return nil, "", err
+	}
+
+	user, token, err := s.login(email, password)
+	switch {
+	case errors.Is(err, ErrInvalidCredentials):
+		if lockErr := s.recordLoginFailure(lockKey); lockErr != nil {
+			err = lockErr
+		}
+		if s.config.FailedLoginDelay > 0 {
+			s.config.Sleep(s.config.FailedLoginDelay)
+		}
+	case err == nil && s.lockoutEnabled():
+		if resetErr := s.broker.ResetLoginFailures(lockKey); resetErr != nil {
+			logger.Log.Warn("Failed to reset failed login count",
+				zap.String("email", email),
+				zap.Error(resetErr),
+			)
+		}
+	}
+	return user, token, err
+}
+
+// lockoutEnabled reports whether failed logins are tracked
+func (s *AuthService) lockoutEnabled() bool {
+	return s.broker != nil && s.config.LockoutThreshold > 0
+}
+
+// checkLockout returns an AccountLockedError while email is locked.
+// Redis errors let the login through (the IP rate limit still applies).
+func (s *AuthService) checkLockout(email string) error {
+	if !s.lockoutEnabled() {
+		return nil
+	}
+
+	until, err := s.broker.GetLoginLockedUntil(email)
+	if err != nil {
+		logger.Log.Warn("Failed to check login lockout",
+			zap.String("email", email),
+			zap.Error(err),
+		)
+		return nil
+	}
+	if remaining := time.Until(until); remaining > 0 {
+		return &AccountLockedError{RetryAfter: remaining}
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failed login, returning an AccountLockedError
+// when it is the one that reaches LockoutThreshold
+func (s *AuthService) recordLoginFailure(email string) error {
+	if !s.lockoutEnabled() {
+		return nil
+	}
+
+	until, err := s.broker.RecordLoginFailure(email, s.config.LockoutThreshold, s.config.LockoutDuration)
+	if err != nil {
+		logger.Log.Warn("Failed to record failed login",
+			zap.String("email", email),
+			zap.Error(err),
+		)
+		return nil
+	}
+	if until.IsZero() {
+		return nil
+	}
+
+	logger.Log.Warn("Login locked after repeated failures",
+		zap.String("email", email),
+		zap.Int("failures", s.config.LockoutThreshold),
+		zap.Time("until", until),
+	)
+	return &AccountLockedError{RetryAfter: time.Until(until)}
+}
+
+func (s *AuthService) login(email, password string) (*models.User, string, error) {
 	start := time.Now()
 
 	logger.Log.Debug("Processing user login",
@@ -166,6 +752,15 @@ func (s *AuthService) Login(email, password string) (*models.User, string, error
 		logger.Log.Warn("Login failed: user not found",
 			zap.String("email", email),
 		)
+		if hash, err := dummyPasswordHash(); err == nil {
+			utils.VerifyPassword(password, hash)
+		}
+		return nil, "", ErrInvalidCredentials
+	}
+	if user.Role == models.RoleSystem {
+		logger.Log.Warn("Login failed: system user cannot log in",
+			zap.String("email", email),
+		)
 		return nil, "", ErrInvalidCredentials
 	}
 
@@ -189,7 +784,12 @@ func (s *AuthService) Login(email, password string) (*models.User, string, error
 		return nil, "", ErrInvalidCredentials
 	}
 
-	// 3. Generate JWT token
+	// 3. Upgrade hashes made with older Argon2 parameters (the only time we have the password)
+	if utils.NeedsRehash(user.PasswordHash) {
+		s.rehashPassword(user, password)
+	}
+
+	// 4. Generate JWT token
 	token, err := utils.GenerateToken(user, s.jwtSecret, s.jwtExpiration)
 	if err != nil {
 		logger.Log.Error("Failed to generate JWT token",
@@ -209,13 +809,126 @@ func (s *AuthService) Login(email, password string) (*models.User, string, error
 	return user, token, nil
 }
 
+// rehashPassword stores the password hashed with the current parameters.
+// Best-effort: the old hash still verifies, so failures only delay the upgrade.
+func (s *AuthService) rehashPassword(user *models.User, password string) {
+	passwordHash, err := utils.HashPassword(password)
+	if err == nil {
+		_, err = s.userRepo.UpdatePasswordHash(user.ID, passwordHash)
+	}
+	if err != nil {
+		logger.Log.Warn("Failed to upgrade password hash",
+			zap.String("user_id", user.ID.String()),
+			zap.Error(err),
+		)
+		return
+	}
+
+	user.PasswordHash = passwordHash
+	logger.Log.Info("Upgraded password hash to current parameters",
+		zap.String("user_id", user.ID.String()),
+	)
+}
+
+// ChangePassword replaces the user's password after checking the current one.
+// Every refresh token of the user is revoked, and with a TokenRevoker every
+// access token issued so far, so other devices are logged out right away;
+// the caller renews its own session.
+func (s *AuthService) ChangePassword(userID uuid.UUID, oldPassword, newPassword string) error {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+
+	valid, err := utils.VerifyPassword(oldPassword, user.PasswordHash)
+	if err != nil {
+		logger.Log.Error("Failed to verify password",
+			zap.String("user_id", userID.String()),
+			zap.Error(err),
+		)
+		return err
+	}
+	if !valid {
+		logger.Log.Warn("Password change failed: wrong current password",
+			zap.String("user_id", userID.String()),
+		)
+		return ErrInvalidCredentials
+	}
+
+	if err := validatePassword(newPassword); err != nil {
+		return err
+	}
+	passwordHash, err := utils.HashPassword(newPassword)
+	if err != nil {
+		logger.Log.Error("Failed to hash password",
+			zap.String("user_id", userID.String()),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	var revoked int64
+	err = s.userRepo.Transaction(func(tx *gorm.DB) error {
+		found, err := s.userRepo.WithTx(tx).UpdatePasswordHash(userID, passwordHash)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return ErrUserNotFound // Banned meanwhile
+		}
+		revoked, err = s.refreshRepo.WithTx(tx).RevokeAllForUser(userID)
+		return err
+	})
+	if err != nil {
+		logger.Log.Error("Failed to change password",
+			zap.String("user_id", userID.String()),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	if s.config.TokenRevoker != nil {
+		// Kept until the longest-lived access token issued so far has expired
+		ttl := max(s.jwtExpiration, s.config.AccessTokenTTL)
+		if err := s.config.TokenRevoker.RevokeIssuedBefore(userID.String(), time.Now(), ttl); err != nil {
+			logger.Log.Error("Failed to revoke access tokens after password change",
+				zap.String("user_id", userID.String()),
+				zap.Error(err),
+			)
+		}
+	}
+
+	logger.Log.Info("Password changed",
+		zap.String("user_id", userID.String()),
+		zap.Int64("revoked_refresh_tokens", revoked),
+	)
+	return nil
+}
+
+// validateUsername enforces configured length limits (counted in runes).
+// Every path that sets a username must go through here.
+func (s *AuthService) validateUsername(username string) error {
+    if strings.EqualFold(username, models.SystemUsername) {
+        return errors.New("username is reserved")
+    }
+
+    length := utf8.RuneCountInString(username)
+    if length < s.config.UsernameMinLength {
+        return fmt.Errorf("username must be at least %d characters", s.config.UsernameMinLength)
+    }
+    if length > s.config.UsernameMaxLength {
+        return fmt.Errorf("username must be at most %d characters", s.config.UsernameMaxLength)
+    }
+    return nil
+}
+
 func (s *AuthService) validateRegisterInput(username, email, password string) error {
     // Username validation
-    if len(username) < 3 {
-        return errors.New("username must be at least 3 characters")
-    }
-    if len(username) > 50 {
-        return errors.New("username must be at most 50 characters")
+    if err := s.validateUsername(username); err != nil {
+        return err
     }
     
     // Email validation (regex)
@@ -227,16 +940,24 @@ func (s *AuthService) validateRegisterInput(username, email, password string) er
     }
     
     // Password validation
-    if len(password) < 8 {
-        return errors.New("password must be at least 8 characters")
-    }
-    if len(password) > 128 {
-        return errors.New("password too long")
+    if err := validatePassword(password); err != nil {
+        return err
     }
     
     return nil
 }
 
+// validatePassword applies the password rules of registration and password changes
+func validatePassword(password string) error {
+	if len(password) < 8 {
+		return errors.New("password must be at least 8 characters")
+	}
+	if len(password) > 128 {
+		return errors.New("password too long")
+	}
+	return utils.ValidatePasswordStrength(password)
+}
+
 // GetAllUsers returns all users (including soft-deleted ones)
 func (s *AuthService) GetAllUsers() ([]*models.User, error) {
 	logger.Log.Debug("Fetching all users (including deleted)")
@@ -256,45 +977,78 @@ func (s *AuthService) GetAllUsers() ([]*models.User, error) {
 	return users, nil
 }
 
-// BanUser soft deletes a user (sets DeletedAt)
-func (s *AuthService) BanUser(userID, adminID, reason string) error {
+// BanUser soft deletes a user and their messages and records an audit entry.
+// All three steps run in one transaction. Returns the number of messages removed.
+func (s *AuthService) BanUser(userID, adminID, reason string) (int64, error) {
 	logger.Log.Info("Banning user",
 		zap.String("user_id", userID),
 		zap.String("admin_id", adminID),
 		zap.String("reason", reason),
 	)
 
-	// Parse UUID
+	// Parse UUIDs
 	uid, err := uuid.Parse(userID)
 	if err != nil {
 		logger.Log.Warn("Invalid user ID format",
 			zap.String("user_id", userID),
 			zap.Error(err),
 		)
-		return errors.New("invalid user ID format")
+		return 0, errors.New("invalid user ID format")
 	}
+	actorID, err := uuid.Parse(adminID)
+	if err != nil {
+		return 0, errors.New("invalid admin ID format")
+	}
+
+	// Soft delete user + messages + audit entry (atomic)
+	var deletedMessages int64
+	err = s.userRepo.Transaction(func(tx *gorm.DB) error {
+		if err := s.userRepo.WithTx(tx).SoftDeleteUser(uid); err != nil {
+			return err
+		}
+
+		count, err := s.messageRepo.WithTx(tx).SoftDeleteByUserID(uid, true)
+		if err != nil {
+			return err
+		}
+		deletedMessages = count
+
+		if _, err := s.refreshRepo.WithTx(tx).RevokeAllForUser(uid); err != nil {
+			return err
+		}
 
-	// Soft delete user
-	if err := s.userRepo.SoftDeleteUser(uid); err != nil {
+		return s.auditRepo.WithTx(tx).Create(&models.AuditLog{
+			Action:   models.AuditActionBan,
+			ActorID:  actorID,
+			TargetID: uid,
+			Reason:   reason,
+		})
+	})
+	if err != nil {
 		logger.Log.Error("Failed to ban user",
 			zap.String("user_id", userID),
 			zap.Error(err),
 		)
-		return err
+		return 0, err
 	}
 
-	// TODO: Delete all user's messages (will be implemented in message service)
+	// Kick live WebSocket connections on every node
+	s.publishUserBanned(userID)
+	s.markCachedMessagesDeleted([]string{userID})
 
 	logger.Log.Info("User banned successfully",
 		zap.String("user_id", userID),
 		zap.String("admin_id", adminID),
+		zap.Int64("deleted_messages", deletedMessages),
 	)
 
-	return nil
+	return deletedMessages, nil
 }
 
-// BanBulk bans multiple users at once
-func (s *AuthService) BanBulk(userIDs []string, adminID, reason string) error {
+// BanBulk bans multiple users at once (single transaction, like BanUser,
+// refresh tokens revoked too).
+// Returns the number of messages removed.
+func (s *AuthService) BanBulk(userIDs []string, adminID, reason string) (int64, error) {
 	logger.Log.Info("Bulk banning users",
 		zap.Int("count", len(userIDs)),
 		zap.String("admin_id", adminID),
@@ -315,22 +1069,360 @@ func (s *AuthService) BanBulk(userIDs []string, adminID, reason string) error {
 	}
 
 	if len(uuids) == 0 {
-		return errors.New("no valid user IDs provided")
+		return 0, errors.New("no valid user IDs provided")
 	}
 
-	// Bulk soft delete
-	if err := s.userRepo.BulkSoftDelete(uuids); err != nil {
+	actorID, err := uuid.Parse(adminID)
+	if err != nil {
+		return 0, errors.New("invalid admin ID format")
+	}
+
+	// Bulk soft delete users + messages + audit entries (atomic)
+	var deletedMessages int64
+	err = s.userRepo.Transaction(func(tx *gorm.DB) error {
+		if err := s.userRepo.WithTx(tx).BulkSoftDelete(uuids); err != nil {
+			return err
+		}
+
+		count, err := s.messageRepo.WithTx(tx).SoftDeleteByUserIDs(uuids, true)
+		if err != nil {
+			return err
+		}
+		deletedMessages = count
+
+		if _, err := s.refreshRepo.WithTx(tx).RevokeAllForUsers(uuids); err != nil {
+			return err
+		}
+
+		entries := make([]models.AuditLog, 0, len(uuids))
+		for _, uid := range uuids {
+			entries = append(entries, models.AuditLog{
+				Action:   models.AuditActionBan,
+				ActorID:  actorID,
+				TargetID: uid,
+				Reason:   reason,
+			})
+		}
+
+		return s.auditRepo.WithTx(tx).CreateBatch(entries)
+	})
+	if err != nil {
 		logger.Log.Error("Failed to bulk ban users",
 			zap.Error(err),
 		)
-		return err
+		return 0, err
 	}
 
-	// TODO: Delete all messages from these users
+	banned := make([]string, 0, len(uuids))
+	for _, uid := range uuids {
+		s.publishUserBanned(uid.String())
+		banned = append(banned, uid.String())
+	}
+	s.markCachedMessagesDeleted(banned)
 
 	logger.Log.Info("Users banned successfully",
 		zap.Int("count", len(uuids)),
+		zap.Int64("deleted_messages", deletedMessages),
+	)
+
+	return deletedMessages, nil
+}
+
+// UnbanUser restores a banned user so they can log in again and records an
+// audit entry. Messages removed by the ban stay deleted. Fails with
+// ErrUnbanConflict if a newer account now holds the username or email.
+func (s *AuthService) UnbanUser(userID, adminID string) error {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return errors.New("invalid user ID format")
+	}
+	actorID, err := uuid.Parse(adminID)
+	if err != nil {
+		return errors.New("invalid admin ID format")
+	}
+
+	err = s.userRepo.Transaction(func(tx *gorm.DB) error {
+		txRepo := s.userRepo.WithTx(tx)
+
+		if err := checkUnbannable(txRepo, uid); err != nil {
+			return err
+		}
+
+		if err := txRepo.RestoreUser(uid); err != nil {
+			// A registration can take the name between the check and the update
+			if errors.Is(err, repository.ErrDuplicate) {
+				return ErrUnbanConflict
+			}
+			return err
+		}
+
+		return s.auditRepo.WithTx(tx).Create(&models.AuditLog{
+			Action:   models.AuditActionUnban,
+			ActorID:  actorID,
+			TargetID: uid,
+		})
+	})
+	if err != nil {
+		logger.Log.Warn("Failed to unban user",
+			zap.String("user_id", userID),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	logger.Log.Info("User unbanned successfully",
+		zap.String("user_id", userID),
+		zap.String("admin_id", adminID),
 	)
 
 	return nil
 }
+
+// UnbanBulk restores several banned users at once with one UPDATE and an
+// audit entry each. Invalid IDs and users that UnbanUser would refuse (not
+// found, not banned, identity taken) are skipped. As with UnbanUser, their
+// messages stay deleted. Returns the number of users unbanned.
+func (s *AuthService) UnbanBulk(userIDs []string, adminID string) (int64, error) {
+	logger.Log.Info("Bulk unbanning users",
+		zap.Int("count", len(userIDs)),
+		zap.String("admin_id", adminID),
+	)
+
+	var uuids []uuid.UUID
+	for _, id := range userIDs {
+		uid, err := uuid.Parse(id)
+		if err != nil {
+			logger.Log.Warn("Invalid user ID in bulk unban",
+				zap.String("user_id", id),
+				zap.Error(err),
+			)
+			continue // Skip invalid IDs
+		}
+		if !slices.Contains(uuids, uid) {
+			uuids = append(uuids, uid)
+		}
+	}
+
+	if len(uuids) == 0 {
+		return 0, errors.New("no valid user IDs provided")
+	}
+
+	actorID, err := uuid.Parse(adminID)
+	if err != nil {
+		return 0, errors.New("invalid admin ID format")
+	}
+
+	// Bulk restore users + audit entries (atomic)
+	var unbanned int64
+	err = s.userRepo.Transaction(func(tx *gorm.DB) error {
+		txRepo := s.userRepo.WithTx(tx)
+
+		restorable := make([]uuid.UUID, 0, len(uuids))
+		for _, uid := range uuids {
+			err := checkUnbannable(txRepo, uid)
+			switch {
+			case err == nil:
+				restorable = append(restorable, uid)
+			case errors.Is(err, ErrUserNotFound), errors.Is(err, ErrNotBanned), errors.Is(err, ErrUnbanConflict):
+				logger.Log.Warn("Skipping user in bulk unban",
+					zap.String("user_id", uid.String()),
+					zap.Error(err),
+				)
+			default:
+				return err
+			}
+		}
+		if len(restorable) == 0 {
+			return nil
+		}
+
+		count, err := txRepo.BulkRestore(restorable)
+		if err != nil {
+			if errors.Is(err, repository.ErrDuplicate) {
+				return ErrUnbanConflict
+			}
+			return err
+		}
+		unbanned = count
+
+		entries := make([]models.AuditLog, 0, len(restorable))
+		for _, uid := range restorable {
+			entries = append(entries, models.AuditLog{
+				Action:   models.AuditActionUnban,
+				ActorID:  actorID,
+				TargetID: uid,
+			})
+		}
+
+		return s.auditRepo.WithTx(tx).CreateBatch(entries)
+	})
+	if err != nil {
+		logger.Log.Error("Failed to bulk unban users",
+			zap.Error(err),
+		)
+		return 0, err
+	}
+
+	logger.Log.Info("Users unbanned successfully",
+		zap.Int64("count", unbanned),
+		zap.String("admin_id", adminID),
+	)
+
+	return unbanned, nil
+}
+
+// checkUnbannable returns why a user can't be unbanned: ErrUserNotFound,
+// ErrNotBanned, or ErrUnbanConflict if a newer account holds the username or email
+func checkUnbannable(userRepo *repository.UserRepository, uid uuid.UUID) error {
+	user, err := userRepo.GetUserByIDUnscoped(uid)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+	if !user.DeletedAt.Valid {
+		return ErrNotBanned
+	}
+
+	taken, err := userRepo.IdentityTaken(user.Username, user.Email, uid)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrUnbanConflict
+	}
+	return nil
+}
+
+// MuteUser stops a user from sending for the given duration without banning
+// them: they stay logged in and can still read. The mute lives in Redis and
+// expires on its own; an audit entry records it.
+func (s *AuthService) MuteUser(userID, adminID string, duration time.Duration, reason string) error {
+	if s.broker == nil {
+		return ErrMuteUnavailable
+	}
+	if duration < time.Second || duration > maxMuteDuration {
+		return ErrInvalidMuteDuration
+	}
+
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return errors.New("invalid user ID format")
+	}
+	actorID, err := uuid.Parse(adminID)
+	if err != nil {
+		return errors.New("invalid admin ID format")
+	}
+
+	user, err := s.userRepo.GetUserByID(uid)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+
+	until := time.Now().Add(duration)
+	if err := s.broker.MuteUser(userID, until); err != nil {
+		logger.Log.Error("Failed to mute user",
+			zap.String("user_id", userID),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	s.recordAudit(models.AuditActionMute, actorID, uid, reason)
+
+	logger.Log.Info("User muted",
+		zap.String("user_id", userID),
+		zap.String("admin_id", adminID),
+		zap.Duration("duration", duration),
+		zap.Time("until", until),
+	)
+
+	return nil
+}
+
+// UnmuteUser lifts a mute before it expires
+func (s *AuthService) UnmuteUser(userID, adminID string) error {
+	if s.broker == nil {
+		return ErrMuteUnavailable
+	}
+
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return errors.New("invalid user ID format")
+	}
+	actorID, err := uuid.Parse(adminID)
+	if err != nil {
+		return errors.New("invalid admin ID format")
+	}
+
+	wasMuted, err := s.broker.UnmuteUser(userID)
+	if err != nil {
+		logger.Log.Error("Failed to unmute user",
+			zap.String("user_id", userID),
+			zap.Error(err),
+		)
+		return err
+	}
+	if !wasMuted {
+		return ErrNotMuted
+	}
+
+	s.recordAudit(models.AuditActionUnmute, actorID, uid, "")
+
+	logger.Log.Info("User unmuted",
+		zap.String("user_id", userID),
+		zap.String("admin_id", adminID),
+	)
+
+	return nil
+}
+
+// recordAudit writes an audit entry for an action that already took effect
+// outside the database, so a failure is logged and not returned
+func (s *AuthService) recordAudit(action models.AuditAction, actorID, targetID uuid.UUID, reason string) {
+	err := s.auditRepo.Create(&models.AuditLog{
+		Action:   action,
+		ActorID:  actorID,
+		TargetID: targetID,
+		Reason:   reason,
+	})
+	if err != nil {
+		logger.Log.Warn("Failed to write audit entry",
+			zap.String("action", string(action)),
+			zap.String("target_id", targetID.String()),
+			zap.Error(err),
+		)
+	}
+}
+
+// markCachedMessagesDeleted hides banned users' messages in the recent-messages
+// cache. The ban is already committed, so a failure is logged and not returned.
+func (s *AuthService) markCachedMessagesDeleted(userIDs []string) {
+	if s.broker == nil {
+		return
+	}
+	if _, err := s.broker.MarkUserMessagesAsDeleted(userIDs); err != nil {
+		logger.Log.Warn("Failed to mark banned users' messages deleted in cache",
+			zap.Int("user_count", len(userIDs)),
+			zap.Error(err),
+		)
+	}
+}
+
+// publishUserBanned broadcasts a ban event. The ban itself is already
+// committed, so a publish failure is logged and not returned.
+func (s *AuthService) publishUserBanned(userID string) {
+	if s.broker == nil {
+		return
+	}
+	if err := s.broker.PublishUserBanned(userID); err != nil {
+		logger.Log.Warn("Failed to publish user banned event",
+			zap.String("user_id", userID),
+			zap.Error(err),
+		)
+	}
+}
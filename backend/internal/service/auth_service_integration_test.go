@@ -0,0 +1,748 @@
+package service_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Baaaki/digital-square/internal/broker"
+	"github.com/Baaaki/digital-square/internal/models"
+	"github.com/Baaaki/digital-square/internal/repository"
+	"github.com/Baaaki/digital-square/internal/service"
+	"github.com/Baaaki/digital-square/internal/testutil"
+	"github.com/Baaaki/digital-square/internal/utils"
+	"github.com/Baaaki/digital-square/pkg/logger"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/crypto/argon2"
+	"gorm.io/gorm"
+)
+
+// AuthServiceIntegrationTestSuite defines test suite
+type AuthServiceIntegrationTestSuite struct {
+	suite.Suite
+	testDB   *testutil.TestDatabase
+	userRepo *repository.UserRepository
+}
+
+// SetupSuite runs before all tests
+func (s *AuthServiceIntegrationTestSuite) SetupSuite() {
+	logger.Init(false)
+	s.testDB = testutil.SetupTestDatabase(s.T())
+	s.userRepo = repository.NewUserRepository(s.testDB.DB)
+}
+
+// TearDownSuite runs after all tests
+func (s *AuthServiceIntegrationTestSuite) TearDownSuite() {
+	s.testDB.Teardown(s.T())
+}
+
+// SetupTest runs before each test (clean database)
+func (s *AuthServiceIntegrationTestSuite) SetupTest() {
+	testutil.CleanDatabase(s.T(), s.testDB.DB)
+}
+
+// newAuthService builds an AuthService with the given config
+func (s *AuthServiceIntegrationTestSuite) newAuthService(config service.AuthServiceConfig) *service.AuthService {
+	return service.NewAuthService(
+		s.userRepo,
+		repository.NewMessageRepository(s.testDB.DB),
+		repository.NewAuditLogRepository(s.testDB.DB),
+		repository.NewRefreshTokenRepository(s.testDB.DB),
+		repository.NewVerificationTokenRepository(s.testDB.DB),
+		nil,
+		"test-secret-key", time.Hour, "development", config,
+	)
+}
+
+// TestUsernameLengthBoundaries tests configured min/max username length
+func (s *AuthServiceIntegrationTestSuite) TestUsernameLengthBoundaries() {
+	authService := s.newAuthService(service.AuthServiceConfig{
+		UsernameMinLength: 4,
+		UsernameMaxLength: 10,
+	})
+
+	testCases := []struct {
+		name     string
+		username string
+		wantErr  string
+	}{
+		{"One under min", "abc", "at least 4 characters"},
+		{"Exactly min", "abcd", ""},
+		{"Exactly max", "abcdefghij", ""},
+		{"One over max", "abcdefghijk", "at most 10 characters"},
+		// Multi-byte runes: 10 runes but 20 bytes must still pass
+		{"Exactly max multi-byte", strings.Repeat("ş", 10), ""},
+		{"One over max multi-byte", strings.Repeat("ş", 11), "at most 10 characters"},
+		{"Reserved system name", "System", "reserved"},
+	}
+
+	for i, tc := range testCases {
+		s.Run(tc.name, func() {
+			email := "user" + string(rune('a'+i)) + "@example.com"
+			_, _, err := authService.Register(tc.username, email, "SecurePass123")
+			if tc.wantErr == "" {
+				assert.NoError(s.T(), err)
+			} else {
+				assert.Error(s.T(), err)
+				assert.Contains(s.T(), err.Error(), tc.wantErr)
+			}
+		})
+	}
+}
+
+// createUserWithMessages inserts a user with n live messages
+func (s *AuthServiceIntegrationTestSuite) createUserWithMessages(username string, n int) *testutil.TestUser {
+	user, _ := testutil.CreateTestUser(username, username+"@example.com", "Test123456", models.RoleUser)
+	s.testDB.DB.Create(user)
+	for i := 0; i < n; i++ {
+		s.testDB.DB.Create(testutil.CreateTestMessage(user.ID, "hello"))
+	}
+	return user
+}
+
+// TestBanUserIsAtomic tests that a ban removes the user and their messages
+// and records an audit entry
+func (s *AuthServiceIntegrationTestSuite) TestBanUserIsAtomic() {
+	authService := s.newAuthService(service.DefaultAuthServiceConfig())
+	user := s.createUserWithMessages("spammer", 3)
+	adminID := uuid.New().String()
+
+	removed, err := authService.BanUser(user.ID, adminID, "spam")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(3), removed)
+
+	var liveUsers, liveMessages, adminDeleted, audits int64
+	s.testDB.DB.Model(&testutil.TestUser{}).Where("id = ?", user.ID).Count(&liveUsers)
+	s.testDB.DB.Model(&testutil.TestMessage{}).Where("user_id = ? AND deleted_at IS NULL", user.ID).Count(&liveMessages)
+	s.testDB.DB.Model(&testutil.TestMessage{}).Where("user_id = ? AND is_deleted_by_admin = ?", user.ID, true).Count(&adminDeleted)
+	s.testDB.DB.Model(&testutil.TestAuditLog{}).Where("target_id = ? AND actor_id = ? AND action = ?", user.ID, adminID, "ban").Count(&audits)
+
+	assert.Zero(s.T(), liveUsers)
+	assert.Zero(s.T(), liveMessages)
+	assert.Equal(s.T(), int64(3), adminDeleted)
+	assert.Equal(s.T(), int64(1), audits)
+}
+
+// TestBanBulkDeletesMessagesInOneUpdate tests that a bulk ban removes every
+// banned user's messages with a single UPDATE and reports the count
+func (s *AuthServiceIntegrationTestSuite) TestBanBulkDeletesMessagesInOneUpdate() {
+	authService := s.newAuthService(service.DefaultAuthServiceConfig())
+	first := s.createUserWithMessages("spammer1", 2)
+	second := s.createUserWithMessages("spammer2", 3)
+	bystander := s.createUserWithMessages("bystander", 1)
+
+	var messageUpdates int
+	callbacks := s.testDB.DB.Callback().Update()
+	require.NoError(s.T(), callbacks.Before("gorm:update").Register("test:count_message_updates", func(tx *gorm.DB) {
+		if tx.Statement.Table == "messages" {
+			messageUpdates++
+		}
+	}))
+	defer callbacks.Remove("test:count_message_updates")
+
+	removed, err := authService.BanBulk([]string{first.ID, second.ID}, uuid.New().String(), "spam")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(5), removed)
+	assert.Equal(s.T(), 1, messageUpdates)
+
+	var liveMessages int64
+	s.testDB.DB.Model(&testutil.TestMessage{}).Where("deleted_at IS NULL").Count(&liveMessages)
+	assert.Equal(s.T(), int64(1), liveMessages, "Only %s's message should be left", bystander.Username)
+}
+
+// TestBanUserRollsBackWhenMessageDeleteFails tests that a failing message
+// delete step leaves the user active
+func (s *AuthServiceIntegrationTestSuite) TestBanUserRollsBackWhenMessageDeleteFails() {
+	authService := s.newAuthService(service.DefaultAuthServiceConfig())
+	user := s.createUserWithMessages("victim", 2)
+
+	// Force every UPDATE on the messages table to fail
+	callbacks := s.testDB.DB.Callback().Update()
+	require.NoError(s.T(), callbacks.Before("gorm:update").Register("test:fail_message_update", func(tx *gorm.DB) {
+		if tx.Statement.Table == "messages" {
+			tx.AddError(errors.New("forced message delete failure"))
+		}
+	}))
+	defer callbacks.Remove("test:fail_message_update")
+
+	_, err := authService.BanUser(user.ID, uuid.New().String(), "spam")
+	require.Error(s.T(), err)
+
+	var liveUsers, liveMessages, audits int64
+	s.testDB.DB.Model(&testutil.TestUser{}).Where("id = ?", user.ID).Count(&liveUsers)
+	s.testDB.DB.Model(&testutil.TestMessage{}).Where("user_id = ? AND deleted_at IS NULL", user.ID).Count(&liveMessages)
+	s.testDB.DB.Model(&testutil.TestAuditLog{}).Count(&audits)
+
+	assert.Equal(s.T(), int64(1), liveUsers, "user soft delete must be rolled back")
+	assert.Equal(s.T(), int64(2), liveMessages)
+	assert.Zero(s.T(), audits)
+}
+
+// TestConcurrentDuplicateRegistration tests that racing registrations yield one user and a conflict error
+func (s *AuthServiceIntegrationTestSuite) TestConcurrentDuplicateRegistration() {
+	authService := s.newAuthService(service.DefaultAuthServiceConfig())
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			_, _, errs[i] = authService.Register("racer", "racer@example.com", "SecurePass123")
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	var succeeded int
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		assert.ErrorIs(s.T(), err, service.ErrEmailAlreadyExists)
+	}
+	assert.Equal(s.T(), 1, succeeded, "Exactly one registration should succeed")
+
+	var count int64
+	s.testDB.DB.Model(&testutil.TestUser{}).Where("email = ?", "racer@example.com").Count(&count)
+	assert.Equal(s.T(), int64(1), count)
+}
+
+// TestRegisterMapsDuplicateKey tests the unique-constraint path when the pre-check misses a row
+func (s *AuthServiceIntegrationTestSuite) TestRegisterMapsDuplicateKey() {
+	authService := s.newAuthService(service.DefaultAuthServiceConfig())
+
+	// Soft-deleted users are invisible to the pre-checks but still hold the unique index
+	user, err := testutil.CreateTestUser("banned", "banned@example.com", "SecurePass123", models.RoleUser)
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.testDB.DB.Create(user).Error)
+	require.NoError(s.T(), s.testDB.DB.Delete(user).Error)
+
+	_, _, err = authService.Register("fresh", "banned@example.com", "SecurePass123")
+	assert.ErrorIs(s.T(), err, service.ErrEmailAlreadyExists)
+
+	_, _, err = authService.Register("banned", "fresh@example.com", "SecurePass123")
+	assert.ErrorIs(s.T(), err, service.ErrUsernameAlreadyExists)
+}
+
+// TestFirstUserBecomesAdmin tests the bootstrap: first account is admin, later ones get the default role
+func (s *AuthServiceIntegrationTestSuite) TestFirstUserBecomesAdmin() {
+	config := service.DefaultAuthServiceConfig()
+	config.FirstUserAdmin = true
+	authService := s.newAuthService(config)
+
+	first, _, err := authService.Register("founder", "founder@example.com", "SecurePass123")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), models.RoleAdmin, first.Role)
+
+	second, _, err := authService.Register("member", "member@example.com", "SecurePass123")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), models.RoleUser, second.Role)
+
+	stored, err := s.userRepo.GetUserByID(first.ID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), models.RoleAdmin, stored.Role)
+}
+
+// TestFirstUserAdminIsRaceFree tests that concurrent first registrations yield exactly one admin
+func (s *AuthServiceIntegrationTestSuite) TestFirstUserAdminIsRaceFree() {
+	config := service.DefaultAuthServiceConfig()
+	config.FirstUserAdmin = true
+	authService := s.newAuthService(config)
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	users := make([]*models.User, 5)
+	for i := range users {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			users[i], _, _ = authService.Register(fmt.Sprintf("early%d", i), fmt.Sprintf("early%d@example.com", i), "SecurePass123")
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	var admins int
+	for _, user := range users {
+		require.NotNil(s.T(), user)
+		if user.Role == models.RoleAdmin {
+			admins++
+		}
+	}
+	assert.Equal(s.T(), 1, admins, "Exactly one racing registration should become admin")
+}
+
+// TestDefaultRoleIsConfigurable tests that registrations use the configured default role
+func (s *AuthServiceIntegrationTestSuite) TestDefaultRoleIsConfigurable() {
+	// An existing account means bootstrap doesn't apply
+	existing, err := testutil.CreateTestUser("existing", "existing@example.com", "SecurePass123", models.RoleUser)
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.testDB.DB.Create(existing).Error)
+
+	authService := s.newAuthService(service.AuthServiceConfig{
+		UsernameMinLength: 3,
+		UsernameMaxLength: 50,
+		DefaultRole:       models.RoleAdmin,
+		FirstUserAdmin:    true,
+	})
+
+	user, _, err := authService.Register("promoted", "promoted@example.com", "SecurePass123")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), models.RoleAdmin, user.Role)
+
+	// Unset default falls back to user
+	authService = s.newAuthService(service.AuthServiceConfig{UsernameMinLength: 3, UsernameMaxLength: 50})
+	user, _, err = authService.Register("plain", "plain@example.com", "SecurePass123")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), models.RoleUser, user.Role)
+}
+
+// TestRefreshSessionRotatesTokens tests refresh token rotation and reuse rejection
+func (s *AuthServiceIntegrationTestSuite) TestRefreshSessionRotatesTokens() {
+	authService := s.newAuthService(service.DefaultAuthServiceConfig())
+	user, accessToken, err := authService.Register("refresher", "refresher@example.com", "SecurePass123")
+	require.NoError(s.T(), err)
+	refreshToken, err := authService.IssueRefreshToken(user.ID)
+	require.NoError(s.T(), err)
+
+	newAccess, newRefresh, err := authService.RefreshSession(accessToken, refreshToken)
+	require.NoError(s.T(), err)
+	assert.NotEmpty(s.T(), newAccess)
+	assert.NotEqual(s.T(), refreshToken, newRefresh)
+
+	// Only the hash is stored
+	var stored int64
+	s.testDB.DB.Model(&testutil.TestRefreshToken{}).Where("token_hash = ?", refreshToken).Count(&stored)
+	assert.Zero(s.T(), stored)
+
+	// Replaying the consumed token fails and revokes the rotated one too
+	_, _, err = authService.RefreshSession(accessToken, refreshToken)
+	assert.ErrorIs(s.T(), err, service.ErrRefreshTokenReused)
+
+	_, _, err = authService.RefreshSession(newAccess, newRefresh)
+	assert.ErrorIs(s.T(), err, service.ErrRefreshTokenReused)
+}
+
+// TestRefreshSessionRejectsForeignToken tests that a refresh token only works with its owner's access token
+func (s *AuthServiceIntegrationTestSuite) TestRefreshSessionRejectsForeignToken() {
+	authService := s.newAuthService(service.DefaultAuthServiceConfig())
+	alice, _, err := authService.Register("alice", "alice@example.com", "SecurePass123")
+	require.NoError(s.T(), err)
+	_, bobAccess, err := authService.Register("bob", "bob@example.com", "SecurePass123")
+	require.NoError(s.T(), err)
+
+	aliceRefresh, err := authService.IssueRefreshToken(alice.ID)
+	require.NoError(s.T(), err)
+
+	_, _, err = authService.RefreshSession(bobAccess, aliceRefresh)
+	assert.ErrorIs(s.T(), err, service.ErrInvalidRefreshToken)
+
+	_, _, err = authService.RefreshSession(bobAccess, "not-a-token")
+	assert.ErrorIs(s.T(), err, service.ErrInvalidRefreshToken)
+}
+
+// TestRefreshTokenDeniedAfterBan tests that users banned alone or in bulk
+// can't renew their session
+func (s *AuthServiceIntegrationTestSuite) TestRefreshTokenDeniedAfterBan() {
+	authService := s.newAuthService(service.DefaultAuthServiceConfig())
+	user, accessToken, err := authService.Register("soonbanned", "soonbanned@example.com", "SecurePass123")
+	require.NoError(s.T(), err)
+	refreshToken, err := authService.IssueRefreshToken(user.ID)
+	require.NoError(s.T(), err)
+
+	_, err = authService.BanUser(user.ID.String(), uuid.New().String(), "spam")
+	require.NoError(s.T(), err)
+
+	_, err = authService.RefreshToken(accessToken)
+	assert.ErrorIs(s.T(), err, service.ErrUserBanned)
+
+	// The ban revoked outstanding refresh tokens
+	_, _, err = authService.RefreshSession(accessToken, refreshToken)
+	assert.ErrorIs(s.T(), err, service.ErrRefreshTokenReused)
+
+	// So does a bulk ban
+	other, otherAccess, err := authService.Register("alsobanned", "alsobanned@example.com", "SecurePass123")
+	require.NoError(s.T(), err)
+	otherRefresh, err := authService.IssueRefreshToken(other.ID)
+	require.NoError(s.T(), err)
+
+	_, err = authService.BanBulk([]string{other.ID.String()}, uuid.New().String(), "spam")
+	require.NoError(s.T(), err)
+
+	_, _, err = authService.RefreshSession(otherAccess, otherRefresh)
+	assert.ErrorIs(s.T(), err, service.ErrRefreshTokenReused)
+}
+
+// TestUnbanRestoresLogin tests that an unbanned user can log in again while
+// the messages removed by the ban stay deleted
+func (s *AuthServiceIntegrationTestSuite) TestUnbanRestoresLogin() {
+	authService := s.newAuthService(service.DefaultAuthServiceConfig())
+	user, _, err := authService.Register("pardoned", "pardoned@example.com", "SecurePass123")
+	require.NoError(s.T(), err)
+	adminID := uuid.New().String()
+
+	assert.ErrorIs(s.T(), authService.UnbanUser(user.ID.String(), adminID), service.ErrNotBanned)
+	assert.ErrorIs(s.T(), authService.UnbanUser(uuid.New().String(), adminID), service.ErrUserNotFound)
+
+	_, err = authService.BanUser(user.ID.String(), adminID, "spam")
+	require.NoError(s.T(), err)
+	_, _, err = authService.Login("pardoned@example.com", "SecurePass123")
+	require.ErrorIs(s.T(), err, service.ErrInvalidCredentials)
+
+	require.NoError(s.T(), authService.UnbanUser(user.ID.String(), adminID))
+	loggedIn, _, err := authService.Login("pardoned@example.com", "SecurePass123")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), user.ID, loggedIn.ID)
+
+	var audits int64
+	s.testDB.DB.Model(&testutil.TestAuditLog{}).Where("target_id = ? AND action = ?", user.ID, "unban").Count(&audits)
+	assert.Equal(s.T(), int64(1), audits)
+}
+
+// TestUnbanConflictsWithNewerAccount tests that an unban is refused once a
+// newer account holds the email
+func (s *AuthServiceIntegrationTestSuite) TestUnbanConflictsWithNewerAccount() {
+	authService := s.newAuthService(service.DefaultAuthServiceConfig())
+	user, _, err := authService.Register("original", "shared@example.com", "SecurePass123")
+	require.NoError(s.T(), err)
+	_, err = authService.BanUser(user.ID.String(), uuid.New().String(), "spam")
+	require.NoError(s.T(), err)
+
+	// Soft-deleted rows normally keep their unique slot; drop the index so a
+	// newer account can take the email, as a partial index would allow
+	require.NoError(s.T(), s.testDB.DB.Exec("DROP INDEX idx_users_email").Error)
+	defer func() {
+		s.testDB.DB.Unscoped().Where("email = ?", "shared@example.com").Delete(&testutil.TestUser{})
+		s.testDB.DB.Exec("CREATE UNIQUE INDEX idx_users_email ON users(email)")
+	}()
+	_, _, err = authService.Register("newcomer", "shared@example.com", "SecurePass123")
+	require.NoError(s.T(), err)
+
+	err = authService.UnbanUser(user.ID.String(), uuid.New().String())
+	assert.ErrorIs(s.T(), err, service.ErrUnbanConflict)
+
+	var stillBanned int64
+	s.testDB.DB.Unscoped().Model(&testutil.TestUser{}).Where("id = ? AND deleted_at IS NOT NULL", user.ID).Count(&stillBanned)
+	assert.Equal(s.T(), int64(1), stillBanned)
+}
+
+// TestUnbanBulkRestoresUsers tests that a bulk unban reactivates every
+// banned user, skipping invalid and never-banned IDs
+func (s *AuthServiceIntegrationTestSuite) TestUnbanBulkRestoresUsers() {
+	authService := s.newAuthService(service.DefaultAuthServiceConfig())
+	first := s.createUserWithMessages("reformed1", 1)
+	second := s.createUserWithMessages("reformed2", 2)
+	bystander := s.createUserWithMessages("neverbanned", 1)
+	adminID := uuid.New().String()
+
+	_, err := authService.BanBulk([]string{first.ID, second.ID}, adminID, "spam")
+	require.NoError(s.T(), err)
+
+	unbanned, err := authService.UnbanBulk([]string{first.ID, second.ID, bystander.ID, "not-a-uuid"}, adminID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(2), unbanned)
+
+	var liveUsers, audits, liveMessages int64
+	s.testDB.DB.Model(&testutil.TestUser{}).Where("id IN ?", []string{first.ID, second.ID}).Count(&liveUsers)
+	s.testDB.DB.Model(&testutil.TestAuditLog{}).Where("action = ? AND actor_id = ?", "unban", adminID).Count(&audits)
+	s.testDB.DB.Model(&testutil.TestMessage{}).Where("user_id IN ? AND deleted_at IS NULL", []string{first.ID, second.ID}).Count(&liveMessages)
+	assert.Equal(s.T(), int64(2), liveUsers)
+	assert.Equal(s.T(), int64(2), audits)
+	assert.Zero(s.T(), liveMessages, "Messages removed by the ban stay deleted")
+
+	_, err = authService.UnbanBulk([]string{"not-a-uuid"}, adminID)
+	assert.Error(s.T(), err)
+}
+
+// TestFailedLoginDelay tests that every failed login waits the configured
+// delay, whether or not the email exists, and successful logins don't
+func (s *AuthServiceIntegrationTestSuite) TestFailedLoginDelay() {
+	var slept []time.Duration
+	authService := s.newAuthService(service.AuthServiceConfig{
+		UsernameMinLength: 3,
+		UsernameMaxLength: 50,
+		FailedLoginDelay:  200 * time.Millisecond,
+		Sleep:             func(d time.Duration) { slept = append(slept, d) },
+	})
+	_, _, err := authService.Register("guarded", "guarded@example.com", "SecurePass123")
+	require.NoError(s.T(), err)
+
+	_, _, err = authService.Login("guarded@example.com", "WrongPass123")
+	assert.ErrorIs(s.T(), err, service.ErrInvalidCredentials)
+	_, _, err = authService.Login("nobody@example.com", "SecurePass123")
+	assert.ErrorIs(s.T(), err, service.ErrInvalidCredentials)
+	assert.Equal(s.T(), []time.Duration{200 * time.Millisecond, 200 * time.Millisecond}, slept)
+
+	_, _, err = authService.Login("guarded@example.com", "SecurePass123")
+	require.NoError(s.T(), err)
+	assert.Len(s.T(), slept, 2, "Successful logins must not be delayed")
+}
+
+// TestAccountLockout tests that repeated failed logins lock an email for the
+// cooldown, that unknown emails lock the same way, and that a successful
+// login resets the count
+func (s *AuthServiceIntegrationTestSuite) TestAccountLockout() {
+	testRedis := testutil.SetupTestRedis(s.T())
+	defer testRedis.Teardown(s.T())
+	redisBroker, err := broker.NewRedisMessageBroker(testRedis.URL, broker.BrokerConfig{})
+	require.NoError(s.T(), err)
+	defer redisBroker.Close()
+
+	authService := service.NewAuthService(
+		s.userRepo,
+		repository.NewMessageRepository(s.testDB.DB),
+		repository.NewAuditLogRepository(s.testDB.DB),
+		repository.NewRefreshTokenRepository(s.testDB.DB),
+		repository.NewVerificationTokenRepository(s.testDB.DB),
+		redisBroker,
+		"test-secret-key", time.Hour, "development",
+		service.AuthServiceConfig{
+			UsernameMinLength: 3,
+			UsernameMaxLength: 50,
+			LockoutThreshold:  3,
+			LockoutDuration:   15 * time.Minute,
+		},
+	)
+	_, _, err = authService.Register("guarded", "guarded@example.com", "SecurePass123")
+	require.NoError(s.T(), err)
+
+	s.Run("Success resets the count", func() {
+		for i := 0; i < 2; i++ {
+			_, _, err := authService.Login("guarded@example.com", "WrongPass123")
+			assert.ErrorIs(s.T(), err, service.ErrInvalidCredentials)
+		}
+		_, _, err := authService.Login("guarded@example.com", "SecurePass123")
+		require.NoError(s.T(), err)
+
+		for i := 0; i < 2; i++ {
+			_, _, err := authService.Login("guarded@example.com", "WrongPass123")
+			assert.ErrorIs(s.T(), err, service.ErrInvalidCredentials, "Failures before the success must not count")
+		}
+		_, _, err = authService.Login("guarded@example.com", "SecurePass123")
+		require.NoError(s.T(), err)
+	})
+
+	var knownErr, unknownErr error
+	s.Run("Lock after threshold", func() {
+		// Case variations count against the same email
+		emails := []string{"guarded@example.com", "Guarded@Example.com", "guarded@example.com"}
+		for i, email := range emails {
+			_, _, knownErr = authService.Login(email, "WrongPass123")
+			if i < len(emails)-1 {
+				assert.ErrorIs(s.T(), knownErr, service.ErrInvalidCredentials)
+			}
+		}
+		var locked *service.AccountLockedError
+		require.ErrorAs(s.T(), knownErr, &locked)
+		assert.ErrorIs(s.T(), knownErr, service.ErrAccountLocked)
+		assert.InDelta(s.T(), (15 * time.Minute).Seconds(), locked.RetryAfter.Seconds(), 5)
+
+		// Even the right password is refused while locked
+		_, _, err := authService.Login("guarded@example.com", "SecurePass123")
+		assert.ErrorIs(s.T(), err, service.ErrAccountLocked)
+	})
+
+	s.Run("Unknown emails lock alike", func() {
+		for i := 0; i < 3; i++ {
+			_, _, unknownErr = authService.Login("nobody@example.com", "WrongPass123")
+		}
+		assert.ErrorIs(s.T(), unknownErr, service.ErrAccountLocked)
+		assert.Equal(s.T(), knownErr.Error(), unknownErr.Error(), "The lockout must not reveal whether the email exists")
+	})
+
+	s.Run("Cooldown expiry", func() {
+		testRedis.Server.FastForward(16 * time.Minute)
+
+		_, _, err := authService.Login("guarded@example.com", "SecurePass123")
+		require.NoError(s.T(), err)
+		_, _, err = authService.Login("nobody@example.com", "WrongPass123")
+		assert.ErrorIs(s.T(), err, service.ErrInvalidCredentials, "The count starts over after a lockout")
+	})
+}
+
+// captureMailer records verification links instead of sending them
+type captureMailer struct {
+	links []string
+}
+
+func (m *captureMailer) SendVerification(to, username, link string) error {
+	m.links = append(m.links, link)
+	return nil
+}
+
+// token returns the token of the most recent link
+func (m *captureMailer) token(t *testing.T) string {
+	require.NotEmpty(t, m.links)
+	u, err := url.Parse(m.links[len(m.links)-1])
+	require.NoError(t, err)
+	return u.Query().Get("token")
+}
+
+// TestEmailVerification tests the verification flow and that the status survives a re-login
+func (s *AuthServiceIntegrationTestSuite) TestEmailVerification() {
+	mail := &captureMailer{}
+	config := service.DefaultAuthServiceConfig()
+	config.VerificationURL = "https://chat.example.com/api/auth/verify"
+	config.Mailer = mail
+	authService := s.newAuthService(config)
+
+	user, accessToken, err := authService.Register("verifier", "verifier@example.com", "SecurePass123")
+	require.NoError(s.T(), err)
+	assert.False(s.T(), user.EmailVerified)
+	claims, err := utils.ValidateToken(accessToken, "test-secret-key")
+	require.NoError(s.T(), err)
+	assert.True(s.T(), claims.EmailUnverified)
+
+	require.Len(s.T(), mail.links, 1)
+	assert.True(s.T(), strings.HasPrefix(mail.links[0], "https://chat.example.com/api/auth/verify?token="))
+	firstToken := mail.token(s.T())
+
+	// Resending invalidates the first link
+	require.NoError(s.T(), authService.SendVerificationToken(user.ID))
+	assert.ErrorIs(s.T(), authService.VerifyEmail(firstToken), service.ErrInvalidVerification)
+	assert.ErrorIs(s.T(), authService.VerifyEmail("bogus"), service.ErrInvalidVerification)
+
+	token := mail.token(s.T())
+	require.NoError(s.T(), authService.VerifyEmail(token))
+	assert.ErrorIs(s.T(), authService.VerifyEmail(token), service.ErrInvalidVerification, "tokens are single-use")
+	assert.ErrorIs(s.T(), authService.SendVerificationToken(user.ID), service.ErrAlreadyVerified)
+
+	// A new login carries the verified status
+	user, accessToken, err = authService.Login("verifier@example.com", "SecurePass123")
+	require.NoError(s.T(), err)
+	assert.True(s.T(), user.EmailVerified)
+	claims, err = utils.ValidateToken(accessToken, "test-secret-key")
+	require.NoError(s.T(), err)
+	assert.False(s.T(), claims.EmailUnverified)
+}
+
+// TestResendVerification tests that a resend replaces the emailed link, that
+// unknown and verified emails get the same answer, and the per-email cooldown
+func (s *AuthServiceIntegrationTestSuite) TestResendVerification() {
+	testRedis := testutil.SetupTestRedis(s.T())
+	defer testRedis.Teardown(s.T())
+	redisBroker, err := broker.NewRedisMessageBroker(testRedis.URL, broker.BrokerConfig{})
+	require.NoError(s.T(), err)
+	defer redisBroker.Close()
+
+	mail := &captureMailer{}
+	config := service.DefaultAuthServiceConfig()
+	config.Mailer = mail
+	config.VerificationResendCooldown = time.Minute
+	authService := service.NewAuthService(
+		s.userRepo,
+		repository.NewMessageRepository(s.testDB.DB),
+		repository.NewAuditLogRepository(s.testDB.DB),
+		repository.NewRefreshTokenRepository(s.testDB.DB),
+		repository.NewVerificationTokenRepository(s.testDB.DB),
+		redisBroker,
+		"test-secret-key", time.Hour, "development", config,
+	)
+
+	_, _, err = authService.Register("forgetful", "forgetful@example.com", "SecurePass123")
+	require.NoError(s.T(), err)
+	require.Len(s.T(), mail.links, 1)
+	firstToken := mail.token(s.T())
+
+	s.Run("Resend replaces the link", func() {
+		require.NoError(s.T(), authService.ResendVerification("forgetful@example.com"))
+		require.Len(s.T(), mail.links, 2)
+		assert.ErrorIs(s.T(), authService.VerifyEmail(firstToken), service.ErrInvalidVerification)
+		require.NoError(s.T(), authService.VerifyEmail(mail.token(s.T())))
+	})
+
+	s.Run("Cooldown per email", func() {
+		err := authService.ResendVerification(" Forgetful@Example.com ")
+		assert.ErrorIs(s.T(), err, service.ErrResendTooSoon)
+		var cooldown *service.ResendCooldownError
+		require.ErrorAs(s.T(), err, &cooldown)
+		assert.Greater(s.T(), cooldown.RetryAfter, time.Duration(0))
+		assert.LessOrEqual(s.T(), cooldown.RetryAfter, time.Minute)
+
+		testRedis.Server.FastForward(time.Minute)
+		assert.NoError(s.T(), authService.ResendVerification("forgetful@example.com"), "Allowed once the cooldown is over")
+	})
+
+	s.Run("Unknown and verified emails answer alike", func() {
+		sent := len(mail.links)
+		assert.NoError(s.T(), authService.ResendVerification("nobody@example.com"))
+		assert.ErrorIs(s.T(), authService.ResendVerification("nobody@example.com"), service.ErrResendTooSoon)
+		assert.Len(s.T(), mail.links, sent, "No email for unknown or verified accounts")
+	})
+}
+
+// TestEmailVerificationExpiry tests that expired links are rejected
+func (s *AuthServiceIntegrationTestSuite) TestEmailVerificationExpiry() {
+	mail := &captureMailer{}
+	config := service.DefaultAuthServiceConfig()
+	config.VerificationTokenTTL = time.Millisecond
+	config.Mailer = mail
+	authService := s.newAuthService(config)
+
+	_, _, err := authService.Register("latecomer", "latecomer@example.com", "SecurePass123")
+	require.NoError(s.T(), err)
+	assert.True(s.T(), strings.HasPrefix(mail.links[0], "/api/auth/verify?token="))
+
+	time.Sleep(5 * time.Millisecond)
+	assert.ErrorIs(s.T(), authService.VerifyEmail(mail.token(s.T())), service.ErrInvalidVerification)
+}
+
+// TestEmailVerificationDisabled tests that accounts start verified when verification is off
+func (s *AuthServiceIntegrationTestSuite) TestEmailVerificationDisabled() {
+	mail := &captureMailer{}
+	config := service.DefaultAuthServiceConfig()
+	config.RequireEmailVerification = false
+	config.Mailer = mail
+	authService := s.newAuthService(config)
+
+	user, _, err := authService.Register("trusted", "trusted@example.com", "SecurePass123")
+	require.NoError(s.T(), err)
+	assert.True(s.T(), user.EmailVerified)
+	assert.Empty(s.T(), mail.links)
+}
+
+// TestLoginUpgradesWeakHash tests that a login rehashes a password stored
+// with older Argon2 parameters
+func (s *AuthServiceIntegrationTestSuite) TestLoginUpgradesWeakHash() {
+	authService := s.newAuthService(service.DefaultAuthServiceConfig())
+	user, _, err := authService.Register("oldtimer", "oldtimer@example.com", "SecurePass123")
+	require.NoError(s.T(), err)
+
+	// Replace the hash with one made with weaker parameters
+	salt := []byte("0123456789abcdef")
+	key := argon2.IDKey([]byte("SecurePass123"), salt, 1, 8*1024, 1, utils.KeyLength)
+	weak := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, 8*1024, 1, 1,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+	require.NoError(s.T(), s.testDB.DB.Model(&testutil.TestUser{}).Where("id = ?", user.ID.String()).
+		Update("password_hash", weak).Error)
+
+	_, _, err = authService.Login("oldtimer@example.com", "SecurePass123")
+	require.NoError(s.T(), err)
+
+	stored, err := s.userRepo.GetUserByID(user.ID)
+	require.NoError(s.T(), err)
+	assert.NotEqual(s.T(), weak, stored.PasswordHash)
+	assert.False(s.T(), utils.NeedsRehash(stored.PasswordHash))
+
+	// The upgraded hash verifies the same password
+	_, _, err = authService.Login("oldtimer@example.com", "SecurePass123")
+	assert.NoError(s.T(), err)
+}
+
+// TestSuite runs all tests in the suite
+func TestAuthServiceIntegrationTestSuite(t *testing.T) {
+	suite.Run(t, new(AuthServiceIntegrationTestSuite))
+}
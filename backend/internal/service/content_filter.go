@@ -0,0 +1,150 @@
+package service
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Baaaki/digital-square/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// Content filter modes, selected with NewBlocklistFilter
+const (
+	ContentFilterReject = "reject" // Refuse the message with ErrBlockedContent (default)
+	ContentFilterMask   = "mask"   // Replace each blocked word with asterisks
+)
+
+// ContentFilter checks message content before it is stored. It gets the
+// sanitized (HTML-escaped) content and returns what to store instead, or an
+// error wrapping ErrBlockedContent to refuse the message.
+type ContentFilter interface {
+	Filter(content string) (string, error)
+}
+
+// BlocklistFilter is a ContentFilter over a list of words. Matching is
+// case-insensitive and on whole words only: a blocked word inside a longer
+// one (the Scunthorpe problem) is left alone.
+type BlocklistFilter struct {
+	words map[string]struct{} // Lowercased
+	mode  string
+}
+
+// NewBlocklistFilter builds a filter for words. Unknown modes reject, so a
+// typo never lets blocked words through. Entries with spaces or punctuation
+// ("dang it", "o'clock") could never match a single word, so they are
+// skipped with a warning.
+func NewBlocklistFilter(words []string, mode string) *BlocklistFilter {
+	f := &BlocklistFilter{
+		words: make(map[string]struct{}, len(words)),
+		mode:  mode,
+	}
+	if mode != ContentFilterMask {
+		f.mode = ContentFilterReject
+	}
+	for _, word := range words {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word == "" {
+			continue
+		}
+		if strings.IndexFunc(word, func(r rune) bool { return !isWordRune(r) }) >= 0 {
+			logger.Log.Warn("Ignoring blocklist entry that is not a single word",
+				zap.String("entry", word),
+			)
+			continue
+		}
+		f.words[word] = struct{}{}
+	}
+	return f
+}
+
+// Mode returns the effective mode (ContentFilterReject or ContentFilterMask)
+func (f *BlocklistFilter) Mode() string {
+	return f.mode
+}
+
+// Len returns the number of distinct words blocked
+func (f *BlocklistFilter) Len() int {
+	return len(f.words)
+}
+
+// LoadBlocklist reads a blocklist file: one word per line, blank lines and
+// lines starting with # are skipped
+func LoadBlocklist(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	return words, scanner.Err()
+}
+
+// isWordRune reports whether r belongs to a word for blocklist matching.
+// Anything else (spaces, punctuation, "_") separates words.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+}
+
+// Filter implements ContentFilter
+func (f *BlocklistFilter) Filter(content string) (string, error) {
+	if len(f.words) == 0 {
+		return content, nil
+	}
+
+	var masked strings.Builder
+	last := 0 // End of the content already copied to masked
+	for start := 0; start < len(content); {
+		r, size := utf8.DecodeRuneInString(content[start:])
+		if !isWordRune(r) {
+			start += size
+			continue
+		}
+
+		end := start + size
+		for end < len(content) {
+			r, size = utf8.DecodeRuneInString(content[end:])
+			if !isWordRune(r) {
+				break
+			}
+			end += size
+		}
+
+		word := content[start:end]
+		if _, blocked := f.words[strings.ToLower(word)]; blocked && !isEntity(content, start, end) {
+			if f.mode == ContentFilterReject {
+				return "", ErrBlockedContent
+			}
+			masked.WriteString(content[last:start])
+			masked.WriteString(strings.Repeat("*", utf8.RuneCountInString(word)))
+			last = end
+		}
+		start = end
+	}
+
+	if last == 0 {
+		return content, nil
+	}
+	masked.WriteString(content[last:])
+	return masked.String(), nil
+}
+
+// isEntity reports whether content[start:end] is the name or number of an
+// HTML entity added by escaping (&amp; &#39;), which must stay intact
+func isEntity(content string, start, end int) bool {
+	if end >= len(content) || content[end] != ';' {
+		return false
+	}
+	return strings.HasSuffix(content[:start], "&") || strings.HasSuffix(content[:start], "&#")
+}
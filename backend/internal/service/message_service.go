@@ -3,42 +3,426 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
 	"html"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
 	"unicode/utf8"
 
 	"github.com/Baaaki/digital-square/internal/broker"
 	"github.com/Baaaki/digital-square/internal/models"
+	"github.com/Baaaki/digital-square/internal/moderation"
 	"github.com/Baaaki/digital-square/internal/repository"
+	"github.com/Baaaki/digital-square/internal/utils"
 	"github.com/Baaaki/digital-square/internal/wal"
 	"github.com/Baaaki/digital-square/pkg/logger"
+	"github.com/Baaaki/digital-square/pkg/metrics"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 var (
 	ErrMessageNotFound = errors.New("message not found")
 	ErrUnauthorized    = errors.New("unauthorized to delete this message")
-	ErrMessageTooLong  = errors.New("message too long (max 5000 characters)")
+	ErrMessageTooLong  = errors.New("message too long")
 	ErrMessageTooShort = errors.New("message cannot be empty")
+	ErrAccountTooNew   = errors.New("account is too new to send messages")
+	ErrUserMuted       = errors.New("you are muted")
+	ErrUserNotFound    = errors.New("user not found")
+	ErrNotDeleted      = errors.New("message is not deleted")
+	ErrNothingToDelete = errors.New("you have no messages to delete")
+	ErrRestoreDenied   = errors.New("only messages you deleted yourself can be restored")
+	ErrTooManySeen     = fmt.Errorf("at most %d message IDs per read receipt", maxSeenBatch)
+	ErrInvalidSeenID   = errors.New("invalid message ID in read receipt")
+	ErrInvalidRoom     = errors.New("invalid room (1-32 characters: a-z, 0-9, '-' or '_')")
+	ErrInvalidClientID = fmt.Errorf("client_msg_id must be at most %d characters", maxClientMsgIDLength)
+
+	ErrMessageBlocked        = errors.New("message blocked by moderation")
+	ErrModerationUnavailable = errors.New("moderation is unavailable, try again later")
+	ErrBlockedContent        = errors.New("message contains blocked words")
+
+	ErrSearchOffsetTooLarge = errors.New("search offset too large, narrow the search instead")
+	ErrEmptySearch          = errors.New("search query is required")
+
+	ErrInvalidEmoji     = errors.New("unsupported reaction emoji")
+	ErrAlreadyReacted   = errors.New("you already reacted with this emoji")
+	ErrReactionNotFound = errors.New("you have not reacted with this emoji")
+)
+
+// maxSeenBatch caps message IDs per read receipt (one screen of history)
+const maxSeenBatch = 100
+
+// maxClientMsgIDLength caps client-generated idempotency keys (a UUID fits)
+const maxClientMsgIDLength = 64
+
+// MaxPageSize caps how many messages one history page may request
+const MaxPageSize = 100
+
+// Search caps applied when MessageServiceConfig leaves them unset
+const (
+	defaultSearchLimit       = 50
+	defaultSearchMaxLimit    = 100
+	defaultSearchMaxIDsLimit = 1000
+	defaultSearchMaxOffset   = 10000
 )
 
+// Room total cache settings applied when MessageServiceConfig leaves them unset
+const (
+	defaultRoomCountCacheKey = "room_count:"
+	defaultRoomCountCacheTTL = 30 * time.Second
+)
+
+// Batch writer settings applied when MessageServiceConfig leaves them unset
+const (
+	defaultBatchWriterInterval   = 1 * time.Minute
+	defaultBatchWriterMaxPending = 500
+)
+
+// defaultMaxMessageLength is the content limit in characters (runes)
+// when MessageServiceConfig leaves it unset
+const defaultMaxMessageLength = 5000
+
+// defaultSendIdempotencyTTL is how long Redis remembers a client message key
+// when MessageServiceConfig leaves it unset
+const defaultSendIdempotencyTTL = 10 * time.Minute
+
+// ReactionEmojis is the allowlist of emojis users can react with
+var ReactionEmojis = []string{"👍", "👎", "❤️", "😂", "😮", "😢", "😡", "🎉", "🔥", "👀"}
+
+// roomIDPattern keeps room IDs safe to embed in Redis keys and URLs
+var roomIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// ResolveRoomID returns the room to use for a client-supplied ID:
+// models.DefaultRoomID when empty, ErrInvalidRoom when malformed.
+func ResolveRoomID(roomID string) (string, error) {
+	if roomID == "" {
+		return models.DefaultRoomID, nil
+	}
+	if !roomIDPattern.MatchString(roomID) {
+		return "", ErrInvalidRoom
+	}
+	return roomID, nil
+}
+
+// isNameRune reports whether r can continue a username or address in a mention
+func isNameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// maxMentionsPerMessage caps how many users one message can notify
+const maxMentionsPerMessage = 10
+
+// mentionPattern matches @name: name runes, optionally joined by . or -
+// (so a trailing "." or "-" is punctuation, not part of the name)
+var mentionPattern = regexp.MustCompile(`@([\p{L}\p{N}_]+(?:[.-][\p{L}\p{N}_]+)*)`)
+
+// codePattern matches `inline code` and ```fenced``` blocks, where an @ is
+// code rather than a mention
+var codePattern = regexp.MustCompile("(?s)```.*?```|`[^`\n]*`")
+
+// ExtractMentions returns the distinct usernames @mentioned in content,
+// lowercased, in order of appearance and at most maxMentionsPerMessage.
+// It works on sanitized (HTML-escaped) content: escaping never produces an
+// @, and entities (&amp; &#39;...) end in ";" so they can't run into a name.
+// An @ right after a name rune or "/" (addresses, URLs) or inside code is
+// not a mention.
+func ExtractMentions(content string) []string {
+	if !strings.Contains(content, "@") {
+		return nil
+	}
+	content = codePattern.ReplaceAllString(content, " ")
+
+	var names []string
+	for _, match := range mentionPattern.FindAllStringSubmatchIndex(content, -1) {
+		before, _ := utf8.DecodeLastRuneInString(content[:match[0]])
+		if match[0] > 0 && (isNameRune(before) || before == '/') {
+			continue
+		}
+		name := strings.ToLower(content[match[2]:match[3]])
+		if slices.Contains(names, name) {
+			continue
+		}
+		names = append(names, name)
+		if len(names) == maxMentionsPerMessage {
+			break
+		}
+	}
+	return names
+}
+
+// MessageServiceConfig holds tunable message sending rules
+type MessageServiceConfig struct {
+	MaxMessageLength       int           // Max content length in characters, Unicode-aware (0 = 5000)
+	MinAccountAge          time.Duration // Minimum account age before sending (0 = disabled, admins exempt)
+	TrimWhitespace         bool          // Trim leading/trailing whitespace
+	MaxConsecutiveNewlines int           // Collapse longer runs of blank lines (0 = disabled)
+	DetectLanguage         bool          // Tag messages with a detected language
+
+	Moderator          moderation.Moderator // External content check before accepting (nil = disabled)
+	ContentFilter      ContentFilter        // Blocklist check on sanitized content, rejects or masks (nil = disabled)
+	ModerationFailOpen bool                 // Accept messages when the moderator errors or times out
+
+	// Admin search caps: larger limits are clamped, deeper offsets rejected
+	SearchMaxLimit    int // Max messages per page (0 = 100)
+	SearchMaxIDsLimit int // Max IDs per page in IDs-only mode (0 = 1000)
+	SearchMaxOffset   int // Max offset (0 = 10000)
+
+	// Room totals for history pages, cached so paging doesn't COUNT every time
+	RoomCountCacheKey string        // Key prefix, the room ID is appended (empty = "room_count:")
+	RoomCountCacheTTL time.Duration // How long a total may be stale (0 = 30s)
+
+	// Batch writer (WAL → PostgreSQL). Accepted messages are fsynced to the WAL
+	// and survive a process crash (replayed on boot), but until the next batch
+	// they exist only on this node's disk: for at most BatchWriterInterval, or
+	// less once BatchWriterMaxPending messages have queued up.
+	BatchWriterInterval   time.Duration // Periodic flush, the floor under adaptive flushes (0 = 1m)
+	BatchWriterMaxPending int           // Flush early once this many sends are pending (0 = 500)
+
+	// Retried sends with the same client message key return the first message.
+	// Redis catches quick retries; later ones hit the message_id constraint.
+	SendIdempotencyTTL time.Duration // How long Redis remembers a key (0 = 10m)
+
+	Metrics *metrics.Metrics // Delete, WAL backlog and batch write metrics (nil = disabled)
+}
+
+// CacheStats counts recent-messages reads served by Redis (hits) and by
+// PostgreSQL (misses, including Redis errors) since startup
+type CacheStats struct {
+	Hits     uint64  `json:"cache_hits_total"`
+	Misses   uint64  `json:"cache_misses_total"`
+	HitRatio float64 `json:"hit_ratio"` // Hits / (Hits + Misses), 0 before the first read
+}
+
+// SearchResult is one page of a message search. Either Messages or IDs is
+// set, depending on the IDs-only mode.
+type SearchResult struct {
+	Messages []models.Message
+	IDs      []uint64
+	Total    int64 // All matches, regardless of paging
+	Limit    int   // Effective page size after clamping
+	Offset   int
+}
+
 type MessageService struct {
 	messageRepo *repository.MessageRepository //for database
+	userRepo    *repository.UserRepository    // for sender checks (account age, role)
 	broker      broker.MessageBroker          // for pub/sub
 	wal         *wal.WAL                      // for wal, you know :D
+	config      MessageServiceConfig
+	newlineRun  *regexp.Regexp // Matches runs longer than MaxConsecutiveNewlines (nil = disabled)
+	batchMu     sync.Mutex     // Serializes processBatch (ticker vs Flush) so Cleanups don't race
+	pending     atomic.Int64   // Sends since the last batch started (adaptive flush trigger)
+	flushNow    chan struct{}  // Signalled when pending reaches BatchWriterMaxPending
+
+	cacheHits   atomic.Uint64 // GetRecentMessages served from Redis
+	cacheMisses atomic.Uint64 // GetRecentMessages that fell back to PostgreSQL
 }
 
 func NewMessageService(
 	messageRepo *repository.MessageRepository,
+	userRepo *repository.UserRepository,
 	broker broker.MessageBroker,
 	wal *wal.WAL,
+	config MessageServiceConfig,
 ) *MessageService {
-	return &MessageService{
+	s := &MessageService{
 		messageRepo: messageRepo,
+		userRepo:    userRepo,
 		broker:      broker,
 		wal:         wal,
+		config:      config,
+		flushNow:    make(chan struct{}, 1),
+	}
+	if config.MaxMessageLength <= 0 {
+		s.config.MaxMessageLength = defaultMaxMessageLength
+	}
+	if config.SearchMaxLimit <= 0 {
+		s.config.SearchMaxLimit = defaultSearchMaxLimit
+	}
+	if config.SearchMaxIDsLimit <= 0 {
+		s.config.SearchMaxIDsLimit = defaultSearchMaxIDsLimit
+	}
+	if config.SearchMaxOffset <= 0 {
+		s.config.SearchMaxOffset = defaultSearchMaxOffset
+	}
+	if config.RoomCountCacheKey == "" {
+		s.config.RoomCountCacheKey = defaultRoomCountCacheKey
+	}
+	if config.RoomCountCacheTTL <= 0 {
+		s.config.RoomCountCacheTTL = defaultRoomCountCacheTTL
+	}
+	if config.BatchWriterInterval <= 0 {
+		s.config.BatchWriterInterval = defaultBatchWriterInterval
+	}
+	if config.BatchWriterMaxPending <= 0 {
+		s.config.BatchWriterMaxPending = defaultBatchWriterMaxPending
+	}
+	if config.SendIdempotencyTTL <= 0 {
+		s.config.SendIdempotencyTTL = defaultSendIdempotencyTTL
+	}
+	if config.MaxConsecutiveNewlines > 0 {
+		// N+1 or more newlines, allowing whitespace-only lines in between
+		s.newlineRun = regexp.MustCompile(fmt.Sprintf(`\n(?:[ \t]*\n){%d,}`, config.MaxConsecutiveNewlines))
+	}
+	return s
+}
+
+// MaxMessageLength returns the effective content limit in characters (runes)
+func (s *MessageService) MaxMessageLength() int {
+	return s.config.MaxMessageLength
+}
+
+// IsActiveUser reports whether the account exists and is not banned. Banned
+// accounts are soft-deleted, so GetUserByID doesn't find them; their access
+// tokens stay valid until they expire, so paths that act for a user check this.
+func (s *MessageService) IsActiveUser(userID uuid.UUID) (bool, error) {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return false, err
+	}
+	return user != nil, nil
+}
+
+// checkSender rejects banned senders with ErrUserBanned: the ban soft-deletes
+// the account but its access token stays valid until it expires. With
+// MinAccountAge set it also rejects accounts younger than that; the error then
+// wraps ErrAccountTooNew and includes the remaining wait.
+func (s *MessageService) checkSender(userID uuid.UUID) error {
+	if userID == models.SystemUserID {
+		return nil // Announcements, already admin-gated
+	}
+
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserBanned
+	}
+	if s.config.MinAccountAge <= 0 || user.Role == models.RoleAdmin || user.Role == models.RoleSystem {
+		return nil
+	}
+
+	age := time.Since(user.CreatedAt)
+	if age < s.config.MinAccountAge {
+		remaining := (s.config.MinAccountAge - age).Round(time.Second)
+		return fmt.Errorf("%w: try again in %s", ErrAccountTooNew, remaining)
+	}
+
+	return nil
+}
+
+// checkMuted rejects senders with an active mute. Fails open on Redis
+// errors, like the rate limiter: muted users can read either way.
+func (s *MessageService) checkMuted(userID uuid.UUID) error {
+	until, err := s.broker.GetMutedUntil(userID.String())
+	if err != nil {
+		logger.Log.Warn("Mute check failed",
+			zap.String("user_id", userID.String()),
+			zap.Error(err),
+		)
+		return nil
+	}
+	if until.IsZero() {
+		return nil
+	}
+	return fmt.Errorf("%w until %s", ErrUserMuted, until.UTC().Format(time.RFC3339))
+}
+
+// moderate asks the configured moderator about a message. Blocked messages
+// return ErrMessageBlocked; flagged ones are accepted and logged for review.
+// When the moderator fails, ModerationFailOpen decides whether to accept.
+func (s *MessageService) moderate(messageID string, userID uuid.UUID, username, roomID, content string) error {
+	if s.config.Moderator == nil {
+		return nil
+	}
+
+	result, err := s.config.Moderator.Moderate(context.Background(), moderation.Request{
+		MessageID: messageID,
+		UserID:    userID.String(),
+		Username:  username,
+		RoomID:    roomID,
+		Content:   content,
+	})
+	if err != nil {
+		logger.Log.Warn("Moderation check failed",
+			zap.String("message_id", messageID),
+			zap.String("user_id", userID.String()),
+			zap.Bool("fail_open", s.config.ModerationFailOpen),
+			zap.Error(err),
+		)
+		if s.config.ModerationFailOpen {
+			return nil
+		}
+		return ErrModerationUnavailable
+	}
+
+	switch result.Decision {
+	case moderation.DecisionBlock:
+		logger.Log.Info("Message blocked by moderation",
+			zap.String("message_id", messageID),
+			zap.String("user_id", userID.String()),
+			zap.String("reason", result.Reason),
+		)
+		if result.Reason != "" {
+			return fmt.Errorf("%w: %s", ErrMessageBlocked, result.Reason)
+		}
+		return ErrMessageBlocked
+	case moderation.DecisionFlag:
+		logger.Log.Warn("Message flagged by moderation",
+			zap.String("message_id", messageID),
+			zap.String("user_id", userID.String()),
+			zap.String("room_id", roomID),
+			zap.String("reason", result.Reason),
+		)
+	}
+	return nil
+}
+
+// filterContent runs the configured ContentFilter on sanitized content and
+// returns what to store (masked in mask mode)
+func (s *MessageService) filterContent(messageID string, userID uuid.UUID, content string) (string, error) {
+	if s.config.ContentFilter == nil {
+		return content, nil
+	}
+
+	filtered, err := s.config.ContentFilter.Filter(content)
+	if err != nil {
+		logger.Log.Info("Message blocked by content filter",
+			zap.String("message_id", messageID),
+			zap.String("user_id", userID.String()),
+			zap.Error(err),
+		)
+		return "", err
+	}
+	return filtered, nil
+}
+
+// normalizeContent applies the configured whitespace cleanup. It runs before
+// validation so whitespace-only messages are rejected as empty.
+func (s *MessageService) normalizeContent(content string) string {
+	if s.newlineRun != nil {
+		content = strings.ReplaceAll(content, "\r\n", "\n")
+		content = s.newlineRun.ReplaceAllString(content, strings.Repeat("\n", s.config.MaxConsecutiveNewlines))
+	}
+	if s.config.TrimWhitespace {
+		content = strings.TrimSpace(content)
+	}
+	return content
+}
+
+// detectLanguage tags the (unescaped) content, or returns "" when detection is off
+func (s *MessageService) detectLanguage(content string) string {
+	if !s.config.DetectLanguage {
+		return ""
 	}
+	return utils.DetectLanguage(content)
 }
 
 // validateMessageContent validates message content for security and length constraints
@@ -48,9 +432,9 @@ func (s *MessageService) validateMessageContent(content string) error {
 		return ErrMessageTooShort
 	}
 
-	// 2. Max length check (5000 characters, Unicode-aware)
-	if utf8.RuneCountInString(content) > 5000 {
-		return ErrMessageTooLong
+	// 2. Max length check (MaxMessageLength characters, Unicode-aware)
+	if utf8.RuneCountInString(content) > s.config.MaxMessageLength {
+		return fmt.Errorf("%w (max %d characters)", ErrMessageTooLong, s.config.MaxMessageLength)
 	}
 
 	// 3. Minimum length check (at least 1 character)
@@ -61,23 +445,111 @@ func (s *MessageService) validateMessageContent(content string) error {
 	return nil
 }
 
-func (s *MessageService) SendMessage(userID uuid.UUID, username, content string) (*models.Message, error) {
+func (s *MessageService) SendMessage(userID uuid.UUID, username, roomID, content string) (*models.Message, error) {
+	msg, _, err := s.SendMessageOnce(userID, username, roomID, content, "")
+	return msg, err
+}
+
+// clientMessageID derives the message ID of a send with a client message key
+func clientMessageID(userID uuid.UUID, clientMsgID string) string {
+	return uuid.NewSHA1(userID, []byte(clientMsgID)).String()
+}
+
+// FindSentMessage returns the message the user already sent with a client
+// message key (nil = none, or no key), looking in Redis and then the database
+// like SendMessageOnce. Handlers answer retries with it before applying the
+// send limits, which are only meant for new messages.
+func (s *MessageService) FindSentMessage(userID uuid.UUID, clientMsgID string) (*models.Message, error) {
+	if clientMsgID == "" || len(clientMsgID) > maxClientMsgIDLength {
+		return nil, nil
+	}
+
+	original, err := s.broker.GetSend(userID.String(), clientMsgID)
+	if err != nil {
+		logger.Log.Warn("Failed to look up client message key",
+			zap.String("user_id", userID.String()),
+			zap.Error(err),
+		)
+	} else if original != nil {
+		return original, nil
+	}
+
+	return s.messageRepo.GetByMessageIDUnscoped(clientMessageID(userID, clientMsgID))
+}
+
+// SendMessageOnce is SendMessage with a client-generated idempotency key.
+// A retry with a key the user already sent returns the original message and
+// duplicate = true instead of writing a new one (nothing to broadcast again).
+// An empty key always sends.
+func (s *MessageService) SendMessageOnce(userID uuid.UUID, username, roomID, content, clientMsgID string) (msg *models.Message, duplicate bool, err error) {
 	start := time.Now()
 	messageID := uuid.New().String()
-	now := time.Now()
+	now := time.Now() // Server clock only - clients can never set CreatedAt
+
+	if len(clientMsgID) > maxClientMsgIDLength {
+		return nil, false, ErrInvalidClientID
+	}
+	if clientMsgID != "" {
+		// Same user + key = same message ID, so the unique constraint on
+		// message_id dedupes retries Redis no longer remembers
+		messageID = clientMessageID(userID, clientMsgID)
+		original, err := s.messageRepo.GetByMessageIDUnscoped(messageID)
+		if err != nil {
+			return nil, false, err
+		}
+		if original != nil {
+			logger.Log.Info("Duplicate send ignored (already persisted)",
+				zap.String("message_id", messageID),
+				zap.String("user_id", userID.String()),
+			)
+			return original, true, nil
+		}
+	}
+
+	roomID, err = ResolveRoomID(roomID)
+	if err != nil {
+		return nil, false, err
+	}
 
-	// 1. VALIDATE INPUT (length, empty check)
+	// 1. NORMALIZE + VALIDATE INPUT (whitespace cleanup, length, empty check)
+	content = s.normalizeContent(content)
 	if err := s.validateMessageContent(content); err != nil {
 		logger.Log.Warn("Message validation failed",
 			zap.String("user_id", userID.String()),
 			zap.Int("content_length", utf8.RuneCountInString(content)),
 			zap.Error(err),
 		)
-		return nil, err
+		return nil, false, err
+	}
+
+	// 2. SENDER CHECKS (banned, account age with admins exempt, mute)
+	if err := s.checkSender(userID); err != nil {
+		logger.Log.Warn("Message rejected: sender not allowed",
+			zap.String("user_id", userID.String()),
+			zap.Error(err),
+		)
+		return nil, false, err
 	}
 
-	// 2. SANITIZE CONTENT (XSS Prevention)
-	sanitizedContent := html.EscapeString(content)
+	if err := s.checkMuted(userID); err != nil {
+		logger.Log.Warn("Message rejected: sender is muted",
+			zap.String("user_id", userID.String()),
+			zap.Error(err),
+		)
+		return nil, false, err
+	}
+
+	// 3. EXTERNAL MODERATION (on the text as typed, before escaping)
+	if err := s.moderate(messageID, userID, username, roomID, content); err != nil {
+		return nil, false, err
+	}
+
+	// 4. SANITIZE CONTENT (XSS Prevention), then apply the blocklist
+	sanitizedContent, err := s.filterContent(messageID, userID, html.EscapeString(content))
+	if err != nil {
+		return nil, false, err
+	}
+	lang := s.detectLanguage(content)
 
 	logger.Log.Debug("Processing message send",
 		zap.String("user_id", userID.String()),
@@ -86,20 +558,43 @@ func (s *MessageService) SendMessage(userID uuid.UUID, username, content string)
 		zap.Int("sanitized_length", len(sanitizedContent)),
 	)
 
-	msg := &models.Message{
+	msg = &models.Message{
 		MessageID: messageID,
 		UserID:    userID,
 		Username:  username, // ✅ Store username (denormalized for performance)
+		RoomID:    roomID,
 		Content:   sanitizedContent, // ✅ Sanitized content (not original)
+		Lang:      lang,
 		CreatedAt: now,
 	}
 
+	// 5. CLAIM THE KEY (a concurrent or recent retry returns the first send;
+	//    fail open on Redis errors - the message_id constraint still dedupes)
+	if clientMsgID != "" {
+		original, err := s.broker.ClaimSend(userID.String(), clientMsgID, *msg, s.config.SendIdempotencyTTL)
+		if err != nil {
+			logger.Log.Warn("Failed to claim client message key",
+				zap.String("message_id", messageID),
+				zap.Error(err),
+			)
+		} else if original != nil {
+			logger.Log.Info("Duplicate send ignored (retry)",
+				zap.String("message_id", original.MessageID),
+				zap.String("user_id", userID.String()),
+			)
+			return original, true, nil
+		}
+	}
+
 	// 1. Write to WAL FIRST (sync - durability, crash recovery)
 	walStart := time.Now()
 	walEntry := wal.WALEntry{
 		MessageID: msg.MessageID,
 		UserID:    msg.UserID.String(),
+		Username:  msg.Username,
+		RoomID:    msg.RoomID,
 		Content:   msg.Content,
+		Lang:      msg.Lang,
 		Timestamp: msg.CreatedAt,
 	}
 	if err := s.wal.Write(walEntry); err != nil {
@@ -107,9 +602,20 @@ func (s *MessageService) SendMessage(userID uuid.UUID, username, content string)
 			zap.String("message_id", messageID),
 			zap.Error(err),
 		)
-		return nil, err
+		if clientMsgID != "" {
+			// Nothing was sent, so a retry must be able to go through
+			if err := s.broker.ReleaseSend(userID.String(), clientMsgID); err != nil {
+				logger.Log.Warn("Failed to release client message key",
+					zap.String("message_id", messageID),
+					zap.Error(err),
+				)
+			}
+		}
+		return nil, false, err
 	}
 	walDuration := time.Since(walStart)
+	s.config.Metrics.AddWALPending(1)
+	s.notePending()
 
 	logger.Log.Info("Message written to WAL",
 		zap.String("message_id", messageID),
@@ -134,19 +640,86 @@ func (s *MessageService) SendMessage(userID uuid.UUID, username, content string)
 		}
 	}()
 
-	// 3. WebSocket handler will broadcast to all connected clients (in-memory)
-	//    PostgreSQL write will be handled by Batch Writer (every 1 minute)
+	// 3. Record mentions asynchronously (notification feed for offline users)
+	if usernames := ExtractMentions(msg.Content); len(usernames) > 0 {
+		go s.recordMentions(*msg, usernames)
+	}
+
+	// 4. WebSocket handler will broadcast to all connected clients (in-memory)
+	//    PostgreSQL write will be handled by Batch Writer (every BatchWriterInterval, earlier under load)
 
-	return msg, nil
+	return msg, false, nil
+}
+
+// recordMentions stores a mention for each existing user named in the
+// message. Unknown names are ignored, as are the author and the system user.
+func (s *MessageService) recordMentions(msg models.Message, usernames []string) {
+	users, err := s.userRepo.GetUsersByUsernames(usernames)
+	if err != nil {
+		logger.Log.Warn("Failed to resolve mentioned users",
+			zap.String("message_id", msg.MessageID),
+			zap.Error(err),
+		)
+		return
+	}
+
+	mentions := make([]models.Mention, 0, len(users))
+	for _, user := range users {
+		if user.ID == msg.UserID || user.Role == models.RoleSystem {
+			continue
+		}
+		mentions = append(mentions, models.Mention{
+			MessageID:      msg.MessageID,
+			UserID:         user.ID,
+			AuthorID:       msg.UserID,
+			AuthorUsername: msg.Username,
+			RoomID:         msg.RoomID,
+			CreatedAt:      msg.CreatedAt,
+		})
+	}
+
+	if err := s.messageRepo.CreateMentions(mentions); err != nil {
+		logger.Log.Warn("Failed to record mentions",
+			zap.String("message_id", msg.MessageID),
+			zap.Int("mention_count", len(mentions)),
+			zap.Error(err),
+		)
+	}
+}
+
+// GetUnreadMentions returns the user's unread mentions (newest first)
+func (s *MessageService) GetUnreadMentions(userID uuid.UUID, limit int) ([]models.Mention, error) {
+	return s.messageRepo.GetUnreadMentions(userID, limit)
+}
+
+// MarkMentionsRead marks the user's mentions with the given IDs as read, or
+// all unread ones when ids is empty. Returns how many were marked.
+func (s *MessageService) MarkMentionsRead(userID uuid.UUID, ids []uint64) (int64, error) {
+	return s.messageRepo.MarkMentionsRead(userID, ids, time.Now())
+}
+
+// SendSystemMessage sends an announcement to a room, authored by the system user.
+// It goes through the same validation, WAL and cache path as user messages;
+// regular users can't delete it because they don't own it.
+func (s *MessageService) SendSystemMessage(roomID, content string) (*models.Message, error) {
+	return s.SendMessage(models.SystemUserID, models.SystemUsername, roomID, content)
 }
 
-func (s *MessageService) GetRecentMessages(limit int) ([]models.Message, error) {
+// GetRecentMessages returns a room's latest messages (Redis cache, PostgreSQL on a miss)
+func (s *MessageService) GetRecentMessages(roomID string, limit int) ([]models.Message, error) {
 	start := time.Now()
 
+	roomID, err := ResolveRoomID(roomID)
+	if err != nil {
+		return nil, err
+	}
+
 	// Try Redis cache first (updated in real-time)
-	cachedMsgs, err := s.broker.GetRecentMessages(limit)
+	cachedMsgs, err := s.broker.GetRecentMessages(roomID, limit)
 	if err == nil && len(cachedMsgs) > 0 {
+		s.cacheHits.Add(1)
 		logger.Log.Debug("Cache HIT: Retrieved messages from Redis",
+			zap.String("room_id", roomID),
 			zap.Int("message_count", len(cachedMsgs)),
 			zap.Duration("duration", time.Since(start)),
 		)
@@ -154,12 +727,14 @@ func (s *MessageService) GetRecentMessages(limit int) ([]models.Message, error)
 	}
 
 	// Cache miss - fallback to PostgreSQL
+	s.cacheMisses.Add(1)
 	logger.Log.Debug("Cache MISS: Fetching from PostgreSQL",
+		zap.String("room_id", roomID),
 		zap.Int("limit", limit),
 	)
 
 	dbStart := time.Now()
-	messages, err := s.messageRepo.GetRecentMessages(limit)
+	messages, err := s.messageRepo.GetRecentMessages(roomID, limit)
 	if err != nil {
 		logger.Log.Error("Failed to get recent messages from PostgreSQL",
 			zap.Error(err),
@@ -173,14 +748,21 @@ func (s *MessageService) GetRecentMessages(limit int) ([]models.Message, error)
 		zap.Duration("total_duration", time.Since(start)),
 	)
 
-	// Warm up Redis cache for next connection
+	// Warm up Redis cache for next connection (one atomic swap, newest first
+	// like the DB result; CacheMessages would append to whatever a concurrent
+	// send already pushed and duplicate it)
 	if len(messages) > 0 {
 		go func() {
 			warmupStart := time.Now()
-			for _, msg := range messages {
-				s.broker.CacheMessage(msg)
+			if err := s.broker.ReplaceRecent(roomID, messages); err != nil {
+				logger.Log.Warn("Failed to warm up Redis cache",
+					zap.String("room_id", roomID),
+					zap.Error(err),
+				)
+				return
 			}
 			logger.Log.Info("Warmed up Redis cache",
+				zap.String("room_id", roomID),
 				zap.Int("message_count", len(messages)),
 				zap.Duration("warmup_duration", time.Since(warmupStart)),
 			)
@@ -190,77 +772,667 @@ func (s *MessageService) GetRecentMessages(limit int) ([]models.Message, error)
 	return messages, nil
 }
 
-func (s *MessageService) GetMessagesBefore(beforeID uint64, limit int, isAdmin bool) ([]models.Message, error) {
-	return s.messageRepo.GetMessagesBefore(beforeID, limit)
+// CacheStats returns the recent-messages cache hit/miss counters
+func (s *MessageService) CacheStats() CacheStats {
+	stats := CacheStats{
+		Hits:   s.cacheHits.Load(),
+		Misses: s.cacheMisses.Load(),
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(total)
+	}
+	return stats
 }
 
-func (s *MessageService) DeleteMessage(messageID string, userID uuid.UUID, isAdmin bool) error {
-	start := time.Now()
+// PurgeCache clears the Redis recent-messages cache (e.g. after corrupted entries).
+// The next GetRecentMessages falls back to PostgreSQL and re-warms the cache.
+func (s *MessageService) PurgeCache() error {
+	if err := s.broker.PurgeCache(); err != nil {
+		logger.Log.Error("Failed to purge Redis cache", zap.Error(err))
+		return err
+	}
 
-	logger.Log.Debug("Processing message delete",
-		zap.String("message_id", messageID),
-		zap.String("user_id", userID.String()),
-		zap.Bool("is_admin", isAdmin),
-	)
+	logger.Log.Info("Redis cache purged")
+	return nil
+}
 
-	msg, err := s.messageRepo.GetByMessageID(messageID)
-	if err != nil {
-		logger.Log.Warn("Message not found for deletion",
-			zap.String("message_id", messageID),
-			zap.Error(err),
-		)
-		return ErrMessageNotFound
+// MarkSeen records a read receipt for the given messages
+func (s *MessageService) MarkSeen(userID uuid.UUID, messageIDs []string) error {
+	if len(messageIDs) == 0 {
+		return nil
 	}
-
-	if !isAdmin && msg.UserID != userID {
-		logger.Log.Warn("Unauthorized delete attempt",
-			zap.String("message_id", messageID),
-			zap.String("requesting_user_id", userID.String()),
-			zap.String("message_owner_id", msg.UserID.String()),
-		)
-		return ErrUnauthorized
+	if len(messageIDs) > maxSeenBatch {
+		return ErrTooManySeen
+	}
+	// Only well-formed IDs get a Redis key
+	for _, id := range messageIDs {
+		if _, err := uuid.Parse(id); err != nil {
+			return ErrInvalidSeenID
+		}
 	}
 
-	deletedBy := userID
-	isDeletedByAdmin := isAdmin
+	return s.broker.MarkSeen(userID.String(), messageIDs)
+}
 
-	if err := s.messageRepo.SoftDeleteMessage(msg.ID, deletedBy, isDeletedByAdmin); err != nil {
-		logger.Log.Error("Failed to soft delete message",
-			zap.String("message_id", messageID),
-			zap.Error(err),
-		)
-		return err
+// GetSeenCounts returns approximate distinct viewer counts keyed by message ID
+func (s *MessageService) GetSeenCounts(messageIDs []string) (map[string]int64, error) {
+	if len(messageIDs) == 0 {
+		return map[string]int64{}, nil
 	}
+	return s.broker.GetSeenCounts(messageIDs)
+}
 
-	// Update Redis cache - mark message as deleted (soft delete in cache)
-	// This allows admins to see deleted messages from cache
-	if err := s.broker.MarkMessageAsDeleted(messageID, isDeletedByAdmin); err != nil {
-		logger.Log.Warn("Failed to update Redis cache for deleted message",
-			zap.String("message_id", messageID),
-			zap.Error(err),
-		)
-		// Don't fail the delete operation if Redis update fails
-		// PostgreSQL is source of truth
+// GetMessagesBefore returns a room's messages older than beforeID (pagination)
+func (s *MessageService) GetMessagesBefore(roomID string, beforeID uint64, limit int, isAdmin bool) ([]models.Message, error) {
+	roomID, err := ResolveRoomID(roomID)
+	if err != nil {
+		return nil, err
+	}
+	return s.messageRepo.GetMessagesBefore(roomID, beforeID, limit)
+}
+
+// GetMessagesAfter pages forward from an ID, oldest first. The limit is
+// capped at MaxPageSize (non-positive = MaxPageSize).
+func (s *MessageService) GetMessagesAfter(roomID string, afterID uint64, limit int, isAdmin bool) ([]models.Message, error) {
+	roomID, err := ResolveRoomID(roomID)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+	return s.messageRepo.GetMessagesAfter(roomID, afterID, limit)
+}
+
+// GetMessagesSince returns what a reconnecting client missed after lastSeenID
+// (the newest persisted ID it has), newest first like GetRecentMessages.
+// Messages still only in the WAL have no ID yet; they come from the recent
+// cache when newer than the last seen one. An ID ahead of this room's newest
+// (another node's database, a stale client) is clamped to the newest.
+// caughtUp = false means more than limit were missed or lastSeenID is unknown,
+// and the latest limit messages are returned instead.
+func (s *MessageService) GetMessagesSince(roomID string, lastSeenID uint64, limit int) (messages []models.Message, caughtUp bool, err error) {
+	roomID, err = ResolveRoomID(roomID)
+	if err != nil {
+		return nil, false, err
+	}
+	if limit <= 0 || limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	resync := func() ([]models.Message, bool, error) {
+		messages, err := s.GetRecentMessages(roomID, limit)
+		return messages, false, err
+	}
+
+	// One extra row tells a gap of exactly limit from a larger one
+	missed, err := s.messageRepo.GetMessagesAfter(roomID, lastSeenID, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(missed) > limit {
+		return resync()
+	}
+
+	lastSeen, err := s.messageRepo.GetMessageByID(lastSeenID)
+	if err != nil {
+		return nil, false, err
+	}
+	if lastSeen == nil || lastSeen.RoomID != roomID {
+		if len(missed) > 0 {
+			return resync()
+		}
+		newest, err := s.messageRepo.GetRecentMessages(roomID, 1)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(newest) == 0 || newest[0].ID > lastSeenID {
+			return resync()
+		}
+		logger.Log.Debug("Last seen message ID ahead of this room, clamping",
+			zap.String("room_id", roomID),
+			zap.Uint64("last_seen_id", lastSeenID),
+			zap.Uint64("newest_id", newest[0].ID),
+		)
+		lastSeen = &newest[0]
+	}
+
+	// Unpersisted messages newer than the last seen one
+	recent, err := s.GetRecentMessages(roomID, limit)
+	if err != nil {
+		return nil, false, err
+	}
+	persisted := make(map[string]bool, len(missed))
+	for _, msg := range missed {
+		persisted[msg.MessageID] = true
+	}
+	for _, msg := range recent {
+		if msg.ID == 0 && !persisted[msg.MessageID] && msg.CreatedAt.After(lastSeen.CreatedAt) {
+			messages = append(messages, msg)
+		}
+	}
+	if len(messages)+len(missed) > limit {
+		return resync()
+	}
+
+	// recent is newest first and every unpersisted message is newer than the
+	// persisted ones; missed is oldest first
+	for i := len(missed) - 1; i >= 0; i-- {
+		messages = append(messages, missed[i])
+	}
+	return messages, true, nil
+}
+
+// CountRoomMessages returns a room's live message count. The total is cached
+// for RoomCountCacheTTL, so it can lag behind recent sends and deletes.
+func (s *MessageService) CountRoomMessages(roomID string) (int64, error) {
+	roomID, err := ResolveRoomID(roomID)
+	if err != nil {
+		return 0, err
+	}
+
+	key := s.config.RoomCountCacheKey + roomID
+	count, ok, err := s.broker.GetCachedCount(key)
+	if err != nil {
+		logger.Log.Warn("Failed to read cached room total, counting in PostgreSQL",
+			zap.String("room_id", roomID),
+			zap.Error(err),
+		)
+	}
+	if ok {
+		return count, nil
+	}
+
+	count, err = s.messageRepo.CountMessages(roomID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.broker.SetCachedCount(key, count, s.config.RoomCountCacheTTL); err != nil {
+		logger.Log.Warn("Failed to cache room total",
+			zap.String("room_id", roomID),
+			zap.Error(err),
+		)
+	}
+	return count, nil
+}
+
+// SearchMessages runs an admin search with the configured caps applied: the
+// limit is clamped to SearchMaxLimit (SearchMaxIDsLimit when idsOnly) and
+// offsets beyond SearchMaxOffset return ErrSearchOffsetTooLarge, since deep
+// OFFSET pagination makes the database scan and discard every skipped row.
+// The query is the text as typed; it is escaped like content before matching.
+func (s *MessageService) SearchMessages(search repository.MessageSearch, idsOnly bool) (*SearchResult, error) {
+	// Content is stored HTML-escaped, so "&" must be looked up as "&amp;"
+	search.Query = html.EscapeString(search.Query)
+
+	maxLimit := s.config.SearchMaxLimit
+	if idsOnly {
+		maxLimit = s.config.SearchMaxIDsLimit
+	}
+	if search.Limit <= 0 {
+		search.Limit = defaultSearchLimit
+	}
+	if search.Limit > maxLimit {
+		search.Limit = maxLimit
+	}
+	if search.Offset < 0 {
+		search.Offset = 0
+	}
+	if search.Offset > s.config.SearchMaxOffset {
+		return nil, ErrSearchOffsetTooLarge
+	}
+	if search.RoomID != "" {
+		roomID, err := ResolveRoomID(search.RoomID)
+		if err != nil {
+			return nil, err
+		}
+		search.RoomID = roomID
+	}
+
+	total, err := s.messageRepo.CountSearch(search)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SearchResult{Total: total, Limit: search.Limit, Offset: search.Offset}
+	if idsOnly {
+		result.IDs, err = s.messageRepo.SearchMessageIDs(search)
+	} else {
+		result.Messages, err = s.messageRepo.SearchMessages(search)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// DeleteLastMessage deletes the user's most recent live message in a room,
+// so clients can offer "delete my last message" without tracking IDs.
+// Messages still waiting in the WAL are persisted first, otherwise the last
+// one sent would be skipped for an older one.
+func (s *MessageService) DeleteLastMessage(userID uuid.UUID, roomID string, isAdmin bool) (*models.Message, error) {
+	roomID, err := ResolveRoomID(roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	pending, err := s.hasPendingFrom(userID, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if pending {
+		if _, err := s.processBatch(); err != nil {
+			return nil, err
+		}
+	}
+
+	msg, err := s.messageRepo.GetLatestByUser(userID, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if msg == nil {
+		return nil, ErrNothingToDelete
+	}
+
+	return s.DeleteMessage(msg.MessageID, userID, isAdmin)
+}
+
+// hasPendingFrom reports whether the WAL holds unpersisted messages of the user in the room
+func (s *MessageService) hasPendingFrom(userID uuid.UUID, roomID string) (bool, error) {
+	entries, err := s.wal.GetAllEntries()
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		if entry.UserID == userID.String() && entry.RoomID == roomID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// isPending reports whether the message is in the WAL, not yet persisted
+func (s *MessageService) isPending(messageID string) (bool, error) {
+	entries, err := s.wal.GetAllEntries()
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		if entry.MessageID == messageID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// FullTextSearch finds messages containing every word of the query, newest
+// first, with the same paging caps as SearchMessages. Deleted messages are
+// only included for admins.
+func (s *MessageService) FullTextSearch(query string, limit, offset int, isAdmin bool) (*SearchResult, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, ErrEmptySearch
+	}
+
+	return s.SearchMessages(repository.MessageSearch{
+		Query:          query,
+		FullText:       true,
+		IncludeDeleted: isAdmin,
+		Limit:          limit,
+		Offset:         offset,
+	}, false)
+}
+
+// DeleteMessage soft-deletes a message. Returns the message so callers can notify its room.
+func (s *MessageService) DeleteMessage(messageID string, userID uuid.UUID, isAdmin bool) (*models.Message, error) {
+	start := time.Now()
+
+	logger.Log.Debug("Processing message delete",
+		zap.String("message_id", messageID),
+		zap.String("user_id", userID.String()),
+		zap.Bool("is_admin", isAdmin),
+	)
+
+	msg, err := s.messageRepo.GetByMessageID(messageID)
+	if err != nil {
+		if !errors.Is(err, repository.ErrNotFound) {
+			logger.Log.Error("Failed to load message for delete",
+				zap.String("message_id", messageID),
+				zap.Error(err),
+			)
+			return nil, err
+		}
+		logger.Log.Warn("Message not found for deletion",
+			zap.String("message_id", messageID),
+		)
+		return nil, ErrMessageNotFound
+	}
+
+	if !isAdmin && msg.UserID != userID {
+		logger.Log.Warn("Unauthorized delete attempt",
+			zap.String("message_id", messageID),
+			zap.String("requesting_user_id", userID.String()),
+			zap.String("message_owner_id", msg.UserID.String()),
+		)
+		return nil, ErrUnauthorized
+	}
+
+	deletedBy := userID
+	isDeletedByAdmin := isAdmin
+
+	if err := s.messageRepo.SoftDeleteMessage(msg.ID, deletedBy, isDeletedByAdmin); err != nil {
+		logger.Log.Error("Failed to soft delete message",
+			zap.String("message_id", messageID),
+			zap.Error(err),
+		)
+		return nil, err
+	}
+
+	// Update Redis cache - mark message as deleted (soft delete in cache)
+	// This allows admins to see deleted messages from cache
+	if err := s.broker.MarkMessageAsDeleted(msg.RoomID, messageID, isDeletedByAdmin); err != nil {
+		logger.Log.Warn("Failed to update Redis cache for deleted message",
+			zap.String("message_id", messageID),
+			zap.Error(err),
+		)
+		// Don't fail the delete operation if Redis update fails
+		// PostgreSQL is source of truth
+	}
+
+	s.config.Metrics.MessageDeleted()
+	logger.Log.Info("Message deleted successfully",
+		zap.String("message_id", messageID),
+		zap.String("deleted_by", userID.String()),
+		zap.Bool("is_admin", isAdmin),
+		zap.Duration("duration", time.Since(start)),
+	)
+
+	msg.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	msg.DeletedBy = &deletedBy
+	msg.IsDeletedByAdmin = isDeletedByAdmin
+	return msg, nil
+}
+
+// EditMessage replaces the content of a message. Only the author (or an admin) may edit.
+// The previous content is kept in message_edits as an audit trail.
+func (s *MessageService) EditMessage(messageID string, userID uuid.UUID, isAdmin bool, newContent string) (*models.Message, error) {
+	start := time.Now()
+
+	// Same rules as SendMessage: normalize, validate, mute check, moderate,
+	// then escape
+	newContent = s.normalizeContent(newContent)
+	if err := s.validateMessageContent(newContent); err != nil {
+		logger.Log.Warn("Edit validation failed",
+			zap.String("message_id", messageID),
+			zap.String("user_id", userID.String()),
+			zap.Error(err),
+		)
+		return nil, err
+	}
+
+	msg, err := s.messageRepo.GetByMessageID(messageID)
+	if err != nil {
+		if !errors.Is(err, repository.ErrNotFound) {
+			logger.Log.Error("Failed to load message for edit",
+				zap.String("message_id", messageID),
+				zap.Error(err),
+			)
+			return nil, err
+		}
+		logger.Log.Warn("Message not found for edit",
+			zap.String("message_id", messageID),
+		)
+		return nil, ErrMessageNotFound
+	}
+
+	if !isAdmin && msg.UserID != userID {
+		logger.Log.Warn("Unauthorized edit attempt",
+			zap.String("message_id", messageID),
+			zap.String("requesting_user_id", userID.String()),
+			zap.String("message_owner_id", msg.UserID.String()),
+		)
+		return nil, ErrUnauthorized
+	}
+
+	if err := s.checkMuted(userID); err != nil {
+		logger.Log.Warn("Edit rejected: editor is muted",
+			zap.String("message_id", messageID),
+			zap.String("user_id", userID.String()),
+			zap.Error(err),
+		)
+		return nil, err
+	}
+
+	// Moderated like a send, on the text as typed, before escaping
+	if err := s.moderate(messageID, msg.UserID, msg.Username, msg.RoomID, newContent); err != nil {
+		return nil, err
+	}
+	sanitizedContent, err := s.filterContent(messageID, userID, html.EscapeString(newContent))
+	if err != nil {
+		return nil, err
+	}
+	lang := s.detectLanguage(newContent)
+
+	editedAt := time.Now()
+	if err := s.messageRepo.EditMessage(msg, sanitizedContent, lang, userID, editedAt); err != nil {
+		logger.Log.Error("Failed to edit message",
+			zap.String("message_id", messageID),
+			zap.Error(err),
+		)
+		return nil, err
+	}
+	msg.Content = sanitizedContent
+	msg.Lang = lang
+	msg.EditedAt = &editedAt
+
+	if err := s.broker.MarkMessageAsEdited(msg.RoomID, messageID, sanitizedContent, lang, editedAt); err != nil {
+		logger.Log.Warn("Failed to update Redis cache for edited message",
+			zap.String("message_id", messageID),
+			zap.Error(err),
+		)
+		// PostgreSQL is source of truth
+	}
+
+	logger.Log.Info("Message edited successfully",
+		zap.String("message_id", messageID),
+		zap.String("edited_by", userID.String()),
+		zap.Bool("is_admin", isAdmin),
+		zap.Duration("duration", time.Since(start)),
+	)
+
+	return msg, nil
+}
+
+// GetMessageEdits returns the previous versions of a message (oldest first)
+func (s *MessageService) GetMessageEdits(messageID string) ([]models.MessageEdit, error) {
+	return s.messageRepo.GetEdits(messageID)
+}
+
+// AddReaction records a user's emoji reaction to a live, persisted message.
+// Returns the message (for its room) and the emoji's new count.
+func (s *MessageService) AddReaction(messageID string, userID uuid.UUID, emoji string) (*models.Message, int64, error) {
+	if !slices.Contains(ReactionEmojis, emoji) {
+		return nil, 0, ErrInvalidEmoji
+	}
+
+	msg, err := s.loadReactionTarget(messageID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// The unique index decides: two racing requests can't both insert
+	err = s.messageRepo.AddReaction(&models.MessageReaction{
+		MessageID: messageID,
+		UserID:    userID,
+		Emoji:     emoji,
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			return nil, 0, ErrAlreadyReacted
+		}
+		logger.Log.Error("Failed to add reaction",
+			zap.String("message_id", messageID),
+			zap.String("user_id", userID.String()),
+			zap.Error(err),
+		)
+		return nil, 0, err
+	}
+
+	count, err := s.messageRepo.CountReactions(messageID, emoji)
+	if err != nil {
+		return nil, 0, err
+	}
+	return msg, count, nil
+}
+
+// RemoveReaction withdraws a user's emoji reaction.
+// Returns the message (for its room) and the emoji's new count.
+func (s *MessageService) RemoveReaction(messageID string, userID uuid.UUID, emoji string) (*models.Message, int64, error) {
+	if !slices.Contains(ReactionEmojis, emoji) {
+		return nil, 0, ErrInvalidEmoji
+	}
+
+	msg, err := s.loadReactionTarget(messageID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	removed, err := s.messageRepo.RemoveReaction(messageID, userID, emoji)
+	if err != nil {
+		logger.Log.Error("Failed to remove reaction",
+			zap.String("message_id", messageID),
+			zap.String("user_id", userID.String()),
+			zap.Error(err),
+		)
+		return nil, 0, err
+	}
+	if !removed {
+		return nil, 0, ErrReactionNotFound
+	}
+
+	count, err := s.messageRepo.CountReactions(messageID, emoji)
+	if err != nil {
+		return nil, 0, err
+	}
+	return msg, count, nil
+}
+
+// loadReactionTarget loads the message a reaction refers to. Reactions
+// reference it in PostgreSQL, so a message still waiting in the WAL (just
+// sent, reacted to at once) is persisted first instead of reported missing.
+func (s *MessageService) loadReactionTarget(messageID string) (*models.Message, error) {
+	msg, err := s.messageRepo.GetByMessageID(messageID)
+	if errors.Is(err, repository.ErrNotFound) {
+		pending, walErr := s.isPending(messageID)
+		if walErr != nil {
+			return nil, walErr
+		}
+		if pending {
+			if _, err := s.processBatch(); err != nil {
+				return nil, err
+			}
+			msg, err = s.messageRepo.GetByMessageID(messageID)
+		}
+	}
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		logger.Log.Error("Failed to load message for reaction",
+			zap.String("message_id", messageID),
+			zap.Error(err),
+		)
+		return nil, err
+	}
+	return msg, nil
+}
+
+// GetReactionCounts returns reaction counts keyed by message ID, then emoji
+func (s *MessageService) GetReactionCounts(messageIDs []string) (map[string]map[string]int64, error) {
+	return s.messageRepo.GetReactionCounts(messageIDs)
+}
+
+// GetDeletedMessages returns messages the user authored that are soft-deleted
+func (s *MessageService) GetDeletedMessages(userID uuid.UUID, limit int) ([]models.Message, error) {
+	return s.messageRepo.GetDeletedByUser(userID, limit)
+}
+
+// RestoreMessage restores a message the user deleted themselves.
+// Admin-deleted messages can never be restored by their author.
+func (s *MessageService) RestoreMessage(messageID string, userID uuid.UUID) (*models.Message, error) {
+	msg, err := s.messageRepo.GetByMessageIDUnscoped(messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Scoped to the requesting user: others' messages look like they don't exist
+	if msg == nil || msg.UserID != userID {
+		return nil, ErrMessageNotFound
+	}
+
+	if !msg.DeletedAt.Valid {
+		return nil, ErrNotDeleted
+	}
+
+	if msg.IsDeletedByAdmin || msg.DeletedBy == nil || *msg.DeletedBy != userID {
+		logger.Log.Warn("Restore denied: message not self-deleted",
+			zap.String("message_id", messageID),
+			zap.String("user_id", userID.String()),
+			zap.Bool("deleted_by_admin", msg.IsDeletedByAdmin),
+		)
+		return nil, ErrRestoreDenied
+	}
+
+	if err := s.messageRepo.RestoreMessage(msg.ID); err != nil {
+		logger.Log.Error("Failed to restore message",
+			zap.String("message_id", messageID),
+			zap.Error(err),
+		)
+		return nil, err
+	}
+
+	if err := s.broker.MarkMessageAsRestored(msg.RoomID, messageID); err != nil {
+		logger.Log.Warn("Failed to update Redis cache for restored message",
+			zap.String("message_id", messageID),
+			zap.Error(err),
+		)
+	}
+
+	msg.DeletedAt.Valid = false
+	msg.DeletedBy = nil
+
+	logger.Log.Info("Message restored by author",
+		zap.String("message_id", messageID),
+		zap.String("user_id", userID.String()),
+	)
+
+	return msg, nil
+}
+
+// notePending counts a WAL write and wakes the batch writer once
+// BatchWriterMaxPending sends are waiting (never blocks the sender)
+func (s *MessageService) notePending() {
+	if s.pending.Add(1) < int64(s.config.BatchWriterMaxPending) {
+		return
+	}
+	select {
+	case s.flushNow <- struct{}{}:
+	default: // A flush is already requested
 	}
-
-	logger.Log.Info("Message deleted successfully",
-		zap.String("message_id", messageID),
-		zap.String("deleted_by", userID.String()),
-		zap.Bool("is_admin", isAdmin),
-		zap.Duration("duration", time.Since(start)),
-	)
-
-	return nil
 }
 
 // StartBatchWriter starts a background goroutine that writes messages from WAL to PostgreSQL
-// Runs every 1 minute and writes ALL messages in WAL (no limit)
+// Runs every BatchWriterInterval, or earlier under bursty traffic, and writes ALL messages in WAL (no limit)
 func (s *MessageService) StartBatchWriter(ctx context.Context) {
 	go func() {
-		ticker := time.NewTicker(1 * time.Minute)
+		ticker := time.NewTicker(s.config.BatchWriterInterval)
 		defer ticker.Stop()
 
-		logger.Log.Info("Batch Writer started: Writing WAL to PostgreSQL every 1 minute")
+		logger.Log.Info("Batch Writer started: Writing WAL to PostgreSQL",
+			zap.Duration("interval", s.config.BatchWriterInterval),
+			zap.Int("max_pending", s.config.BatchWriterMaxPending),
+		)
 
 		for {
 			select {
@@ -271,28 +1443,98 @@ func (s *MessageService) StartBatchWriter(ctx context.Context) {
 			case <-ticker.C:
 				logger.Log.Debug("Batch Writer tick - checking WAL")
 				s.processBatch()
+
+			case <-s.wal.FlushRequests():
+				// Too many WAL segments - drain now instead of waiting for the tick
+				logger.Log.Info("Batch Writer: Forced flush (WAL segment limit)")
+				s.processBatch()
+
+			case <-s.flushNow:
+				// Burst of sends - persist them without waiting a full interval
+				logger.Log.Debug("Batch Writer: Early flush (pending threshold)")
+				s.processBatch()
 			}
 		}
 	}()
 }
 
-// processBatch reads ALL messages from WAL and writes to PostgreSQL
-func (s *MessageService) processBatch() {
+// Flush synchronously drains the WAL to PostgreSQL (graceful shutdown).
+// Keeps running batches until the WAL is empty or ctx expires.
+func (s *MessageService) Flush(ctx context.Context) error {
+	start := time.Now()
+	total := 0
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		done := make(chan error, 1)
+		var processed int
+		go func() {
+			var err error
+			processed, err = s.processBatch()
+			done <- err
+		}()
+
+		select {
+		case <-ctx.Done():
+			logger.Log.Warn("WAL flush interrupted",
+				zap.Int("flushed_count", total),
+				zap.Error(ctx.Err()),
+			)
+			return ctx.Err()
+		case err := <-done:
+			if err != nil {
+				return err
+			}
+		}
+
+		// An empty pass means everything written before it is persisted
+		if processed == 0 {
+			logger.Log.Info("WAL flushed to PostgreSQL",
+				zap.Int("flushed_count", total),
+				zap.Duration("duration", time.Since(start)),
+			)
+			return nil
+		}
+		total += processed
+	}
+}
+
+// ProcessBatchNow runs one batch cycle immediately (tests, admin ops).
+// Returns the number of messages persisted; the ticker runs the same cycle.
+func (s *MessageService) ProcessBatchNow() (int, error) {
+	return s.processBatch()
+}
+
+// processBatch reads ALL messages from WAL and writes to PostgreSQL.
+// Returns the number of messages persisted.
+func (s *MessageService) processBatch() (int, error) {
+	s.batchMu.Lock()
+	defer s.batchMu.Unlock()
+
 	start := time.Now()
 
+	// Sends from here on may miss this batch, so they count towards the next one
+	s.pending.Store(0)
+
 	// 1. Get ALL entries from WAL
 	entries, err := s.wal.GetAllEntries()
 	if err != nil {
 		logger.Log.Error("Batch Writer: Failed to read WAL",
 			zap.Error(err),
 		)
-		return
+		return 0, err
 	}
 
+	// Resync the backlog gauge (covers entries left by a previous run)
+	s.config.Metrics.SetWALPending(len(entries))
+
 	// 2. If WAL is empty, skip (no unnecessary PostgreSQL calls)
 	if len(entries) == 0 {
 		// WAL is empty, nothing to do (no log needed - too noisy)
-		return
+		return 0, nil
 	}
 
 	logger.Log.Info("Batch Writer: Found messages in WAL",
@@ -303,27 +1545,57 @@ func (s *MessageService) processBatch() {
 	messages := make([]models.Message, 0, len(entries))
 	messageIDs := make([]string, 0, len(entries))
 
+	var deadLetters []wal.WALEntry
+
 	for _, entry := range entries {
-		userID, _ := uuid.Parse(entry.UserID)
+		userID, err := uuid.Parse(entry.UserID)
+		if err != nil || userID == uuid.Nil {
+			// Inserting it would attribute the message to the zero UUID
+			logger.Log.Error("Batch Writer: Malformed user ID in WAL entry, dead-lettering",
+				zap.String("message_id", entry.MessageID),
+				zap.String("user_id", entry.UserID),
+				zap.Error(err),
+			)
+			deadLetters = append(deadLetters, entry)
+			continue
+		}
 		messages = append(messages, models.Message{
 			MessageID: entry.MessageID,
 			UserID:    userID,
+			Username:  entry.Username,
+			RoomID:    entry.RoomID,
 			Content:   entry.Content,
+			Lang:      entry.Lang,
 			CreatedAt: entry.Timestamp,
 		})
 		messageIDs = append(messageIDs, entry.MessageID)
 	}
 
+	// Park bad entries before anything is cleaned up so they're never lost
+	if err := s.wal.DeadLetter(deadLetters); err != nil {
+		logger.Log.Error("Batch Writer: Failed to dead-letter WAL entries",
+			zap.Int("entry_count", len(deadLetters)),
+			zap.Error(err),
+		)
+		return 0, err
+	}
+	for _, entry := range deadLetters {
+		messageIDs = append(messageIDs, entry.MessageID)
+	}
+
 	// 4. Batch insert to PostgreSQL
 	insertStart := time.Now()
-	if err := s.messageRepo.BatchInsert(messages); err != nil {
+	// Duplicates (a retried send already persisted, or a batch whose WAL
+	// cleanup failed) are skipped rather than failing the whole batch
+	if _, err := s.messageRepo.BatchInsertIgnoreDuplicates(messages); err != nil {
 		logger.Log.Error("Batch Writer: Failed to insert messages to PostgreSQL",
 			zap.Int("message_count", len(messages)),
 			zap.Error(err),
 		)
-		return
+		return 0, err
 	}
 	insertDuration := time.Since(insertStart)
+	s.config.Metrics.ObserveBatchWrite(insertDuration)
 
 	logger.Log.Info("Batch Writer: Messages written to PostgreSQL",
 		zap.Int("message_count", len(messages)),
@@ -337,13 +1609,16 @@ func (s *MessageService) processBatch() {
 			zap.Int("message_count", len(messageIDs)),
 			zap.Error(err),
 		)
-		return
+		return 0, err
 	}
 	cleanupDuration := time.Since(cleanupStart)
+	s.config.Metrics.AddWALPending(-len(messageIDs))
 
 	logger.Log.Info("Batch Writer: Batch processing completed",
 		zap.Int("message_count", len(messages)),
 		zap.Duration("cleanup_duration", cleanupDuration),
 		zap.Duration("total_duration", time.Since(start)),
 	)
+
+	return len(messages), nil
 }
@@ -2,19 +2,33 @@ package service_test
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/Baaaki/digital-square/internal/broker"
 	"github.com/Baaaki/digital-square/internal/models"
+	"github.com/Baaaki/digital-square/internal/moderation"
 	"github.com/Baaaki/digital-square/internal/repository"
 	"github.com/Baaaki/digital-square/internal/service"
 	"github.com/Baaaki/digital-square/internal/testutil"
+	"github.com/Baaaki/digital-square/internal/utils"
 	"github.com/Baaaki/digital-square/internal/wal"
 	"github.com/Baaaki/digital-square/pkg/logger"
+	"github.com/Baaaki/digital-square/pkg/metrics"
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -45,12 +59,13 @@ func (s *MessageServiceIntegrationTestSuite) SetupSuite() {
 	s.walInstance = walInstance
 
 	// Setup Redis broker
-	redisBroker, err := broker.NewRedisMessageBroker(s.testRedis.URL)
+	redisBroker, err := broker.NewRedisMessageBroker(s.testRedis.URL, broker.BrokerConfig{})
 	assert.NoError(s.T(), err)
 
 	// Setup repositories and services
 	messageRepo := repository.NewMessageRepository(s.testDB.DB)
-	s.messageService = service.NewMessageService(messageRepo, redisBroker, s.walInstance)
+	userRepo := repository.NewUserRepository(s.testDB.DB)
+	s.messageService = service.NewMessageService(messageRepo, userRepo, redisBroker, s.walInstance, service.MessageServiceConfig{})
 
 	// Create test user
 	s.testUser, _ = testutil.CreateTestUser("testuser", "test@example.com", "Test123", models.RoleUser)
@@ -75,15 +90,22 @@ func (s *MessageServiceIntegrationTestSuite) SetupTest() {
 		s.walInstance.Close()
 	}
 	os.RemoveAll("/tmp/test_wal_messages")
+	os.RemoveAll("/tmp/test_wal_messages.deadletter")
 
 	// Create new WAL instance
 	walInstance, _ := wal.NewWAL("/tmp/test_wal_messages")
 	s.walInstance = walInstance
 
 	// Update MessageService with new WAL instance
+	s.messageService = s.newMessageService(service.MessageServiceConfig{})
+}
+
+// newMessageService builds a MessageService on the suite's DB, Redis and WAL
+func (s *MessageServiceIntegrationTestSuite) newMessageService(config service.MessageServiceConfig) *service.MessageService {
 	messageRepo := repository.NewMessageRepository(s.testDB.DB)
-	redisBroker, _ := broker.NewRedisMessageBroker(s.testRedis.URL)
-	s.messageService = service.NewMessageService(messageRepo, redisBroker, s.walInstance)
+	userRepo := repository.NewUserRepository(s.testDB.DB)
+	redisBroker, _ := broker.NewRedisMessageBroker(s.testRedis.URL, broker.BrokerConfig{})
+	return service.NewMessageService(messageRepo, userRepo, redisBroker, s.walInstance, config)
 }
 
 // getUserID is a helper to convert string ID to UUID (for SQLite compatibility)
@@ -94,7 +116,7 @@ func (s *MessageServiceIntegrationTestSuite) getUserID() uuid.UUID {
 // TestSendMessage tests message sending (WAL write)
 func (s *MessageServiceIntegrationTestSuite) TestSendMessage() {
 	// Send message
-	msg, err := s.messageService.SendMessage(s.getUserID(), s.testUser.Username, "Hello, World!")
+	msg, err := s.messageService.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, "Hello, World!")
 	assert.NoError(s.T(), err)
 	assert.NotNil(s.T(), msg)
 	assert.Equal(s.T(), "Hello, World!", msg.Content)
@@ -111,7 +133,7 @@ func (s *MessageServiceIntegrationTestSuite) TestSendMessage() {
 func (s *MessageServiceIntegrationTestSuite) TestSendMessageXSSSanitization() {
 	// Send message with XSS payload
 	xssPayload := "<script>alert('XSS')</script>"
-	msg, err := s.messageService.SendMessage(s.getUserID(), s.testUser.Username, xssPayload)
+	msg, err := s.messageService.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, xssPayload)
 
 	assert.NoError(s.T(), err)
 	assert.NotNil(s.T(), msg)
@@ -144,7 +166,7 @@ func (s *MessageServiceIntegrationTestSuite) TestSendMessageValidation() {
 
 	for _, tc := range testCases {
 		s.Run(tc.name, func() {
-			msg, err := s.messageService.SendMessage(s.getUserID(), s.testUser.Username, tc.content)
+			msg, err := s.messageService.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, tc.content)
 			assert.Error(s.T(), err)
 			assert.Nil(s.T(), msg)
 			assert.Contains(s.T(), err.Error(), tc.expectedError)
@@ -152,11 +174,254 @@ func (s *MessageServiceIntegrationTestSuite) TestSendMessageValidation() {
 	}
 }
 
+// TestSendMessageMaxLength tests that MaxMessageLength counts runes, not
+// bytes: a message exactly at the limit passes, one rune over fails
+func (s *MessageServiceIntegrationTestSuite) TestSendMessageMaxLength() {
+	svc := s.newMessageService(service.MessageServiceConfig{MaxMessageLength: 10})
+	assert.Equal(s.T(), 10, svc.MaxMessageLength())
+
+	atLimit := strings.Repeat("ü", 10) // 10 runes, 20 bytes
+	msg, err := svc.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, atLimit)
+	assert.NoError(s.T(), err)
+	if assert.NotNil(s.T(), msg) {
+		assert.Equal(s.T(), atLimit, msg.Content)
+	}
+
+	msg, err = svc.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, atLimit+"👋")
+	assert.ErrorIs(s.T(), err, service.ErrMessageTooLong)
+	assert.Contains(s.T(), err.Error(), "max 10 characters")
+	assert.Nil(s.T(), msg)
+
+	// Unset falls back to the default
+	assert.Equal(s.T(), 5000, s.newMessageService(service.MessageServiceConfig{}).MaxMessageLength())
+}
+
+// TestSendMessageMinAccountAge tests that fresh accounts must wait before sending
+func (s *MessageServiceIntegrationTestSuite) TestSendMessageMinAccountAge() {
+	svc := s.newMessageService(service.MessageServiceConfig{MinAccountAge: 10 * time.Minute})
+
+	// Freshly created user is rejected with the remaining wait
+	freshUser, _ := testutil.CreateTestUser("freshuser", "fresh@example.com", "Pass123456", models.RoleUser)
+	s.testDB.DB.Create(freshUser)
+
+	msg, err := svc.SendMessage(testutil.ParseUUID(s.T(), freshUser.ID), freshUser.Username, models.DefaultRoomID, "first!")
+	assert.Nil(s.T(), msg)
+	assert.ErrorIs(s.T(), err, service.ErrAccountTooNew)
+	assert.Contains(s.T(), err.Error(), "try again in")
+
+	// Account older than the limit is allowed
+	oldUser, _ := testutil.CreateTestUser("olduser", "old@example.com", "Pass123456", models.RoleUser)
+	oldUser.CreatedAt = time.Now().Add(-1 * time.Hour)
+	s.testDB.DB.Create(oldUser)
+
+	msg, err = svc.SendMessage(testutil.ParseUUID(s.T(), oldUser.ID), oldUser.Username, models.DefaultRoomID, "hello")
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), msg)
+
+	// Admins are exempt even when freshly created
+	freshAdmin, _ := testutil.CreateTestUser("freshadmin", "freshadmin@example.com", "Pass123456", models.RoleAdmin)
+	s.testDB.DB.Create(freshAdmin)
+
+	msg, err = svc.SendMessage(testutil.ParseUUID(s.T(), freshAdmin.ID), freshAdmin.Username, models.DefaultRoomID, "announcement")
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), msg)
+}
+
+// TestSendMessageNormalization tests whitespace trimming and newline collapsing
+func (s *MessageServiceIntegrationTestSuite) TestSendMessageNormalization() {
+	svc := s.newMessageService(service.MessageServiceConfig{
+		TrimWhitespace:         true,
+		MaxConsecutiveNewlines: 2,
+	})
+
+	// Whitespace-only content is rejected as empty
+	for _, content := range []string{"   ", "\n\n\t\n", " \r\n \r\n "} {
+		msg, err := svc.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, content)
+		assert.Nil(s.T(), msg)
+		assert.ErrorIs(s.T(), err, service.ErrMessageTooShort, "content %q", content)
+	}
+
+	// Ends are trimmed and long runs of blank lines collapse to two newlines
+	msg, err := svc.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, "  hello\n\n\n  \n\r\nworld\n\nagain  \n")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), "hello\n\nworld\n\nagain", msg.Content)
+
+	// Zero config leaves content untouched
+	msg, err = s.messageService.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, " a\n\n\nb ")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), " a\n\n\nb ", msg.Content)
+}
+
+// TestSendMessageContentFilterReject tests that blocked words reject the
+// message, case-insensitively and only as whole words
+func (s *MessageServiceIntegrationTestSuite) TestSendMessageContentFilterReject() {
+	filter := service.NewBlocklistFilter([]string{"darn", " Heck ", "DARN", "dang it", "o'clock", "x-rated"}, service.ContentFilterReject)
+	assert.Equal(s.T(), 2, filter.Len(), "Entries that aren't a single word can never match")
+	svc := s.newMessageService(service.MessageServiceConfig{ContentFilter: filter})
+
+	for _, content := range []string{"darn it", "oh HECK!", "what the ...heck?", "Darn\nagain"} {
+		msg, err := svc.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, content)
+		assert.Nil(s.T(), msg)
+		assert.ErrorIs(s.T(), err, service.ErrBlockedContent, "content %q", content)
+	}
+
+	// Words that merely contain a blocked one pass (the Scunthorpe problem)
+	msg, err := svc.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, "darned heckler")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), "darned heckler", msg.Content)
+}
+
+// TestSendMessageContentFilterMask tests that mask mode stars out blocked
+// words and leaves the HTML escaping intact
+func (s *MessageServiceIntegrationTestSuite) TestSendMessageContentFilterMask() {
+	svc := s.newMessageService(service.MessageServiceConfig{
+		ContentFilter: service.NewBlocklistFilter([]string{"darn", "grüß", "amp"}, service.ContentFilterMask),
+	})
+
+	msg, err := svc.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, "Darn, <b>GRÜß</b> & darnation amp")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), "****, &lt;b&gt;****&lt;/b&gt; &amp; darnation ***", msg.Content)
+
+	// A custom ContentFilter can be injected
+	svc = s.newMessageService(service.MessageServiceConfig{ContentFilter: upperFilter{}})
+	msg, err = svc.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, "quiet")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), "QUIET", msg.Content)
+}
+
+// upperFilter is a ContentFilter that shouts every message
+type upperFilter struct{}
+
+func (upperFilter) Filter(content string) (string, error) {
+	return strings.ToUpper(content), nil
+}
+
+// TestSendMessageLanguageTag tests that detected languages are stored and survive the batch write
+func (s *MessageServiceIntegrationTestSuite) TestSendMessageLanguageTag() {
+	svc := s.newMessageService(service.MessageServiceConfig{DetectLanguage: true})
+
+	english, err := svc.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, "What is the plan for this weekend and who is coming?")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), "en", english.Lang)
+
+	turkish, err := svc.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, "Bu akşam ne yapıyoruz, sen de geliyor musun?")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), "tr", turkish.Lang)
+
+	ambiguous, err := svc.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, "👍")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), utils.LangUnknown, ambiguous.Lang)
+
+	_, err = svc.ProcessBatchNow()
+	require.NoError(s.T(), err)
+
+	var stored models.Message
+	s.testDB.DB.Where("message_id = ?", turkish.MessageID).First(&stored)
+	assert.Equal(s.T(), "tr", stored.Lang)
+
+	// Detection off leaves the tag empty
+	plain, err := s.messageService.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, "What is the plan?")
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), plain.Lang)
+}
+
+// TestSendSystemMessage tests that announcements are attributed to the
+// system user and protected from deletion by regular users
+func (s *MessageServiceIntegrationTestSuite) TestSendSystemMessage() {
+	userRepo := repository.NewUserRepository(s.testDB.DB)
+	systemUser, err := userRepo.EnsureSystemUser()
+	require.NoError(s.T(), err)
+	defer s.testDB.DB.Unscoped().Delete(&testutil.TestUser{}, "id = ?", models.SystemUserID.String())
+	assert.Equal(s.T(), models.RoleSystem, systemUser.Role)
+
+	// Seeding again is a no-op
+	_, err = userRepo.EnsureSystemUser()
+	require.NoError(s.T(), err)
+
+	msg, err := s.messageService.SendSystemMessage(models.DefaultRoomID, "Maintenance tonight at 22:00")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), models.SystemUserID, msg.UserID)
+	assert.Equal(s.T(), models.SystemUsername, msg.Username)
+
+	// Persisted in the WAL like any other message
+	entries, err := s.walInstance.GetAllEntries()
+	require.NoError(s.T(), err)
+	require.Len(s.T(), entries, 1)
+	assert.Equal(s.T(), msg.MessageID, entries[0].MessageID)
+	assert.Equal(s.T(), models.SystemUserID.String(), entries[0].UserID)
+
+	// Once in PostgreSQL, a regular user can't delete it
+	stored := testutil.CreateTestMessage(models.SystemUserID.String(), msg.Content)
+	stored.MessageID = msg.MessageID
+	s.testDB.DB.Create(stored)
+
+	_, err = s.messageService.DeleteMessage(msg.MessageID, s.getUserID(), false)
+	assert.ErrorIs(s.T(), err, service.ErrUnauthorized)
+
+	var live int64
+	s.testDB.DB.Model(&testutil.TestMessage{}).Where("message_id = ? AND deleted_at IS NULL", msg.MessageID).Count(&live)
+	assert.Equal(s.T(), int64(1), live)
+}
+
+// TestSendMessageOnceDedupesRetries tests that resending with the same client
+// message key returns the first message instead of writing a new one
+func (s *MessageServiceIntegrationTestSuite) TestSendMessageOnceDedupesRetries() {
+	send := func(key string) (*models.Message, bool) {
+		msg, duplicate, err := s.messageService.SendMessageOnce(s.getUserID(), s.testUser.Username, models.DefaultRoomID, "Sent once", key)
+		require.NoError(s.T(), err)
+		return msg, duplicate
+	}
+	countRows := func(messageID string) int64 {
+		var n int64
+		s.testDB.DB.Model(&testutil.TestMessage{}).Where("message_id = ?", messageID).Count(&n)
+		return n
+	}
+
+	key := uuid.New().String()
+	first, duplicate := send(key)
+	assert.False(s.T(), duplicate)
+
+	// Quick retry: Redis remembers the key
+	retry, duplicate := send(key)
+	assert.True(s.T(), duplicate)
+	assert.Equal(s.T(), first.MessageID, retry.MessageID)
+	entries, err := s.walInstance.GetAllEntries()
+	require.NoError(s.T(), err)
+	assert.Len(s.T(), entries, 1, "a retry must not reach the WAL")
+
+	// Late retry: Redis forgot, the persisted row answers
+	_, err = s.messageService.ProcessBatchNow()
+	require.NoError(s.T(), err)
+	s.testRedis.Server.FlushAll()
+	retry, duplicate = send(key)
+	assert.True(s.T(), duplicate)
+	assert.Equal(s.T(), first.MessageID, retry.MessageID)
+
+	// Redis forgot before the batch ran: the same message ID is written twice
+	// and the batch keeps one row
+	key = uuid.New().String()
+	first, _ = send(key)
+	s.testRedis.Server.FlushAll()
+	retry, _ = send(key)
+	assert.Equal(s.T(), first.MessageID, retry.MessageID)
+	_, err = s.messageService.ProcessBatchNow()
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(1), countRows(first.MessageID))
+
+	// Other keys are other messages
+	other, duplicate := send(uuid.New().String())
+	assert.False(s.T(), duplicate)
+	assert.NotEqual(s.T(), first.MessageID, other.MessageID)
+
+	_, _, err = s.messageService.SendMessageOnce(s.getUserID(), s.testUser.Username, models.DefaultRoomID, "Sent once", strings.Repeat("k", 65))
+	assert.ErrorIs(s.T(), err, service.ErrInvalidClientID)
+}
+
 // TestBatchWriterWALToPostgreSQL tests batch writer functionality
 func (s *MessageServiceIntegrationTestSuite) TestBatchWriterWALToPostgreSQL() {
 	// Send 5 messages (goes to WAL)
 	for i := 0; i < 5; i++ {
-		_, err := s.messageService.SendMessage(s.getUserID(), s.testUser.Username, "Test message")
+		_, err := s.messageService.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, "Test message")
 		assert.NoError(s.T(), err)
 	}
 
@@ -170,27 +435,190 @@ func (s *MessageServiceIntegrationTestSuite) TestBatchWriterWALToPostgreSQL() {
 	s.testDB.DB.Model(&models.Message{}).Count(&count)
 	assert.Equal(s.T(), int64(0), count)
 
-	// Manually trigger batch writer (simulate 1 minute passing)
+	// Run one batch cycle (same path as the 1 minute ticker)
+	persisted, err := s.messageService.ProcessBatchNow()
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 5, persisted)
+
+	// Messages are in PostgreSQL
+	s.testDB.DB.Model(&models.Message{}).Count(&count)
+	assert.Equal(s.T(), int64(5), count)
+
+	// WAL was cleaned up after the successful write
+	entries, err = s.walInstance.GetAllEntries()
+	assert.NoError(s.T(), err)
+	assert.Empty(s.T(), entries)
+
+	// Nothing left to persist
+	persisted, err = s.messageService.ProcessBatchNow()
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 0, persisted)
+}
+
+// TestBatchWriterDrainsAfterTornTail tests that a torn WAL line (a crash
+// mid-append) doesn't stop the batch writer from persisting the entries
+// around it
+func (s *MessageServiceIntegrationTestSuite) TestBatchWriterDrainsAfterTornTail() {
+	_, err := s.messageService.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, "Before the crash")
+	require.NoError(s.T(), err)
+
+	walPath := "/tmp/test_wal_messages"
+	data, err := os.ReadFile(walPath)
+	require.NoError(s.T(), err)
+	line := strings.TrimSpace(string(data))
+	file, err := os.OpenFile(walPath, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(s.T(), err)
+	_, err = file.WriteString(line[:len(line)/2] + "\n")
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), file.Close())
+
+	_, err = s.messageService.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, "After the restart")
+	require.NoError(s.T(), err)
+
+	persisted, err := s.messageService.ProcessBatchNow()
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 2, persisted)
+
+	var count int64
+	s.testDB.DB.Model(&models.Message{}).Count(&count)
+	assert.Equal(s.T(), int64(2), count)
+
+	// The torn line was dropped from the WAL and kept in the dead-letter file
+	corrupt, err := s.walInstance.Verify()
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), corrupt)
+	deadLettered, err := os.ReadFile(s.walInstance.DeadLetterPath())
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), line[:len(line)/2]+"\n", string(deadLettered))
+}
+
+// TestFlushDrainsWAL tests that Flush persists every WAL entry before returning
+func (s *MessageServiceIntegrationTestSuite) TestFlushDrainsWAL() {
+	for i := 0; i < 5; i++ {
+		_, err := s.messageService.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, fmt.Sprintf("Flush me %d", i))
+		require.NoError(s.T(), err)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// Start batch writer
-	s.messageService.StartBatchWriter(ctx)
+	// Concurrent flushes (like the ticker racing a shutdown) must not double-insert
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = s.messageService.Flush(ctx)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		assert.NoError(s.T(), err)
+	}
 
-	// Wait 2 seconds for batch writer to run
-	time.Sleep(2 * time.Second)
+	entries, err := s.walInstance.GetAllEntries()
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), entries, "WAL should be drained")
 
-	// Trigger manually by calling processBatch (reflection not needed, we can't call private method)
-	// Instead, we'll test via GetRecentMessages which should load from PostgreSQL
+	var count int64
+	s.testDB.DB.Model(&testutil.TestMessage{}).Count(&count)
+	assert.Equal(s.T(), int64(5), count)
+}
+
+// TestFlushRespectsContext tests that Flush gives up once the context is done
+func (s *MessageServiceIntegrationTestSuite) TestFlushRespectsContext() {
+	_, err := s.messageService.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, "Left behind")
+	require.NoError(s.T(), err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
 
-	// Note: In real scenario, batch writer runs every 1 minute
-	// For testing, we need to either:
-	// 1. Make processBatch() public (not recommended)
-	// 2. Wait 1 minute (too slow for tests)
-	// 3. Test indirectly via GetRecentMessages after batch writes
+	err = s.messageService.Flush(ctx)
+	assert.ErrorIs(s.T(), err, context.Canceled)
 
-	// For now, let's verify WAL cleanup happens after manual batch insert
-	// We'll create a separate test for full batch writer
+	entries, err := s.walInstance.GetAllEntries()
+	require.NoError(s.T(), err)
+	assert.Len(s.T(), entries, 1, "entries stay in the WAL for the next boot")
+}
+
+// TestSegmentLimitForcesFlush tests that hitting the WAL segment cap drains
+// the WAL without waiting for the batch writer's tick
+func (s *MessageServiceIntegrationTestSuite) TestSegmentLimitForcesFlush() {
+	const maxSegments = 3
+
+	s.walInstance.Close()
+	walInstance, err := wal.NewWALWithConfig(filepath.Join(s.T().TempDir(), "wal.log"), wal.WALConfig{
+		MaxSegmentBytes: 512, // A few entries per segment
+		MaxSegments:     maxSegments,
+	})
+	require.NoError(s.T(), err)
+	s.walInstance = walInstance
+	svc := s.newMessageService(service.MessageServiceConfig{})
+
+	// The tick is a minute away, so only a forced flush can drain the WAL
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	svc.StartBatchWriter(ctx)
+
+	const total = 30
+	peak := 0
+	for i := 0; i < total; i++ {
+		_, err := svc.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, fmt.Sprintf("Segment filler %d", i))
+		require.NoError(s.T(), err)
+		if n, _ := walInstance.SegmentCount(); n > peak {
+			peak = n
+		}
+	}
+	assert.GreaterOrEqual(s.T(), peak, maxSegments, "test should reach the segment cap")
+
+	require.Eventually(s.T(), func() bool {
+		n, err := walInstance.SegmentCount()
+		return err == nil && n < maxSegments
+	}, 5*time.Second, 20*time.Millisecond, "forced flush should remove old segments")
+
+	// Nothing lost: every message is either persisted or still in the WAL
+	require.Eventually(s.T(), func() bool {
+		var persisted int64
+		s.testDB.DB.Model(&testutil.TestMessage{}).Count(&persisted)
+		entries, err := walInstance.GetAllEntries()
+		return err == nil && persisted > 0 && int(persisted)+len(entries) == total
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+// TestPendingThresholdFlushesEarly tests that a burst of sends is persisted
+// once BatchWriterMaxPending is reached, long before the interval
+func (s *MessageServiceIntegrationTestSuite) TestPendingThresholdFlushesEarly() {
+	svc := s.newMessageService(service.MessageServiceConfig{
+		BatchWriterInterval:   time.Hour,
+		BatchWriterMaxPending: 5,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	svc.StartBatchWriter(ctx)
+
+	countPersisted := func() int64 {
+		var persisted int64
+		s.testDB.DB.Model(&testutil.TestMessage{}).Count(&persisted)
+		return persisted
+	}
+
+	// Below the threshold nothing is flushed
+	for i := 0; i < 4; i++ {
+		_, err := svc.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, fmt.Sprintf("Burst %d", i))
+		require.NoError(s.T(), err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(s.T(), int64(0), countPersisted())
+
+	_, err := svc.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, "Burst 4")
+	require.NoError(s.T(), err)
+
+	require.Eventually(s.T(), func() bool {
+		entries, err := s.walInstance.GetAllEntries()
+		return err == nil && len(entries) == 0 && countPersisted() == 5
+	}, 5*time.Second, 20*time.Millisecond, "threshold should trigger a flush")
 }
 
 // TestDeleteMessage tests message deletion (soft delete)
@@ -200,7 +628,7 @@ func (s *MessageServiceIntegrationTestSuite) TestDeleteMessage() {
 	s.testDB.DB.Create(msg)
 
 	// Delete message (user deletes own message)
-	err := s.messageService.DeleteMessage(msg.MessageID, s.getUserID(), false)
+	_, err := s.messageService.DeleteMessage(msg.MessageID, s.getUserID(), false)
 	assert.NoError(s.T(), err)
 
 	// Verify message is soft deleted
@@ -227,7 +655,7 @@ func (s *MessageServiceIntegrationTestSuite) TestDeleteMessageAdmin() {
 	s.testDB.DB.Create(adminUser)
 
 	adminUUID := testutil.ParseUUID(s.T(), adminUser.ID)
-	err := s.messageService.DeleteMessage(msg.MessageID, adminUUID, true)
+	_, err := s.messageService.DeleteMessage(msg.MessageID, adminUUID, true)
 	assert.NoError(s.T(), err)
 
 	// Verify message is soft deleted by admin
@@ -248,7 +676,7 @@ func (s *MessageServiceIntegrationTestSuite) TestDeleteMessageUnauthorized() {
 	s.testDB.DB.Create(msg)
 
 	// Regular user tries to delete other user's message
-	err := s.messageService.DeleteMessage(msg.MessageID, s.getUserID(), false)
+	_, err := s.messageService.DeleteMessage(msg.MessageID, s.getUserID(), false)
 	assert.Error(s.T(), err)
 	assert.Equal(s.T(), service.ErrUnauthorized, err)
 
@@ -258,6 +686,232 @@ func (s *MessageServiceIntegrationTestSuite) TestDeleteMessageUnauthorized() {
 	assert.False(s.T(), notDeletedMsg.DeletedAt.Valid)
 }
 
+// TestEditMessage tests that the author can edit and the previous content is kept
+func (s *MessageServiceIntegrationTestSuite) TestEditMessage() {
+	msg := testutil.CreateTestMessage(s.testUser.ID, "Frist!")
+	s.testDB.DB.Create(msg)
+
+	edited, err := s.messageService.EditMessage(msg.MessageID, s.getUserID(), false, "First! <b>fixed</b>")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), "First! &lt;b&gt;fixed&lt;/b&gt;", edited.Content, "Edits are sanitized like sends")
+	require.NotNil(s.T(), edited.EditedAt)
+
+	var stored models.Message
+	s.testDB.DB.Where("message_id = ?", msg.MessageID).First(&stored)
+	assert.Equal(s.T(), edited.Content, stored.Content)
+	assert.NotNil(s.T(), stored.EditedAt)
+
+	// Second edit appends to the history
+	_, err = s.messageService.EditMessage(msg.MessageID, s.getUserID(), false, "First!")
+	require.NoError(s.T(), err)
+
+	edits, err := s.messageService.GetMessageEdits(msg.MessageID)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), edits, 2)
+	assert.Equal(s.T(), "Frist!", edits[0].PreviousContent)
+	assert.Equal(s.T(), edited.Content, edits[1].PreviousContent)
+	assert.Equal(s.T(), s.getUserID(), edits[0].EditedBy)
+
+	// Validation applies to edits too
+	_, err = s.messageService.EditMessage(msg.MessageID, s.getUserID(), false, "")
+	assert.ErrorIs(s.T(), err, service.ErrMessageTooShort)
+}
+
+// TestEditMessageUnauthorized tests that only the author or an admin can edit
+func (s *MessageServiceIntegrationTestSuite) TestEditMessageUnauthorized() {
+	otherUser, _ := testutil.CreateTestUser("editother", "editother@example.com", "Pass123", models.RoleUser)
+	s.testDB.DB.Create(otherUser)
+
+	msg := testutil.CreateTestMessage(otherUser.ID, "Not yours")
+	s.testDB.DB.Create(msg)
+
+	_, err := s.messageService.EditMessage(msg.MessageID, s.getUserID(), false, "Hijacked")
+	assert.Equal(s.T(), service.ErrUnauthorized, err)
+
+	var unchanged models.Message
+	s.testDB.DB.Where("message_id = ?", msg.MessageID).First(&unchanged)
+	assert.Equal(s.T(), "Not yours", unchanged.Content)
+	assert.Nil(s.T(), unchanged.EditedAt)
+
+	// Admins may edit anyone's message
+	adminUser, _ := testutil.DefaultAdminUser()
+	s.testDB.DB.Create(adminUser)
+	adminUUID := testutil.ParseUUID(s.T(), adminUser.ID)
+
+	edited, err := s.messageService.EditMessage(msg.MessageID, adminUUID, true, "Moderated")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), "Moderated", edited.Content)
+}
+
+// TestReactions tests adding and removing reactions, the one-per-emoji rule,
+// the emoji allowlist and aggregated counts
+func (s *MessageServiceIntegrationTestSuite) TestReactions() {
+	otherUser, _ := testutil.CreateTestUser("reactor", "reactor@example.com", "Pass123", models.RoleUser)
+	s.testDB.DB.Create(otherUser)
+
+	msg := testutil.CreateTestMessage(s.testUser.ID, "React to me")
+	s.testDB.DB.Create(msg)
+
+	reacted, count, err := s.messageService.AddReaction(msg.MessageID, s.getUserID(), "👍")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), msg.RoomID, reacted.RoomID)
+	assert.Equal(s.T(), int64(1), count)
+
+	_, count, err = s.messageService.AddReaction(msg.MessageID, uuid.MustParse(otherUser.ID), "👍")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(2), count)
+
+	// Once per user per emoji, but other emojis are fine
+	_, _, err = s.messageService.AddReaction(msg.MessageID, s.getUserID(), "👍")
+	assert.ErrorIs(s.T(), err, service.ErrAlreadyReacted)
+	_, count, err = s.messageService.AddReaction(msg.MessageID, s.getUserID(), "🎉")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(1), count)
+
+	// Only allowlisted emojis on existing messages
+	_, _, err = s.messageService.AddReaction(msg.MessageID, s.getUserID(), "lol")
+	assert.ErrorIs(s.T(), err, service.ErrInvalidEmoji)
+	_, _, err = s.messageService.AddReaction(uuid.New().String(), s.getUserID(), "👍")
+	assert.ErrorIs(s.T(), err, service.ErrMessageNotFound)
+
+	counts, err := s.messageService.GetReactionCounts([]string{msg.MessageID})
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), map[string]int64{"👍": 2, "🎉": 1}, counts[msg.MessageID])
+
+	_, count, err = s.messageService.RemoveReaction(msg.MessageID, s.getUserID(), "👍")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(1), count)
+	_, _, err = s.messageService.RemoveReaction(msg.MessageID, s.getUserID(), "👍")
+	assert.ErrorIs(s.T(), err, service.ErrReactionNotFound)
+}
+
+// TestReactionToPendingMessage tests that a message still in the WAL can be
+// reacted to: it is persisted first instead of reported missing
+func (s *MessageServiceIntegrationTestSuite) TestReactionToPendingMessage() {
+	msg, err := s.messageService.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, "Just sent")
+	require.NoError(s.T(), err)
+
+	reacted, count, err := s.messageService.AddReaction(msg.MessageID, s.getUserID(), "👍")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), msg.RoomID, reacted.RoomID)
+	assert.Equal(s.T(), int64(1), count)
+
+	entries, err := s.walInstance.GetAllEntries()
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), entries, "the message should have been persisted")
+}
+
+// TestExtractMentions tests mention parsing on sanitized (escaped) content
+func (s *MessageServiceIntegrationTestSuite) TestExtractMentions() {
+	tests := []struct {
+		content string
+		want    []string
+	}{
+		{"hi @Alice and @bob", []string{"alice", "bob"}},
+		{"@alice @ALICE again", []string{"alice"}},
+		{"ping @bob.smith.", []string{"bob.smith"}},
+		{"<@alice> '@bob's \"@carol\" & @dave", []string{"alice", "bob", "carol", "dave"}},
+		{"mail me@alice.example or see https://x.example/@bob", nil},
+		{"run `ssh @alice` then ```\n@bob\n``` @carol", []string{"carol"}},
+		{"no mentions here", nil},
+	}
+	for _, tt := range tests {
+		assert.Equal(s.T(), tt.want, service.ExtractMentions(html.EscapeString(tt.content)), tt.content)
+	}
+}
+
+// TestMentionsAreRecordedForExistingUsers tests that mentions land in the
+// notification feed of real users only, and can be marked read
+func (s *MessageServiceIntegrationTestSuite) TestMentionsAreRecordedForExistingUsers() {
+	mentioned, _ := testutil.CreateTestUser("Mentioned", "mentioned@example.com", "Pass123", models.RoleUser)
+	s.testDB.DB.Create(mentioned)
+	mentionedID := testutil.ParseUUID(s.T(), mentioned.ID)
+
+	msg, err := s.messageService.SendMessage(s.getUserID(), "testuser", "mentions-room",
+		"@mentioned @nobody-here @testuser see `@mentioned`")
+	require.NoError(s.T(), err)
+	_, err = s.messageService.SendMessage(s.getUserID(), "testuser", "mentions-room", "hey @MENTIONED <3")
+	require.NoError(s.T(), err)
+
+	var mentions []models.Mention
+	require.Eventually(s.T(), func() bool {
+		mentions, err = s.messageService.GetUnreadMentions(mentionedID, 50)
+		return err == nil && len(mentions) == 2
+	}, 3*time.Second, 20*time.Millisecond)
+
+	first := mentions[1] // Newest first
+	assert.Equal(s.T(), msg.MessageID, first.MessageID)
+	assert.Equal(s.T(), s.getUserID(), first.AuthorID)
+	assert.Equal(s.T(), "testuser", first.AuthorUsername)
+	assert.Equal(s.T(), "mentions-room", first.RoomID)
+
+	// Unknown names and the author's own name create nothing
+	var total int64
+	s.testDB.DB.Model(&models.Mention{}).Where("message_id = ?", msg.MessageID).Count(&total)
+	assert.Equal(s.T(), int64(1), total)
+
+	marked, err := s.messageService.MarkMentionsRead(mentionedID, []uint64{first.ID})
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(1), marked)
+
+	marked, err = s.messageService.MarkMentionsRead(mentionedID, nil)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(1), marked)
+
+	mentions, err = s.messageService.GetUnreadMentions(mentionedID, 50)
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), mentions)
+}
+
+// TestRestoreOwnDeletedMessage tests that authors can restore self-deleted messages
+func (s *MessageServiceIntegrationTestSuite) TestRestoreOwnDeletedMessage() {
+	msg := testutil.CreateTestMessageWithDelete(s.testUser.ID, "Oops, deleted", s.testUser.ID, false)
+	s.testDB.DB.Create(msg)
+
+	deleted, err := s.messageService.GetDeletedMessages(s.getUserID(), 50)
+	assert.NoError(s.T(), err)
+	assert.Len(s.T(), deleted, 1)
+
+	restored, err := s.messageService.RestoreMessage(msg.MessageID, s.getUserID())
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), msg.MessageID, restored.MessageID)
+
+	// Message is visible again (default scope excludes deleted rows)
+	var visible models.Message
+	err = s.testDB.DB.Where("message_id = ?", msg.MessageID).First(&visible).Error
+	assert.NoError(s.T(), err)
+	assert.Nil(s.T(), visible.DeletedBy)
+	assert.False(s.T(), visible.IsDeletedByAdmin)
+}
+
+// TestRestoreAdminDeletedMessageRejected tests that admin removals are final for authors
+func (s *MessageServiceIntegrationTestSuite) TestRestoreAdminDeletedMessageRejected() {
+	adminUser, _ := testutil.CreateTestUser("restoreadmin", "restoreadmin@example.com", "Admin123456", models.RoleAdmin)
+	s.testDB.DB.Create(adminUser)
+
+	msg := testutil.CreateTestMessageWithDelete(s.testUser.ID, "Removed by admin", adminUser.ID, true)
+	s.testDB.DB.Create(msg)
+
+	restored, err := s.messageService.RestoreMessage(msg.MessageID, s.getUserID())
+	assert.Nil(s.T(), restored)
+	assert.ErrorIs(s.T(), err, service.ErrRestoreDenied)
+
+	// Still deleted
+	var stillDeleted models.Message
+	s.testDB.DB.Unscoped().Where("message_id = ?", msg.MessageID).First(&stillDeleted)
+	assert.True(s.T(), stillDeleted.DeletedAt.Valid)
+	assert.True(s.T(), stillDeleted.IsDeletedByAdmin)
+
+	// Another user's message is reported as not found
+	otherUser, _ := testutil.CreateTestUser("restoreother", "restoreother@example.com", "Pass123456", models.RoleUser)
+	s.testDB.DB.Create(otherUser)
+	otherMsg := testutil.CreateTestMessageWithDelete(otherUser.ID, "Not yours", otherUser.ID, false)
+	s.testDB.DB.Create(otherMsg)
+
+	_, err = s.messageService.RestoreMessage(otherMsg.MessageID, s.getUserID())
+	assert.ErrorIs(s.T(), err, service.ErrMessageNotFound)
+}
+
 // TestGetRecentMessages tests retrieving recent messages from cache/database
 func (s *MessageServiceIntegrationTestSuite) TestGetRecentMessages() {
 	// Create 10 messages directly in database
@@ -268,7 +922,7 @@ func (s *MessageServiceIntegrationTestSuite) TestGetRecentMessages() {
 	}
 
 	// Get recent messages
-	messages, err := s.messageService.GetRecentMessages(5)
+	messages, err := s.messageService.GetRecentMessages(models.DefaultRoomID, 5)
 	assert.NoError(s.T(), err)
 	assert.Len(s.T(), messages, 5)
 
@@ -276,6 +930,602 @@ func (s *MessageServiceIntegrationTestSuite) TestGetRecentMessages() {
 	assert.True(s.T(), messages[0].CreatedAt.After(messages[4].CreatedAt) || messages[0].CreatedAt.Equal(messages[4].CreatedAt))
 }
 
+// TestPurgeCacheRepopulatesFromDatabase tests that a purge drops bad cache entries
+// and the next read reloads from PostgreSQL
+func (s *MessageServiceIntegrationTestSuite) TestPurgeCacheRepopulatesFromDatabase() {
+	s.testRedis.Server.FlushAll()
+	for i := 0; i < 3; i++ {
+		msg := testutil.CreateTestMessage(s.testUser.ID, fmt.Sprintf("Persisted %d", i))
+		s.testDB.DB.Create(msg)
+	}
+
+	// A corrupted entry that only exists in the cache
+	redisBroker, err := broker.NewRedisMessageBroker(s.testRedis.URL, broker.BrokerConfig{})
+	require.NoError(s.T(), err)
+	defer redisBroker.Close()
+	require.NoError(s.T(), redisBroker.CacheMessage(models.Message{MessageID: "bad-entry", Content: "garbage"}))
+
+	messages, err := s.messageService.GetRecentMessages(models.DefaultRoomID, 10)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), messages, 1, "Cache hit serves the bad entry")
+
+	require.NoError(s.T(), s.messageService.PurgeCache())
+	assert.False(s.T(), s.testRedis.Server.Exists(broker.RecentCacheKey(models.DefaultRoomID)))
+
+	messages, err = s.messageService.GetRecentMessages(models.DefaultRoomID, 10)
+	require.NoError(s.T(), err)
+	assert.Len(s.T(), messages, 3, "Cache miss reads PostgreSQL")
+
+	// The miss re-warms the cache in the background
+	assert.Eventually(s.T(), func() bool {
+		cached, err := redisBroker.GetRecentMessages(models.DefaultRoomID, 10)
+		return err == nil && len(cached) == 3
+	}, time.Second, 10*time.Millisecond)
+}
+
+// TestRecentCacheExpires tests that a recent-messages list nobody writes to
+// expires and the next read repopulates it from PostgreSQL
+func (s *MessageServiceIntegrationTestSuite) TestRecentCacheExpires() {
+	s.testRedis.Server.FlushAll()
+	for i := 0; i < 3; i++ {
+		msg := testutil.CreateTestMessage(s.testUser.ID, fmt.Sprintf("Persisted %d", i))
+		s.testDB.DB.Create(msg)
+	}
+
+	redisBroker, err := broker.NewRedisMessageBroker(s.testRedis.URL, broker.BrokerConfig{RecentTTL: time.Hour})
+	require.NoError(s.T(), err)
+	defer redisBroker.Close()
+	svc := service.NewMessageService(
+		repository.NewMessageRepository(s.testDB.DB),
+		repository.NewUserRepository(s.testDB.DB),
+		redisBroker, s.walInstance, service.MessageServiceConfig{},
+	)
+
+	// A stale entry that only exists in the cache
+	key := broker.RecentCacheKey(models.DefaultRoomID)
+	require.NoError(s.T(), redisBroker.CacheMessage(models.Message{MessageID: "stale-entry", Content: "stale"}))
+	assert.Equal(s.T(), time.Hour, s.testRedis.Server.TTL(key))
+
+	// Writes push the expiry back
+	s.testRedis.Server.FastForward(30 * time.Minute)
+	require.NoError(s.T(), redisBroker.CacheMessage(models.Message{MessageID: "stale-entry-2", Content: "stale"}))
+	assert.Equal(s.T(), time.Hour, s.testRedis.Server.TTL(key))
+
+	messages, err := svc.GetRecentMessages(models.DefaultRoomID, 10)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), messages, 2, "Cache hit serves the stale entries")
+
+	s.testRedis.Server.FastForward(time.Hour)
+	assert.False(s.T(), s.testRedis.Server.Exists(key))
+
+	messages, err = svc.GetRecentMessages(models.DefaultRoomID, 10)
+	require.NoError(s.T(), err)
+	assert.Len(s.T(), messages, 3, "Expired cache reads PostgreSQL")
+
+	// The repopulated list expires too
+	assert.Eventually(s.T(), func() bool {
+		cached, err := redisBroker.GetRecentMessages(models.DefaultRoomID, 10)
+		return err == nil && len(cached) == 3
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(s.T(), time.Hour, s.testRedis.Server.TTL(key))
+}
+
+// TestCacheStats tests that hit and miss counters follow where reads are served from
+func (s *MessageServiceIntegrationTestSuite) TestCacheStats() {
+	s.testRedis.Server.FlushAll()
+	s.testDB.DB.Create(testutil.CreateTestMessage(s.testUser.ID, "Persisted"))
+	svc := s.newMessageService(service.MessageServiceConfig{})
+	assert.Equal(s.T(), service.CacheStats{}, svc.CacheStats())
+
+	// Empty cache: served by PostgreSQL, which warms the cache
+	_, err := svc.GetRecentMessages(models.DefaultRoomID, 10)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), service.CacheStats{Misses: 1}, svc.CacheStats())
+
+	assert.Eventually(s.T(), func() bool {
+		return s.testRedis.Server.Exists(broker.RecentCacheKey(models.DefaultRoomID))
+	}, time.Second, 10*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		_, err = svc.GetRecentMessages(models.DefaultRoomID, 10)
+		require.NoError(s.T(), err)
+	}
+	stats := svc.CacheStats()
+	assert.Equal(s.T(), uint64(3), stats.Hits)
+	assert.Equal(s.T(), uint64(1), stats.Misses)
+	assert.InDelta(s.T(), 0.75, stats.HitRatio, 1e-9)
+}
+
+// TestBatchWriterMetrics tests that the WAL backlog, batch write timings and
+// deletes are recorded
+func (s *MessageServiceIntegrationTestSuite) TestBatchWriterMetrics() {
+	reg := prometheus.NewRegistry()
+	svc := s.newMessageService(service.MessageServiceConfig{Metrics: metrics.New(reg)})
+
+	var last *models.Message
+	for i := 0; i < 3; i++ {
+		msg, err := svc.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, fmt.Sprintf("Metered %d", i))
+		require.NoError(s.T(), err)
+		last = msg
+	}
+	require.NoError(s.T(), promtest.GatherAndCompare(reg, strings.NewReader(`
+# HELP digital_square_wal_entries_pending WAL entries not yet persisted to PostgreSQL.
+# TYPE digital_square_wal_entries_pending gauge
+digital_square_wal_entries_pending 3
+`), "digital_square_wal_entries_pending"))
+
+	persisted, err := svc.ProcessBatchNow()
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 3, persisted)
+
+	_, err = svc.DeleteMessage(last.MessageID, s.getUserID(), false)
+	require.NoError(s.T(), err)
+
+	families, err := reg.Gather()
+	require.NoError(s.T(), err)
+	values := make(map[string]float64)
+	for _, family := range families {
+		metric := family.GetMetric()[0]
+		switch {
+		case metric.GetHistogram() != nil:
+			values[family.GetName()] = float64(metric.GetHistogram().GetSampleCount())
+		case metric.GetGauge() != nil:
+			values[family.GetName()] = metric.GetGauge().GetValue()
+		case metric.GetCounter() != nil:
+			values[family.GetName()] = metric.GetCounter().GetValue()
+		}
+	}
+	assert.Equal(s.T(), 0.0, values["digital_square_wal_entries_pending"])
+	assert.Equal(s.T(), 1.0, values["digital_square_batch_write_duration_seconds"], "One batch observed")
+	assert.Equal(s.T(), 1.0, values["digital_square_messages_deleted_total"])
+}
+
+// TestRoomsAreIsolated tests that cache, history and pagination are scoped to a room
+func (s *MessageServiceIntegrationTestSuite) TestRoomsAreIsolated() {
+	s.testRedis.Server.FlushAll()
+
+	for i := 0; i < 2; i++ {
+		_, err := s.messageService.SendMessage(s.getUserID(), s.testUser.Username, "", fmt.Sprintf("General %d", i))
+		require.NoError(s.T(), err)
+	}
+	random, err := s.messageService.SendMessage(s.getUserID(), s.testUser.Username, "random", "Hi random")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), "random", random.RoomID)
+
+	_, err = s.messageService.SendMessage(s.getUserID(), s.testUser.Username, "Not A Room!", "nope")
+	assert.ErrorIs(s.T(), err, service.ErrInvalidRoom)
+
+	// An entry written before rooms existed has no room_id
+	require.NoError(s.T(), s.walInstance.Write(wal.WALEntry{
+		MessageID: uuid.New().String(),
+		UserID:    s.testUser.ID,
+		Content:   "From before rooms",
+		Timestamp: time.Now(),
+	}))
+
+	// Cache (writes are async)
+	assert.Eventually(s.T(), func() bool {
+		cached, err := s.messageService.GetRecentMessages("random", 10)
+		return err == nil && len(cached) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// PostgreSQL
+	_, err = s.messageService.ProcessBatchNow()
+	require.NoError(s.T(), err)
+	s.testRedis.Server.FlushAll()
+
+	general, err := s.messageService.GetRecentMessages(models.DefaultRoomID, 10)
+	require.NoError(s.T(), err)
+	assert.Len(s.T(), general, 3, "Unroomed WAL entries land in general")
+	for _, msg := range general {
+		assert.Equal(s.T(), models.DefaultRoomID, msg.RoomID)
+	}
+
+	rooms, err := s.messageService.GetRecentMessages("random", 10)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), rooms, 1)
+	assert.Equal(s.T(), random.MessageID, rooms[0].MessageID)
+
+	// Pagination never crosses rooms
+	before, err := s.messageService.GetMessagesBefore("random", rooms[0].ID+100, 50, false)
+	require.NoError(s.T(), err)
+	assert.Len(s.T(), before, 1)
+
+	before, err = s.messageService.GetMessagesBefore(models.DefaultRoomID, rooms[0].ID+100, 50, false)
+	require.NoError(s.T(), err)
+	assert.Len(s.T(), before, 3)
+}
+
+// TestMalformedWALUserIDIsDeadLettered verifies a bad user ID isn't stored as the zero UUID
+func (s *MessageServiceIntegrationTestSuite) TestMalformedWALUserIDIsDeadLettered() {
+	os.Remove(s.walInstance.DeadLetterPath())
+	defer os.Remove(s.walInstance.DeadLetterPath())
+
+	good, err := s.messageService.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, "Valid message")
+	require.NoError(s.T(), err)
+
+	badID := uuid.New().String()
+	require.NoError(s.T(), s.walInstance.Write(wal.WALEntry{
+		MessageID: badID,
+		UserID:    "not-a-uuid",
+		Content:   "Malformed author",
+		Timestamp: time.Now(),
+	}))
+
+	persisted, err := s.messageService.ProcessBatchNow()
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 1, persisted)
+
+	var zeroAttributed int64
+	s.testDB.DB.Model(&models.Message{}).Where("user_id = ?", uuid.Nil).Count(&zeroAttributed)
+	assert.Zero(s.T(), zeroAttributed, "No message may be attributed to the zero UUID")
+
+	var stored models.Message
+	require.NoError(s.T(), s.testDB.DB.Where("message_id = ?", good.MessageID).First(&stored).Error)
+	assert.Equal(s.T(), s.getUserID(), stored.UserID)
+
+	// The bad entry leaves the WAL but is kept in the dead-letter file
+	remaining, err := s.walInstance.GetAllEntries()
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), remaining)
+
+	data, err := os.ReadFile(s.walInstance.DeadLetterPath())
+	require.NoError(s.T(), err)
+	assert.Contains(s.T(), string(data), badID)
+	assert.NotContains(s.T(), string(data), good.MessageID)
+}
+
+// moderationWebhook starts a stub moderation service that blocks content containing "spam"
+func (s *MessageServiceIntegrationTestSuite) moderationWebhook(delay time.Duration) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		var req moderation.Request
+		json.NewDecoder(r.Body).Decode(&req)
+		switch {
+		case strings.Contains(req.Content, "spam"):
+			w.Write([]byte(`{"decision":"block","reason":"looks like spam"}`))
+		case strings.Contains(req.Content, "iffy"):
+			w.Write([]byte(`{"decision":"flag"}`))
+		default:
+			w.Write([]byte(`{"decision":"allow"}`))
+		}
+	}))
+	s.T().Cleanup(server.Close)
+	return server
+}
+
+// TestModerationWebhookDecisions verifies blocked messages never reach the WAL
+func (s *MessageServiceIntegrationTestSuite) TestModerationWebhookDecisions() {
+	server := s.moderationWebhook(0)
+	svc := s.newMessageService(service.MessageServiceConfig{
+		Moderator: moderation.NewWebhookModerator(moderation.WebhookConfig{URL: server.URL}),
+	})
+
+	allowed, err := svc.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, "Hello <everyone>")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), "Hello &lt;everyone&gt;", allowed.Content)
+
+	_, err = svc.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, "Buy spam now")
+	assert.ErrorIs(s.T(), err, service.ErrMessageBlocked)
+	assert.Contains(s.T(), err.Error(), "looks like spam")
+
+	flagged, err := svc.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, "Somewhat iffy")
+	require.NoError(s.T(), err, "Flagged messages are still delivered")
+
+	entries, err := s.walInstance.GetAllEntries()
+	require.NoError(s.T(), err)
+	require.Len(s.T(), entries, 2)
+	assert.Equal(s.T(), allowed.MessageID, entries[0].MessageID)
+	assert.Equal(s.T(), flagged.MessageID, entries[1].MessageID)
+}
+
+// TestEditMessageModerated verifies edits can't slip blocked content past the webhook
+func (s *MessageServiceIntegrationTestSuite) TestEditMessageModerated() {
+	server := s.moderationWebhook(0)
+	svc := s.newMessageService(service.MessageServiceConfig{
+		Moderator: moderation.NewWebhookModerator(moderation.WebhookConfig{URL: server.URL}),
+	})
+	msg := testutil.CreateTestMessage(s.testUser.ID, "Hello")
+	s.testDB.DB.Create(msg)
+
+	_, err := svc.EditMessage(msg.MessageID, s.getUserID(), false, "Buy spam now")
+	assert.ErrorIs(s.T(), err, service.ErrMessageBlocked)
+
+	var stored models.Message
+	s.testDB.DB.Where("message_id = ?", msg.MessageID).First(&stored)
+	assert.Equal(s.T(), "Hello", stored.Content)
+	assert.Nil(s.T(), stored.EditedAt)
+}
+
+// TestModerationWebhookTimeout verifies the fail-open/closed policy when the webhook is too slow
+func (s *MessageServiceIntegrationTestSuite) TestModerationWebhookTimeout() {
+	server := s.moderationWebhook(200 * time.Millisecond)
+	moderator := moderation.NewWebhookModerator(moderation.WebhookConfig{URL: server.URL, Timeout: 20 * time.Millisecond})
+
+	closed := s.newMessageService(service.MessageServiceConfig{Moderator: moderator})
+	_, err := closed.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, "Hello")
+	assert.ErrorIs(s.T(), err, service.ErrModerationUnavailable)
+
+	open := s.newMessageService(service.MessageServiceConfig{Moderator: moderator, ModerationFailOpen: true})
+	msg, err := open.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, "Hello")
+	require.NoError(s.T(), err)
+
+	entries, err := s.walInstance.GetAllEntries()
+	require.NoError(s.T(), err)
+	require.Len(s.T(), entries, 1, "Only the fail-open send is accepted")
+	assert.Equal(s.T(), msg.MessageID, entries[0].MessageID)
+}
+
+// TestGetMessagesAfter tests forward paging: oldest first, scoped to the
+// room and capped at MaxPageSize
+func (s *MessageServiceIntegrationTestSuite) TestGetMessagesAfter() {
+	var ids []uint64
+	for i := 0; i < service.MaxPageSize+20; i++ {
+		msg := testutil.CreateTestMessage(s.testUser.ID, fmt.Sprintf("message %d", i))
+		s.testDB.DB.Create(msg)
+		ids = append(ids, msg.ID)
+	}
+	other := testutil.CreateTestMessage(s.testUser.ID, "elsewhere")
+	other.RoomID = "random"
+	s.testDB.DB.Create(other)
+
+	messages, err := s.messageService.GetMessagesAfter("", ids[9], 5, false)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), messages, 5)
+	for i, msg := range messages {
+		assert.Equal(s.T(), ids[10+i], msg.ID)
+	}
+
+	// Asking for more than the cap gets a capped page, never the other room
+	messages, err = s.messageService.GetMessagesAfter("", 0, 100000, false)
+	require.NoError(s.T(), err)
+	assert.Len(s.T(), messages, service.MaxPageSize)
+
+	messages, err = s.messageService.GetMessagesAfter("general", ids[len(ids)-1], 50, false)
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), messages)
+}
+
+// TestGetMessagesSince tests reconnect catch-up: persisted messages after the
+// last seen ID plus ones still only in the WAL, without what the client has
+func (s *MessageServiceIntegrationTestSuite) TestGetMessagesSince() {
+	const room = "catchup"
+	send := func(content string) {
+		_, err := s.messageService.SendMessage(s.getUserID(), s.testUser.Username, room, content)
+		require.NoError(s.T(), err)
+
+		// Caching is asynchronous; keep the cache in send order
+		require.Eventually(s.T(), func() bool {
+			recent, err := s.messageService.GetRecentMessages(room, 1)
+			return err == nil && len(recent) == 1 && recent[0].Content == content
+		}, time.Second, 5*time.Millisecond)
+	}
+	contents := func(messages []models.Message) []string {
+		out := make([]string, len(messages))
+		for i, msg := range messages {
+			out[i] = msg.Content
+		}
+		return out
+	}
+
+	send("p1")
+	send("p2")
+	send("p3")
+	_, err := s.messageService.ProcessBatchNow()
+	require.NoError(s.T(), err)
+	persisted, err := s.messageService.GetMessagesAfter(room, 0, 10, false)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), persisted, 3)
+	send("w1")
+	send("w2")
+
+	// Newest first, like the initial history
+	missed, caughtUp, err := s.messageService.GetMessagesSince(room, persisted[0].ID, 100)
+	require.NoError(s.T(), err)
+	assert.True(s.T(), caughtUp)
+	assert.Equal(s.T(), []string{"w2", "w1", "p3", "p2"}, contents(missed))
+
+	// An ID ahead of the room is clamped to its newest message
+	missed, caughtUp, err = s.messageService.GetMessagesSince(room, persisted[2].ID+1000, 100)
+	require.NoError(s.T(), err)
+	assert.True(s.T(), caughtUp)
+	assert.Equal(s.T(), []string{"w2", "w1"}, contents(missed))
+
+	// More missed than the limit: the latest page instead
+	missed, caughtUp, err = s.messageService.GetMessagesSince(room, persisted[0].ID, 3)
+	require.NoError(s.T(), err)
+	assert.False(s.T(), caughtUp)
+	assert.Equal(s.T(), []string{"w2", "w1", "p3"}, contents(missed))
+}
+
+// TestCountRoomMessages tests that room totals skip deleted messages and
+// other rooms, and are served from the cache until the TTL passes
+func (s *MessageServiceIntegrationTestSuite) TestCountRoomMessages() {
+	s.testRedis.Server.FlushAll()
+	for i := 0; i < 3; i++ {
+		s.testDB.DB.Create(testutil.CreateTestMessage(s.testUser.ID, fmt.Sprintf("message %d", i)))
+	}
+	s.testDB.DB.Create(testutil.CreateTestMessageWithDelete(s.testUser.ID, "gone", s.testUser.ID, false))
+	other := testutil.CreateTestMessage(s.testUser.ID, "elsewhere")
+	other.RoomID = "random"
+	s.testDB.DB.Create(other)
+
+	svc := s.newMessageService(service.MessageServiceConfig{
+		RoomCountCacheKey: "test_total:",
+		RoomCountCacheTTL: time.Minute,
+	})
+
+	total, err := svc.CountRoomMessages("")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(3), total)
+	assert.True(s.T(), s.testRedis.Server.Exists("test_total:general"))
+	assert.Equal(s.T(), time.Minute, s.testRedis.Server.TTL("test_total:general"))
+
+	// New messages show up only once the cached total expires
+	s.testDB.DB.Create(testutil.CreateTestMessage(s.testUser.ID, "late"))
+	total, err = svc.CountRoomMessages("general")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(3), total, "Served from the cache")
+
+	s.testRedis.Server.FastForward(time.Minute)
+	total, err = svc.CountRoomMessages("general")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(4), total)
+
+	total, err = svc.CountRoomMessages("random")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(1), total)
+
+	_, err = svc.CountRoomMessages("Not A Room!")
+	assert.Error(s.T(), err)
+}
+
+// TestSearchMessagesCaps tests that search limits are clamped, deep offsets
+// are rejected and the total ignores paging
+func (s *MessageServiceIntegrationTestSuite) TestSearchMessagesCaps() {
+	for i := 0; i < 12; i++ {
+		content := fmt.Sprintf("needle %d", i)
+		if i%3 == 0 {
+			content = fmt.Sprintf("hay %d", i)
+		}
+		msg := testutil.CreateTestMessage(s.testUser.ID, content)
+		s.testDB.DB.Create(msg)
+	}
+	searchService := s.newMessageService(service.MessageServiceConfig{
+		SearchMaxLimit:    5,
+		SearchMaxIDsLimit: 7,
+		SearchMaxOffset:   6,
+	})
+
+	// Limit above the cap is clamped; total counts every match
+	result, err := searchService.SearchMessages(repository.MessageSearch{Query: "NEEDLE", Limit: 1000}, false)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(8), result.Total)
+	assert.Equal(s.T(), 5, result.Limit)
+	assert.Len(s.T(), result.Messages, 5)
+	assert.Nil(s.T(), result.IDs)
+
+	// IDs-only pages get the larger cap and carry no messages
+	result, err = searchService.SearchMessages(repository.MessageSearch{Query: "needle", Limit: 1000}, true)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 7, result.Limit)
+	assert.Len(s.T(), result.IDs, 7)
+	assert.Nil(s.T(), result.Messages)
+
+	// Offset at the cap is allowed, beyond it is rejected
+	result, err = searchService.SearchMessages(repository.MessageSearch{Query: "needle", Offset: 6}, false)
+	require.NoError(s.T(), err)
+	assert.Len(s.T(), result.Messages, 2)
+
+	_, err = searchService.SearchMessages(repository.MessageSearch{Query: "needle", Offset: 7}, false)
+	assert.ErrorIs(s.T(), err, service.ErrSearchOffsetTooLarge)
+
+	// Wildcards in the query match literally
+	result, err = searchService.SearchMessages(repository.MessageSearch{Query: "%"}, false)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(0), result.Total)
+}
+
+// TestSearchMatchesEscapedContent tests that queries with characters escaped
+// on send (& ' < ") find the stored messages
+func (s *MessageServiceIntegrationTestSuite) TestSearchMatchesEscapedContent() {
+	_, err := s.messageService.SendMessage(s.getUserID(), s.testUser.Username, models.DefaultRoomID, `Tom & Jerry's <b>"show"</b>`)
+	require.NoError(s.T(), err)
+	_, err = s.messageService.ProcessBatchNow()
+	require.NoError(s.T(), err)
+
+	for _, query := range []string{"Tom & Jerry", "Jerry's", "<b>", `"show"`} {
+		result, err := s.messageService.SearchMessages(repository.MessageSearch{Query: query}, false)
+		require.NoError(s.T(), err)
+		assert.Equal(s.T(), int64(1), result.Total, "substring search for %q", query)
+
+		result, err = s.messageService.FullTextSearch(query, 10, 0, false)
+		require.NoError(s.T(), err)
+		assert.Equal(s.T(), int64(1), result.Total, "full-text search for %q", query)
+	}
+}
+
+// TestFullTextSearch tests that every word must match, deleted messages are
+// only found by admins, and hostile input is treated as plain words
+func (s *MessageServiceIntegrationTestSuite) TestFullTextSearch() {
+	for _, content := range []string{"the quick brown fox", "a quick reply", "brown bread, quick"} {
+		s.testDB.DB.Create(testutil.CreateTestMessage(s.testUser.ID, content))
+	}
+	deleted := testutil.CreateTestMessage(s.testUser.ID, "quick and brown but deleted")
+	s.testDB.DB.Create(deleted)
+	s.testDB.DB.Model(deleted).Update("deleted_at", time.Now())
+
+	result, err := s.messageService.FullTextSearch("Quick BROWN", 10, 0, false)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(2), result.Total)
+	for _, msg := range result.Messages {
+		assert.False(s.T(), msg.DeletedAt.Valid)
+	}
+
+	result, err = s.messageService.FullTextSearch("quick brown", 1, 0, true)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(3), result.Total, "Admins also find deleted messages")
+	assert.Len(s.T(), result.Messages, 1)
+
+	_, err = s.messageService.FullTextSearch("   ", 10, 0, false)
+	assert.ErrorIs(s.T(), err, service.ErrEmptySearch)
+
+	result, err = s.messageService.FullTextSearch("'); DROP TABLE messages; --", 10, 0, false)
+	require.NoError(s.T(), err)
+	assert.Zero(s.T(), result.Total)
+	var count int64
+	require.NoError(s.T(), s.testDB.DB.Model(&testutil.TestMessage{}).Count(&count).Error)
+	assert.Equal(s.T(), int64(4), count)
+}
+
+// TestMutedUserCannotSend tests that a mute rejects sends and edits with its
+// expiry until an admin lifts it, and that mutes of unknown users fail
+func (s *MessageServiceIntegrationTestSuite) TestMutedUserCannotSend() {
+	redisBroker, err := broker.NewRedisMessageBroker(s.testRedis.URL, broker.BrokerConfig{})
+	require.NoError(s.T(), err)
+	authService := service.NewAuthService(
+		repository.NewUserRepository(s.testDB.DB),
+		repository.NewMessageRepository(s.testDB.DB),
+		repository.NewAuditLogRepository(s.testDB.DB),
+		repository.NewRefreshTokenRepository(s.testDB.DB),
+		repository.NewVerificationTokenRepository(s.testDB.DB),
+		redisBroker,
+		"test-secret-key", time.Hour, "development", service.AuthServiceConfig{},
+	)
+	adminID := uuid.New().String()
+	userID := s.getUserID()
+
+	assert.ErrorIs(s.T(), authService.MuteUser(userID.String(), adminID, 0, "spam"), service.ErrInvalidMuteDuration)
+	assert.ErrorIs(s.T(), authService.MuteUser(uuid.New().String(), adminID, time.Minute, "spam"), service.ErrUserNotFound)
+
+	require.NoError(s.T(), authService.MuteUser(userID.String(), adminID, 10*time.Minute, "spam"))
+	_, err = s.messageService.SendMessage(userID, "testuser", "", "still here?")
+	require.ErrorIs(s.T(), err, service.ErrUserMuted)
+	assert.Contains(s.T(), err.Error(), "you are muted until ")
+
+	// Editing an earlier message can't get around the mute
+	msg := testutil.CreateTestMessage(s.testUser.ID, "Hello")
+	s.testDB.DB.Create(msg)
+	_, err = s.messageService.EditMessage(msg.MessageID, userID, false, "spam spam spam")
+	require.ErrorIs(s.T(), err, service.ErrUserMuted)
+	var stored models.Message
+	s.testDB.DB.Where("message_id = ?", msg.MessageID).First(&stored)
+	assert.Equal(s.T(), "Hello", stored.Content)
+
+	// Reading is unaffected
+	_, err = s.messageService.GetRecentMessages("", 10)
+	assert.NoError(s.T(), err)
+
+	require.NoError(s.T(), authService.UnmuteUser(userID.String(), adminID))
+	assert.ErrorIs(s.T(), authService.UnmuteUser(userID.String(), adminID), service.ErrNotMuted)
+	_, err = s.messageService.SendMessage(userID, "testuser", "", "back again")
+	assert.NoError(s.T(), err)
+
+	var actions []models.AuditAction
+	s.testDB.DB.Model(&models.AuditLog{}).Order("id").Pluck("action", &actions)
+	assert.Equal(s.T(), []models.AuditAction{models.AuditActionMute, models.AuditActionUnmute}, actions)
+}
+
 // TestSuite runs all tests in the suite
 func TestMessageServiceIntegrationTestSuite(t *testing.T) {
 	suite.Run(t, new(MessageServiceIntegrationTestSuite))
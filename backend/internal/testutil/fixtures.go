@@ -17,13 +17,14 @@ func CreateTestUser(username, email, password string, role models.Role) (*TestUs
 	}
 
 	return &TestUser{
-		ID:           uuid.New().String(), // SQLite stores UUID as string
-		Username:     username,
-		Email:        email,
-		PasswordHash: hashedPassword,
-		Role:         string(role),
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now(),
+		ID:            uuid.New().String(), // SQLite stores UUID as string
+		Username:      username,
+		Email:         email,
+		PasswordHash:  hashedPassword,
+		Role:          string(role),
+		EmailVerified: true, // Fixtures can send; tests of unverified accounts clear it
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
 	}, nil
 }
 
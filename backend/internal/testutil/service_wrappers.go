@@ -16,13 +16,13 @@ func WrapMessageService(svc *service.MessageService) *TestMessageService {
 	return &TestMessageService{svc}
 }
 
-// SendMessageStr accepts string UUID (SQLite-compatible) and converts to uuid.UUID
+// SendMessageStr accepts string UUID (SQLite-compatible) and sends to the default room
 func (t *TestMessageService) SendMessageStr(userID, username, content string) (*models.Message, error) {
 	uid, err := uuid.Parse(userID)
 	if err != nil {
 		return nil, err
 	}
-	return t.MessageService.SendMessage(uid, username, content)
+	return t.MessageService.SendMessage(uid, username, models.DefaultRoomID, content)
 }
 
 // DeleteMessageStr accepts string UUID (SQLite-compatible) and converts to uuid.UUID
@@ -31,5 +31,6 @@ func (t *TestMessageService) DeleteMessageStr(messageID, userID string, isAdmin
 	if err != nil {
 		return err
 	}
-	return t.MessageService.DeleteMessage(messageID, uid, isAdmin)
+	_, err = t.MessageService.DeleteMessage(messageID, uid, isAdmin)
+	return err
 }
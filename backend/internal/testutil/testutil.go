@@ -25,14 +25,15 @@ type TestRedis struct {
 
 // TestUser is a SQLite-compatible version of models.User for testing
 type TestUser struct {
-	ID           string `gorm:"type:text;primaryKey"` // SQLite uses TEXT for UUID
-	Username     string `gorm:"type:varchar(50);uniqueIndex;not null"`
-	Email        string `gorm:"type:varchar(100);uniqueIndex;not null"`
-	PasswordHash string `gorm:"type:varchar(255);not null"`
-	Role         string `gorm:"type:varchar(20);not null;default:'user'"`
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
-	DeletedAt    gorm.DeletedAt `gorm:"index"`
+	ID            string `gorm:"type:text;primaryKey"` // SQLite uses TEXT for UUID
+	Username      string `gorm:"type:varchar(50);uniqueIndex;not null"`
+	Email         string `gorm:"type:varchar(100);uniqueIndex;not null"`
+	PasswordHash  string `gorm:"type:varchar(255);not null"`
+	Role          string `gorm:"type:varchar(20);not null;default:'user'"`
+	EmailVerified bool   `gorm:"not null;default:false"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	DeletedAt     gorm.DeletedAt `gorm:"index"`
 }
 
 // TableName overrides the table name for GORM
@@ -44,12 +45,16 @@ func (TestUser) TableName() string {
 type TestMessage struct {
 	ID               uint64         `gorm:"primaryKey;autoIncrement"`
 	MessageID        string         `gorm:"type:varchar(50);uniqueIndex;not null"`
-	UserID           string         `gorm:"type:text;not null;index"` // SQLite uses TEXT for UUID
+	UserID           string         `gorm:"type:text;not null;index;index:idx_messages_user_deleted,priority:1"` // SQLite uses TEXT for UUID
+	Username         string         `gorm:"type:varchar(50)"`
+	RoomID           string         `gorm:"type:varchar(32);not null;default:'general';index:idx_messages_room_created,priority:1"`
 	Content          string         `gorm:"type:text;not null"`
-	CreatedAt        time.Time      `gorm:"index"`
-	DeletedAt        sql.NullTime   `gorm:"index"`
+	Lang             string         `gorm:"type:varchar(10)"`
+	CreatedAt        time.Time      `gorm:"index;index:idx_messages_room_created,priority:2"`
+	DeletedAt        sql.NullTime   `gorm:"index;index:idx_messages_user_deleted,priority:2"`
 	DeletedBy        sql.NullString `gorm:"type:text"` // UUID as text
 	IsDeletedByAdmin bool           `gorm:"default:false"`
+	EditedAt         *time.Time     // nil = never edited
 	User             TestUser       `gorm:"foreignKey:UserID;references:ID"`
 }
 
@@ -58,20 +63,110 @@ func (TestMessage) TableName() string {
 	return "messages"
 }
 
+// TestAuditLog is a SQLite-compatible version of models.AuditLog for testing
+type TestAuditLog struct {
+	ID        uint64    `gorm:"primaryKey;autoIncrement"`
+	Action    string    `gorm:"type:varchar(50);not null;index"`
+	ActorID   string    `gorm:"type:text;not null;index"` // UUID as text
+	TargetID  string    `gorm:"type:text;not null;index"` // UUID as text
+	Reason    string    `gorm:"type:text"`
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// TableName overrides the table name for GORM
+func (TestAuditLog) TableName() string {
+	return "audit_logs"
+}
+
+// TestMessageEdit is a SQLite-compatible version of models.MessageEdit for testing
+type TestMessageEdit struct {
+	ID              uint64    `gorm:"primaryKey;autoIncrement"`
+	MessageID       string    `gorm:"type:varchar(50);not null;index"`
+	PreviousContent string    `gorm:"type:text;not null"`
+	EditedBy        string    `gorm:"type:text;not null;index"` // UUID as text
+	EditedAt        time.Time `gorm:"index"`
+}
+
+// TableName overrides the table name for GORM
+func (TestMessageEdit) TableName() string {
+	return "message_edits"
+}
+
+// TestMessageReaction is a SQLite-compatible version of models.MessageReaction for testing
+type TestMessageReaction struct {
+	ID        uint64 `gorm:"primaryKey;autoIncrement"`
+	MessageID string `gorm:"type:varchar(50);not null;uniqueIndex:idx_reactions_message_user_emoji,priority:1"`
+	UserID    string `gorm:"type:text;not null;uniqueIndex:idx_reactions_message_user_emoji,priority:2"` // UUID as text
+	Emoji     string `gorm:"type:varchar(16);not null;uniqueIndex:idx_reactions_message_user_emoji,priority:3"`
+	CreatedAt time.Time
+}
+
+// TableName overrides the table name for GORM
+func (TestMessageReaction) TableName() string {
+	return "message_reactions"
+}
+
+// TestMention is a SQLite-compatible version of models.Mention for testing
+type TestMention struct {
+	ID             uint64     `gorm:"primaryKey;autoIncrement"`
+	MessageID      string     `gorm:"type:varchar(50);not null;uniqueIndex:idx_mentions_message_user,priority:1"`
+	UserID         string     `gorm:"type:text;not null;uniqueIndex:idx_mentions_message_user,priority:2;index:idx_mentions_user_read,priority:1"` // UUID as text
+	AuthorID       string     `gorm:"type:text;not null"`                                                                                          // UUID as text
+	AuthorUsername string     `gorm:"type:varchar(50)"`
+	RoomID         string     `gorm:"type:varchar(32);not null"`
+	ReadAt         *time.Time `gorm:"index:idx_mentions_user_read,priority:2"`
+	CreatedAt      time.Time
+}
+
+// TableName overrides the table name for GORM
+func (TestMention) TableName() string {
+	return "mentions"
+}
+
+// TestRefreshToken is a SQLite-compatible version of models.RefreshToken for testing
+type TestRefreshToken struct {
+	ID         uint64     `gorm:"primaryKey;autoIncrement"`
+	UserID     string     `gorm:"type:text;not null;index"` // UUID as text
+	TokenHash  string     `gorm:"type:varchar(64);uniqueIndex;not null"`
+	ExpiresAt  time.Time  `gorm:"not null"`
+	ConsumedAt *time.Time // nil = still usable
+	CreatedAt  time.Time
+}
+
+// TableName overrides the table name for GORM
+func (TestRefreshToken) TableName() string {
+	return "refresh_tokens"
+}
+
+// TestVerificationToken is a SQLite-compatible version of models.VerificationToken for testing
+type TestVerificationToken struct {
+	ID         uint64     `gorm:"primaryKey;autoIncrement"`
+	UserID     string     `gorm:"type:text;not null;index"` // UUID as text
+	TokenHash  string     `gorm:"type:varchar(64);uniqueIndex;not null"`
+	ExpiresAt  time.Time  `gorm:"not null"`
+	ConsumedAt *time.Time // nil = still usable
+	CreatedAt  time.Time
+}
+
+// TableName overrides the table name for GORM
+func (TestVerificationToken) TableName() string {
+	return "verification_tokens"
+}
+
 // SetupTestDatabase creates an in-memory SQLite database for integration tests
 // No Docker required! Fast and isolated.
-func SetupTestDatabase(t *testing.T) *TestDatabase {
+func SetupTestDatabase(t testing.TB) *TestDatabase {
 	// Use in-memory SQLite database (":memory:" means RAM-only)
 	dsn := "file::memory:?cache=shared"
 
-	// Connect with GORM
-	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	// Connect with GORM (translate errors like production does)
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		t.Fatalf("Failed to connect to test database: %v", err)
 	}
 
 	// Auto-migrate SQLite-compatible test models
-	err = db.AutoMigrate(&TestUser{}, &TestMessage{})
+	err = db.AutoMigrate(&TestUser{}, &TestMessage{}, &TestAuditLog{}, &TestMessageEdit{}, &TestMessageReaction{}, &TestMention{}, &TestRefreshToken{}, &TestVerificationToken{})
 	if err != nil {
 		t.Fatalf("Failed to run migrations: %v", err)
 	}
@@ -83,7 +178,7 @@ func SetupTestDatabase(t *testing.T) *TestDatabase {
 }
 
 // Teardown cleans up the test database (closes connection)
-func (td *TestDatabase) Teardown(t *testing.T) {
+func (td *TestDatabase) Teardown(t testing.TB) {
 	sqlDB, err := td.DB.DB()
 	if err != nil {
 		t.Logf("Warning: Failed to get underlying DB: %v", err)
@@ -120,7 +215,7 @@ func (tr *TestRedis) Teardown(t *testing.T) {
 // CleanDatabase deletes all records from tables (for test isolation)
 func CleanDatabase(t *testing.T, db *gorm.DB) {
 	// Delete all records from tables (SQLite doesn't support TRUNCATE)
-	tables := []string{"messages", "users"}
+	tables := []string{"audit_logs", "message_edits", "message_reactions", "mentions", "messages", "refresh_tokens", "verification_tokens", "users"}
 	for _, table := range tables {
 		if err := db.Exec(fmt.Sprintf("DELETE FROM %s", table)).Error; err != nil {
 			t.Logf("Warning: Failed to clean table %s: %v", table, err)
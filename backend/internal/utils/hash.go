@@ -7,12 +7,14 @@ import (
     "encoding/base64"
     "errors"
     "fmt"
+    "runtime"
     "strings"
+    "sync/atomic"
     
     "golang.org/x/crypto/argon2"
 )
 
-// Argon2 parameters
+// Default Argon2 parameters (see SetArgon2Params)
 const (
     SaltLength  = 16
     Memory      = 64 * 1024  // 64 MB
@@ -26,9 +28,106 @@ var (
     ErrIncompatibleVersion = errors.New("incompatible argon2 version")
 )
 
-// HashPassword generates Argon2id hash
+// Argon2Params are the cost parameters of new hashes. Existing hashes carry
+// their own and keep verifying after a change (NeedsRehash flags them).
+type Argon2Params struct {
+    Memory      int // KiB
+    Iterations  int
+    Parallelism int
+    KeyLength   int // Bytes
+}
+
+// Bounds accepted by SetArgon2Params: below them hashes are cheap to crack,
+// above them a single login can exhaust the host
+const (
+    MinArgon2Memory     = 8 * 1024        // 8 MB
+    MaxArgon2Memory     = 4 * 1024 * 1024 // 4 GB
+    MaxArgon2Iterations = 16
+    MaxArgon2Threads    = 64
+    MinArgon2KeyLength  = 16
+    MaxArgon2KeyLength  = 64
+)
+
+// DefaultArgon2Params returns the built-in parameters
+func DefaultArgon2Params() Argon2Params {
+    return Argon2Params{
+        Memory:      Memory,
+        Iterations:  Iterations,
+        Parallelism: Parallelism,
+        KeyLength:   KeyLength,
+    }
+}
+
+// Validate checks the parameters against the accepted bounds
+func (p Argon2Params) Validate() error {
+    switch {
+    case p.Memory < MinArgon2Memory || p.Memory > MaxArgon2Memory:
+        return fmt.Errorf("argon2 memory must be between %d and %d KiB, got %d", MinArgon2Memory, MaxArgon2Memory, p.Memory)
+    case p.Iterations < 1 || p.Iterations > MaxArgon2Iterations:
+        return fmt.Errorf("argon2 iterations must be between 1 and %d, got %d", MaxArgon2Iterations, p.Iterations)
+    case p.Parallelism < 1 || p.Parallelism > MaxArgon2Threads:
+        return fmt.Errorf("argon2 parallelism must be between 1 and %d, got %d", MaxArgon2Threads, p.Parallelism)
+    case p.KeyLength < MinArgon2KeyLength || p.KeyLength > MaxArgon2KeyLength:
+        return fmt.Errorf("argon2 key length must be between %d and %d bytes, got %d", MinArgon2KeyLength, MaxArgon2KeyLength, p.KeyLength)
+    }
+    return nil
+}
+
+var argon2Params atomic.Pointer[Argon2Params]
+
+// SetArgon2Params sets the parameters of new hashes, once at startup
+func SetArgon2Params(p Argon2Params) error {
+    if err := p.Validate(); err != nil {
+        return err
+    }
+    argon2Params.Store(&p)
+    return nil
+}
+
+// currentArgon2Params returns the configured parameters (defaults if unset)
+func currentArgon2Params() Argon2Params {
+    if p := argon2Params.Load(); p != nil {
+        return *p
+    }
+    return DefaultArgon2Params()
+}
+
+// hashSlots bounds how many Argon2 computations run at once. Each holds
+// Memory KiB until it returns, so a login storm queues here instead of
+// exhausting RAM.
+var hashSlots atomic.Pointer[chan struct{}]
+
+// argon2Key computes the key (swapped in tests to observe concurrency)
+var argon2Key = argon2.IDKey
+
+func init() {
+    SetHashConcurrency(0)
+}
+
+// SetHashConcurrency limits concurrent HashPassword/VerifyPassword calls,
+// once at startup (0 = number of CPUs). Calls beyond it wait their turn.
+func SetHashConcurrency(n int) {
+    if n <= 0 {
+        n = runtime.NumCPU()
+    }
+    slots := make(chan struct{}, n)
+    hashSlots.Store(&slots)
+}
+
+// idKey runs Argon2id in one of the hashing slots
+func idKey(password, salt []byte, iterations, memory uint32, parallelism uint8, keyLength uint32) []byte {
+    slots := *hashSlots.Load()
+    slots <- struct{}{}
+    defer func() { <-slots }()
+
+    return argon2Key(password, salt, iterations, memory, parallelism, keyLength)
+}
+
+// HashPassword generates Argon2id hash with the current parameters
 // Output format: $argon2id$v=19$m=65536,t=1,p=4$salt$hash
 func HashPassword(password string) (string, error) {
+    params := currentArgon2Params()
+    
     // Generate random salt
     salt := make([]byte, SaltLength)
     if _, err := rand.Read(salt); err != nil {
@@ -36,22 +135,22 @@ func HashPassword(password string) (string, error) {
     }
     
     // Generate hash
-    hash := argon2.IDKey(
+    hash := idKey(
         []byte(password),
         salt,
-        Iterations,
-        Memory,
-        Parallelism,
-        KeyLength,
+        uint32(params.Iterations),
+        uint32(params.Memory),
+        uint8(params.Parallelism),
+        uint32(params.KeyLength),
     )
     
     // Encode: $argon2id$v=19$m=65536,t=1,p=4$salt$hash
     encoded := fmt.Sprintf(
         "$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
         argon2.Version,
-        Memory,
-        Iterations,
-        Parallelism,
+        params.Memory,
+        params.Iterations,
+        params.Parallelism,
         base64.RawStdEncoding.EncodeToString(salt),
         base64.RawStdEncoding.EncodeToString(hash),
     )
@@ -68,7 +167,7 @@ func VerifyPassword(password, encodedHash string) (bool, error) {
     }
     
     // Generate hash with same params
-    testHash := argon2.IDKey(
+    testHash := idKey(
         []byte(password),
         salt,
         params.iterations,
@@ -85,6 +184,23 @@ func VerifyPassword(password, encodedHash string) (bool, error) {
     return false, nil
 }
 
+// NeedsRehash reports whether the hash was made with other parameters than
+// the current ones (or can't be read), so it should be replaced by
+// HashPassword the next time the password is known
+func NeedsRehash(encodedHash string) bool {
+    salt, hash, params, err := decodeHash(encodedHash)
+    if err != nil {
+        return true
+    }
+    
+    current := currentArgon2Params()
+    return int(params.memory) != current.Memory ||
+        int(params.iterations) != current.Iterations ||
+        int(params.parallelism) != current.Parallelism ||
+        len(salt) != SaltLength ||
+        len(hash) != current.KeyLength
+}
+
 // hashParams holds Argon2 parameters
 type hashParams struct {
     memory      uint32
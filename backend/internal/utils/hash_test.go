@@ -1,11 +1,17 @@
 package utils
 
 import (
+	"encoding/base64"
+	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/argon2"
 )
 
 // Test constants
@@ -254,6 +260,105 @@ func TestVerifyPassword_TableDriven(t *testing.T) {
 	}
 }
 
+// weakHash hashes password with lower-than-current Argon2 parameters, like a
+// hash stored before the parameters were raised
+func weakHash(password string) string {
+	salt := []byte("0123456789abcdef")
+	key := argon2.IDKey([]byte(password), salt, 1, 8*1024, 1, KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, 8*1024, 1, 1,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+}
+
+func TestNeedsRehash(t *testing.T) {
+	current, err := HashPassword(testPassword)
+	require.NoError(t, err, "Setup: HashPassword should not fail")
+	assert.False(t, NeedsRehash(current), "Hash with current params is up to date")
+
+	weak := weakHash(testPassword)
+	match, err := VerifyPassword(testPassword, weak)
+	require.NoError(t, err)
+	assert.True(t, match, "Weak hashes still verify")
+	assert.True(t, NeedsRehash(weak), "Hash with weaker params needs a rehash")
+
+	assert.True(t, NeedsRehash("not-a-hash"), "Unreadable hash needs a rehash")
+}
+
+func TestSetArgon2Params(t *testing.T) {
+	t.Cleanup(func() { require.NoError(t, SetArgon2Params(DefaultArgon2Params())) })
+
+	oldHash, err := HashPassword(testPassword)
+	require.NoError(t, err, "Setup: HashPassword should not fail")
+
+	require.NoError(t, SetArgon2Params(Argon2Params{Memory: 8 * 1024, Iterations: 2, Parallelism: 1, KeyLength: 32}))
+	newHash, err := HashPassword(testPassword)
+	require.NoError(t, err)
+	assert.Contains(t, newHash, "$m=8192,t=2,p=1$", "New hashes use the configured params")
+	assert.False(t, NeedsRehash(newHash))
+
+	// Hashes made before the change keep their own params
+	match, err := VerifyPassword(testPassword, oldHash)
+	require.NoError(t, err)
+	assert.True(t, match, "Old hashes still verify")
+	assert.True(t, NeedsRehash(oldHash))
+
+	// Out-of-bounds params are rejected and leave the current ones in place
+	for _, params := range []Argon2Params{
+		{Memory: 1024, Iterations: 1, Parallelism: 1, KeyLength: 32},
+		{Memory: 8 * 1024, Iterations: 0, Parallelism: 1, KeyLength: 32},
+		{Memory: 8 * 1024, Iterations: 1, Parallelism: 0, KeyLength: 32},
+		{Memory: 8 * 1024, Iterations: 1, Parallelism: 1, KeyLength: 8},
+		{Memory: MaxArgon2Memory + 1, Iterations: 1, Parallelism: 1, KeyLength: 32},
+	} {
+		assert.Error(t, SetArgon2Params(params), "%+v", params)
+	}
+	assert.False(t, NeedsRehash(newHash))
+}
+
+func TestSetHashConcurrency(t *testing.T) {
+	const limit = 2
+	SetHashConcurrency(limit)
+	t.Cleanup(func() {
+		SetHashConcurrency(0)
+		argon2Key = argon2.IDKey
+	})
+
+	// Count computations in flight, holding each long enough to overlap
+	var active, peak atomic.Int64
+	argon2Key = func(password, salt []byte, iterations, memory uint32, threads uint8, keyLen uint32) []byte {
+		n := active.Add(1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		defer active.Add(-1)
+		time.Sleep(20 * time.Millisecond)
+		return argon2.IDKey(password, salt, iterations, memory, threads, keyLen)
+	}
+
+	hash, err := HashPassword(testPassword)
+	require.NoError(t, err, "Setup: HashPassword should not fail")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := HashPassword(testPassword)
+			assert.NoError(t, err)
+		}()
+		go func() {
+			defer wg.Done()
+			match, err := VerifyPassword(testPassword, hash)
+			assert.NoError(t, err)
+			assert.True(t, match)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(limit), peak.Load(), "Calls beyond the limit should queue")
+}
+
 // Benchmark tests
 func BenchmarkHashPassword(b *testing.B) {
 	password := testPassword
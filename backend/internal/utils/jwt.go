@@ -1,7 +1,12 @@
 package utils
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"sync/atomic"
 	"time"
 
 	"github.com/Baaaki/digital-square/internal/models"
@@ -12,6 +17,7 @@ import (
 var (
 	ErrInvalidToken = errors.New("invalid token")
 	ErrExpiredToken = errors.New("token expired")
+	ErrEmptySecret  = errors.New("jwt secret is empty")
 )
 
 type Claims struct {
@@ -19,23 +25,75 @@ type Claims struct {
 	Email    string      `json:"email"`
 	Username string      `json:"username"`
 	Role     models.Role `json:"role"`
+
+	// Set for accounts that haven't verified their email (read-only until
+	// then). Negative so tokens issued before verification existed stay valid.
+	EmailUnverified bool `json:"email_unverified,omitempty"`
+
+	// Issue time in milliseconds. iat only has whole seconds, too coarse to
+	// tell a token renewed right after a password change from one issued
+	// just before it. Zero in tokens issued before it existed (iat is used).
+	IssuedAtMs int64 `json:"iat_ms,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// IssuedAtTime returns when the token was issued (zero if unknown)
+func (c *Claims) IssuedAtTime() time.Time {
+	if c.IssuedAtMs != 0 {
+		return time.UnixMilli(c.IssuedAtMs)
+	}
+	if c.IssuedAt != nil {
+		return c.IssuedAt.Time
+	}
+	return time.Time{}
+}
+
+// TokenIdentity names who issues tokens and who they are for, so a token
+// minted by another deployment sharing the secret is rejected
+type TokenIdentity struct {
+	Issuer   string // iss claim (empty = not set or checked)
+	Audience string // aud claim (empty = not set or checked)
+}
+
+var tokenIdentity atomic.Pointer[TokenIdentity]
+
+// SetTokenIdentity sets the issuer and audience of new tokens and the ones
+// ValidateToken requires, once at startup
+func SetTokenIdentity(id TokenIdentity) {
+	tokenIdentity.Store(&id)
+}
+
+// currentTokenIdentity returns the configured identity (none if unset)
+func currentTokenIdentity() TokenIdentity {
+	if id := tokenIdentity.Load(); id != nil {
+		return *id
+	}
+	return TokenIdentity{}
+}
+
 func GenerateToken(user *models.User, secretKey string, expiresIn time.Duration) (string, error) {
 	now := time.Now()
+	identity := currentTokenIdentity()
 
 	claims := &Claims{
 		UserID:   user.ID,
 		Email:    user.Email,
 		Username: user.Username,
 		Role:     user.Role,
+
+		EmailUnverified: !user.EmailVerified,
+		IssuedAtMs:      now.UnixMilli(),
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(), // jti: lets a single token be revoked on logout
 			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
 			IssuedAt:  jwt.NewNumericDate(now),
 			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    identity.Issuer,
 		},
 	}
+	if identity.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{identity.Audience}
+	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
@@ -47,7 +105,39 @@ func GenerateToken(user *models.User, secretKey string, expiresIn time.Duration)
 	return tokenString, nil
 }
 
+// GenerateRefreshToken returns a random opaque refresh token and the hash to store
+func GenerateRefreshToken() (token, tokenHash string, err error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(raw)
+	return token, HashRefreshToken(token), nil
+}
+
+// HashRefreshToken hashes a refresh token for storage and lookup.
+// The token is high-entropy random data, so a fast hash is sufficient.
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 func ValidateToken(tokenString, secretKey string) (*Claims, error) {
+	// Anyone could sign for an empty key
+	if secretKey == "" {
+		return nil, ErrEmptySecret
+	}
+
+	// Tokens from another issuer or for another audience fail to parse
+	identity := currentTokenIdentity()
+	var options []jwt.ParserOption
+	if identity.Issuer != "" {
+		options = append(options, jwt.WithIssuer(identity.Issuer))
+	}
+	if identity.Audience != "" {
+		options = append(options, jwt.WithAudience(identity.Audience))
+	}
+
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&Claims{},
@@ -58,6 +148,7 @@ func ValidateToken(tokenString, secretKey string) (*Claims, error) {
 			}
 			return []byte(secretKey), nil
 		},
+		options...,
 	)
 
 	if err != nil {
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/Baaaki/digital-square/internal/models"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -176,7 +177,7 @@ func TestValidateToken_EmptySecret(t *testing.T) {
 	claims, err := ValidateToken(token, "")
 
 	// Assert
-	assert.Error(t, err, "ValidateToken should return error for empty secret")
+	assert.ErrorIs(t, err, ErrEmptySecret, "ValidateToken should return error for empty secret")
 	assert.Nil(t, claims, "Claims should be nil for empty secret")
 }
 
@@ -361,3 +362,35 @@ func BenchmarkValidateToken(b *testing.B) {
 		_, _ = ValidateToken(token, testSecret)
 	}
 }
+
+func TestValidateToken_IssuerAndAudience(t *testing.T) {
+	t.Cleanup(func() { SetTokenIdentity(TokenIdentity{}) })
+	user := createTestUser(models.RoleUser)
+
+	// Minted before iss/aud were configured
+	legacyToken, err := GenerateToken(user, testSecret, testTokenDuration)
+	require.NoError(t, err, "Setup: GenerateToken should not fail")
+
+	SetTokenIdentity(TokenIdentity{Issuer: "staging", Audience: "staging-api"})
+	stagingToken, err := GenerateToken(user, testSecret, testTokenDuration)
+	require.NoError(t, err, "Setup: GenerateToken should not fail")
+
+	SetTokenIdentity(TokenIdentity{Issuer: "prod", Audience: "prod-api"})
+	prodToken, err := GenerateToken(user, testSecret, testTokenDuration)
+	require.NoError(t, err, "Setup: GenerateToken should not fail")
+
+	claims, err := ValidateToken(prodToken, testSecret)
+	require.NoError(t, err, "Token for this deployment should validate")
+	assert.Equal(t, "prod", claims.Issuer)
+	assert.Equal(t, []string{"prod-api"}, []string(claims.Audience))
+
+	// Same secret, other deployment
+	_, err = ValidateToken(stagingToken, testSecret)
+	assert.ErrorIs(t, err, jwt.ErrTokenInvalidIssuer, "Wrong issuer should be rejected")
+	_, err = ValidateToken(legacyToken, testSecret)
+	assert.Error(t, err, "Token without iss/aud should be rejected")
+
+	SetTokenIdentity(TokenIdentity{Issuer: "staging", Audience: "prod-api"})
+	_, err = ValidateToken(stagingToken, testSecret)
+	assert.ErrorIs(t, err, jwt.ErrTokenInvalidAudience, "Wrong audience should be rejected")
+}
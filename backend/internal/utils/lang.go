@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+)
+
+// LangUnknown tags content whose language can't be determined
+const LangUnknown = "unknown"
+
+// minLangLetters is the least number of letters worth guessing from
+const minLangLetters = 3
+
+// scriptLangs maps scripts used by (mostly) one language to its ISO 639-1 code.
+// Order matters: Japanese mixes Kana with Han, so Kana is checked first.
+var scriptLangs = []struct {
+	table *unicode.RangeTable
+	lang  string
+}{
+	{unicode.Hangul, "ko"},
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Han, "zh"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Greek, "el"},
+	{unicode.Arabic, "ar"},
+	{unicode.Hebrew, "he"},
+	{unicode.Thai, "th"},
+	{unicode.Devanagari, "hi"},
+}
+
+// latinStopwords are frequent short words per Latin-script language
+var latinStopwords = map[string][]string{
+	"en": {"the", "and", "is", "are", "was", "of", "to", "in", "it", "you", "that", "this", "with", "for", "not", "have", "what", "my", "be", "over"},
+	"tr": {"ve", "bir", "bu", "da", "de", "ne", "çok", "için", "ile", "ben", "sen", "var", "yok", "mi", "mı", "ama", "gibi", "daha", "nasıl", "değil"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "ich", "du", "ein", "eine", "mit", "auf", "auch", "im", "zu", "es", "wie", "was", "den", "sie"},
+	"fr": {"le", "la", "les", "et", "est", "un", "une", "des", "je", "tu", "pas", "que", "qui", "dans", "pour", "sur", "avec", "ce", "du", "mais"},
+	"es": {"el", "los", "las", "y", "es", "un", "una", "que", "en", "por", "para", "con", "pero", "muy", "yo", "del", "como", "está", "qué", "no"},
+	"it": {"il", "lo", "gli", "e", "è", "di", "che", "non", "un", "una", "per", "con", "sono", "ma", "questo", "come", "anche", "della", "io", "ciao"},
+}
+
+// latinHints are letters that (almost) only one of the languages above uses
+var latinHints = map[rune]string{
+	'ğ': "tr", 'ş': "tr", 'ı': "tr",
+	'ß': "de", 'ä': "de",
+	'ñ': "es", '¿': "es", '¡': "es",
+	'œ': "fr", 'ê': "fr", 'â': "fr", 'ë': "fr",
+}
+
+// latinIndex maps each stopword to the languages that use it
+var latinIndex = func() map[string][]string {
+	index := make(map[string][]string)
+	for lang, words := range latinStopwords {
+		for _, word := range words {
+			index[word] = append(index[word], lang)
+		}
+	}
+	return index
+}()
+
+// DetectLanguage returns a best-effort ISO 639-1 code for the text, or LangUnknown.
+// It is deliberately lightweight: distinctive scripts decide directly, and
+// Latin-script text is scored by stopwords and language-specific letters.
+// Short or ambiguous text is LangUnknown rather than a guess.
+func DetectLanguage(text string) string {
+	scriptCounts := make(map[string]int)
+	letters, latin := 0, 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if unicode.Is(unicode.Latin, r) {
+			latin++
+			continue
+		}
+		for _, s := range scriptLangs {
+			if unicode.Is(s.table, r) {
+				scriptCounts[s.lang]++
+				break
+			}
+		}
+	}
+
+	if letters < minLangLetters {
+		return LangUnknown
+	}
+
+	// A distinctive script that makes up most of the letters decides
+	if scriptCounts["ja"] > 0 && scriptCounts["ja"]+scriptCounts["zh"] > letters/2 {
+		return "ja"
+	}
+	for _, s := range scriptLangs {
+		if scriptCounts[s.lang] > letters/2 {
+			return s.lang
+		}
+	}
+
+	if latin <= letters/2 {
+		return LangUnknown
+	}
+	return detectLatin(text)
+}
+
+// detectLatin scores Latin-script text; ties and no evidence are LangUnknown
+func detectLatin(text string) string {
+	scores := make(map[string]int)
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	for _, word := range words {
+		for _, lang := range latinIndex[word] {
+			scores[lang]++
+		}
+	}
+	for _, r := range text {
+		if lang, ok := latinHints[unicode.ToLower(r)]; ok {
+			scores[lang]++
+		}
+	}
+
+	best, bestScore, tied := LangUnknown, 0, false
+	for lang, score := range scores {
+		switch {
+		case score > bestScore:
+			best, bestScore, tied = lang, score, false
+		case score == bestScore:
+			tied = true
+		}
+	}
+	if bestScore == 0 || tied {
+		return LangUnknown
+	}
+	return best
+}
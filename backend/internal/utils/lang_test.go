@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectLanguage_ObviousLanguages(t *testing.T) {
+	testCases := []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{"English", "The quick brown fox jumps over the lazy dog and that is it", "en"},
+		{"Turkish", "Bugün hava çok güzel ve ben de dışarı çıkmak istiyorum", "tr"},
+		{"German", "Der Hund ist nicht im Haus und die Katze auch nicht", "de"},
+		{"French", "Je ne sais pas ce que tu veux dire avec les mots", "fr"},
+		{"Spanish", "¿Qué tal? Yo estoy muy bien, pero los niños no", "es"},
+		{"Russian", "Привет, как у тебя дела сегодня?", "ru"},
+		{"Japanese", "今日はいい天気ですね", "ja"},
+		{"Korean", "안녕하세요 반갑습니다", "ko"},
+		{"Escaped HTML still English", "this is &lt;b&gt;not&lt;/b&gt; the way", "en"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, DetectLanguage(tc.text))
+		})
+	}
+}
+
+func TestDetectLanguage_Unknown(t *testing.T) {
+	testCases := []struct {
+		name string
+		text string
+	}{
+		{"Empty", ""},
+		{"Whitespace", "   \n\t"},
+		{"Digits and punctuation", "123 !!! ???"},
+		{"Emoji", "😀😀😀"},
+		{"Too short", "ok"},
+		{"No stopwords", "Hello Bob"},
+		{"Tied evidence", "de la"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, LangUnknown, DetectLanguage(tc.text))
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+var (
+	ErrPasswordTooCommon = errors.New("password is too common")
+	ErrPasswordNoUpper   = errors.New("password must contain an uppercase letter")
+	ErrPasswordNoLower   = errors.New("password must contain a lowercase letter")
+	ErrPasswordNoDigit   = errors.New("password must contain a digit")
+)
+
+// commonPasswords are well-known choices (compared case-insensitively).
+// Checked first, so "Password123" is rejected and "password123" gets the
+// clearer message.
+var commonPasswords = map[string]struct{}{
+	"password":       {},
+	"password1":      {},
+	"password12":     {},
+	"password123":    {},
+	"passw0rd":       {},
+	"p@ssw0rd":       {},
+	"p@ssword1":      {},
+	"12345678":       {},
+	"123456789":      {},
+	"1234567890":     {},
+	"qwerty123":      {},
+	"qwerty1234":     {},
+	"qwertyuiop":     {},
+	"1q2w3e4r":       {},
+	"1qaz2wsx":       {},
+	"zaq12wsx":       {},
+	"abc12345":       {},
+	"abcd1234":       {},
+	"admin123":       {},
+	"administrator1": {},
+	"changeme1":      {},
+	"changeme123":    {},
+	"iloveyou1":      {},
+	"letmein1":       {},
+	"letmein123":     {},
+	"welcome1":       {},
+	"welcome123":     {},
+	"football1":      {},
+	"baseball1":      {},
+	"monkey123":      {},
+	"dragon123":      {},
+	"sunshine1":      {},
+	"princess1":      {},
+	"superman1":      {},
+	"master123":      {},
+	"trustno1":       {},
+}
+
+// ValidatePasswordStrength rejects common passwords and requires an
+// uppercase letter, a lowercase letter and a digit. Checks are per code
+// point, so any script counts: letters of scripts without case (e.g.
+// Japanese) satisfy both case rules. Length is checked by the caller.
+func ValidatePasswordStrength(password string) error {
+	if _, common := commonPasswords[strings.ToLower(password)]; common {
+		return ErrPasswordTooCommon
+	}
+
+	var hasUpper, hasLower, hasDigit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsLetter(r): // Uncased letter
+			hasUpper, hasLower = true, true
+		}
+	}
+
+	switch {
+	case !hasUpper:
+		return ErrPasswordNoUpper
+	case !hasLower:
+		return ErrPasswordNoLower
+	case !hasDigit:
+		return ErrPasswordNoDigit
+	}
+	return nil
+}
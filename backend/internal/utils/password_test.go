@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePasswordStrength(t *testing.T) {
+	testCases := []struct {
+		name     string
+		password string
+		expected error
+	}{
+		{"Strong", "SecurePass123", nil},
+		{"Common", "password123", ErrPasswordTooCommon},
+		{"Common any case", "Password123", ErrPasswordTooCommon},
+		{"Common digits only", "12345678", ErrPasswordTooCommon},
+		{"No uppercase", "securepass123", ErrPasswordNoUpper},
+		{"No lowercase", "SECUREPASS123", ErrPasswordNoLower},
+		{"No digit", "SecurePassword", ErrPasswordNoDigit},
+		// Non-ASCII letters are letters
+		{"Turkish", "Şifre123!", nil},
+		{"Russian", "Пароль123", nil},
+		{"Turkish lowercase only", "şifreçok123", ErrPasswordNoUpper},
+		{"Japanese (no case)", "パスワード123", nil},
+		{"Emoji", "🔒🔑Secret123", nil},
+		{"Arabic-Indic digits", "Secretpass٣", nil},
+		{"Emoji are not letters", "🔒🔑🔒🔑123", ErrPasswordNoUpper},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, ValidatePasswordStrength(tc.password))
+		})
+	}
+}
@@ -2,58 +2,213 @@ package wal
 
 import (
     "bufio"
+    "bytes"
     "encoding/json"
+    "errors"
+    "fmt"
+    "hash/crc32"
+    "io"
     "os"
     "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
     "sync"
+    "sync/atomic"
     "time"
 
+    "github.com/Baaaki/digital-square/internal/models"
     "github.com/Baaaki/digital-square/pkg/logger"
     "go.uber.org/zap"
 )
 
 // WALEntry represents a message in the WAL
 type WALEntry struct {
+    Version   int       `json:"version,omitempty"` // Schema version (missing = EntryVersion1), set by Write
     MessageID string    `json:"message_id"`
     UserID    string    `json:"user_id"`
+    Username  string    `json:"username,omitempty"` // Denormalized author name (empty in older entries)
+    RoomID    string    `json:"room_id,omitempty"` // Empty in entries written before rooms (= general)
     Content   string    `json:"content"`
+    Lang      string    `json:"lang,omitempty"` // Detected language (empty = detection off)
     Timestamp time.Time `json:"timestamp"`
+
+    raw []byte // Line as read, for entries from a newer release (rewritten verbatim)
+}
+
+var (
+    // ErrWriteTimeout is returned when a write+sync exceeds the write timeout.
+    // The entry may still reach the file later; it is then ignored by readers.
+    ErrWriteTimeout = errors.New("wal: write timed out")
+    // ErrWriteStalled is returned while a timed-out write is still in progress
+    ErrWriteStalled = errors.New("wal: previous write still in progress")
+    // ErrCorruptEntry matches (errors.Is) any *CorruptEntry reported by Verify.
+    // Readers skip such lines after copying them to the dead-letter file.
+    ErrCorruptEntry = errors.New("wal: corrupt entry")
+    // ErrEntryTooLarge is returned by Write for entries over MaxEntryBytes.
+    // Verify reports such lines as a *CorruptEntry that also matches it.
+    ErrEntryTooLarge = errors.New("wal: entry too large")
+    // ErrUnsupportedVersion is returned by Write for entries of a newer
+    // release. Readers skip such entries and Cleanup keeps them verbatim, for
+    // that release to persist.
+    ErrUnsupportedVersion = errors.New("wal: unsupported entry version")
+)
+
+// Entry schema versions (WALEntry.Version), independent of the line format.
+// When WALEntry gains a field that readers rely on, add a version and
+// default the field for older entries in upgradeEntry.
+const (
+    // EntryVersion1 is every entry written before versioning. Username,
+    // RoomID and Lang were added over time and may be empty.
+    EntryVersion1 = 1
+    // EntryVersion2 entries always have a RoomID
+    EntryVersion2 = 2
+
+    CurrentEntryVersion = EntryVersion2
+)
+
+// Line format. v1 lines are "v1\t<crc32 hex>\t<json>"; lines written before
+// checksums were added are bare JSON and are still read (without verification).
+const entryPrefixV1 = "v1\t"
+
+// DefaultMaxSegmentBytes is the active segment size that triggers rotation
+const DefaultMaxSegmentBytes = 64 << 20 // 64 MB
+
+// DefaultMaxEntryBytes bounds one encoded line. Readers reject longer lines,
+// so it must cover the largest entry ever written.
+const DefaultMaxEntryBytes = 1 << 20 // 1 MB
+
+// CorruptEntry describes a WAL line that failed its checksum or didn't parse
+type CorruptEntry struct {
+    Segment string // Path of the segment file holding the line
+    Line    int    // 1-based line number within the segment
+    Raw     []byte // The line as read from disk
+    Reason  string
+
+    Oversized bool // Longer than MaxEntryBytes (copied to the dead-letter file on read)
 }
 
-// WAL manages write-ahead log
+func (e *CorruptEntry) Error() string {
+    return fmt.Sprintf("wal: corrupt entry at %s line %d: %s", filepath.Base(e.Segment), e.Line, e.Reason)
+}
+
+// Is lets errors.Is(err, ErrCorruptEntry) match, and ErrEntryTooLarge for oversized lines
+func (e *CorruptEntry) Is(target error) bool {
+    return target == ErrCorruptEntry || (e.Oversized && target == ErrEntryTooLarge)
+}
+
+// WALConfig holds optional WAL settings
+type WALConfig struct {
+    WriteTimeout    time.Duration // Max time for write+sync (0 = no timeout)
+    MaxSegmentBytes int64         // Rotate the active segment past this size (0 = DefaultMaxSegmentBytes)
+    MaxSegments     int           // Segment count (sealed + active) that triggers a flush request (0 = no limit)
+    MaxEntryBytes   int           // Longest encoded line written or read (0 = DefaultMaxEntryBytes)
+}
+
+// WAL manages write-ahead log.
+// The active segment is filePath; full segments are renamed to filePath.000001,
+// filePath.000002, ... and read back in that order before the active one.
 type WAL struct {
     filePath string
     file     *os.File
     mu       sync.Mutex
+    config   WALConfig
+
+    activeSize  atomic.Int64  // Bytes in the active segment (written by timed-out writes too)
+    nextSeq     int           // Sequence number for the next rotated segment
+    flushNeeded chan struct{} // Signalled when rotation reaches MaxSegments
+
+    syncFile  func(*os.File) error  // Swappable for tests (defaults to File.Sync)
+    pending   chan struct{}         // Closed when a timed-out write finishes (nil = none)
+    abandoned map[string]struct{}   // Message IDs whose write timed out
+    deadLettered map[string]struct{} // "segment:line:crc" of corrupt lines already dead-lettered
+    unsupported  map[string]struct{} // Message IDs of newer-release entries already logged
 }
 
 // NewWAL creates a new WAL instance
 func NewWAL(filePath string) (*WAL, error) {
+    return NewWALWithConfig(filePath, WALConfig{})
+}
+
+// NewWALWithConfig creates a new WAL instance with the given settings
+func NewWALWithConfig(filePath string, config WALConfig) (*WAL, error) {
     // Create directory if it doesn't exist
     dir := filepath.Dir(filePath)
     if err := os.MkdirAll(dir, 0755); err != nil {
         return nil, err
     }
 
+    if config.MaxSegmentBytes <= 0 {
+        config.MaxSegmentBytes = DefaultMaxSegmentBytes
+    }
+    if config.MaxEntryBytes <= 0 {
+        config.MaxEntryBytes = DefaultMaxEntryBytes
+    }
+
     // Open file with READ+WRITE+APPEND mode (for concurrent read/write)
     file, err := os.OpenFile(filePath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
     if err != nil {
         return nil, err
     }
 
-    return &WAL{
-        filePath: filePath,
-        file:     file,
-    }, nil
+    info, err := file.Stat()
+    if err != nil {
+        file.Close()
+        return nil, err
+    }
+
+    w := &WAL{
+        filePath:  filePath,
+        file:      file,
+        config:    config,
+        syncFile:  (*os.File).Sync,
+        abandoned: make(map[string]struct{}),
+        deadLettered: make(map[string]struct{}),
+        unsupported:  make(map[string]struct{}),
+
+        flushNeeded: make(chan struct{}, 1),
+    }
+    w.activeSize.Store(info.Size())
+
+    // Continue numbering after any segments left by a previous run
+    segments, err := w.sealedSegmentsUnsafe()
+    if err != nil {
+        file.Close()
+        return nil, err
+    }
+    w.nextSeq = 1
+    if len(segments) > 0 {
+        w.nextSeq = segments[len(segments)-1].seq + 1
+    }
+
+    return w, nil
 }
 
-// Write appends a message to WAL
+// Write appends a message to WAL. Entries are stored as CurrentEntryVersion;
+// callers leave Version unset. With a write timeout set, the write+sync runs in a goroutine; if it takes
+// too long Write returns ErrWriteTimeout instead of blocking the caller.
 func (w *WAL) Write(entry WALEntry) error {
     start := time.Now()
     w.mu.Lock()
     defer w.mu.Unlock()
 
-    data, err := json.Marshal(entry)
+    // Don't queue behind a write that is still stuck in the kernel
+    if w.stalledUnsafe() {
+        return ErrWriteStalled
+    }
+
+    if w.activeSize.Load() >= w.config.MaxSegmentBytes {
+        if err := w.rotateUnsafe(); err != nil {
+            return err
+        }
+    }
+
+    entry, err := upgradeEntry(entry)
+    if err != nil {
+        return err
+    }
+
+    data, err := encodeEntry(entry)
     if err != nil {
         logger.Log.Error("WAL: Failed to marshal entry",
             zap.String("message_id", entry.MessageID),
@@ -61,13 +216,59 @@ func (w *WAL) Write(entry WALEntry) error {
         )
         return err
     }
+    if len(data) > w.config.MaxEntryBytes {
+        logger.Log.Error("WAL: Entry too large",
+            zap.String("message_id", entry.MessageID),
+            zap.Int("bytes", len(data)),
+            zap.Int("max_bytes", w.config.MaxEntryBytes),
+        )
+        return ErrEntryTooLarge
+    }
+
+    if w.config.WriteTimeout <= 0 {
+        return w.writeAndSync(w.file, entry.MessageID, data, start)
+    }
+
+    done := make(chan error, 1)
+    file := w.file
+    go func() {
+        done <- w.writeAndSync(file, entry.MessageID, data, start)
+    }()
+
+    timer := time.NewTimer(w.config.WriteTimeout)
+    defer timer.Stop()
+
+    select {
+    case err := <-done:
+        return err
+    case <-timer.C:
+        // The goroutine still owns the file handle. Block further writes and
+        // file swaps (Cleanup) until it returns, and hide the entry from
+        // readers because the caller has reported the send as failed.
+        pending := make(chan struct{})
+        w.pending = pending
+        w.abandoned[entry.MessageID] = struct{}{}
+        go func() {
+            <-done
+            close(pending)
+        }()
+
+        logger.Log.Error("WAL: Write timed out",
+            zap.String("message_id", entry.MessageID),
+            zap.Duration("timeout", w.config.WriteTimeout),
+        )
+        return ErrWriteTimeout
+    }
+}
 
-    // Write to file
+// writeAndSync writes one line and forces it to disk
+func (w *WAL) writeAndSync(file *os.File, messageID string, data []byte, start time.Time) error {
     writeStart := time.Now()
-    _, err = w.file.WriteString(string(data) + "\n")
+    n, err := file.WriteString(string(data) + "\n")
+    w.activeSize.Add(int64(n))
     if err != nil {
         logger.Log.Error("WAL: Failed to write to file",
-            zap.String("message_id", entry.MessageID),
+            zap.String("message_id", messageID),
             zap.Error(err),
         )
         return err
@@ -75,9 +276,9 @@ func (w *WAL) Write(entry WALEntry) error {
 
     // Force sync to disk (durability)
     syncStart := time.Now()
-    if err := w.file.Sync(); err != nil {
+    if err := w.syncFile(file); err != nil {
         logger.Log.Error("WAL: Failed to sync to disk",
-            zap.String("message_id", entry.MessageID),
+            zap.String("message_id", messageID),
             zap.Error(err),
         )
         return err
@@ -85,7 +286,7 @@ func (w *WAL) Write(entry WALEntry) error {
     syncDuration := time.Since(syncStart)
 
     logger.Log.Debug("WAL: Entry written and synced",
-        zap.String("message_id", entry.MessageID),
+        zap.String("message_id", messageID),
         zap.Duration("write_duration", time.Since(writeStart)),
         zap.Duration("sync_duration", syncDuration),
         zap.Duration("total_duration", time.Since(start)),
@@ -94,6 +295,140 @@ func (w *WAL) Write(entry WALEntry) error {
     return nil
 }
 
+// rotateUnsafe seals the active segment under the next sequence number and
+// starts a new, empty active segment (caller holds mu, no write in flight)
+func (w *WAL) rotateUnsafe() error {
+    sealed := segmentPath(w.filePath, w.nextSeq)
+
+    if err := w.file.Close(); err != nil {
+        return err
+    }
+    if err := os.Rename(w.filePath, sealed); err != nil {
+        logger.Log.Error("WAL: Failed to rotate segment",
+            zap.String("segment", sealed),
+            zap.Error(err),
+        )
+        // Keep appending to the current file rather than losing the handle
+        file, reopenErr := os.OpenFile(w.filePath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+        if reopenErr != nil {
+            return reopenErr
+        }
+        w.file = file
+        return err
+    }
+
+    file, err := os.OpenFile(w.filePath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+    if err != nil {
+        return err
+    }
+    w.file = file
+    w.activeSize.Store(0)
+    w.nextSeq++
+
+    logger.Log.Info("WAL: Rotated segment",
+        zap.String("segment", sealed),
+        zap.Int64("max_segment_bytes", w.config.MaxSegmentBytes),
+    )
+
+    if w.config.MaxSegments > 0 {
+        segments, err := w.sealedSegmentsUnsafe()
+        if err == nil && len(segments)+1 >= w.config.MaxSegments {
+            w.requestFlush(len(segments) + 1)
+        }
+    }
+    return nil
+}
+
+// requestFlush signals FlushRequests without blocking (one pending request is enough)
+func (w *WAL) requestFlush(segmentCount int) {
+    select {
+    case w.flushNeeded <- struct{}{}:
+        logger.Log.Warn("WAL: Segment limit reached, requesting flush",
+            zap.Int("segment_count", segmentCount),
+            zap.Int("max_segments", w.config.MaxSegments),
+        )
+    default:
+    }
+}
+
+// FlushRequests delivers a signal whenever the segment count reaches
+// MaxSegments; the consumer should drain the WAL so old segments are removed
+func (w *WAL) FlushRequests() <-chan struct{} {
+    return w.flushNeeded
+}
+
+// SegmentCount returns the number of segment files, including the active one
+func (w *WAL) SegmentCount() (int, error) {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+
+    segments, err := w.sealedSegmentsUnsafe()
+    if err != nil {
+        return 0, err
+    }
+    return len(segments) + 1, nil
+}
+
+// segment is a sealed WAL segment on disk
+type segment struct {
+    path string
+    seq  int
+}
+
+// segmentPath returns the file name for a sealed segment
+func segmentPath(filePath string, seq int) string {
+    return fmt.Sprintf("%s.%06d", filePath, seq)
+}
+
+// sealedSegmentsUnsafe lists rotated segments, oldest first
+func (w *WAL) sealedSegmentsUnsafe() ([]segment, error) {
+    matches, err := filepath.Glob(w.filePath + ".*")
+    if err != nil {
+        return nil, err
+    }
+
+    var segments []segment
+    for _, path := range matches {
+        seq, err := strconv.Atoi(strings.TrimPrefix(path, w.filePath+"."))
+        if err != nil {
+            continue // Not a segment (e.g. the .tmp file used by Cleanup)
+        }
+        segments = append(segments, segment{path: path, seq: seq})
+    }
+    sort.Slice(segments, func(i, j int) bool {
+        return segments[i].seq < segments[j].seq
+    })
+    return segments, nil
+}
+
+// segmentPathsUnsafe lists every segment in write order, active segment last
+func (w *WAL) segmentPathsUnsafe() ([]string, error) {
+    segments, err := w.sealedSegmentsUnsafe()
+    if err != nil {
+        return nil, err
+    }
+
+    paths := make([]string, 0, len(segments)+1)
+    for _, seg := range segments {
+        paths = append(paths, seg.path)
+    }
+    return append(paths, w.filePath), nil
+}
+
+// stalledUnsafe reports whether a timed-out write is still running (caller holds mu)
+func (w *WAL) stalledUnsafe() bool {
+    if w.pending == nil {
+        return false
+    }
+    select {
+    case <-w.pending:
+        w.pending = nil
+        return false
+    default:
+        return true
+    }
+}
+
 // ReadAll reads all entries from WAL
 func (w *WAL) ReadAll() ([]WALEntry, error) {
     w.mu.Lock()
@@ -116,7 +451,9 @@ func (w *WAL) GetAllEntries() ([]WALEntry, error) {
     return entries, nil
 }
 
-// Cleanup removes entries that have been persisted to PostgreSQL
+// Cleanup removes entries that have been persisted to PostgreSQL.
+// Sealed segments with nothing left to keep are deleted; only segments that
+// still hold unpersisted entries are rewritten.
 func (w *WAL) Cleanup(persistedIDs []string) error {
     start := time.Now()
     w.mu.Lock()
@@ -126,34 +463,137 @@ func (w *WAL) Cleanup(persistedIDs []string) error {
         zap.Int("persisted_count", len(persistedIDs)),
     )
 
-    // Read all entries
-    allEntries, err := w.readAllUnsafe()
-    if err != nil {
-        logger.Log.Error("WAL: Failed to read entries for cleanup",
-            zap.Error(err),
-        )
-        return err
+    // Swapping the file under a stuck writer would lose track of its handle
+    if w.stalledUnsafe() {
+        return ErrWriteStalled
     }
 
-    beforeCount := len(allEntries)
-
     // Create map for fast lookup
     persistedMap := make(map[string]bool)
     for _, id := range persistedIDs {
         persistedMap[id] = true
     }
 
-    // Filter out persisted entries
-    var remainingEntries []WALEntry
-    for _, entry := range allEntries {
-        if !persistedMap[entry.MessageID] {
-            remainingEntries = append(remainingEntries, entry)
+    paths, err := w.segmentPathsUnsafe()
+    if err != nil {
+        return err
+    }
+
+    var beforeCount, afterCount, removedSegments int
+    for _, path := range paths {
+        entries, legacy, corrupt, err := w.readSegmentUnsafe(path)
+        if err != nil {
+            logger.Log.Error("WAL: Failed to read entries for cleanup",
+                zap.String("segment", path),
+                zap.Error(err),
+            )
+            return err
+        }
+
+        // Filter out persisted entries and abandoned ones (hidden from
+        // readers already, so dropping them here removes them for good)
+        var remainingEntries []WALEntry
+        for _, entry := range entries {
+            if _, ok := w.abandoned[entry.MessageID]; ok {
+                continue
+            }
+            beforeCount++
+            if !persistedMap[entry.MessageID] {
+                remainingEntries = append(remainingEntries, entry)
+            }
+        }
+        afterCount += len(remainingEntries)
+
+        switch {
+        case len(remainingEntries) == len(entries) && !legacy && corrupt == 0:
+            // Nothing to drop or upgrade in this segment (corrupt lines are
+            // dropped by the rewrite; they were dead-lettered when read)
+        case path != w.filePath && len(remainingEntries) == 0:
+            if err := os.Remove(path); err != nil {
+                logger.Log.Error("WAL: Failed to remove persisted segment",
+                    zap.String("segment", path),
+                    zap.Error(err),
+                )
+                return err
+            }
+            removedSegments++
+        case path != w.filePath:
+            if _, err := rewriteSegment(path, remainingEntries, w.syncFile); err != nil {
+                return err
+            }
+        default:
+            if err := w.rewriteActiveUnsafe(remainingEntries); err != nil {
+                return err
+            }
         }
     }
+    w.abandoned = make(map[string]struct{})
+    w.deadLettered = make(map[string]struct{}) // Their lines are gone now
+
+    logger.Log.Info("WAL: Cleanup completed",
+        zap.Int("before_count", beforeCount),
+        zap.Int("deleted_count", beforeCount-afterCount),
+        zap.Int("remaining_count", afterCount),
+        zap.Int("removed_segments", removedSegments),
+        zap.Duration("duration", time.Since(start)),
+    )
 
-    afterCount := len(remainingEntries)
-    deletedCount := beforeCount - afterCount
+    return nil
+}
+
+// DeadLetterPath is the file that DeadLetter appends to
+func (w *WAL) DeadLetterPath() string {
+    return w.filePath + ".deadletter"
+}
 
+// DeadLetter appends entries that can never be persisted (e.g. a malformed
+// user ID) to a side file for manual inspection, so they can be dropped from
+// the WAL without being lost. The file is not a segment and is never replayed.
+func (w *WAL) DeadLetter(entries []WALEntry) error {
+    if len(entries) == 0 {
+        return nil
+    }
+
+    lines := make([][]byte, 0, len(entries))
+    for _, entry := range entries {
+        data, err := encodeEntry(entry)
+        if err != nil {
+            return err
+        }
+        lines = append(lines, data)
+    }
+
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    return w.appendDeadLetterUnsafe(lines)
+}
+
+// appendDeadLetterUnsafe appends raw lines to the dead-letter file and syncs it
+func (w *WAL) appendDeadLetterUnsafe(lines [][]byte) error {
+    f, err := os.OpenFile(w.DeadLetterPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+    if err != nil {
+        logger.Log.Error("WAL: Failed to open dead-letter file",
+            zap.String("file_path", w.DeadLetterPath()),
+            zap.Error(err),
+        )
+        return err
+    }
+    defer f.Close()
+
+    for _, line := range lines {
+        if _, err := f.WriteString(string(line) + "\n"); err != nil {
+            logger.Log.Error("WAL: Failed to write dead-letter entry",
+                zap.Error(err),
+            )
+            return err
+        }
+    }
+
+    return w.syncFile(f)
+}
+
+// rewriteActiveUnsafe replaces the active segment's contents and reopens it
+func (w *WAL) rewriteActiveUnsafe(entries []WALEntry) error {
     // Close the current file before replacing it
     if err := w.file.Close(); err != nil {
         logger.Log.Error("WAL: Failed to close file for cleanup",
@@ -162,81 +602,368 @@ func (w *WAL) Cleanup(persistedIDs []string) error {
         return err
     }
 
-    // Rewrite WAL file with only remaining entries
-    tempFile := w.filePath + ".tmp"
+    // Reopened whether or not the rewrite worked: on failure the original
+    // file is still in place and writes must go on to it
+    size, rewriteErr := rewriteSegment(w.filePath, entries, w.syncFile)
+
+    // Reopen the file with same flags (CRITICAL!)
+    newFile, err := os.OpenFile(w.filePath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+    if err != nil {
+        logger.Log.Error("WAL: Failed to reopen file after cleanup",
+            zap.String("file_path", w.filePath),
+            zap.Error(err),
+        )
+        return errors.Join(rewriteErr, err)
+    }
+
+    // Update the file pointer to the new file
+    w.file = newFile
+    if rewriteErr != nil {
+        return rewriteErr
+    }
+    w.activeSize.Store(size)
+    return nil
+}
+
+// rewriteSegment atomically replaces a segment file with the given entries.
+// Rewritten entries get checksums (upgrades legacy lines); entries from a
+// newer release are copied verbatim. Returns the new size. On any error the
+// temp file is removed and the original segment is left as it was.
+func rewriteSegment(path string, entries []WALEntry, syncFile func(*os.File) error) (int64, error) {
+    tempFile := path + ".tmp"
     f, err := os.Create(tempFile)
     if err != nil {
         logger.Log.Error("WAL: Failed to create temp file",
             zap.String("temp_file", tempFile),
             zap.Error(err),
         )
-        return err
+        return 0, err
     }
 
-    for _, entry := range remainingEntries {
-        data, _ := json.Marshal(entry)
-        f.WriteString(string(data) + "\n")
+    size, err := writeSegment(f, entries, syncFile)
+    if closeErr := f.Close(); err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        // A short write (disk full, EIO) must never replace the segment
+        logger.Log.Error("WAL: Failed to write temp file, keeping the segment",
+            zap.String("temp_file", tempFile),
+            zap.String("segment", path),
+            zap.Error(err),
+        )
+        os.Remove(tempFile)
+        return 0, err
     }
-
-    f.Sync()
-    f.Close()
 
     // Replace old file with new one (atomic)
-    if err := os.Rename(tempFile, w.filePath); err != nil {
+    if err := os.Rename(tempFile, path); err != nil {
         logger.Log.Error("WAL: Failed to rename temp file",
             zap.String("temp_file", tempFile),
-            zap.String("target_file", w.filePath),
+            zap.String("target_file", path),
             zap.Error(err),
         )
-        return err
+        os.Remove(tempFile)
+        return 0, err
     }
 
-    // Reopen the file with same flags (CRITICAL!)
-    newFile, err := os.OpenFile(w.filePath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+    return size, nil
+}
+
+// writeSegment writes entries to f, one line each, and syncs it
+func writeSegment(f *os.File, entries []WALEntry, syncFile func(*os.File) error) (int64, error) {
+    var size int64
+    for _, entry := range entries {
+        data := entry.raw
+        if data == nil {
+            var err error
+            if data, err = encodeEntry(entry); err != nil {
+                return 0, err
+            }
+        }
+        n, err := f.Write(append(data, '\n'))
+        size += int64(n)
+        if err != nil {
+            return 0, err
+        }
+    }
+    return size, syncFile(f)
+}
+
+// readAllUnsafe reads all entries across segments, oldest first, without
+// locking (internal use only). Entries are upgraded to CurrentEntryVersion.
+// Corrupt lines (see readSegmentUnsafe) and entries from a newer release are
+// skipped, so one bad line can't hold back the entries around it.
+func (w *WAL) readAllUnsafe() ([]WALEntry, error) {
+    paths, err := w.segmentPathsUnsafe()
     if err != nil {
-        logger.Log.Error("WAL: Failed to reopen file after cleanup",
-            zap.String("file_path", w.filePath),
-            zap.Error(err),
-        )
-        return err
+        return nil, err
     }
 
-    // Update the file pointer to the new file
-    w.file = newFile
+    var entries []WALEntry
+    for _, path := range paths {
+        segmentEntries, _, _, err := w.readSegmentUnsafe(path)
+        if err != nil {
+            logger.Log.Error("WAL: Failed to read segment",
+                zap.String("segment", path),
+                zap.Error(err),
+            )
+            return nil, err
+        }
 
-    logger.Log.Info("WAL: Cleanup completed",
-        zap.Int("before_count", beforeCount),
-        zap.Int("deleted_count", deletedCount),
-        zap.Int("remaining_count", afterCount),
-        zap.Duration("duration", time.Since(start)),
-    )
+        for _, entry := range segmentEntries {
+            if _, ok := w.abandoned[entry.MessageID]; ok {
+                continue // Write timed out - the sender was told it failed
+            }
+            entry, err := upgradeEntry(entry)
+            if err != nil {
+                // Skipped, not fatal: the rest of the WAL must still drain
+                if _, logged := w.unsupported[entry.MessageID]; !logged {
+                    w.unsupported[entry.MessageID] = struct{}{}
+                    logger.Log.Error("WAL: Skipping entry from a newer release, upgrade to persist it",
+                        zap.String("segment", path),
+                        zap.String("message_id", entry.MessageID),
+                        zap.Int("version", entry.Version),
+                    )
+                }
+                continue
+            }
+            entries = append(entries, entry)
+        }
+    }
 
+    if entries == nil {
+        entries = []WALEntry{}
+    }
+    return entries, nil
+}
+
+// upgradeEntry brings an entry of any known version up to
+// CurrentEntryVersion, one version at a time
+func upgradeEntry(entry WALEntry) (WALEntry, error) {
+    switch entry.Version {
+    case 0, EntryVersion1:
+        if entry.RoomID == "" {
+            entry.RoomID = models.DefaultRoomID // Written before rooms
+        }
+        entry.Version = EntryVersion2
+        fallthrough
+    case EntryVersion2:
+        return entry, nil
+    default:
+        return entry, fmt.Errorf("%w %d (message %s)", ErrUnsupportedVersion, entry.Version, entry.MessageID)
+    }
+}
+
+// readSegmentUnsafe reads one segment like readSegment and returns how many
+// corrupt lines it skipped. Each corrupt line is logged and its raw bytes
+// copied to the dead-letter file, once per line however often it is read,
+// so Cleanup can drop it from the segment without losing it.
+func (w *WAL) readSegmentUnsafe(path string) ([]WALEntry, bool, int, error) {
+    entries, legacy, corrupt, err := readSegment(path, w.config.MaxEntryBytes)
+    if err != nil {
+        return nil, false, 0, err
+    }
+
+    for _, ce := range corrupt {
+        if err := w.deadLetterCorruptUnsafe(ce); err != nil {
+            return nil, false, 0, err
+        }
+    }
+    return entries, legacy, len(corrupt), nil
+}
+
+// deadLetterCorruptUnsafe copies a corrupt line to the dead-letter file and
+// logs it, unless that was already done for this line
+func (w *WAL) deadLetterCorruptUnsafe(ce CorruptEntry) error {
+    key := fmt.Sprintf("%s:%d:%08x", ce.Segment, ce.Line, crc32.ChecksumIEEE(ce.Raw))
+    if _, done := w.deadLettered[key]; done {
+        return nil
+    }
+    if err := w.appendDeadLetterUnsafe([][]byte{ce.Raw}); err != nil {
+        return err
+    }
+    w.deadLettered[key] = struct{}{}
+
+    if ce.Oversized {
+        // Intact, just over this instance's limit: replaying the dead-letter
+        // file with walreplay (or with a higher limit) recovers it
+        logger.Log.Error("WAL: Skipping oversized entry, raise WAL_MAX_ENTRY_BYTES or replay the dead-letter file to recover it",
+            zap.String("segment", ce.Segment),
+            zap.Int("line", ce.Line),
+            zap.String("reason", ce.Reason),
+            zap.Int("bytes", len(ce.Raw)),
+            zap.Int("max_bytes", w.config.MaxEntryBytes),
+            zap.String("dead_letter", w.DeadLetterPath()),
+        )
+        return nil
+    }
+    logger.Log.Error("WAL: Skipping corrupt entry, copied to the dead-letter file",
+        zap.String("segment", ce.Segment),
+        zap.Int("line", ce.Line),
+        zap.String("reason", ce.Reason),
+        zap.String("dead_letter", w.DeadLetterPath()),
+    )
     return nil
 }
 
-// readAllUnsafe reads all entries without locking (internal use only)
-func (w *WAL) readAllUnsafe() ([]WALEntry, error) {
-    file, err := os.Open(w.filePath)
+// readSegment decodes every entry in one segment file (missing file = empty).
+// legacy reports whether any line predates checksums. Lines that fail their
+// checksum, don't parse or exceed maxEntryBytes are returned in corrupt and
+// decoding goes on.
+func readSegment(path string, maxEntryBytes int) (entries []WALEntry, legacy bool, corrupt []CorruptEntry, err error) {
+    file, err := os.Open(path)
     if err != nil {
         if os.IsNotExist(err) {
-            return []WALEntry{}, nil
+            return nil, false, nil, nil
+        }
+        return nil, false, nil, err
+    }
+    defer file.Close()
+
+    err = scanLines(file, maxEntryBytes, func(lineNum int, line []byte) error {
+        entry, err := decodeLine(path, lineNum, line, maxEntryBytes)
+        var ce *CorruptEntry
+        if errors.As(err, &ce) {
+            corrupt = append(corrupt, *ce)
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        if !bytes.HasPrefix(line, []byte(entryPrefixV1)) {
+            legacy = true
+        }
+        if entry.Version > CurrentEntryVersion {
+            entry.raw = bytes.Clone(line) // May carry fields this release doesn't know
+        }
+        entries = append(entries, entry)
+        return nil
+    })
+    if err != nil {
+        return nil, false, nil, err
+    }
+
+    return entries, legacy, corrupt, nil
+}
+
+// Verify scans every segment and reports every corrupt line.
+// The error is only for I/O failures.
+func (w *WAL) Verify() ([]CorruptEntry, error) {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+
+    paths, err := w.segmentPathsUnsafe()
+    if err != nil {
+        return nil, err
+    }
+
+    var corrupt []CorruptEntry
+    for _, path := range paths {
+        found, err := verifySegment(path, w.config.MaxEntryBytes)
+        if err != nil {
+            return nil, err
+        }
+        corrupt = append(corrupt, found...)
+    }
+
+    return corrupt, nil
+}
+
+// verifySegment reports the corrupt lines of one segment file
+func verifySegment(path string, maxEntryBytes int) ([]CorruptEntry, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil, nil
         }
         return nil, err
     }
     defer file.Close()
 
-    var entries []WALEntry
-    scanner := bufio.NewScanner(file)
+    var corrupt []CorruptEntry
+    err = scanLines(file, maxEntryBytes, func(lineNum int, line []byte) error {
+        if _, err := decodeLine(path, lineNum, line, maxEntryBytes); err != nil {
+            var ce *CorruptEntry
+            if errors.As(err, &ce) {
+                corrupt = append(corrupt, *ce)
+            }
+        }
+        return nil
+    })
+
+    return corrupt, err
+}
 
-    for scanner.Scan() {
-        var entry WALEntry
-        if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
-            continue
+// scanLines calls fn with each line of r (without its line ending) and its
+// 1-based number. Unlike bufio.Scanner it never stops at a long line: lines
+// are read whole, and decodeLine rejects those over maxEntryBytes.
+func scanLines(r io.Reader, maxEntryBytes int, fn func(lineNum int, line []byte) error) error {
+    reader := bufio.NewReaderSize(r, min(maxEntryBytes+1, 1<<20))
+    for lineNum := 1; ; lineNum++ {
+        line, readErr := reader.ReadBytes('\n')
+        if readErr != nil && readErr != io.EOF {
+            return readErr
+        }
+        if len(line) == 0 && readErr == io.EOF {
+            return nil
+        }
+
+        line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+        if err := fn(lineNum, line); err != nil {
+            return err
+        }
+        if readErr == io.EOF {
+            return nil
         }
-        entries = append(entries, entry)
     }
+}
 
-    return entries, scanner.Err()
+// encodeEntry serializes an entry as a checksummed v1 line (without newline)
+func encodeEntry(entry WALEntry) ([]byte, error) {
+    data, err := json.Marshal(entry)
+    if err != nil {
+        return nil, err
+    }
+    return []byte(fmt.Sprintf("%s%08x\t%s", entryPrefixV1, crc32.ChecksumIEEE(data), data)), nil
+}
+
+// decodeLine parses one WAL line, verifying the checksum of v1 lines
+func decodeLine(segment string, lineNum int, line []byte, maxEntryBytes int) (WALEntry, error) {
+    var entry WALEntry
+    corrupt := func(reason string) (WALEntry, error) {
+        return WALEntry{}, &CorruptEntry{Segment: segment, Line: lineNum, Raw: bytes.Clone(line), Reason: reason}
+    }
+
+    if len(line) > maxEntryBytes {
+        return WALEntry{}, &CorruptEntry{
+            Segment:   segment,
+            Line:      lineNum,
+            Raw:       bytes.Clone(line),
+            Reason:    fmt.Sprintf("line is %d bytes, over the %d-byte max entry size", len(line), maxEntryBytes),
+            Oversized: true,
+        }
+    }
+
+    data := line
+    if rest, ok := bytes.CutPrefix(line, []byte(entryPrefixV1)); ok {
+        sum, payload, found := bytes.Cut(rest, []byte("\t"))
+        if !found {
+            return corrupt("missing checksum separator")
+        }
+        want, err := strconv.ParseUint(string(sum), 16, 32)
+        if err != nil || len(sum) != 8 {
+            return corrupt("malformed checksum")
+        }
+        if got := crc32.ChecksumIEEE(payload); got != uint32(want) {
+            return corrupt(fmt.Sprintf("checksum mismatch: want %08x, got %08x", want, got))
+        }
+        data = payload
+    }
+
+    if err := json.Unmarshal(data, &entry); err != nil {
+        return corrupt(err.Error())
+    }
+    return entry, nil
 }
 
 // Close closes the WAL file
@@ -1,7 +1,13 @@
 package wal
 
 import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -144,3 +150,542 @@ func TestWAL_MultipleCleanups(t *testing.T) {
 
 	t.Log("✅ Multiple cleanups work correctly!")
 }
+
+func TestWAL_WriteTimeout(t *testing.T) {
+	logger.Init(false)
+
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	w, err := NewWALWithConfig(walPath, WALConfig{WriteTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	defer w.Close()
+
+	// Inject a sync that hangs on first use until released (e.g. NFS stall)
+	release := make(chan struct{})
+	var stalled sync.Once
+	w.syncFile = func(f *os.File) error {
+		stalled.Do(func() { <-release })
+		return f.Sync()
+	}
+
+	start := time.Now()
+	err = w.Write(WALEntry{MessageID: "slow", UserID: "user1", Content: "stuck", Timestamp: time.Now()})
+	if !errors.Is(err, ErrWriteTimeout) {
+		t.Fatalf("Expected ErrWriteTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Write should fail fast, took %v", elapsed)
+	}
+
+	// While the stuck sync is still running, writes and cleanup fail fast
+	if err := w.Write(WALEntry{MessageID: "next", UserID: "user1", Content: "x", Timestamp: time.Now()}); !errors.Is(err, ErrWriteStalled) {
+		t.Fatalf("Expected ErrWriteStalled, got %v", err)
+	}
+	if err := w.Cleanup(nil); !errors.Is(err, ErrWriteStalled) {
+		t.Fatalf("Expected ErrWriteStalled from Cleanup, got %v", err)
+	}
+
+	// Sync recovers: the WAL accepts writes again
+	close(release)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		err = w.Write(WALEntry{MessageID: "after", UserID: "user1", Content: "ok", Timestamp: time.Now()})
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrWriteStalled) || time.Now().After(deadline) {
+			t.Fatalf("Write after recovery failed: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The timed-out entry landed in the file but must not be replayed
+	entries, err := w.GetAllEntries()
+	if err != nil {
+		t.Fatalf("Failed to read entries: %v", err)
+	}
+	if len(entries) != 1 || entries[0].MessageID != "after" {
+		t.Fatalf("Expected only the 'after' entry, got %+v", entries)
+	}
+}
+
+func TestWAL_LegacyEntriesReadable(t *testing.T) {
+	logger.Init(false)
+
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+
+	// A file written before checksums existed: bare JSON lines
+	legacy := `{"message_id":"old1","user_id":"user1","content":"Hello","timestamp":"2024-01-01T00:00:00Z"}` + "\n"
+	if err := os.WriteFile(walPath, []byte(legacy), 0644); err != nil {
+		t.Fatalf("Failed to write legacy WAL: %v", err)
+	}
+
+	w, err := NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	defer w.Close()
+
+	if err := w.Write(WALEntry{MessageID: "new1", UserID: "user1", Content: "Hi", Timestamp: time.Now()}); err != nil {
+		t.Fatalf("Failed to write entry: %v", err)
+	}
+
+	entries, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read mixed-version WAL: %v", err)
+	}
+	if len(entries) != 2 || entries[0].MessageID != "old1" || entries[1].MessageID != "new1" {
+		t.Fatalf("Expected [old1 new1], got %+v", entries)
+	}
+
+	// Cleanup rewrites everything in the checksummed format
+	if err := w.Cleanup(nil); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	data, err := os.ReadFile(walPath)
+	if err != nil {
+		t.Fatalf("Failed to read WAL file: %v", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if !strings.HasPrefix(line, entryPrefixV1) {
+			t.Fatalf("Expected v1 line after cleanup, got %q", line)
+		}
+	}
+}
+
+func TestWAL_EntryVersionUpgrade(t *testing.T) {
+	logger.Init(false)
+
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+
+	// Version 1 entries as older releases wrote them: one without a version
+	// field, one with it; neither has a room
+	var lines []byte
+	for _, entry := range []WALEntry{
+		{MessageID: "unversioned", UserID: "user1", Content: "Hello", Timestamp: time.Now()},
+		{Version: EntryVersion1, MessageID: "v1", UserID: "user1", Content: "Hi", Timestamp: time.Now()},
+	} {
+		line, err := encodeEntry(entry)
+		if err != nil {
+			t.Fatalf("Failed to encode entry: %v", err)
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+	if err := os.WriteFile(walPath, lines, 0644); err != nil {
+		t.Fatalf("Failed to write v1 WAL: %v", err)
+	}
+
+	w, err := NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	defer w.Close()
+
+	if err := w.Write(WALEntry{MessageID: "current", UserID: "user1", RoomID: "dev", Content: "Hey", Timestamp: time.Now()}); err != nil {
+		t.Fatalf("Failed to write entry: %v", err)
+	}
+
+	entries, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read WAL: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %+v", entries)
+	}
+	wantRooms := []string{"general", "general", "dev"}
+	for i, entry := range entries {
+		if entry.Version != CurrentEntryVersion {
+			t.Errorf("%s: expected version %d, got %d", entry.MessageID, CurrentEntryVersion, entry.Version)
+		}
+		if entry.RoomID != wantRooms[i] {
+			t.Errorf("%s: expected room %q, got %q", entry.MessageID, wantRooms[i], entry.RoomID)
+		}
+	}
+}
+
+func TestWAL_UnsupportedEntryVersion(t *testing.T) {
+	logger.Init(false)
+
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	w, err := NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	defer w.Close()
+
+	// A newer release's entry, with a field this one doesn't know, between two valid ones
+	data := []byte(fmt.Sprintf(`{"version":%d,"message_id":"future","user_id":"user1","content":"Hi","reply_to":"msg1"}`, CurrentEntryVersion+1))
+	future := fmt.Sprintf("%s%08x\t%s", entryPrefixV1, crc32.ChecksumIEEE(data), data)
+	if err := w.Write(WALEntry{MessageID: "msg1", UserID: "user1", Content: "Hello", Timestamp: time.Now()}); err != nil {
+		t.Fatalf("Failed to write entry: %v", err)
+	}
+	f, err := os.OpenFile(walPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open WAL: %v", err)
+	}
+	f.WriteString(future + "\n")
+	f.Close()
+	if err := w.Write(WALEntry{MessageID: "msg2", UserID: "user1", Content: "Hello", Timestamp: time.Now()}); err != nil {
+		t.Fatalf("Failed to write entry: %v", err)
+	}
+
+	// Skipped rather than read with fields missing; the rest still reads
+	entries, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if len(entries) != 2 || entries[0].MessageID != "msg1" || entries[1].MessageID != "msg2" {
+		t.Fatalf("Expected msg1 and msg2, got %+v", entries)
+	}
+
+	// Cleanup keeps it verbatim for the newer release
+	if err := w.Cleanup([]string{"msg1", "msg2"}); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	remaining, err := os.ReadFile(walPath)
+	if err != nil {
+		t.Fatalf("Failed to read WAL: %v", err)
+	}
+	if string(remaining) != future+"\n" {
+		t.Fatalf("Expected only the future entry, unchanged, got %q", remaining)
+	}
+
+	if err := w.Write(WALEntry{Version: CurrentEntryVersion + 1, MessageID: "future2"}); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("Expected Write to reject the version, got %v", err)
+	}
+}
+
+func TestWAL_CorruptEntryDetected(t *testing.T) {
+	logger.Init(false)
+
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	w, err := NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	defer w.Close()
+
+	for _, id := range []string{"msg1", "msg2", "msg3"} {
+		if err := w.Write(WALEntry{MessageID: id, UserID: "user1", Content: "Hello", Timestamp: time.Now()}); err != nil {
+			t.Fatalf("Failed to write entry: %v", err)
+		}
+	}
+
+	// Flip a byte inside msg2's content and append a torn write
+	data, err := os.ReadFile(walPath)
+	if err != nil {
+		t.Fatalf("Failed to read WAL file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	lines[1] = strings.Replace(lines[1], "Hello", "Jello", 1)
+	torn := lines[2][:len(lines[2])/2]
+	corrupted := strings.Join(lines, "\n") + "\n" + torn + "\n"
+	if err := os.WriteFile(walPath, []byte(corrupted), 0644); err != nil {
+		t.Fatalf("Failed to write corrupted WAL: %v", err)
+	}
+
+	// Verify reports every bad line
+	report, err := w.Verify()
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if len(report) != 2 || report[0].Line != 2 || report[1].Line != 4 {
+		t.Fatalf("Expected corrupt lines [2 4], got %+v", report)
+	}
+	if string(report[0].Raw) != lines[1] {
+		t.Fatalf("Expected raw line %q, got %q", lines[1], report[0].Raw)
+	}
+
+	// Readers skip the bad lines and keep going
+	for i := 0; i < 2; i++ {
+		entries, err := w.ReadAll()
+		if err != nil {
+			t.Fatalf("ReadAll failed: %v", err)
+		}
+		if len(entries) != 2 || entries[0].MessageID != "msg1" || entries[1].MessageID != "msg3" {
+			t.Fatalf("Expected msg1 and msg3, got %+v", entries)
+		}
+	}
+
+	// Each bad line is dead-lettered once, however often it is read
+	data, err = os.ReadFile(w.DeadLetterPath())
+	if err != nil {
+		t.Fatalf("Failed to read dead-letter file: %v", err)
+	}
+	deadLettered := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(deadLettered) != 2 || deadLettered[0] != lines[1] || deadLettered[1] != torn {
+		t.Fatalf("Expected both bad lines dead-lettered once, got %q", deadLettered)
+	}
+
+	// Cleanup drops them from the segment
+	if err := w.Cleanup([]string{"msg1"}); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	report, err = w.Verify()
+	if err != nil || len(report) != 0 {
+		t.Fatalf("Expected no corrupt lines after cleanup, got %+v, %v", report, err)
+	}
+	entries, err := w.ReadAll()
+	if err != nil || len(entries) != 1 || entries[0].MessageID != "msg3" {
+		t.Fatalf("Expected only msg3 after cleanup, got %+v, %v", entries, err)
+	}
+}
+
+func TestWAL_SegmentRotation(t *testing.T) {
+	logger.Init(false)
+
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	config := WALConfig{MaxSegmentBytes: 512}
+	w, err := NewWALWithConfig(walPath, config)
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+
+	var ids []string
+	write := func(w *WAL, n int) {
+		for i := 0; i < n; i++ {
+			id := fmt.Sprintf("msg%02d", len(ids)+1)
+			if err := w.Write(WALEntry{MessageID: id, UserID: "user1", Content: "Hello", Timestamp: time.Now()}); err != nil {
+				t.Fatalf("Failed to write %s: %v", id, err)
+			}
+			ids = append(ids, id)
+		}
+	}
+	assertIDs := func(w *WAL, want []string) {
+		t.Helper()
+		entries, err := w.GetAllEntries()
+		if err != nil {
+			t.Fatalf("Failed to read WAL: %v", err)
+		}
+		if len(entries) != len(want) {
+			t.Fatalf("Expected %d entries, got %d", len(want), len(entries))
+		}
+		for i, entry := range entries {
+			if entry.MessageID != want[i] {
+				t.Fatalf("Entry %d: expected %s, got %s", i, want[i], entry.MessageID)
+			}
+		}
+	}
+
+	write(w, 20)
+	segments, _ := filepath.Glob(walPath + ".*")
+	if len(segments) < 3 {
+		t.Fatalf("Expected several rotated segments, got %v", segments)
+	}
+
+	// Every entry is visible, in write order across segment boundaries
+	assertIDs(w, ids)
+
+	// Persisting the oldest half deletes whole segments instead of rewriting
+	if err := w.Cleanup(ids[:10]); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	remaining, _ := filepath.Glob(walPath + ".*")
+	if len(remaining) >= len(segments) {
+		t.Fatalf("Expected persisted segments to be removed, had %d, now %d", len(segments), len(remaining))
+	}
+	assertIDs(w, ids[10:])
+
+	// Numbering and ordering survive a restart
+	w.Close()
+	w, err = NewWALWithConfig(walPath, config)
+	if err != nil {
+		t.Fatalf("Failed to reopen WAL: %v", err)
+	}
+	defer w.Close()
+
+	write(w, 10)
+	assertIDs(w, ids[10:])
+
+	if err := w.Cleanup(ids); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	assertIDs(w, nil)
+	if leftover, _ := filepath.Glob(walPath + ".*"); len(leftover) != 0 {
+		t.Fatalf("Expected all sealed segments removed, got %v", leftover)
+	}
+}
+
+func TestWAL_CleanupKeepsSegmentsOnWriteFailure(t *testing.T) {
+	logger.Init(false)
+
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	w, err := NewWALWithConfig(walPath, WALConfig{MaxSegmentBytes: 512})
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	defer w.Close()
+
+	var ids []string
+	for i := 0; i < 8; i++ {
+		id := fmt.Sprintf("msg%02d", i+1)
+		if err := w.Write(WALEntry{MessageID: id, UserID: "user1", Content: "Hello", Timestamp: time.Now()}); err != nil {
+			t.Fatalf("Failed to write entry: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	snapshot := func() map[string]string {
+		paths, err := filepath.Glob(walPath + "*")
+		if err != nil {
+			t.Fatalf("Failed to list segments: %v", err)
+		}
+		files := make(map[string]string)
+		for _, path := range paths {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("Failed to read %s: %v", path, err)
+			}
+			files[filepath.Base(path)] = string(data)
+		}
+		return files
+	}
+	before := snapshot()
+	if len(before) < 2 {
+		t.Fatalf("Expected a sealed segment besides the active one, got %v", before)
+	}
+
+	// Disk full while rewriting: every segment stays as it was, no temp files
+	w.syncFile = func(*os.File) error { return errors.New("no space left on device") }
+	if err := w.Cleanup([]string{ids[0], ids[len(ids)-1]}); err == nil {
+		t.Fatal("Expected cleanup to fail")
+	}
+	after := snapshot()
+	if len(after) != len(before) {
+		t.Fatalf("Expected segments %v, got %v", before, after)
+	}
+	for name, data := range before {
+		if after[name] != data {
+			t.Fatalf("Segment %s changed after a failed cleanup", name)
+		}
+	}
+
+	// The WAL stays usable and loses nothing
+	w.syncFile = (*os.File).Sync
+	if err := w.Write(WALEntry{MessageID: "msg09", UserID: "user1", Content: "Hello", Timestamp: time.Now()}); err != nil {
+		t.Fatalf("Write after failed cleanup: %v", err)
+	}
+	entries, err := w.ReadAll()
+	if err != nil || len(entries) != 9 {
+		t.Fatalf("Expected all 9 entries, got %d, %v", len(entries), err)
+	}
+}
+
+func TestWAL_LongEntriesReadable(t *testing.T) {
+	logger.Init(false)
+
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	w, err := NewWALWithConfig(walPath, WALConfig{MaxEntryBytes: 256 << 10})
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	defer w.Close()
+
+	// Past bufio.Scanner's 64 KB default token size
+	long := strings.Repeat("ä", 50_000) // 100 KB
+	for _, entry := range []WALEntry{
+		{MessageID: "short1", UserID: "user1", Content: "Hi", Timestamp: time.Now()},
+		{MessageID: "long1", UserID: "user1", Content: long, Timestamp: time.Now()},
+		{MessageID: "short2", UserID: "user1", Content: "Bye", Timestamp: time.Now()},
+	} {
+		if err := w.Write(entry); err != nil {
+			t.Fatalf("Failed to write entry: %v", err)
+		}
+	}
+
+	entries, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read WAL: %v", err)
+	}
+	if len(entries) != 3 || entries[1].MessageID != "long1" || entries[1].Content != long || entries[2].MessageID != "short2" {
+		t.Fatalf("Expected [short1 long1 short2] with the long content intact, got %d entries", len(entries))
+	}
+	if corrupt, err := w.Verify(); err != nil || len(corrupt) != 0 {
+		t.Fatalf("Expected clean verify, got %v, %v", corrupt, err)
+	}
+
+	// Entries that couldn't be read back are refused up front
+	err = w.Write(WALEntry{MessageID: "huge1", UserID: "user1", Content: strings.Repeat(long, 3), Timestamp: time.Now()})
+	if !errors.Is(err, ErrEntryTooLarge) {
+		t.Fatalf("Expected ErrEntryTooLarge, got %v", err)
+	}
+}
+
+func TestWAL_OversizedEntryDeadLettered(t *testing.T) {
+	logger.Init(false)
+
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	w, err := NewWALWithConfig(walPath, WALConfig{MaxEntryBytes: 256 << 10})
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	long := strings.Repeat("x", 100_000)
+	for _, entry := range []WALEntry{
+		{MessageID: "short1", UserID: "user1", Content: "Hi", Timestamp: time.Now()},
+		{MessageID: "long1", UserID: "user1", Content: long, Timestamp: time.Now()},
+		{MessageID: "short2", UserID: "user1", Content: "Bye", Timestamp: time.Now()},
+	} {
+		if err := w.Write(entry); err != nil {
+			t.Fatalf("Failed to write entry: %v", err)
+		}
+	}
+	w.Close()
+
+	// Restarted with a lower limit than the entry was written under
+	w, err = NewWALWithConfig(walPath, WALConfig{MaxEntryBytes: 64 << 10})
+	if err != nil {
+		t.Fatalf("Failed to reopen WAL: %v", err)
+	}
+
+	// Verify names the line and the limit
+	corrupt, err := w.Verify()
+	if err != nil || len(corrupt) != 1 || !corrupt[0].Oversized || corrupt[0].Line != 2 {
+		t.Fatalf("Expected verify to report line 2 as oversized, got %v, %v", corrupt, err)
+	}
+	if !errors.Is(&corrupt[0], ErrEntryTooLarge) || !strings.Contains(corrupt[0].Error(), "max entry size") {
+		t.Fatalf("Expected an oversized entry error, got %q", corrupt[0].Error())
+	}
+
+	// Readers skip it and keep going
+	for i := 0; i < 2; i++ {
+		entries, err := w.ReadAll()
+		if err != nil {
+			t.Fatalf("ReadAll failed: %v", err)
+		}
+		if len(entries) != 2 || entries[0].MessageID != "short1" || entries[1].MessageID != "short2" {
+			t.Fatalf("Expected short1 and short2, got %d entries", len(entries))
+		}
+	}
+
+	// Copied to the dead-letter file once, however often it is read
+	data, err := os.ReadFile(w.DeadLetterPath())
+	if err != nil {
+		t.Fatalf("Failed to read dead-letter file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], `"message_id":"long1"`) {
+		t.Fatalf("Expected the raw long1 line dead-lettered once, got %d lines", len(lines))
+	}
+
+	// Cleanup drops it from the segment
+	if err := w.Cleanup(nil); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	corrupt, err = w.Verify()
+	if err != nil || len(corrupt) != 0 {
+		t.Fatalf("Expected no corrupt lines after cleanup, got %v, %v", corrupt, err)
+	}
+	w.Close()
+
+	// The dead-letter file replays under a higher limit
+	dl, err := NewWALWithConfig(w.DeadLetterPath(), WALConfig{MaxEntryBytes: 256 << 10})
+	if err != nil {
+		t.Fatalf("Failed to open dead-letter file: %v", err)
+	}
+	defer dl.Close()
+	entries, err := dl.ReadAll()
+	if err != nil || len(entries) != 1 || entries[0].Content != long {
+		t.Fatalf("Expected long1 from the dead-letter file, got %d, %v", len(entries), err)
+	}
+}
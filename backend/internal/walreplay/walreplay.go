@@ -0,0 +1,82 @@
+package walreplay
+
+import (
+	"github.com/Baaaki/digital-square/internal/models"
+	"github.com/Baaaki/digital-square/internal/repository"
+	"github.com/Baaaki/digital-square/internal/wal"
+	"github.com/google/uuid"
+)
+
+// Result summarizes a replay run
+type Result struct {
+	Total    int // Entries read from the WAL
+	Inserted int // Rows written to the database
+	Skipped  int // Already present (duplicate MessageID)
+	Invalid  int // Unparseable user ID
+	Orphaned int // Author no longer exists in the users table
+}
+
+// Replay inserts every WAL entry into the database, ignoring messages that
+// already exist. Used to rebuild messages after a database loss when the
+// WAL survived. The WAL itself is left untouched.
+func Replay(w *wal.WAL, messageRepo *repository.MessageRepository, userRepo *repository.UserRepository) (Result, error) {
+	entries, err := w.GetAllEntries()
+	if err != nil {
+		return Result{}, err
+	}
+
+	result := Result{Total: len(entries)}
+	if len(entries) == 0 {
+		return result, nil
+	}
+
+	// Parse authors up front so one bad entry doesn't fail the batch
+	userIDs := make([]uuid.UUID, 0, len(entries))
+	parsed := make([]uuid.UUID, len(entries))
+	valid := make([]bool, len(entries))
+	for i, entry := range entries {
+		userID, err := uuid.Parse(entry.UserID)
+		if err != nil {
+			result.Invalid++
+			continue
+		}
+		parsed[i] = userID
+		valid[i] = true
+		userIDs = append(userIDs, userID)
+	}
+
+	// messages.user_id has a foreign key, so authors must exist
+	existing, err := userRepo.ExistingIDs(userIDs)
+	if err != nil {
+		return result, err
+	}
+
+	messages := make([]models.Message, 0, len(entries))
+	for i, entry := range entries {
+		if !valid[i] {
+			continue
+		}
+		if !existing[parsed[i]] {
+			result.Orphaned++
+			continue
+		}
+		messages = append(messages, models.Message{
+			MessageID: entry.MessageID,
+			UserID:    parsed[i],
+			Username:  entry.Username,
+			RoomID:    entry.RoomID,
+			Content:   entry.Content,
+			Lang:      entry.Lang,
+			CreatedAt: entry.Timestamp,
+		})
+	}
+
+	inserted, err := messageRepo.BatchInsertIgnoreDuplicates(messages)
+	if err != nil {
+		return result, err
+	}
+
+	result.Inserted = int(inserted)
+	result.Skipped = len(messages) - result.Inserted
+	return result, nil
+}
@@ -0,0 +1,71 @@
+package walreplay_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Baaaki/digital-square/internal/models"
+	"github.com/Baaaki/digital-square/internal/repository"
+	"github.com/Baaaki/digital-square/internal/testutil"
+	"github.com/Baaaki/digital-square/internal/wal"
+	"github.com/Baaaki/digital-square/internal/walreplay"
+	"github.com/Baaaki/digital-square/pkg/logger"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplay(t *testing.T) {
+	logger.Init(false)
+
+	testDB := testutil.SetupTestDatabase(t)
+	defer testDB.Teardown(t)
+	testutil.CleanDatabase(t, testDB.DB)
+
+	user, _ := testutil.CreateTestUser("replayer", "replay@example.com", "Test123456", models.RoleUser)
+	require.NoError(t, testDB.DB.Create(user).Error)
+
+	// One message survived in the database, the rest only in the WAL
+	survivor := testutil.CreateTestMessage(user.ID, "already persisted")
+	require.NoError(t, testDB.DB.Create(survivor).Error)
+
+	w, err := wal.NewWAL(filepath.Join(t.TempDir(), "wal.log"))
+	require.NoError(t, err)
+	defer w.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	entries := []wal.WALEntry{
+		{MessageID: survivor.MessageID, UserID: user.ID, Content: "already persisted", Timestamp: now},
+		{MessageID: uuid.New().String(), UserID: user.ID, Content: "lost 1", Timestamp: now},
+		{MessageID: uuid.New().String(), UserID: user.ID, Content: "lost 2", Timestamp: now.Add(time.Second)},
+		{MessageID: uuid.New().String(), UserID: uuid.New().String(), Content: "author gone", Timestamp: now},
+		{MessageID: uuid.New().String(), UserID: "not-a-uuid", Content: "corrupt", Timestamp: now},
+	}
+	for _, entry := range entries {
+		require.NoError(t, w.Write(entry))
+	}
+
+	messageRepo := repository.NewMessageRepository(testDB.DB)
+	userRepo := repository.NewUserRepository(testDB.DB)
+
+	result, err := walreplay.Replay(w, messageRepo, userRepo)
+	require.NoError(t, err)
+	assert.Equal(t, walreplay.Result{Total: 5, Inserted: 2, Skipped: 1, Orphaned: 1, Invalid: 1}, result)
+
+	var rows []testutil.TestMessage
+	require.NoError(t, testDB.DB.Order("created_at ASC, content ASC").Find(&rows).Error)
+	require.Len(t, rows, 3)
+
+	contents := []string{rows[0].Content, rows[1].Content, rows[2].Content}
+	assert.ElementsMatch(t, []string{"already persisted", "lost 1", "lost 2"}, contents)
+	for _, row := range rows {
+		assert.Equal(t, user.ID, row.UserID)
+	}
+
+	// Replaying again is idempotent
+	result, err = walreplay.Replay(w, messageRepo, userRepo)
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.Inserted)
+	assert.Equal(t, 3, result.Skipped)
+}
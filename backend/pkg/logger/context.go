@@ -0,0 +1,40 @@
+package logger
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// Context keys read by FromContext. RequestLogger sets the request ID,
+// AuthMiddleware the user ID and GeoTagger the optional client location;
+// *gin.Context resolves string keys from its Keys.
+const (
+	RequestIDKey  = "request_id"
+	UserIDKey     = "user_id"
+	GeoCountryKey = "geo_country" // string
+	GeoASNKey     = "geo_asn"     // uint32
+)
+
+// FromContext returns Log tagged with the request ID and user ID found in
+// ctx (usually a *gin.Context), so a request's log lines can be correlated.
+// Missing values are left out.
+func FromContext(ctx context.Context) *zap.Logger {
+	fields := make([]zap.Field, 0, 4)
+	if requestID, ok := ctx.Value(RequestIDKey).(string); ok && requestID != "" {
+		fields = append(fields, zap.String(RequestIDKey, requestID))
+	}
+	if userID, ok := ctx.Value(UserIDKey).(string); ok && userID != "" {
+		fields = append(fields, zap.String(UserIDKey, userID))
+	}
+	if country, ok := ctx.Value(GeoCountryKey).(string); ok && country != "" {
+		fields = append(fields, zap.String(GeoCountryKey, country))
+	}
+	if asn, ok := ctx.Value(GeoASNKey).(uint32); ok && asn != 0 {
+		fields = append(fields, zap.Uint32(GeoASNKey, asn))
+	}
+	if len(fields) == 0 {
+		return Log
+	}
+	return Log.With(fields...)
+}
@@ -0,0 +1,183 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes every metric name
+const namespace = "digital_square"
+
+// Metrics holds the Prometheus collectors for the chat server.
+// A nil *Metrics is valid and records nothing, so callers that don't care
+// about metrics (tests, tools) can leave it unset.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	activeConnections   prometheus.Gauge
+	messagesSent        prometheus.Counter
+	messagesDeleted     prometheus.Counter
+	walPending          prometheus.Gauge
+	batchWriteDuration  prometheus.Histogram
+	rateLimitRejections *prometheus.CounterVec
+	wsDisconnects       *prometheus.CounterVec
+}
+
+// New registers the collectors on reg. Each registry takes one Metrics;
+// tests pass prometheus.NewRegistry() so they don't collide on the default one.
+func New(reg *prometheus.Registry) *Metrics {
+	m := &Metrics{
+		registry: reg,
+		activeConnections: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "websocket_connections_active",
+			Help:      "Open WebSocket connections on this node.",
+		}),
+		messagesSent: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_sent_total",
+			Help:      "Messages accepted over WebSocket (written to the WAL).",
+		}),
+		messagesDeleted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_deleted_total",
+			Help:      "Messages soft-deleted by their author or an admin.",
+		}),
+		walPending: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "wal_entries_pending",
+			Help:      "WAL entries not yet persisted to PostgreSQL.",
+		}),
+		batchWriteDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "batch_write_duration_seconds",
+			Help:      "Time the batch writer spends inserting a batch into PostgreSQL.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12), // 5ms .. ~10s
+		}),
+		rateLimitRejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rate_limit_rejections_total",
+			Help:      "HTTP requests rejected by the rate limiter.",
+		}, []string{"reason"}),
+		wsDisconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "websocket_disconnects_total",
+			Help:      "WebSocket connections ended, by who or what ended them.",
+		}, []string{"reason"}),
+	}
+
+	reg.MustRegister(
+		m.activeConnections,
+		m.messagesSent,
+		m.messagesDeleted,
+		m.walPending,
+		m.batchWriteDuration,
+		m.rateLimitRejections,
+		m.wsDisconnects,
+	)
+	return m
+}
+
+// NewWithRuntime is New plus the standard Go runtime and process collectors
+func NewWithRuntime(reg *prometheus.Registry) *Metrics {
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return New(reg)
+}
+
+// Handler serves the registry in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveCache exposes recent-messages cache counters kept by the caller as
+// cache_hits_total and cache_misses_total (hit ratio = hits / (hits + misses))
+func (m *Metrics) ObserveCache(hits, misses func() uint64) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_hits_total",
+			Help:      "Recent-messages reads served from Redis.",
+		}, func() float64 { return float64(hits()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_misses_total",
+			Help:      "Recent-messages reads that fell back to PostgreSQL.",
+		}, func() float64 { return float64(misses()) }),
+	)
+}
+
+// SetActiveConnections records the number of open WebSocket connections
+func (m *Metrics) SetActiveConnections(n int) {
+	if m == nil {
+		return
+	}
+	m.activeConnections.Set(float64(n))
+}
+
+// MessageSent counts an accepted message
+func (m *Metrics) MessageSent() {
+	if m == nil {
+		return
+	}
+	m.messagesSent.Inc()
+}
+
+// MessageDeleted counts a deleted message
+func (m *Metrics) MessageDeleted() {
+	if m == nil {
+		return
+	}
+	m.messagesDeleted.Inc()
+}
+
+// SetWALPending records the number of WAL entries awaiting the batch writer
+func (m *Metrics) SetWALPending(n int) {
+	if m == nil {
+		return
+	}
+	m.walPending.Set(float64(n))
+}
+
+// AddWALPending adjusts the pending WAL entry count (negative after a batch)
+func (m *Metrics) AddWALPending(n int) {
+	if m == nil {
+		return
+	}
+	m.walPending.Add(float64(n))
+}
+
+// ObserveBatchWrite records how long a batch insert took
+func (m *Metrics) ObserveBatchWrite(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.batchWriteDuration.Observe(d.Seconds())
+}
+
+// RateLimitRejected counts a request rejected by the rate limiter
+// (reason: "limited" or "banned")
+func (m *Metrics) RateLimitRejected(reason string) {
+	if m == nil {
+		return
+	}
+	m.rateLimitRejections.WithLabelValues(reason).Inc()
+}
+
+// WebSocketDisconnected counts an ended connection (reason: "client_close",
+// "server_close", "timeout" or "error")
+func (m *Metrics) WebSocketDisconnected(reason string) {
+	if m == nil {
+		return
+	}
+	m.wsDisconnects.WithLabelValues(reason).Inc()
+}
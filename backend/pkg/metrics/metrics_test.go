@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNilMetricsIsNoop(t *testing.T) {
+	var m *Metrics
+	assert.NotPanics(t, func() {
+		m.SetActiveConnections(3)
+		m.MessageSent()
+		m.MessageDeleted()
+		m.SetWALPending(2)
+		m.AddWALPending(-1)
+		m.ObserveBatchWrite(time.Second)
+		m.RateLimitRejected("limited")
+		m.WebSocketDisconnected("client_close")
+		m.ObserveCache(func() uint64 { return 1 }, func() uint64 { return 0 })
+	})
+}
+
+func TestSeparateRegistriesDontCollide(t *testing.T) {
+	a := New(prometheus.NewRegistry())
+	b := New(prometheus.NewRegistry())
+
+	a.MessageSent()
+	a.MessageSent()
+	b.MessageSent()
+	assert.Equal(t, 2.0, testutil.ToFloat64(a.messagesSent))
+	assert.Equal(t, 1.0, testutil.ToFloat64(b.messagesSent))
+}
+
+func TestRecording(t *testing.T) {
+	m := New(prometheus.NewRegistry())
+
+	m.SetActiveConnections(5)
+	m.SetActiveConnections(4)
+	assert.Equal(t, 4.0, testutil.ToFloat64(m.activeConnections))
+
+	m.SetWALPending(10)
+	m.AddWALPending(1)
+	m.AddWALPending(-8)
+	assert.Equal(t, 3.0, testutil.ToFloat64(m.walPending))
+
+	m.RateLimitRejected("limited")
+	m.RateLimitRejected("limited")
+	m.RateLimitRejected("banned")
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.rateLimitRejections.WithLabelValues("limited")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.rateLimitRejections.WithLabelValues("banned")))
+
+	m.ObserveBatchWrite(20 * time.Millisecond)
+	m.ObserveBatchWrite(40 * time.Millisecond)
+	assert.Equal(t, 1, testutil.CollectAndCount(m.batchWriteDuration))
+}
+
+func TestHandlerExposesMetrics(t *testing.T) {
+	m := New(prometheus.NewRegistry())
+	hits := uint64(0)
+	m.ObserveCache(func() uint64 { return hits }, func() uint64 { return 2 })
+	m.MessageDeleted()
+	hits = 6
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	body := w.Body.String()
+	for _, line := range []string{
+		"digital_square_messages_deleted_total 1",
+		"digital_square_cache_hits_total 6",
+		"digital_square_cache_misses_total 2",
+		"digital_square_websocket_connections_active 0",
+	} {
+		assert.True(t, strings.Contains(body, line), line)
+	}
+}